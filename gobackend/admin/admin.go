@@ -0,0 +1,144 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobackend/streamq"
+	"gobackend/wechat"
+)
+
+// Service serves operator endpoints under /admin/. Every request needs
+// "Authorization: Bearer <ADMIN_TOKEN>"; without ADMIN_TOKEN the endpoints are disabled.
+//
+//	GET  /admin/deadletters?queue=compare&cursor=&limit=50
+//	POST /admin/deadletters/{queue}/{id}/replay
+//	GET  /admin/jobs/{jobId}/refunds
+//	POST /admin/jobs/{jobId}/refunds  {amountFen (0/omitted = the rest), reason} or {refundId} to resend
+type Service struct {
+	token       string
+	deadLetters map[string]*streamq.DeadLetterQueue
+	payments    *wechat.Payments
+}
+
+// NewService takes the dead-letter queues by public name (e.g. "compare", "paygate", "webhook").
+func NewService(deadLetters map[string]*streamq.DeadLetterQueue) *Service {
+	return &Service{
+		token:       strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+		deadLetters: deadLetters,
+	}
+}
+
+// SetPayments enables the refund endpoints.
+func (s *Service) SetPayments(p *wechat.Payments) {
+	s.payments = p
+}
+
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/deadletters", s.authorized(s.handleListDeadLetters))
+	mux.HandleFunc("/admin/deadletters/", s.authorized(s.handleDeadLetterRoutes))
+	mux.HandleFunc("/admin/jobs/", s.authorized(s.handleJobRefunds))
+}
+
+func (s *Service) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if s.token == "" {
+			http.Error(w, "未配置 ADMIN_TOKEN：管理接口已禁用", http.StatusForbidden)
+			return
+		}
+		got := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Service) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("queue"))
+	q, ok := s.deadLetters[name]
+	if !ok {
+		http.Error(w, "queue 必须是: "+strings.Join(s.queueNames(), " / "), http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	items, next, err := q.List(ctx, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"queue":      name,
+		"items":      items,
+		"nextCursor": next,
+	})
+}
+
+func (s *Service) handleDeadLetterRoutes(w http.ResponseWriter, r *http.Request) {
+	// /admin/deadletters/{queue}/{id}/replay
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/deadletters/"), "/"), "/")
+	if len(parts) != 3 || parts[2] != "replay" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, ok := s.deadLetters[parts[0]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	jobID, err := q.Replay(ctx, parts[1])
+	if errors.Is(err, streamq.ErrDeadLetterNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "重新投递失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"queue":    parts[0],
+		"id":       parts[1],
+		"jobId":    jobID,
+		"replayed": true,
+	})
+}
+
+func (s *Service) queueNames() []string {
+	names := make([]string, 0, len(s.deadLetters))
+	for n := range s.deadLetters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
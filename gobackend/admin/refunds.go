@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gobackend/domain"
+	"gobackend/wechat"
+)
+
+func (s *Service) handleJobRefunds(w http.ResponseWriter, r *http.Request) {
+	// /admin/jobs/{jobId}/refunds
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "refunds" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.payments == nil {
+		http.Error(w, "退款未启用", http.StatusNotImplemented)
+		return
+	}
+	jobID := parts[0]
+	switch r.Method {
+	case http.MethodGet:
+		job, err := s.payments.RefundedJob(jobID)
+		if errors.Is(err, wechat.ErrJobNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, refundsView(job))
+	case http.MethodPost:
+		s.handleRefund(w, r, jobID)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) handleRefund(w http.ResponseWriter, r *http.Request, jobID string) {
+	var req struct {
+		AmountFen int64  `json:"amountFen"`
+		Reason    string `json:"reason"`
+		RefundID  string `json:"refundId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	var (
+		rf  *domain.CompareJobRefund
+		err error
+	)
+	if id := strings.TrimSpace(req.RefundID); id != "" {
+		rf, err = s.payments.ResendRefund(jobID, id)
+	} else {
+		rf, err = s.payments.Refund(jobID, req.AmountFen, req.Reason)
+	}
+	switch {
+	case errors.Is(err, wechat.ErrJobNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, wechat.ErrRefundAmount), errors.Is(err, wechat.ErrRefundReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, wechat.ErrNotRefundable), errors.Is(err, wechat.ErrRefundNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case rf == nil && err != nil:
+		log.Printf("admin: refund job=%s: %v", jobID, err)
+		http.Error(w, "退款失败: "+err.Error(), http.StatusBadGateway)
+	case errors.Is(err, wechat.ErrRefundRejected):
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{"refund": rf, "error": err.Error()})
+	case err != nil:
+		// Outcome unknown: the refund stays processing until the notify, or resend it by refundId.
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"refund": rf, "error": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"refund": rf})
+	}
+}
+
+func refundsView(job *domain.CompareJob) map[string]interface{} {
+	refunds := job.Refunds
+	if refunds == nil {
+		refunds = []domain.CompareJobRefund{}
+	}
+	return map[string]interface{}{
+		"jobId":       job.ID,
+		"paid":        job.Paid,
+		"paidVia":     job.PaidVia,
+		"refundState": job.RefundState(),
+		"refundedFen": job.RefundedFen(),
+		"refunds":     refunds,
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// SessionCookie carries the token for browser sessions (set by the login callback).
+const SessionCookie = "gy_session"
+
+type ctxKey struct{}
+
+// UserID is the authenticated user of the request ("" = anonymous).
+func UserID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// WithUserID returns ctx authenticated as userID.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, userID)
+}
+
+// Middleware authenticates requests from "Authorization: Bearer <token>" or the session cookie.
+// Missing or invalid tokens leave the request anonymous; handlers that need a user check UserID.
+func (t *Tokens) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, err := t.Verify(requestToken(r)); err == nil {
+			r = r.WithContext(WithUserID(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestToken(r *http.Request) string {
+	if h := strings.TrimSpace(r.Header.Get("Authorization")); len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
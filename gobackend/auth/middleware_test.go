@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// whoAmI runs r through the middleware and returns the user the handler saw.
+func whoAmI(tk *Tokens, r *http.Request) string {
+	var got string
+	tk.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = UserID(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return got
+}
+
+func TestMiddleware(t *testing.T) {
+	tk := NewTokens([]byte("secret"), time.Hour)
+	tok, _, err := tk.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := NewTokens([]byte("other"), time.Hour).Issue("u2")
+
+	cases := []struct {
+		name   string
+		header string
+		cookie string
+		want   string
+	}{
+		{name: "bearer", header: "Bearer " + tok, want: "u1"},
+		{name: "bearer any case", header: "bearer  " + tok, want: "u1"},
+		{name: "session cookie", cookie: tok, want: "u1"},
+		{name: "no credentials"},
+		{name: "invalid bearer", header: "Bearer " + other},
+		{name: "invalid cookie", cookie: other},
+		{name: "basic auth", header: "Basic " + tok},
+		{name: "bare token", header: tok},
+		{name: "empty bearer", header: "Bearer ", cookie: tok, want: "u1"},
+		// The header wins over the cookie, even when it doesn't verify.
+		{name: "invalid bearer with cookie", header: "Bearer " + other, cookie: tok},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: SessionCookie, Value: tc.cookie})
+		}
+		if got := whoAmI(tk, r); got != tc.want {
+			t.Errorf("%s: user %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gobackend/store"
+	"gobackend/wechat"
+)
+
+// Service serves login (WeChat OAuth) and the current user:
+//
+//	GET  /auth/wechat/login     -> redirect to WeChat (QR page, or the in-app page inside WeChat)
+//	GET  /auth/wechat/callback  -> sets the session cookie, redirects to AUTH_LOGIN_REDIRECT#token=<jwt>
+//	GET  /auth/me               -> current user (401 when anonymous)
+//	POST /auth/logout           -> clears the session cookie
+type Service struct {
+	users  store.UserStore
+	tokens *Tokens
+
+	// callbackURL is the redirect_uri registered with WeChat ("" = derived from the request).
+	callbackURL   string
+	loginRedirect string
+}
+
+const stateCookie = "gy_oauth_state"
+
+func NewService(users store.UserStore, tokens *Tokens) *Service {
+	loginRedirect := strings.TrimSpace(os.Getenv("AUTH_LOGIN_REDIRECT"))
+	if loginRedirect == "" {
+		loginRedirect = "/"
+	}
+	return &Service{
+		users:         users,
+		tokens:        tokens,
+		callbackURL:   strings.TrimSpace(os.Getenv("AUTH_WECHAT_CALLBACK_URL")),
+		loginRedirect: loginRedirect,
+	}
+}
+
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/auth/wechat/login", s.handleWeChatLogin)
+	mux.HandleFunc("/auth/wechat/callback", s.handleWeChatCallback)
+	mux.HandleFunc("/auth/me", s.handleMe)
+	mux.HandleFunc("/auth/logout", s.handleLogout)
+}
+
+func (s *Service) handleWeChatLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !wechat.OAuthEnabled() {
+		http.Error(w, "未配置微信登录", http.StatusServiceUnavailable)
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	// The state cookie ties the callback to this browser (CSRF protection for the login).
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	inWeChat := strings.Contains(r.UserAgent(), "MicroMessenger")
+	http.Redirect(w, r, wechat.OAuthAuthorizeURL(s.callbackFor(r), state, inWeChat), http.StatusFound)
+}
+
+func (s *Service) handleWeChatCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	c, err := r.Cookie(stateCookie)
+	if err != nil || c.Value == "" || c.Value != q.Get("state") {
+		http.Error(w, "登录状态校验失败，请重新登录", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Value: "", Path: "/", MaxAge: -1})
+	code := strings.TrimSpace(q.Get("code"))
+	if code == "" {
+		// User declined the authorization.
+		http.Error(w, "未授权登录", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	wu, err := wechat.OAuthExchange(ctx, code)
+	if err != nil {
+		log.Printf("wechat login failed: %v", err)
+		http.Error(w, "微信登录失败", http.StatusBadGateway)
+		return
+	}
+	u, err := s.users.UpsertWeChat(wu.OpenID, wu.UnionID, wu.Nickname, wu.AvatarURL)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	token, exp, err := s.tokens.Issue(u.ID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	// The fragment never reaches servers/logs; SPAs on another origin read it and send it as Bearer.
+	http.Redirect(w, r, s.loginRedirect+"#"+url.Values{"token": {token}}.Encode(), http.StatusFound)
+}
+
+func (s *Service) handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := UserID(r.Context())
+	if id == "" {
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+		return
+	}
+	u, ok, err := s.users.Get(id)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "用户不存在", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (s *Service) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) callbackFor(r *http.Request) string {
+	if s.callbackURL != "" {
+		return s.callbackURL
+	}
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/auth/wechat/callback"
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
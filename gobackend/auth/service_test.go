@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gobackend/store"
+)
+
+// newLoginTest serves the auth routes behind the middleware with WeChat OAuth mocked.
+func newLoginTest(t *testing.T) (http.Handler, *Tokens) {
+	t.Helper()
+	t.Setenv("WECHAT_MOCK", "1")
+	t.Setenv("AUTH_LOGIN_REDIRECT", "https://app.example.com/done")
+	t.Setenv("AUTH_WECHAT_CALLBACK_URL", "")
+	tk := NewTokens([]byte("secret"), time.Hour)
+	mux := http.NewServeMux()
+	NewService(store.NewInMemoryUserStore(), tk).RegisterRoutes(mux)
+	return tk.Middleware(mux), tk
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func cookieOf(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// startLogin returns the state cookie and the callback URL the (mock) WeChat redirects to.
+func startLogin(t *testing.T, h http.Handler) (*http.Cookie, *url.URL) {
+	t.Helper()
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "http://api.example.com/auth/wechat/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	state := cookieOf(rec, stateCookie)
+	if state == nil || state.Value == "" || !state.HttpOnly {
+		t.Fatalf("login set no state cookie: %v", rec.Result().Cookies())
+	}
+	cb, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || cb.Path != "/auth/wechat/callback" || cb.Query().Get("state") != state.Value {
+		t.Fatalf("login redirect = %s, want the callback with state %s", rec.Header().Get("Location"), state.Value)
+	}
+	return state, cb
+}
+
+func TestWeChatLogin(t *testing.T) {
+	h, tk := newLoginTest(t)
+	state, cb := startLogin(t, h)
+
+	r := httptest.NewRequest(http.MethodGet, cb.String(), nil)
+	r.AddCookie(state)
+	rec := serve(h, r)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	if c := cookieOf(rec, stateCookie); c == nil || c.MaxAge >= 0 {
+		t.Fatalf("state cookie not cleared: %v", c)
+	}
+	session := cookieOf(rec, SessionCookie)
+	if session == nil || !session.HttpOnly {
+		t.Fatal("no session cookie")
+	}
+	uid, err := tk.Verify(session.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "https://app.example.com/done#" + url.Values{"token": {session.Value}}.Encode()
+	if loc := rec.Header().Get("Location"); loc != want {
+		t.Fatalf("callback redirect = %s, want %s", loc, want)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	r.AddCookie(session)
+	if rec := serve(h, r); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), uid) {
+		t.Fatalf("me: %d %s, want user %s", rec.Code, rec.Body, uid)
+	}
+	if rec := serve(h, httptest.NewRequest(http.MethodGet, "/auth/me", nil)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous me: %d, want 401", rec.Code)
+	}
+}
+
+func TestWeChatCallbackStateMismatch(t *testing.T) {
+	h, _ := newLoginTest(t)
+	state, cb := startLogin(t, h)
+	otherState, _ := startLogin(t, h)
+	if otherState.Value == state.Value {
+		t.Fatal("two logins got the same state")
+	}
+
+	withState := func(v string) string {
+		u := *cb
+		q := u.Query()
+		q.Set("state", v)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	cases := []struct {
+		name   string
+		target string
+		cookie *http.Cookie
+	}{
+		{name: "no state cookie", target: cb.String()},
+		{name: "cookie of another login", target: cb.String(), cookie: otherState},
+		{name: "state of another login", target: withState(otherState.Value), cookie: state},
+		{name: "no state param", target: withState(""), cookie: state},
+		{name: "empty cookie and state", target: withState(""), cookie: &http.Cookie{Name: stateCookie, Value: ""}},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.cookie != nil {
+			r.AddCookie(tc.cookie)
+		}
+		rec := serve(h, r)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s, want 400", tc.name, rec.Code, rec.Body)
+		}
+		if c := cookieOf(rec, SessionCookie); c != nil {
+			t.Errorf("%s: session cookie set", tc.name)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	h, _ := newLoginTest(t)
+	rec := serve(h, httptest.NewRequest(http.MethodPost, "/auth/logout", nil))
+	if c := cookieOf(rec, SessionCookie); rec.Code != http.StatusNoContent || c == nil || c.MaxAge >= 0 {
+		t.Fatalf("logout: %d, session cookie %v, want it cleared", rec.Code, c)
+	}
+	if rec := serve(h, httptest.NewRequest(http.MethodGet, "/auth/logout", nil)); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET logout: %d, want 405", rec.Code)
+	}
+}
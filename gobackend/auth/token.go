@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tokens issues and verifies session tokens: HS256 JWTs whose subject is the user ID.
+type Tokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+var ErrInvalidToken = errors.New("登录凭证无效或已过期")
+
+// NewTokens signs with secret (a random one when empty: tokens then only verify in this process).
+func NewTokens(secret []byte, ttl time.Duration) *Tokens {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, _ = rand.Read(secret)
+	}
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+	return &Tokens{secret: secret, ttl: ttl}
+}
+
+// NewTokensFromEnv: AUTH_JWT_SECRET, AUTH_TOKEN_TTL_HOURS (default 168).
+func NewTokensFromEnv() *Tokens {
+	secret := strings.TrimSpace(os.Getenv("AUTH_JWT_SECRET"))
+	if secret == "" {
+		log.Printf("AUTH_JWT_SECRET 为空：使用随机密钥，重启或多副本时登录会失效")
+	}
+	ttl := 7 * 24 * time.Hour
+	if raw := strings.TrimSpace(os.Getenv("AUTH_TOKEN_TTL_HOURS")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			ttl = time.Duration(n) * time.Hour
+		}
+	}
+	return NewTokens([]byte(secret), ttl)
+}
+
+type claims struct {
+	Sub string `json:"sub"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issue returns a token for userID and its expiry.
+func (t *Tokens) Issue(userID string) (string, time.Time, error) {
+	if strings.TrimSpace(userID) == "" {
+		return "", time.Time{}, errors.New("userID 为空")
+	}
+	now := time.Now()
+	exp := now.Add(t.ttl)
+	b, err := json.Marshal(claims{Sub: userID, Iat: now.Unix(), Exp: exp.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signing := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(b)
+	return signing + "." + t.sign(signing), exp, nil
+}
+
+// Verify returns the user ID of a valid, unexpired token.
+func (t *Tokens) Verify(token string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return "", ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(parts[0]+"."+parts[1]))) {
+		return "", ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(b, &c); err != nil || c.Sub == "" || time.Now().Unix() >= c.Exp {
+		return "", ErrInvalidToken
+	}
+	return c.Sub, nil
+}
+
+func (t *Tokens) sign(s string) string {
+	m := hmac.New(sha256.New, t.secret)
+	m.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forge signs header.claims with tk's secret, like Issue but with any header/claims.
+func forge(t *testing.T, tk *Tokens, header string, c interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(b)
+	return signing + "." + tk.sign(signing)
+}
+
+func TestTokensIssueVerify(t *testing.T) {
+	tk := NewTokens([]byte("secret"), time.Hour)
+	tok, exp, err := tk.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(exp); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("expiry in %s, want the 1h ttl", d)
+	}
+	if id, err := tk.Verify(tok); err != nil || id != "u1" {
+		t.Fatalf("Verify = %q, %v", id, err)
+	}
+	if id, err := tk.Verify(" " + tok + "\n"); err != nil || id != "u1" {
+		t.Fatalf("Verify with spaces = %q, %v", id, err)
+	}
+	if _, _, err := tk.Issue(" "); err == nil {
+		t.Fatal("issued a token without a user")
+	}
+}
+
+func TestTokensVerifyRejects(t *testing.T) {
+	tk := NewTokens([]byte("secret"), time.Hour)
+	good, _, err := tk.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(good, ".")
+	now := time.Now().Unix()
+	otherSecret, _, _ := NewTokens([]byte("other"), time.Hour).Issue("u1")
+
+	// The payload of good with sub changed, keeping good's signature.
+	evil, _ := json.Marshal(claims{Sub: "admin", Iat: now, Exp: now + 3600})
+	swapped := parts[0] + "." + base64.RawURLEncoding.EncodeToString(evil) + "." + parts[2]
+
+	flipped := []byte(parts[2])
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	cases := map[string]string{
+		"empty":              "",
+		"two parts":          parts[0] + "." + parts[1],
+		"four parts":         good + ".x",
+		"not a jwt":          "garbage",
+		"tampered signature": parts[0] + "." + parts[1] + "." + string(flipped),
+		"missing signature":  parts[0] + "." + parts[1] + ".",
+		"tampered payload":   swapped,
+		"other secret":       otherSecret,
+		"alg none":           forge(t, tk, `{"alg":"none","typ":"JWT"}`, claims{Sub: "u1", Iat: now, Exp: now + 3600}),
+		"alg none unsigned":  base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".",
+		"alg HS512":          forge(t, tk, `{"alg":"HS512","typ":"JWT"}`, claims{Sub: "u1", Iat: now, Exp: now + 3600}),
+		"header reordered":   forge(t, tk, `{"typ":"JWT","alg":"HS256"}`, claims{Sub: "u1", Iat: now, Exp: now + 3600}),
+		"expired":            forge(t, tk, `{"alg":"HS256","typ":"JWT"}`, claims{Sub: "u1", Iat: now - 7200, Exp: now - 1}),
+		"expires now":        forge(t, tk, `{"alg":"HS256","typ":"JWT"}`, claims{Sub: "u1", Iat: now - 3600, Exp: now}),
+		"no expiry":          forge(t, tk, `{"alg":"HS256","typ":"JWT"}`, map[string]interface{}{"sub": "u1"}),
+		"no subject":         forge(t, tk, `{"alg":"HS256","typ":"JWT"}`, claims{Iat: now, Exp: now + 3600}),
+		"payload not json":   forge(t, tk, `{"alg":"HS256","typ":"JWT"}`, "u1"),
+		"payload not base64": parts[0] + ".!!!." + tk.sign(parts[0]+".!!!"),
+		"exp of wrong type":  forge(t, tk, `{"alg":"HS256","typ":"JWT"}`, map[string]interface{}{"sub": "u1", "exp": "never"}),
+	}
+	for name, tok := range cases {
+		if id, err := tk.Verify(tok); !errors.Is(err, ErrInvalidToken) || id != "" {
+			t.Errorf("%s: Verify = %q, %v, want ErrInvalidToken", name, id, err)
+		}
+	}
+}
+
+func TestNewTokensRandomSecret(t *testing.T) {
+	a, b := NewTokens(nil, 0), NewTokens(nil, 0)
+	tok, exp, err := a.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(exp); d < 7*24*time.Hour-time.Minute {
+		t.Fatalf("default ttl gives expiry in %s, want 7 days", d)
+	}
+	if _, err := b.Verify(tok); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("a token of one random secret verified with another: %v", err)
+	}
+}
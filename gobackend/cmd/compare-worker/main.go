@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -15,11 +17,13 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"gobackend/compare"
+	"gobackend/domain"
+	"gobackend/objstore"
 	"gobackend/obs"
-	"gobackend/ossstore"
 	"gobackend/redislock"
 	"gobackend/store"
 	"gobackend/streamq"
+	"gobackend/webhook"
 )
 
 func main() {
@@ -31,38 +35,46 @@ func main() {
 		log.Fatalf("REDIS_ADDR 为空")
 	}
 
-	jobStore, err := store.NewRedisCompareJobStore(redisAddr, os.Getenv("REDIS_PASSWORD"))
+	// Redis by default; COMPARE_JOB_STORE=sql / redis+sql keeps jobs in SQL (DATABASE_URL).
+	baseStore, err := store.NewCompareJobStoreFromEnv(redisAddr, os.Getenv("REDIS_PASSWORD"))
 	if err != nil {
-		log.Fatalf("init redis store failed: %v", err)
+		log.Fatalf("init job store failed: %v", err)
 	}
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: strings.TrimSpace(os.Getenv("REDIS_PASSWORD")),
 		DB:       readEnvIntDefault("REDIS_DB", 0),
 	})
-
-	var ossSt *ossstore.Store
-	if st, enabled, err := ossstore.NewFromEnv(); err != nil {
-		if enabled {
-			log.Fatalf("init oss store failed: %v", err)
-		}
-	} else if enabled {
-		ossSt = st
-		log.Printf("oss store enabled bucket=%s prefix=%s", strings.TrimSpace(os.Getenv("OSS_BUCKET")), strings.TrimSpace(os.Getenv("OSS_PREFIX")))
-	} else {
-		log.Fatalf("OSS 未启用：worker 无法处理输入/输出文件")
+	// Webhook stream (payment-worker delivers). Status changes on jobs with a callback_url enqueue to it.
+	hookStreamKey := readEnvDefault("WEBHOOK_STREAM_KEY", "gy:comparejobs:webhook")
+	hookGroup := readEnvDefault("WEBHOOK_STREAM_GROUP", "gy-webhook")
+	hookMaxLen := int64(readEnvIntDefault("WEBHOOK_STREAM_MAXLEN", 100000))
+	hookQ := streamq.NewRedisStreamQueue(rdb, hookStreamKey, hookGroup, hookMaxLen)
+	hookQ.SetMessageType(domain.MessageWebhookDeliver)
+	jobStore := webhook.NewNotifyingStore(baseStore, hookQ)
+
+	objSt, err := objstore.NewFromEnv("", filepath.Join(readEnvDefault("TMP_ROOT", "./tmp"), "objects"))
+	if err != nil {
+		log.Fatalf("init object store failed: %v", err)
+	}
+	if objSt == nil {
+		log.Fatalf("对象存储未配置（OBJECT_STORE / OSS_BUCKET / S3_BUCKET）：worker 无法处理输入/输出文件")
 	}
 
 	streamKey := readEnvDefault("COMPARE_STREAM_KEY", "gy:comparejobs:stream")
 	group := readEnvDefault("COMPARE_STREAM_GROUP", "gy-compare")
 	maxLen := int64(readEnvIntDefault("COMPARE_STREAM_MAXLEN", 100000))
 
-	q := streamq.NewRedisStreamQueue(rdb, streamKey, group, maxLen)
+	// Large-upload lane (the API routes uploads >= COMPARE_LARGE_LANE_MB to it).
+	largeStreamKey := readEnvDefault("COMPARE_LARGE_STREAM_KEY", streamKey+":large")
+
 	ctx, cancel := signalContext()
 	defer cancel()
 
-	if err := q.EnsureGroup(ctx); err != nil {
-		log.Fatalf("ensure stream group failed: %v", err)
+	for _, key := range []string{streamKey, largeStreamKey} {
+		if err := streamq.NewRedisStreamQueue(rdb, key, group, maxLen).EnsureGroup(ctx); err != nil {
+			log.Fatalf("ensure stream group failed stream=%s: %v", key, err)
+		}
 	}
 
 	// Pay-gate stream (payment-worker consumes it). compare-worker only enqueues.
@@ -70,39 +82,74 @@ func main() {
 	payGroup := readEnvDefault("COMPARE_PAYGATE_STREAM_GROUP", "gy-paygate")
 	payMaxLen := int64(readEnvIntDefault("COMPARE_PAYGATE_STREAM_MAXLEN", 100000))
 	payQ := streamq.NewRedisStreamQueue(rdb, payStreamKey, payGroup, payMaxLen)
+	payQ.SetMessageType(domain.MessagePaygateCheck)
 
 	tmpRoot := readEnvDefault("TMP_ROOT", "./tmp")
 	lock := redislock.New(rdb, readEnvDefault("COMPARE_JOB_LOCK_PREFIX", "gy:lock:comparejob:"))
-	worker := compare.NewWorker(jobStore, tmpRoot, ossSt, payQ, lock)
+	worker := compare.NewWorker(jobStore, tmpRoot, objSt, payQ, lock)
+	// Per-tenant cap on running jobs across all workers (0 disables); over the cap a job is requeued with backoff.
+	worker.SetTenantLimit(
+		redislock.NewSemaphore(rdb, readEnvDefault("COMPARE_TENANT_SEM_PREFIX", "gy:sem:tenant:")),
+		readEnvIntDefault("COMPARE_TENANT_MAX_RUNNING", 2),
+	)
 
 	consumerName := strings.TrimSpace(os.Getenv("WORKER_CONSUMER_NAME"))
 	if consumerName == "" {
 		consumerName = strings.TrimSpace(os.Getenv("HOSTNAME"))
 	}
-	cons := streamq.NewConsumer(rdb, streamKey, group, consumerName)
+	// Weighted fairness between lanes: at 3:1, while both lanes have backlog every 4th read is a large job.
+	cons := streamq.NewMultiConsumer(rdb, group, consumerName, []streamq.Lane{
+		{Stream: streamKey, Weight: readEnvIntDefault("COMPARE_LANE_WEIGHT_STANDARD", 3)},
+		{Stream: largeStreamKey, Weight: readEnvIntDefault("COMPARE_LANE_WEIGHT_LARGE", 1)},
+	})
 	cons.SetConcurrency(readEnvIntDefault("STREAM_CONCURRENCY", 4))
-	log.Printf("compare-worker start stream=%s group=%s consumer=%s", streamKey, group, consumerName)
-
-	go serveMetrics(readEnvDefault("METRICS_ADDR", ":9090"))
+	cons.SetRetryPolicy(retryPolicyFromEnv())
+	// On SIGTERM: stop reading, let running jobs finish for up to WORKER_DRAIN_SECONDS, release the rest.
+	// Keep it below the pod's terminationGracePeriodSeconds.
+	drain := time.Duration(readEnvIntDefault("WORKER_DRAIN_SECONDS", 25)) * time.Second
+	cons.SetDrainTimeout(drain)
+	log.Printf("compare-worker start streams=%s,%s group=%s consumer=%s", streamKey, largeStreamKey, group, consumerName)
+
+	go serveMetrics(readEnvDefault("METRICS_ADDR", ":9090"), func(ctx context.Context) error {
+		if !cons.Ready() {
+			return errors.New("consumer not running or draining")
+		}
+		return rdb.Ping(ctx).Err()
+	})
 
-	err = cons.ConsumeLoop(ctx, func(ctx context.Context, jobID string) error {
+	run := func(ctx context.Context, env streamq.Envelope) error {
 		// handler should never crash the loop; all failures are persisted to job store.
 		start := time.Now()
-		err := worker.Process(ctx, jobID)
+		err := worker.Process(ctx, env.JobID)
 		obs.RecordWorkerJob("compare-worker", start, err)
 		return err
-	})
+	}
+	// "": messages enqueued before typed envelopes.
+	err = cons.ConsumeLoop(ctx, streamq.Mux{domain.MessageCompareRun: run, "": run}.Handle)
 	if err != nil && err != context.Canceled {
 		log.Fatalf("consume loop exited: %v", err)
 	}
 }
 
-func serveMetrics(addr string) {
+// serveMetrics also serves the probes: /healthz and /livez (process is up) and /readyz
+// (ready(): consuming and Redis reachable; 503 while draining so rollouts stop routing to the pod).
+func serveMetrics(addr string, ready func(ctx context.Context) error) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+	live := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
+	}
+	mux.HandleFunc("/healthz", live)
+	mux.HandleFunc("/livez", live)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+		if err := ready(ctx); err != nil {
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
 	})
 	srv := &http.Server{
 		Addr:              addr,
@@ -132,6 +179,14 @@ func readEnvIntDefault(key string, defaultVal int) int {
 	return n
 }
 
+func retryPolicyFromEnv() streamq.RetryPolicy {
+	return streamq.RetryPolicy{
+		MaxDeliveries: int64(readEnvIntDefault("STREAM_MAX_DELIVERIES", 5)),
+		BaseDelay:     time.Duration(readEnvIntDefault("STREAM_RETRY_BASE_SECONDS", 30)) * time.Second,
+		MaxDelay:      time.Duration(readEnvIntDefault("STREAM_RETRY_MAX_SECONDS", 600)) * time.Second,
+	}
+}
+
 func signalContext() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	ch := make(chan os.Signal, 2)
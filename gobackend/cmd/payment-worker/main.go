@@ -2,23 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 
+	"gobackend/domain"
 	"gobackend/obs"
 	"gobackend/paygate"
 	"gobackend/redislock"
 	"gobackend/store"
 	"gobackend/streamq"
+	"gobackend/subscription"
+	"gobackend/webhook"
+	"gobackend/wechat"
 )
 
 func main() {
@@ -30,21 +36,30 @@ func main() {
 		log.Fatalf("REDIS_ADDR 为空")
 	}
 
-	jobStore, err := store.NewRedisCompareJobStore(redisAddr, os.Getenv("REDIS_PASSWORD"))
+	// Redis by default; COMPARE_JOB_STORE=sql / redis+sql keeps jobs in SQL (DATABASE_URL).
+	baseStore, err := store.NewCompareJobStoreFromEnv(redisAddr, os.Getenv("REDIS_PASSWORD"))
 	if err != nil {
-		log.Fatalf("init redis store failed: %v", err)
+		log.Fatalf("init job store failed: %v", err)
 	}
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: strings.TrimSpace(os.Getenv("REDIS_PASSWORD")),
 		DB:       readEnvIntDefault("REDIS_DB", 0),
 	})
+	// Webhook stream (payment-worker delivers). Status changes on jobs with a callback_url enqueue to it.
+	hookStreamKey := readEnvDefault("WEBHOOK_STREAM_KEY", "gy:comparejobs:webhook")
+	hookGroup := readEnvDefault("WEBHOOK_STREAM_GROUP", "gy-webhook")
+	hookMaxLen := int64(readEnvIntDefault("WEBHOOK_STREAM_MAXLEN", 100000))
+	hookQ := streamq.NewRedisStreamQueue(rdb, hookStreamKey, hookGroup, hookMaxLen)
+	hookQ.SetMessageType(domain.MessageWebhookDeliver)
+	jobStore := webhook.NewNotifyingStore(baseStore, hookQ)
 
 	streamKey := readEnvDefault("COMPARE_PAYGATE_STREAM_KEY", "gy:comparejobs:paygate")
 	group := readEnvDefault("COMPARE_PAYGATE_STREAM_GROUP", "gy-paygate")
 	maxLen := int64(readEnvIntDefault("COMPARE_PAYGATE_STREAM_MAXLEN", 100000))
 
 	q := streamq.NewRedisStreamQueue(rdb, streamKey, group, maxLen)
+	q.SetMessageType(domain.MessagePaygateCheck)
 	ctx, cancel := signalContext()
 	defer cancel()
 
@@ -54,6 +69,9 @@ func main() {
 
 	lock := redislock.New(rdb, readEnvDefault("COMPARE_PAYGATE_LOCK_PREFIX", "gy:lock:comparejob:"))
 	worker := paygate.NewWorker(jobStore, lock)
+	if subs := subscriptionsFromEnv(); subs != nil {
+		worker.SetSubscriptions(subs)
+	}
 
 	consumerName := strings.TrimSpace(os.Getenv("WORKER_CONSUMER_NAME"))
 	if consumerName == "" {
@@ -61,27 +79,112 @@ func main() {
 	}
 	cons := streamq.NewConsumer(rdb, streamKey, group, consumerName)
 	cons.SetConcurrency(readEnvIntDefault("STREAM_CONCURRENCY", 8))
+	cons.SetRetryPolicy(retryPolicyFromEnv())
+	// On SIGTERM: stop reading, let running jobs finish for up to WORKER_DRAIN_SECONDS, release the rest.
+	// Keep it below the pod's terminationGracePeriodSeconds.
+	drain := time.Duration(readEnvIntDefault("WORKER_DRAIN_SECONDS", 25)) * time.Second
+	cons.SetDrainTimeout(drain)
 	log.Printf("payment-worker start stream=%s group=%s consumer=%s", streamKey, group, consumerName)
 
-	go serveMetrics(readEnvDefault("METRICS_ADDR", ":9090"))
+	// Webhook deliveries share this process: they are light and mostly follow payment events.
+	var (
+		hookCons *streamq.Consumer
+		hookDone sync.WaitGroup
+	)
+	if webhook.Enabled() {
+		if err := hookQ.EnsureGroup(ctx); err != nil {
+			log.Fatalf("ensure webhook stream group failed: %v", err)
+		}
+		hookCfg := webhook.ConfigFromEnv()
+		dispatcher := webhook.NewDispatcher(jobStore, hookCfg)
+		hookCons = streamq.NewConsumer(rdb, hookStreamKey, hookGroup, consumerName)
+		hookCons.SetConcurrency(readEnvIntDefault("WEBHOOK_CONCURRENCY", 4))
+		hookCons.SetRetryPolicy(hookCfg.RetryPolicy())
+		hookCons.SetDrainTimeout(drain)
+		log.Printf("payment-worker webhook consumer start stream=%s group=%s", hookStreamKey, hookGroup)
+		hookDone.Add(1)
+		go func() {
+			defer hookDone.Done()
+			deliver := func(ctx context.Context, env streamq.Envelope) error {
+				start := time.Now()
+				err := dispatcher.Process(ctx, env.JobID)
+				obs.RecordWorkerJob("webhook", start, err)
+				return err
+			}
+			err := hookCons.ConsumeLoop(ctx, streamq.Mux{domain.MessageWebhookDeliver: deliver, "": deliver}.Handle)
+			if err != nil && err != context.Canceled {
+				log.Printf("webhook consume loop exited: %v", err)
+			}
+		}()
+	} else {
+		log.Printf("payment-worker: WEBHOOK_SECRET 为空，不投递 webhook")
+	}
+
+	// Missed notifies: ask WeChat Pay about jobs stuck in awaiting_payment (the answers are verified
+	// with the platform certificates kept current here). Top-ups and plan orders are settled by the
+	// API's notify only.
+	go wechat.RunPlatformCertRefresher(ctx)
+	go paygate.NewReconciler(jobStore, wechat.NewPayments(jobStore, nil), lock).Run(ctx)
 
-	err = cons.ConsumeLoop(ctx, func(ctx context.Context, jobID string) error {
+	go serveMetrics(readEnvDefault("METRICS_ADDR", ":9090"), func(ctx context.Context) error {
+		if !cons.Ready() || (hookCons != nil && !hookCons.Ready()) {
+			return errors.New("consumer not running or draining")
+		}
+		return rdb.Ping(ctx).Err()
+	})
+
+	check := func(ctx context.Context, env streamq.Envelope) error {
 		start := time.Now()
-		err := worker.Process(ctx, jobID)
+		err := worker.Process(ctx, env.JobID)
 		obs.RecordWorkerJob("payment-worker", start, err)
 		return err
-	})
+	}
+	// "": messages enqueued before typed envelopes.
+	err = cons.ConsumeLoop(ctx, streamq.Mux{domain.MessagePaygateCheck: check, "": check}.Handle)
+	// Both loops drain in parallel; wait for the webhook one before exiting.
+	hookDone.Wait()
 	if err != nil && err != context.Canceled {
 		log.Fatalf("consume loop exited: %v", err)
 	}
 }
 
-func serveMetrics(addr string) {
+// subscriptionsFromEnv returns the subscription service when SUBSCRIPTION_PLANS and DATABASE_URL
+// are set (the same as on the API), else nil.
+func subscriptionsFromEnv() *subscription.Service {
+	if os.Getenv("SUBSCRIPTION_PLANS") == "" || os.Getenv("DATABASE_URL") == "" {
+		log.Printf("payment-worker: subscriptions disabled (SUBSCRIPTION_PLANS / DATABASE_URL not set)")
+		return nil
+	}
+	db, driver, err := store.OpenSQL()
+	if err != nil {
+		log.Fatalf("init subscription store failed: %v", err)
+	}
+	subStore, err := store.NewSQLSubscriptionStore(db, driver)
+	if err != nil {
+		log.Fatalf("init subscription store failed: %v", err)
+	}
+	return subscription.New(subStore)
+}
+
+// serveMetrics also serves the probes: /healthz and /livez (process is up) and /readyz
+// (ready(): consuming and Redis reachable; 503 while draining so rollouts stop routing to the pod).
+func serveMetrics(addr string, ready func(ctx context.Context) error) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+	live := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
+	}
+	mux.HandleFunc("/healthz", live)
+	mux.HandleFunc("/livez", live)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+		if err := ready(ctx); err != nil {
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
 	})
 	srv := &http.Server{
 		Addr:              addr,
@@ -111,6 +214,14 @@ func readEnvIntDefault(key string, defaultVal int) int {
 	return n
 }
 
+func retryPolicyFromEnv() streamq.RetryPolicy {
+	return streamq.RetryPolicy{
+		MaxDeliveries: int64(readEnvIntDefault("STREAM_MAX_DELIVERIES", 5)),
+		BaseDelay:     time.Duration(readEnvIntDefault("STREAM_RETRY_BASE_SECONDS", 30)) * time.Second,
+		MaxDelay:      time.Duration(readEnvIntDefault("STREAM_RETRY_MAX_SECONDS", 600)) * time.Second,
+	}
+}
+
 func signalContext() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	ch := make(chan os.Signal, 2)
@@ -0,0 +1,89 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// errJobCancelled is the cancel cause of a job context whose job was cancelled by the user
+// (as opposed to the worker shutting down).
+var errJobCancelled = errors.New("任务已取消")
+
+// watchCancel returns a context that is cancelled with errJobCancelled once the job's status
+// becomes cancelled. Changes are pushed by store.CompareJobWatcher when available; the store is
+// also polled every `poll`, since pub/sub delivery is best-effort.
+func watchCancel(parent context.Context, st store.CompareJobStore, jobID string, poll time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	var updates <-chan *domain.CompareJob
+	if watcher, ok := st.(store.CompareJobWatcher); ok {
+		ch, err := watcher.Watch(ctx, jobID)
+		if err != nil {
+			log.Printf("cancel watch failed job=%s, polling only: %v", jobID, err)
+		} else {
+			updates = ch
+		}
+	}
+	go func() {
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case j, ok := <-updates:
+				if !ok {
+					updates = nil
+					continue
+				}
+				if j != nil && j.Status == domain.CompareJobStatusCancelled {
+					cancel(errJobCancelled)
+					return
+				}
+			case <-t.C:
+				j, ok, err := st.Get(jobID)
+				if err == nil && ok && j.Status == domain.CompareJobStatusCancelled {
+					cancel(errJobCancelled)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+func jobCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errJobCancelled)
+}
+
+// cleanupCancelled removes what a cancelled job left behind: the local job dir, the result object
+// (possibly uploaded before the cancel was seen) and the uploaded inputs. Best-effort.
+func (w *Worker) cleanupCancelled(job *domain.CompareJob, jobDir string) {
+	if jobDir != "" {
+		_ = os.RemoveAll(jobDir)
+	}
+	if w.oss == nil || !w.oss.Enabled() || job == nil {
+		return
+	}
+	keys := []string{w.oss.ObjectKeyForJob(job.ID), job.File1OSSKey, job.File2OSSKey, job.BaseOSSKey}
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		if err := w.oss.DeleteObject(k); err != nil {
+			log.Printf("cancel cleanup: delete oss object failed job=%s key=%s: %v", job.ID, k, err)
+		}
+	}
+	_, _, _ = w.store.Update(job.ID, func(j *domain.CompareJob) {
+		if j.Status != domain.CompareJobStatusCancelled {
+			return
+		}
+		j.ResultOSSKey = ""
+		j.ResultPath = ""
+	})
+}
@@ -0,0 +1,174 @@
+package compare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// Server-Sent Events for one job: GET /compare/jobs/{jobId}/events
+//
+//	event: status    data: <same JSON as GET /compare/jobs/{jobId}>   (first event + every status change)
+//	event: progress  data: {"phase":..., "percent":..., ...}
+//	event: payment   data: {"paid":..., "amount":..., "code_url":..., "paidAt":...}
+//
+// The stream ends after a terminal status (ready / failed / cancelled). Changes come from
+// store.CompareJobWatcher (Redis pub/sub, so any API pod can serve the stream); stores without
+// it are polled.
+
+const (
+	sseHeartbeat    = 15 * time.Second
+	ssePollInterval = time.Second
+)
+
+func (s *Service) handleJobEvents(w http.ResponseWriter, r *http.Request, jobID string) {
+	rc := http.NewResponseController(w)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Subscribe before the first Get so no change between the two is lost.
+	var updates <-chan *domain.CompareJob
+	if watcher, ok := s.store.(store.CompareJobWatcher); ok {
+		ch, err := watcher.Watch(ctx, jobID)
+		if err != nil {
+			log.Printf("job events: watch failed job=%s, falling back to polling: %v", jobID, err)
+		} else {
+			updates = ch
+		}
+	}
+
+	job, ok, err := s.store.Get(jobID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || !canAccess(r, job) {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+	w.WriteHeader(http.StatusOK)
+
+	st := &sseJobState{}
+	if err := st.emit(w, job); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	var poll <-chan time.Time
+	if updates == nil {
+		t := time.NewTicker(ssePollInterval)
+		defer t.Stop()
+		poll = t.C
+	}
+
+	for !st.terminal {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case j, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := st.emit(w, j); err != nil {
+				return
+			}
+		case <-poll:
+			j, ok, err := s.store.Get(jobID)
+			if err != nil {
+				continue
+			}
+			if !ok {
+				return
+			}
+			if err := st.emit(w, j); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sseJobState remembers what the client has already seen so only transitions are sent.
+type sseJobState struct {
+	started  bool
+	status   domain.CompareJobStatus
+	progress domain.CompareJobProgress
+	paid     bool
+	codeURL  string
+	terminal bool
+}
+
+func (st *sseJobState) emit(w http.ResponseWriter, job *domain.CompareJob) error {
+	if job == nil {
+		return nil
+	}
+	status := publicStatus(job)
+	if job.Progress != nil && (!st.started || progressChanged(st.progress, *job.Progress)) {
+		st.progress = *job.Progress
+		if err := writeSSE(w, "progress", job.Progress); err != nil {
+			return err
+		}
+	}
+	if st.started && (job.Paid != st.paid || job.CodeURL != st.codeURL) {
+		payment := map[string]interface{}{"paid": job.Paid}
+		if job.CodeURL != "" {
+			payment["amount"] = job.AmountYuan
+			payment["code_url"] = job.CodeURL
+			payment["price"] = job.Price
+		}
+		if job.PaidAt != nil {
+			payment["paidAt"] = job.PaidAt
+		}
+		if err := writeSSE(w, "payment", payment); err != nil {
+			return err
+		}
+	}
+	st.paid, st.codeURL = job.Paid, job.CodeURL
+	if !st.started || status != st.status {
+		if err := writeSSE(w, "status", jobView(job)); err != nil {
+			return err
+		}
+	}
+	st.started = true
+	st.status = status
+	switch status {
+	case domain.CompareJobStatusReady, domain.CompareJobStatusFailed, domain.CompareJobStatusCancelled:
+		st.terminal = true
+	}
+	return nil
+}
+
+func progressChanged(a, b domain.CompareJobProgress) bool {
+	return a.Phase != b.Phase || a.Percent != b.Percent || a.RowsDone != b.RowsDone
+}
+
+func writeSSE(w http.ResponseWriter, event string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
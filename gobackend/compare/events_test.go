@@ -0,0 +1,217 @@
+package compare
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gobackend/auth"
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+type sseEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// openEvents starts GET /compare/jobs/{jobID}/events as user u1 on a real server (so every flush
+// reaches the client) and returns the parsed events; the channel is closed when the stream ends.
+func openEvents(t *testing.T, st store.CompareJobStore, jobID string) <-chan sseEvent {
+	t.Helper()
+	svc := NewService(st, nil, t.TempDir(), nil)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(auth.WithUserID(r.Context(), "u1")))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/compare/jobs/"+jobID+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("events: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	out := make(chan sseEvent, 16)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		var ev sseEvent
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.data); err != nil {
+					t.Errorf("data of %q: %v", ev.name, err)
+				}
+			case line == "":
+				if ev.name != "" {
+					out <- ev
+				}
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return sseEvent{}
+}
+
+func expectEnd(t *testing.T, events <-chan sseEvent) {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if ok {
+			t.Fatalf("unexpected event %s %v", ev.name, ev.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed")
+	}
+}
+
+func processingJob(t *testing.T, st store.CompareJobStore, id string) {
+	t.Helper()
+	job := &domain.CompareJob{ID: id, OwnerID: "u1", CreatedAt: time.Now()}
+	if err := job.Transition(domain.CompareJobStatusProcessing, domain.ActorAPI, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Create(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobEventsStream(t *testing.T) {
+	st := store.NewInMemoryCompareJobStore()
+	processingJob(t, st, "job_1")
+	events := openEvents(t, st, "job_1")
+
+	if ev := nextEvent(t, events); ev.name != "status" || ev.data["status"] != "processing" || ev.data["jobId"] != "job_1" {
+		t.Fatalf("first event = %s %v, want the current status", ev.name, ev.data)
+	}
+
+	if _, _, err := st.Update("job_1", func(j *domain.CompareJob) {
+		j.Progress = &domain.CompareJobProgress{Phase: "compare", Percent: 40}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events); ev.name != "progress" || ev.data["phase"] != "compare" || ev.data["percent"] != float64(40) {
+		t.Fatalf("event = %s %v, want progress", ev.name, ev.data)
+	}
+
+	if _, _, err := store.Transition(st, "job_1", domain.CompareJobStatusAwaitingPayment, domain.ActorCompareWorker, "", func(j *domain.CompareJob) {
+		j.AmountYuan, j.CodeURL = 2, "weixin://x"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events); ev.name != "payment" || ev.data["paid"] != false || ev.data["code_url"] != "weixin://x" {
+		t.Fatalf("event = %s %v, want payment", ev.name, ev.data)
+	}
+	if ev := nextEvent(t, events); ev.name != "status" || ev.data["status"] != "awaiting_payment" || ev.data["amount"] != float64(2) {
+		t.Fatalf("event = %s %v, want awaiting_payment", ev.name, ev.data)
+	}
+
+	// Ready is terminal: the last status is sent and the server closes the stream.
+	if _, _, err := store.Transition(st, "job_1", domain.CompareJobStatusReady, domain.ActorWeChatNotify, "paid", func(j *domain.CompareJob) {
+		j.Paid, j.CodeURL = true, ""
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events); ev.name != "payment" || ev.data["paid"] != true {
+		t.Fatalf("event = %s %v, want paid", ev.name, ev.data)
+	}
+	if ev := nextEvent(t, events); ev.name != "status" || ev.data["status"] != "ready" {
+		t.Fatalf("event = %s %v, want ready", ev.name, ev.data)
+	}
+	expectEnd(t, events)
+}
+
+func TestJobEventsClosesOnTerminalSnapshot(t *testing.T) {
+	st := store.NewInMemoryCompareJobStore()
+	processingJob(t, st, "job_1")
+	if _, _, err := store.Transition(st, "job_1", domain.CompareJobStatusCancelled, domain.ActorAPI, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	events := openEvents(t, st, "job_1")
+	if ev := nextEvent(t, events); ev.name != "status" || ev.data["status"] != "cancelled" {
+		t.Fatalf("event = %s %v", ev.name, ev.data)
+	}
+	expectEnd(t, events)
+}
+
+// racyStore changes the job right after the handler's first Get read it, like a worker finishing
+// between the read and the subscription.
+type racyStore struct {
+	*store.InMemoryCompareJobStore
+	t       *testing.T
+	mu      sync.Mutex
+	watched bool
+	raced   bool
+}
+
+func (s *racyStore) Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error) {
+	s.mu.Lock()
+	s.watched = true
+	s.mu.Unlock()
+	return s.InMemoryCompareJobStore.Watch(ctx, id)
+}
+
+func (s *racyStore) Get(id string) (*domain.CompareJob, bool, error) {
+	j, ok, err := s.InMemoryCompareJobStore.Get(id)
+	s.mu.Lock()
+	first := !s.raced
+	s.raced = true
+	watched := s.watched
+	s.mu.Unlock()
+	if first {
+		if !watched {
+			s.t.Error("first Get before Watch")
+		}
+		if _, _, err := store.Transition(s.InMemoryCompareJobStore, id, domain.CompareJobStatusFailed, domain.ActorCompareWorker, "boom", nil); err != nil {
+			s.t.Error(err)
+		}
+	}
+	return j, ok, err
+}
+
+func TestJobEventsSubscribesBeforeFirstGet(t *testing.T) {
+	st := &racyStore{InMemoryCompareJobStore: store.NewInMemoryCompareJobStore(), t: t}
+	processingJob(t, st.InMemoryCompareJobStore, "job_1")
+	events := openEvents(t, st, "job_1")
+
+	if ev := nextEvent(t, events); ev.name != "status" || ev.data["status"] != "processing" {
+		t.Fatalf("first event = %s %v", ev.name, ev.data)
+	}
+	// The change made after the snapshot still arrives through the watch.
+	if ev := nextEvent(t, events); ev.name != "status" || ev.data["status"] != "failed" {
+		t.Fatalf("event = %s %v, want failed", ev.name, ev.data)
+	}
+	expectEnd(t, events)
+}
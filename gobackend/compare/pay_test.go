@@ -0,0 +1,119 @@
+package compare
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gobackend/auth"
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/wallet"
+)
+
+// cancellingStore cancels the job right before the first Update, like a user cancelling while the
+// wallet payment is in flight.
+type cancellingStore struct {
+	*store.InMemoryCompareJobStore
+	cancelled bool
+}
+
+func (s *cancellingStore) Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
+	if !s.cancelled {
+		s.cancelled = true
+		if _, _, err := store.Transition(s.InMemoryCompareJobStore, id, domain.CompareJobStatusCancelled, domain.ActorAPI, "test", nil); err != nil {
+			return nil, false, err
+		}
+	}
+	return s.InMemoryCompareJobStore.Update(id, fn)
+}
+
+func newPayTest(t *testing.T, st store.CompareJobStore) (*http.ServeMux, *wallet.Wallet, store.LedgerStore) {
+	t.Helper()
+	t.Setenv("WECHAT_MOCK", "1")
+	ledger := store.NewInMemoryLedgerStore()
+	w := wallet.New(ledger)
+	tp, err := w.CreateTopUp("u1", 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreditTopUp(tp.ID, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &domain.CompareJob{ID: "job_1", OwnerID: "u1", CreatedAt: time.Now(), AmountYuan: 2, CodeURL: "weixin://mock"}
+	for _, to := range []domain.CompareJobStatus{domain.CompareJobStatusProcessing, domain.CompareJobStatusAwaitingPayment} {
+		if err := job.Transition(to, domain.ActorAPI, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Create(job); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(st, nil, t.TempDir(), nil)
+	svc.SetWallet(w)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux)
+	return mux, w, ledger
+}
+
+func postPay(mux *http.ServeMux, jobID string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/compare/jobs/"+jobID+"/pay", nil)
+	r = r.WithContext(auth.WithUserID(r.Context(), "u1"))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestPayJobFromWallet(t *testing.T) {
+	st := store.NewInMemoryCompareJobStore()
+	mux, w, _ := newPayTest(t, st)
+
+	for i := 0; i < 2; i++ {
+		if rec := postPay(mux, "job_1"); rec.Code != http.StatusOK {
+			t.Fatalf("pay %d: %d %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	job, _, _ := st.Get("job_1")
+	if !job.Paid || job.PaidVia != domain.PaidViaWallet || job.Status != domain.CompareJobStatusReady {
+		t.Fatalf("job after pay: paid=%v via=%s status=%s", job.Paid, job.PaidVia, job.Status)
+	}
+	if available, _, _ := w.Balance("u1"); available != 300 {
+		t.Fatalf("balance = %d, want 300 (charged once)", available)
+	}
+}
+
+func TestPayJobRefundsWhenTransitionFails(t *testing.T) {
+	st := &cancellingStore{InMemoryCompareJobStore: store.NewInMemoryCompareJobStore()}
+	mux, w, ledger := newPayTest(t, st)
+
+	if rec := postPay(mux, "job_1"); rec.Code != http.StatusConflict {
+		t.Fatalf("pay: %d %s, want 409", rec.Code, rec.Body)
+	}
+	job, _, _ := st.Get("job_1")
+	if job.Paid || job.Status != domain.CompareJobStatusCancelled {
+		t.Fatalf("job: paid=%v status=%s", job.Paid, job.Status)
+	}
+	if available, held, _ := w.Balance("u1"); available != 500 || held != 0 {
+		t.Fatalf("balance = %d / %d held, want the charge refunded", available, held)
+	}
+	if _, ok, _ := ledger.GetJournal("charge:job:job_1"); !ok {
+		t.Fatal("no charge journal")
+	}
+	if _, ok, _ := ledger.GetJournal("refund:job:job_1"); !ok {
+		t.Fatal("no refund journal")
+	}
+	if rev, _ := ledger.Balance(domain.LedgerAccountRevenue); rev != 0 {
+		t.Fatalf("revenue = %d, want 0", rev)
+	}
+
+	// Paying again is refused without charging a second time.
+	if rec := postPay(mux, "job_1"); rec.Code != http.StatusConflict {
+		t.Fatalf("second pay: %d %s", rec.Code, rec.Body)
+	}
+	if available, _, _ := w.Balance("u1"); available != 500 {
+		t.Fatalf("balance after second pay = %d", available)
+	}
+}
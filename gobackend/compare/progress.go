@@ -0,0 +1,158 @@
+package compare
+
+import (
+	"sync"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/excelcmp"
+	"gobackend/store"
+)
+
+// phaseWeights splits 0-100% across the worker phases (sum = 100). Row-based phases
+// advance within their share by rows processed.
+var phaseWeights = []struct {
+	phase  domain.CompareJobPhase
+	weight float64
+}{
+	{domain.CompareJobPhaseDownloading, 5},
+	{domain.CompareJobPhaseConverting, 5},
+	{domain.CompareJobPhaseReadingFile1, 25},
+	{domain.CompareJobPhaseReadingFile2, 25},
+	{domain.CompareJobPhaseDiffing, 25},
+	{domain.CompareJobPhaseWritingExport, 10},
+	{domain.CompareJobPhaseUploading, 5},
+}
+
+func phaseIndex(p domain.CompareJobPhase) int {
+	for i, pw := range phaseWeights {
+		if pw.phase == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// progressReporter turns phase/row events into CompareJob.Progress and writes them to the
+// store at most every `interval` (phase changes are always written).
+// It is safe for concurrent use: excelcmp reads both inputs at the same time.
+type progressReporter struct {
+	store    store.CompareJobStore
+	jobID    string
+	interval time.Duration
+
+	mu        sync.Mutex
+	frac      []float64 // per phaseWeights entry, 0..1
+	phase     domain.CompareJobPhase
+	rowsDone  int64
+	rowsTotal int64
+	lastSave  time.Time
+	lastPhase domain.CompareJobPhase
+	lastPct   int
+
+	wmu     sync.Mutex // serializes store writes so an older snapshot never overwrites a newer one
+	written time.Time
+}
+
+func newProgressReporter(st store.CompareJobStore, jobID string, interval time.Duration) *progressReporter {
+	return &progressReporter{
+		store:    st,
+		jobID:    jobID,
+		interval: interval,
+		frac:     make([]float64, len(phaseWeights)),
+		lastPct:  -1,
+	}
+}
+
+// set records `done` of `total` units for a phase; earlier phases count as complete.
+func (p *progressReporter) set(phase domain.CompareJobPhase, done, total int64) {
+	if p == nil {
+		return
+	}
+	idx := phaseIndex(phase)
+	if idx < 0 {
+		return
+	}
+	p.mu.Lock()
+	for i := 0; i < idx; i++ {
+		// Both inputs are read concurrently: file2 progress says nothing about file1.
+		if phase == domain.CompareJobPhaseReadingFile2 && phaseWeights[i].phase == domain.CompareJobPhaseReadingFile1 {
+			continue
+		}
+		p.frac[i] = 1
+	}
+	f := 0.0
+	if total > 0 {
+		f = float64(done) / float64(total)
+	}
+	if f > 1 {
+		f = 1
+	}
+	if f > p.frac[idx] {
+		p.frac[idx] = f
+	}
+	p.phase = phase
+	if phase == domain.CompareJobPhaseReadingFile2 && p.frac[phaseIndex(domain.CompareJobPhaseReadingFile1)] < 1 {
+		// Show the earlier (still running) read phase until file1 finishes.
+		p.phase = domain.CompareJobPhaseReadingFile1
+	}
+	p.rowsDone, p.rowsTotal = 0, 0
+	if phase == domain.CompareJobPhaseReadingFile1 || phase == domain.CompareJobPhaseReadingFile2 || phase == domain.CompareJobPhaseDiffing {
+		p.rowsDone, p.rowsTotal = done, total
+	}
+	pct := p.percentLocked()
+	now := time.Now()
+	save := p.phase != p.lastPhase || (pct != p.lastPct && (pct == 100 || now.Sub(p.lastSave) >= p.interval))
+	var snapshot domain.CompareJobProgress
+	if save {
+		p.lastSave, p.lastPhase, p.lastPct = now, p.phase, pct
+		snapshot = domain.CompareJobProgress{
+			Phase:     p.phase,
+			Percent:   pct,
+			RowsDone:  p.rowsDone,
+			RowsTotal: p.rowsTotal,
+			UpdatedAt: now,
+		}
+	}
+	p.mu.Unlock()
+
+	if save {
+		p.write(snapshot)
+	}
+}
+
+// excelcmpHook adapts excelcmp progress events (their phase names match domain phases).
+func (p *progressReporter) excelcmpHook(ev excelcmp.Progress) {
+	p.set(domain.CompareJobPhase(ev.Phase), ev.Done, ev.Total)
+}
+
+func (p *progressReporter) percentLocked() int {
+	sum := 0.0
+	for i, pw := range phaseWeights {
+		sum += pw.weight * p.frac[i]
+	}
+	pct := int(sum)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+func (p *progressReporter) write(snapshot domain.CompareJobProgress) {
+	if p.store == nil {
+		return
+	}
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if snapshot.UpdatedAt.Before(p.written) {
+		return
+	}
+	p.written = snapshot.UpdatedAt
+	_, _, _ = p.store.Update(p.jobID, func(j *domain.CompareJob) {
+		if j.Status != domain.CompareJobStatusProcessing {
+			return
+		}
+		cp := snapshot // fresh pointer: readers may still hold the previous one
+		j.Progress = &cp
+	})
+}
@@ -8,6 +8,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -17,11 +20,15 @@ import (
 	"strings"
 	"time"
 
+	"gobackend/auth"
 	"gobackend/domain"
 	"gobackend/excelcmp"
-	"gobackend/ossstore"
+	"gobackend/objstore"
+	"gobackend/pricing"
 	"gobackend/store"
 	"gobackend/streamq"
+	"gobackend/wallet"
+	"gobackend/webhook"
 	"gobackend/wechat"
 )
 
@@ -30,10 +37,25 @@ type Service struct {
 	queue    streamq.CompareQueue
 	tmpRoot  string
 	inflight chan struct{}
-	oss      *ossstore.Store
+	oss      objstore.Store
+
+	// Optional large-upload lane (see SetLargeLane).
+	largeQueue     streamq.CompareQueue
+	largeLaneBytes int64
+
+	// requireLogin rejects anonymous uploads (see SetRequireLogin).
+	requireLogin bool
+
+	// wallet enables paying jobs from the wallet balance (see SetWallet).
+	wallet *wallet.Wallet
+	// payments backs POST /compare/jobs/{id}/check-payment (see SetPayments).
+	payments *wechat.Payments
+	// pricer checks upload coupons and quotes jobs compared in-process (otherwise the paygate
+	// quotes them).
+	pricer *pricing.Engine
 }
 
-func NewService(st store.CompareJobStore, q streamq.CompareQueue, tmpRoot string, oss *ossstore.Store) *Service {
+func NewService(st store.CompareJobStore, q streamq.CompareQueue, tmpRoot string, oss objstore.Store) *Service {
 	maxInflight := readEnvIntDefault("COMPARE_MAX_INFLIGHT", 4)
 	if maxInflight <= 0 {
 		maxInflight = 1
@@ -44,21 +66,45 @@ func NewService(st store.CompareJobStore, q streamq.CompareQueue, tmpRoot string
 		tmpRoot:  tmpRoot,
 		inflight: make(chan struct{}, maxInflight),
 		oss:      oss,
+		pricer:   pricing.NewEngineFromEnv(),
 	}
 }
 
-// FeeFen returns fee in "fen" (1 yuan = 100 fen).
-// Default is 0 (free). You can override by setting env COMPARE_JOB_FEE_FEN (non-negative integer).
-func FeeFen() int64 {
-	raw := strings.TrimSpace(os.Getenv("COMPARE_JOB_FEE_FEN"))
-	if raw == "" {
-		return 0
+// SetLargeLane routes jobs whose uploads total at least thresholdBytes to q (the large lane);
+// everything else stays on the standard queue.
+func (s *Service) SetLargeLane(q streamq.CompareQueue, thresholdBytes int64) {
+	if s == nil {
+		return
+	}
+	s.largeQueue = q
+	s.largeLaneBytes = thresholdBytes
+}
+
+// SetRequireLogin makes POST /compare/jobs require a logged-in user. Otherwise anonymous jobs
+// are allowed and readable by anyone holding the job ID.
+func (s *Service) SetRequireLogin(v bool) {
+	if s == nil {
+		return
 	}
-	n, err := strconv.ParseInt(raw, 10, 64)
-	if err != nil || n < 0 {
-		return 0
+	s.requireLogin = v
+}
+
+// SetWallet enables POST /compare/jobs/{id}/pay, which pays an awaiting_payment job from the
+// owner's wallet instead of the WeChat QR code.
+func (s *Service) SetWallet(w *wallet.Wallet) {
+	if s == nil {
+		return
 	}
-	return n
+	s.wallet = w
+}
+
+// SetPayments enables POST /compare/jobs/{id}/check-payment, which asks WeChat Pay about an
+// awaiting_payment job when the user says they paid but no notify came.
+func (s *Service) SetPayments(p *wechat.Payments) {
+	if s == nil {
+		return
+	}
+	s.payments = p
 }
 
 func (s *Service) RegisterRoutes(mux *http.ServeMux) {
@@ -71,10 +117,18 @@ func (s *Service) handleCreateJob(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if r.Method == http.MethodGet {
+		s.handleListJobs(w, r)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.requireLogin && auth.UserID(r.Context()) == "" {
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+		return
+	}
 
 	// Stream multipart to disk to reduce memory usage (avoid ParseMultipartForm buffering).
 	maxUploadMB := readEnvIntDefault("COMPARE_MAX_UPLOAD_MB", 128)
@@ -100,6 +154,14 @@ func (s *Service) handleCreateJob(w http.ResponseWriter, r *http.Request) {
 		file2Path string
 		file1Name string
 		file2Name string
+		basePath  string
+		baseName  string
+		modeField string
+		precField string
+		cbField   string
+		coupon    string
+		// uploadBytes is the total size of file1/file2/base (picks the lane).
+		uploadBytes int64
 	)
 	for {
 		part, err := mr.NextPart()
@@ -114,7 +176,27 @@ func (s *Service) handleCreateJob(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		name := strings.TrimSpace(part.FormName())
-		if name != "file1" && name != "file2" {
+		if name == "mode" || name == "precedence" || name == "callback_url" || name == "coupon" {
+			// Small text fields (optional): read at most 64 bytes (callback_url: 4KB).
+			limit := int64(64)
+			if name == "callback_url" {
+				limit = 4 << 10
+			}
+			b, _ := io.ReadAll(io.LimitReader(part, limit))
+			_ = part.Close()
+			switch name {
+			case "mode":
+				modeField = strings.ToLower(strings.TrimSpace(string(b)))
+			case "precedence":
+				precField = strings.TrimSpace(string(b))
+			case "coupon":
+				coupon = string(b)
+			default:
+				cbField = strings.TrimSpace(string(b))
+			}
+			continue
+		}
+		if name != "file1" && name != "file2" && name != "base" {
 			// Drain unknown parts to keep parser healthy.
 			_, _ = io.Copy(io.Discard, part)
 			_ = part.Close()
@@ -122,28 +204,70 @@ func (s *Service) handleCreateJob(w http.ResponseWriter, r *http.Request) {
 		}
 
 		fn := safeBaseNameFromName(part.FileName())
-		prefix := "file1_"
-		if name == "file2" {
-			prefix = "file2_"
-		}
-		dst, err := saveUploadTo(jobDir, prefix+fn, part)
+		dst, err := saveUploadTo(jobDir, name+"_"+fn, part)
 		_ = part.Close()
 		if err != nil {
 			http.Error(w, "failed to save "+name, http.StatusInternalServerError)
 			return
 		}
-		if name == "file1" {
+		if fi, err := os.Stat(dst); err == nil {
+			uploadBytes += fi.Size()
+		}
+		switch name {
+		case "file1":
 			file1Path = dst
 			file1Name = fn
-		} else {
+		case "file2":
 			file2Path = dst
 			file2Name = fn
+		case "base":
+			basePath = dst
+			baseName = fn
 		}
 	}
 	if file1Path == "" || file2Path == "" {
 		http.Error(w, "missing file1 or file2", http.StatusBadRequest)
 		return
 	}
+	// mode=merge writes one merged workbook (precedence: file1 / file2 / nonempty).
+	var mergePrec string
+	switch domain.CompareMode(modeField) {
+	case "", domain.CompareModeDiff, domain.CompareModeThreeWay:
+	case domain.CompareModeMerge:
+		if basePath != "" {
+			http.Error(w, "merge 模式不支持 base 文件", http.StatusBadRequest)
+			return
+		}
+		prec, err := excelcmp.ParseMergePrecedence(precField)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mergePrec = string(prec)
+	default:
+		http.Error(w, "unsupported mode", http.StatusBadRequest)
+		return
+	}
+	// Optional discount code, applied when the paygate prices the job.
+	coupon, err = s.pricer.CheckCoupon(coupon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Optional callback_url: POSTed (signed) when the job becomes ready / failed / awaiting_payment.
+	var callbackURL string
+	if cbField != "" {
+		if !webhook.Enabled() {
+			http.Error(w, "未配置 WEBHOOK_SECRET：不支持 callback_url", http.StatusBadRequest)
+			return
+		}
+		u, err := webhook.ValidateCallbackURL(cbField)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		callbackURL = u
+	}
 
 	if s.oss == nil || !s.oss.Enabled() {
 		http.Error(w, "OSS 未启用：无法在 worker 模式下处理上传", http.StatusServiceUnavailable)
@@ -162,33 +286,60 @@ func (s *Service) handleCreateJob(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "上传 OSS 失败: "+err.Error(), http.StatusBadGateway)
 		return
 	}
+	// Optional base file: switches the job to three-way mode (file1=left, file2=right).
+	mode := domain.CompareModeDiff
+	if mergePrec != "" {
+		mode = domain.CompareModeMerge
+	}
+	var baseKey string
+	if basePath != "" {
+		mode = domain.CompareModeThreeWay
+		baseKey = s.oss.ObjectKeyForInput(jobID, "base", baseName)
+		if err := s.oss.PutFileFromPath(baseKey, basePath, excelContentTypeByName(baseName)); err != nil {
+			http.Error(w, "上传 OSS 失败: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
 	// Best-effort cleanup: local inputs are no longer needed.
-	_ = os.Remove(file1Path)
-	_ = os.Remove(file2Path)
 	_ = os.RemoveAll(jobDir)
 
+	lane, queue := domain.CompareLaneStandard, s.queue
+	if s.largeQueue != nil && s.largeLaneBytes > 0 && uploadBytes >= s.largeLaneBytes {
+		lane, queue = domain.CompareLaneLarge, s.largeQueue
+	}
+
 	job := &domain.CompareJob{
 		ID:          jobID,
-		Status:      domain.CompareJobStatusProcessing,
 		CreatedAt:   time.Now(),
+		Mode:        mode,
 		File1Path:   "",
 		File2Path:   "",
 		File1OSSKey: key1,
 		File2OSSKey: key2,
 		File1Name:   file1Name,
 		File2Name:   file2Name,
+		BaseOSSKey:  baseKey,
+		BaseName:    baseName,
 		Paid:        false,
-	}
+
+		MergePrecedence: mergePrec,
+		CallbackURL:     callbackURL,
+		Coupon:          coupon,
+		Lane:            lane,
+		Tenant:          tenantFromRequest(r),
+		OwnerID:         auth.UserID(r.Context()),
+	}
+	_ = job.Transition(domain.CompareJobStatusProcessing, tenantFromRequest(r), "created")
 	_ = s.store.Create(job)
 
 	// Enqueue background compare in Redis Streams (handled by compare-worker)
-	if s.queue != nil {
+	if queue != nil {
 		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 		defer cancel()
-		if err := s.queue.Enqueue(ctx, jobID); err != nil {
-			_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-				j.Status = domain.CompareJobStatusFailed
-				j.Error = "投递任务失败: " + err.Error()
+		if err := queue.Enqueue(ctx, jobID); err != nil {
+			msg := "投递任务失败: " + err.Error()
+			_, _, _ = store.Transition(s.store, jobID, domain.CompareJobStatusFailed, domain.ActorAPI, msg, func(j *domain.CompareJob) {
+				j.Error = msg
 			})
 			http.Error(w, "投递任务失败", http.StatusBadGateway)
 			return
@@ -205,6 +356,10 @@ func (s *Service) handleJobRoutes(w http.ResponseWriter, r *http.Request) {
 	// /compare/jobs/{jobId}
 	// /compare/jobs/{jobId}/export
 	// /compare/jobs/{jobId}/cancel
+	// /compare/jobs/{jobId}/events
+	// /compare/jobs/{jobId}/history
+	// /compare/jobs/{jobId}/pay
+	// /compare/jobs/{jobId}/check-payment
 	path := strings.TrimPrefix(r.URL.Path, "/compare/jobs/")
 	path = strings.Trim(path, "/")
 	if path == "" {
@@ -257,6 +412,58 @@ func (s *Service) handleJobRoutes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(parts) == 2 && parts[1] == "events" {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleJobEvents(w, r, jobID)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "history" {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleJobHistory(w, r, jobID)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "pay" {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handlePayJob(w, r, jobID)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "check-payment" {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleCheckPayment(w, r, jobID)
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
@@ -266,16 +473,111 @@ func (s *Service) handleGetJob(w http.ResponseWriter, r *http.Request, jobID str
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
-	if !ok {
+	if !ok || !canAccess(r, job) {
 		http.NotFound(w, r)
 		return
 	}
-	// 防御性：若已支付且结果已生成，但状态仍停留在 awaiting_payment，则对外视为 ready
-	// （避免轮询端一直弹支付框）。
+	writeJSON(w, http.StatusOK, jobView(job))
+}
+
+// handleJobHistory returns the job's status changes, oldest first.
+func (s *Service) handleJobHistory(w http.ResponseWriter, r *http.Request, jobID string) {
+	job, ok, err := s.store.Get(jobID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || !canAccess(r, job) {
+		http.NotFound(w, r)
+		return
+	}
+	events := job.Events
+	if events == nil {
+		events = []domain.CompareJobEvent{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jobId":  job.ID,
+		"status": string(publicStatus(job)),
+		"events": events,
+	})
+}
+
+// handleListJobs: GET /compare/jobs?status=&from=&to=&cursor=&limit= lists the caller's jobs,
+// newest first. from/to are RFC 3339; pass nextCursor back as cursor for the next page.
+func (s *Service) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	owner := auth.UserID(r.Context())
+	if owner == "" {
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+		return
+	}
+	qv := r.URL.Query()
+	q := store.JobQuery{
+		Owner:  owner,
+		Status: domain.CompareJobStatus(strings.TrimSpace(qv.Get("status"))),
+		Cursor: strings.TrimSpace(qv.Get("cursor")),
+	}
+	switch q.Status {
+	case "", domain.CompareJobStatusProcessing, domain.CompareJobStatusAwaitingPayment,
+		domain.CompareJobStatusReady, domain.CompareJobStatusFailed, domain.CompareJobStatusCancelled:
+	default:
+		http.Error(w, "status 无效", http.StatusBadRequest)
+		return
+	}
+	for name, dst := range map[string]*time.Time{"from": &q.CreatedFrom, "to": &q.CreatedTo} {
+		if raw := strings.TrimSpace(qv.Get(name)); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, name+" 需为 RFC 3339 时间", http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if raw := strings.TrimSpace(qv.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit 无效", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	page, err := s.store.List(q)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	jobs := make([]map[string]interface{}, 0, len(page.Jobs))
+	for _, j := range page.Jobs {
+		v := jobView(j)
+		v["file1Name"] = j.File1Name
+		v["file2Name"] = j.File2Name
+		jobs = append(jobs, v)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs":       jobs,
+		"nextCursor": page.NextCursor,
+	})
+}
+
+// publicStatus is the status shown to clients.
+// 防御性：若已支付且结果已生成，但状态仍停留在 awaiting_payment，则对外视为 ready
+// （避免轮询端一直弹支付框）。
+func publicStatus(job *domain.CompareJob) domain.CompareJobStatus {
 	status := job.Status
 	if status == domain.CompareJobStatusAwaitingPayment && job.Paid && hasResult(job) {
 		status = domain.CompareJobStatusReady
 	}
+	return status
+}
+
+// jobView is the safe subset of a job returned by GET /compare/jobs/{id} and the events stream.
+func jobView(job *domain.CompareJob) map[string]interface{} {
+	status := publicStatus(job)
 	// Return a safe subset
 	resp := map[string]interface{}{
 		"jobId":     job.ID,
@@ -283,9 +585,24 @@ func (s *Service) handleGetJob(w http.ResponseWriter, r *http.Request, jobID str
 		"createdAt": job.CreatedAt,
 		"paid":      job.Paid,
 	}
+	if job.Mode != "" {
+		resp["mode"] = string(job.Mode)
+	}
+	if job.MergePrecedence != "" {
+		resp["precedence"] = job.MergePrecedence
+	}
+	if job.Lane != "" {
+		resp["lane"] = string(job.Lane)
+	}
 	if status == domain.CompareJobStatusAwaitingPayment {
 		resp["amount"] = job.AmountYuan
 		resp["code_url"] = job.CodeURL
+		if job.Price != nil {
+			resp["price"] = job.Price
+		}
+	}
+	if job.Progress != nil {
+		resp["progress"] = job.Progress
 	}
 	if job.Status == domain.CompareJobStatusFailed && job.Error != "" {
 		resp["error"] = job.Error
@@ -296,7 +613,16 @@ func (s *Service) handleGetJob(w http.ResponseWriter, r *http.Request, jobID str
 	if job.PaidAt != nil {
 		resp["paidAt"] = job.PaidAt
 	}
-	writeJSON(w, http.StatusOK, resp)
+	if len(job.Refunds) > 0 {
+		resp["refund"] = map[string]interface{}{
+			"status":      job.RefundState(),
+			"refundedFen": job.RefundedFen(),
+		}
+	}
+	if len(job.WebhookAttempts) > 0 {
+		resp["webhookAttempts"] = job.WebhookAttempts
+	}
+	return resp
 }
 
 func (s *Service) handleCancelJob(w http.ResponseWriter, r *http.Request, jobID string) {
@@ -305,7 +631,7 @@ func (s *Service) handleCancelJob(w http.ResponseWriter, r *http.Request, jobID
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
-	if !ok {
+	if !ok || !canAccess(r, job) {
 		http.NotFound(w, r)
 		return
 	}
@@ -335,14 +661,18 @@ func (s *Service) handleCancelJob(w http.ResponseWriter, r *http.Request, jobID
 	}
 
 	now := time.Now()
-	_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-		// Don't overwrite if paid concurrently.
-		if j.Paid {
-			return
-		}
-		j.Status = domain.CompareJobStatusCancelled
+	// Rejected if it got paid (or finished) concurrently.
+	_, _, err = store.Transition(s.store, jobID, domain.CompareJobStatusCancelled, tenantFromRequest(r), "user cancelled", func(j *domain.CompareJob) {
 		j.CancelledAt = &now
 	})
+	if errors.Is(err, domain.ErrIllegalTransition) {
+		http.Error(w, "订单已支付或已放行，无法取消", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"jobId":     jobID,
@@ -351,13 +681,131 @@ func (s *Service) handleCancelJob(w http.ResponseWriter, r *http.Request, jobID
 	})
 }
 
+// handlePayJob pays an awaiting_payment job from the caller's wallet: charge, close the WeChat
+// order, release the result. The charge is keyed by job, so retries never pay twice; it is
+// refunded if the job can't be released (e.g. cancelled or paid by QR meanwhile).
+func (s *Service) handlePayJob(w http.ResponseWriter, r *http.Request, jobID string) {
+	if s.wallet == nil {
+		http.Error(w, "钱包支付未启用", http.StatusNotImplemented)
+		return
+	}
+	uid := auth.UserID(r.Context())
+	if uid == "" {
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+		return
+	}
+	job, ok, err := s.store.Get(jobID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || !canAccess(r, job) {
+		http.NotFound(w, r)
+		return
+	}
+	if job.Paid {
+		if job.PaidVia == domain.PaidViaWallet {
+			writeJSON(w, http.StatusOK, jobView(job))
+			return
+		}
+		http.Error(w, "订单已支付", http.StatusConflict)
+		return
+	}
+	if job.Status != domain.CompareJobStatusAwaitingPayment {
+		http.Error(w, "任务当前不可支付", http.StatusConflict)
+		return
+	}
+	amountFen := int64(math.Round(job.AmountYuan * 100))
+	ref := "job:" + jobID
+	if _, err := s.wallet.Charge(uid, ref, amountFen, "比对任务 "+jobID); err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInsufficientFunds):
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		case errors.Is(err, wallet.ErrChargeRefunded), errors.Is(err, wallet.ErrKeyConflict):
+			http.Error(w, "钱包支付失败，请扫码支付", http.StatusConflict)
+		default:
+			log.Printf("compare pay: charge job=%s user=%s: %v", jobID, uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	refund := func(why string) {
+		if err := s.wallet.Refund(ref, why); err != nil {
+			log.Printf("compare pay: refund job=%s user=%s: %v", jobID, uid, err)
+		}
+	}
+	if err := wechat.CloseNativeOrder(jobID); err != nil {
+		refund("关闭微信订单失败")
+		http.Error(w, "关闭微信订单失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	now := time.Now()
+	var terr error
+	updated, ok, err := s.store.Update(jobID, func(j *domain.CompareJob) {
+		// Paid by QR in the meantime (ready -> ready would pass the state machine).
+		if j.Paid {
+			terr = &domain.TransitionError{From: j.Status, To: domain.CompareJobStatusReady, Why: "已支付"}
+			return
+		}
+		if terr = j.Transition(domain.CompareJobStatusReady, "user:"+uid, "paid from wallet"); terr != nil {
+			return
+		}
+		j.Paid = true
+		j.PaidAt = &now
+		j.PaidVia = domain.PaidViaWallet
+		j.AmountYuan = 0
+		j.CodeURL = ""
+	})
+	if err != nil {
+		log.Printf("compare pay: update job=%s: %v", jobID, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || terr != nil {
+		refund("任务状态已变化")
+		http.Error(w, "任务当前不可支付", http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobView(updated))
+}
+
+// handleCheckPayment is the "I have paid" button: for an awaiting_payment job it queries the
+// WeChat order and applies a payment the notify missed. It answers with the job as it is now.
+func (s *Service) handleCheckPayment(w http.ResponseWriter, r *http.Request, jobID string) {
+	if s.payments == nil {
+		http.Error(w, "支付查询未启用", http.StatusNotImplemented)
+		return
+	}
+	job, ok, err := s.store.Get(jobID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || !canAccess(r, job) {
+		http.NotFound(w, r)
+		return
+	}
+	if job.Status == domain.CompareJobStatusAwaitingPayment && !job.Paid && job.CodeURL != "" {
+		if _, err := s.payments.Check(jobID, tenantFromRequest(r)); err != nil && !errors.Is(err, wechat.ErrOrderNotExist) {
+			log.Printf("compare check-payment: job=%s: %v", jobID, err)
+			http.Error(w, "查询微信订单失败", http.StatusBadGateway)
+			return
+		}
+		if job, ok, err = s.store.Get(jobID); err != nil || !ok {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, jobView(job))
+}
+
 func (s *Service) handleDownloadExport(w http.ResponseWriter, r *http.Request, jobID string) {
 	job, ok, err := s.store.Get(jobID)
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
-	if !ok {
+	if !ok || !canAccess(r, job) {
 		http.NotFound(w, r)
 		return
 	}
@@ -369,7 +817,7 @@ func (s *Service) handleDownloadExport(w http.ResponseWriter, r *http.Request, j
 		http.Error(w, "请先完成支付后再下载结果", http.StatusPaymentRequired)
 		return
 	}
-	// Prefer OSS signed URL when available (cross-pod safe).
+	// Prefer the object store's signed URL (cross-pod safe).
 	if job.ResultOSSKey != "" && s.oss != nil && s.oss.Enabled() {
 		signed, err := s.oss.SignDownloadURL(job.ResultOSSKey, "比对结果.xlsx")
 		if err != nil {
@@ -378,7 +826,7 @@ func (s *Service) handleDownloadExport(w http.ResponseWriter, r *http.Request, j
 		}
 		// 支持两种响应：
 		// - format=json：返回 {url, filename} 让前端自行 fetch(预览)/跳转(下载)
-		// - 默认：302 重定向到 OSS 签名链接（适合纯下载）
+		// - 默认：302 重定向到签名链接（适合纯下载；本地存储的链接是相对 API 的 /objects/...）
 		if wantsJSON(r) {
 			writeJSON(w, http.StatusOK, map[string]interface{}{
 				"url":      signed,
@@ -435,6 +883,38 @@ func newJobID() string {
 	return fmt.Sprintf("job_%d", time.Now().UnixNano())
 }
 
+// canAccess reports whether the caller may see and act on job (get, history, events, cancel, pay,
+// check-payment, export). Owned jobs are open only to their owner; others get 404, so job IDs
+// can't be probed. Jobs with OwnerID == "" (submitted without logging in) are open to ANYONE who
+// holds the job ID, logged in or not: the unguessable ID is their only credential.
+func canAccess(r *http.Request, job *domain.CompareJob) bool {
+	return job.OwnerID == "" || job.OwnerID == auth.UserID(r.Context())
+}
+
+// tenantFromRequest identifies who submitted a job, for per-tenant concurrency caps: the user when
+// logged in, else the client IP (X-Real-IP / X-Forwarded-For set by nginx).
+func tenantFromRequest(r *http.Request) string {
+	if id := auth.UserID(r.Context()); id != "" {
+		return "user:" + id
+	}
+	ip := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+	if ip == "" {
+		ip, _, _ = strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		ip = strings.TrimSpace(ip)
+	}
+	if ip == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip = strings.TrimSpace(host)
+	}
+	if ip == "" {
+		return ""
+	}
+	return "ip:" + ip
+}
+
 func saveUploadTo(dir, name string, src io.Reader) (string, error) {
 	if dir == "" || name == "" {
 		return "", errors.New("invalid path")
@@ -479,18 +959,12 @@ func (s *Service) runCompareTask(jobID string) {
 	)
 	new1, conv1, err := convertXLSIfNeeded(file1Path)
 	if err != nil {
-		_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-			j.Status = domain.CompareJobStatusFailed
-			j.Error = err.Error()
-		})
+		s.failTask(jobID, err.Error())
 		return
 	}
 	new2, conv2, err := convertXLSIfNeeded(file2Path)
 	if err != nil {
-		_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-			j.Status = domain.CompareJobStatusFailed
-			j.Error = err.Error()
-		})
+		s.failTask(jobID, err.Error())
 		return
 	}
 	if conv1 || conv2 {
@@ -510,10 +984,7 @@ func (s *Service) runCompareTask(jobID string) {
 	// 1) Generate export xlsx in Go (keep same semantics as previous Python implementation)
 	resultPath := filepath.Join(jobDir, "comparison_result.xlsx")
 	if err := excelcmp.GenerateCompareExportXLSX(job.File1Path, job.File2Path, job.File1Name, job.File2Name, resultPath); err != nil {
-		_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-			j.Status = domain.CompareJobStatusFailed
-			j.Error = err.Error()
-		})
+		s.failTask(jobID, err.Error())
 		return
 	}
 
@@ -522,10 +993,7 @@ func (s *Service) runCompareTask(jobID string) {
 	if s.oss != nil && s.oss.Enabled() {
 		ossKey = s.oss.ObjectKeyForJob(jobID)
 		if err := s.oss.PutResultFile(ossKey, resultPath); err != nil {
-			_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-				j.Status = domain.CompareJobStatusFailed
-				j.Error = "上传 OSS 失败: " + err.Error()
-			})
+			s.failTask(jobID, "上传 OSS 失败: "+err.Error())
 			return
 		}
 		// Best-effort cleanup: local file is no longer needed once uploaded.
@@ -558,8 +1026,7 @@ func (s *Service) runCompareTask(jobID string) {
 
 	// If payment already confirmed (rare), directly release.
 	if job.Paid {
-		_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-			j.Status = domain.CompareJobStatusReady
+		_, _, _ = store.Transition(s.store, jobID, domain.CompareJobStatusReady, domain.ActorAPI, "paid", func(j *domain.CompareJob) {
 			j.ResultPath = resultPath
 			if ossKey != "" {
 				j.ResultOSSKey = ossKey
@@ -569,19 +1036,18 @@ func (s *Service) runCompareTask(jobID string) {
 		return
 	}
 
-	feeFen := FeeFen()
+	price := s.pricer.Quote(pricing.Input{Stats: job.Stats, Mode: job.Mode, Coupon: job.Coupon})
+	feeFen := price.TotalFen
 	if feeFen <= 0 {
 		// Free: no WeChat order, directly mark paid and release result.
 		now := time.Now()
-		_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-			if j.Status == domain.CompareJobStatusCancelled {
-				return
-			}
+		_, _, _ = store.Transition(s.store, jobID, domain.CompareJobStatusReady, domain.ActorAPI, "free", func(j *domain.CompareJob) {
+			j.Price = &price
 			if !j.Paid {
 				j.Paid = true
 				j.PaidAt = &now
+				j.PaidVia = domain.PaidViaFree
 			}
-			j.Status = domain.CompareJobStatusReady
 			j.ResultPath = resultPath
 			if ossKey != "" {
 				j.ResultOSSKey = ossKey
@@ -596,33 +1062,37 @@ func (s *Service) runCompareTask(jobID string) {
 	// 2) Create Native payment (feeFen) and gate result
 	codeURL, err := wechat.CreateNativeOrder(jobID, feeFen)
 	if err != nil {
-		_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-			j.Status = domain.CompareJobStatusFailed
+		msg := "创建微信支付订单失败: " + err.Error()
+		_, _, _ = store.Transition(s.store, jobID, domain.CompareJobStatusFailed, domain.ActorAPI, msg, func(j *domain.CompareJob) {
 			j.ResultPath = resultPath
 			if ossKey != "" {
 				j.ResultOSSKey = ossKey
 				j.ResultPath = ""
 			}
-			j.Error = "创建微信支付订单失败: " + err.Error()
+			j.Error = msg
 		})
 		return
 	}
 
-	_, _, _ = s.store.Update(jobID, func(j *domain.CompareJob) {
-		if j.Status == domain.CompareJobStatusCancelled || j.Paid {
-			return
-		}
-		j.Status = domain.CompareJobStatusAwaitingPayment
+	_, _, _ = store.Transition(s.store, jobID, domain.CompareJobStatusAwaitingPayment, domain.ActorAPI, "order created", func(j *domain.CompareJob) {
 		j.ResultPath = resultPath
 		if ossKey != "" {
 			j.ResultOSSKey = ossKey
 			j.ResultPath = ""
 		}
+		j.Price = &price
 		j.AmountYuan = float64(feeFen) / 100.0
 		j.CodeURL = codeURL
 	})
 }
 
+// failTask marks an in-process compare as failed (rejected if it was cancelled meanwhile).
+func (s *Service) failTask(jobID, msg string) {
+	_, _, _ = store.Transition(s.store, jobID, domain.CompareJobStatusFailed, domain.ActorAPI, msg, func(j *domain.CompareJob) {
+		j.Error = msg
+	})
+}
+
 func hasResult(job *domain.CompareJob) bool {
 	if job == nil {
 		return false
@@ -0,0 +1,199 @@
+package compare
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gobackend/auth"
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/wallet"
+	"gobackend/wechat"
+)
+
+// newHandlerTest serves the compare routes over st.
+func newHandlerTest(t *testing.T, st store.CompareJobStore) *http.ServeMux {
+	t.Helper()
+	svc := NewService(st, nil, t.TempDir(), nil)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux)
+	return mux
+}
+
+// serveAs runs the request as user (anonymous if "").
+func serveAs(mux *http.ServeMux, user, method, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if user != "" {
+		r = r.WithContext(auth.WithUserID(r.Context(), user))
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestListJobsHandler(t *testing.T) {
+	st := store.NewInMemoryCompareJobStore()
+	now := time.Now()
+	for i, owner := range []string{"u1", "u2", "u1"} {
+		job := &domain.CompareJob{ID: "job_" + string(rune('1'+i)), OwnerID: owner, Status: domain.CompareJobStatusReady, CreatedAt: now.Add(time.Duration(i) * time.Millisecond)}
+		if err := st.Create(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := newHandlerTest(t, st)
+
+	rec := serveAs(mux, "u1", http.MethodGet, "/compare/jobs?status=ready&limit=1")
+	var page struct {
+		Jobs []struct {
+			JobID string `json:"jobId"`
+		} `json:"jobs"`
+		NextCursor string `json:"nextCursor"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &page) != nil {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body)
+	}
+	if len(page.Jobs) != 1 || page.Jobs[0].JobID != "job_3" || page.NextCursor == "" {
+		t.Fatalf("first page = %s", rec.Body)
+	}
+	rec = serveAs(mux, "u1", http.MethodGet, "/compare/jobs?status=ready&limit=1&cursor="+page.NextCursor)
+	page.Jobs, page.NextCursor = nil, ""
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &page) != nil {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body)
+	}
+	if len(page.Jobs) != 1 || page.Jobs[0].JobID != "job_1" || page.NextCursor != "" {
+		t.Fatalf("second page = %s, want u1's older job only", rec.Body)
+	}
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte("-1:job_1"))
+	for _, target := range []string{
+		"/compare/jobs?cursor=garbage!",
+		"/compare/jobs?cursor=" + forged,
+		"/compare/jobs?status=paid",
+		"/compare/jobs?from=yesterday",
+		"/compare/jobs?limit=0",
+	} {
+		if rec := serveAs(mux, "u1", http.MethodGet, target); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d, want 400", target, rec.Code)
+		}
+	}
+	if rec := serveAs(mux, "", http.MethodGet, "/compare/jobs"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous list: %d, want 401", rec.Code)
+	}
+}
+
+// ownedJobs stores u1's jobs: job_wait awaits payment, job_ready is paid with a local result.
+func ownedJobs(t *testing.T, st store.CompareJobStore, owner string) {
+	t.Helper()
+	result := filepath.Join(t.TempDir(), "result.xlsx")
+	if err := os.WriteFile(result, []byte("xlsx"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wait := &domain.CompareJob{ID: "job_wait", OwnerID: owner, CreatedAt: time.Now(), AmountYuan: 2, CodeURL: "weixin://mock"}
+	ready := &domain.CompareJob{ID: "job_ready", OwnerID: owner, CreatedAt: time.Now(), Paid: true, ResultPath: result}
+	for job, path := range map[*domain.CompareJob][]domain.CompareJobStatus{
+		wait:  {domain.CompareJobStatusProcessing, domain.CompareJobStatusAwaitingPayment},
+		ready: {domain.CompareJobStatusProcessing, domain.CompareJobStatusReady},
+	} {
+		for _, to := range path {
+			if err := job.Transition(to, domain.ActorAPI, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := st.Create(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOtherUsersJobIsNotFound(t *testing.T) {
+	t.Setenv("WECHAT_MOCK", "1")
+	st := store.NewInMemoryCompareJobStore()
+	ownedJobs(t, st, "u1")
+	ledger := store.NewInMemoryLedgerStore()
+	w := wallet.New(ledger)
+	tp, err := w.CreateTopUp("u2", 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreditTopUp(tp.ID, 500); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(st, nil, t.TempDir(), nil)
+	svc.SetWallet(w)
+	svc.SetPayments(wechat.NewPayments(st, nil))
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux)
+
+	requests := []struct{ method, target string }{
+		{http.MethodGet, "/compare/jobs/job_wait"},
+		{http.MethodGet, "/compare/jobs/job_wait/history"},
+		{http.MethodGet, "/compare/jobs/job_wait/events"},
+		{http.MethodPost, "/compare/jobs/job_wait/cancel"},
+		{http.MethodPost, "/compare/jobs/job_wait/pay"},
+		{http.MethodPost, "/compare/jobs/job_wait/check-payment"},
+		{http.MethodGet, "/compare/jobs/job_ready/export"},
+		{http.MethodGet, "/compare/jobs/job_ready/export?format=json"},
+		{http.MethodGet, "/compare/jobs/job_ready/events"},
+		{http.MethodPost, "/compare/jobs/job_ready/cancel"},
+	}
+	for _, user := range []string{"u2", ""} {
+		for _, req := range requests {
+			want := http.StatusNotFound
+			if user == "" && strings.HasSuffix(req.target, "/pay") {
+				want = http.StatusUnauthorized // wallet payment needs a login first
+			}
+			if rec := serveAs(mux, user, req.method, req.target); rec.Code != want {
+				t.Errorf("%s %s as %q: %d %s, want %d", req.method, req.target, user, rec.Code, rec.Body, want)
+			}
+		}
+	}
+
+	for _, id := range []string{"job_wait", "job_ready"} {
+		job, _, _ := st.Get(id)
+		if len(job.Events) != 2 || job.CancelledAt != nil || (id == "job_wait" && job.Paid) {
+			t.Errorf("%s changed by another user: %+v", id, job)
+		}
+	}
+	if bal, _, err := w.Balance("u2"); err != nil || bal != 500 {
+		t.Fatalf("u2 balance = %d, %v, want 500 (not charged)", bal, err)
+	}
+	// The owner still gets through.
+	if rec := serveAs(mux, "u1", http.MethodGet, "/compare/jobs/job_wait"); rec.Code != http.StatusOK {
+		t.Fatalf("owner get: %d", rec.Code)
+	}
+}
+
+// Jobs submitted without logging in have no owner: anyone holding the ID may use them.
+func TestAnonymousJobOpenToIDHolders(t *testing.T) {
+	t.Setenv("WECHAT_MOCK", "1")
+	st := store.NewInMemoryCompareJobStore()
+	ownedJobs(t, st, "")
+	mux := newHandlerTest(t, st)
+
+	for _, user := range []string{"", "u2"} {
+		for _, target := range []string{
+			"/compare/jobs/job_ready",
+			"/compare/jobs/job_ready/history",
+			"/compare/jobs/job_ready/events",
+			"/compare/jobs/job_ready/export",
+			"/compare/jobs/job_wait",
+		} {
+			if rec := serveAs(mux, user, http.MethodGet, target); rec.Code != http.StatusOK {
+				t.Errorf("GET %s as %q: %d %s, want 200", target, user, rec.Code, rec.Body)
+			}
+		}
+	}
+	if rec := serveAs(mux, "u2", http.MethodPost, "/compare/jobs/job_wait/cancel"); rec.Code != http.StatusOK {
+		t.Fatalf("cancel as u2: %d %s", rec.Code, rec.Body)
+	}
+	if job, _, _ := st.Get("job_wait"); job.Status != domain.CompareJobStatusCancelled {
+		t.Fatalf("status = %s, want cancelled", job.Status)
+	}
+}
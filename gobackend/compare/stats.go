@@ -0,0 +1,57 @@
+package compare
+
+import (
+	"os"
+	"sync"
+
+	"gobackend/domain"
+	"gobackend/excelcmp"
+)
+
+// statsCollector gathers CompareJob.Stats (used for pricing) while a job runs: input sizes and
+// sheet counts from the local files, row counts from the excelcmp read phases.
+type statsCollector struct {
+	mu    sync.Mutex
+	stats domain.CompareJobStats
+}
+
+// addInputSize counts a downloaded input (before any .xls conversion).
+func (c *statsCollector) addInputSize(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.stats.InputBytes += fi.Size()
+	c.mu.Unlock()
+}
+
+// addSheets records an input's worksheet count (.xlsx, i.e. after conversion).
+func (c *statsCollector) addSheets(path string) {
+	n, err := excelcmp.SheetCount(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.stats.Sheets = max(c.stats.Sheets, n)
+	c.mu.Unlock()
+}
+
+// observe is chained into the excelcmp progress hook; each read phase ends with Done = rows read.
+func (c *statsCollector) observe(ev excelcmp.Progress) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch ev.Phase {
+	case excelcmp.PhaseReadFile1:
+		c.stats.RowsFile1 = max(c.stats.RowsFile1, ev.Done)
+	case excelcmp.PhaseReadFile2:
+		c.stats.RowsFile2 = max(c.stats.RowsFile2, ev.Done)
+	}
+}
+
+func (c *statsCollector) snapshot() *domain.CompareJobStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	return &s
+}
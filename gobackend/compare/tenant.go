@@ -0,0 +1,69 @@
+package compare
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/redislock"
+	"gobackend/streamq"
+)
+
+// SetTenantLimit caps running jobs per tenant (job.Tenant) across all workers; max<=0 disables it.
+// A job over the cap is requeued through the delayed set instead of failing, waiting longer each
+// time it is still over the cap (see streamq.Requeue).
+func (w *Worker) SetTenantLimit(sem *redislock.Semaphore, max int) {
+	if w == nil {
+		return
+	}
+	w.tenantSem = sem
+	w.tenantMax = max
+}
+
+// holdTenantSlot takes a running slot for the job's tenant and keeps it alive until release is
+// called. It returns a streamq.Requeue error when the tenant is at its cap.
+//
+// The slot holder is unique per call: a duplicate delivery of the same job takes its own slot and
+// releasing it can't free the slot of the worker actually running the job.
+func (w *Worker) holdTenantSlot(ctx context.Context, job *domain.CompareJob) (release func(), err error) {
+	noop := func() {}
+	if w.tenantSem == nil || w.tenantMax <= 0 || job.Tenant == "" {
+		return noop, nil
+	}
+	token, err := redislock.Token()
+	if err != nil {
+		return noop, err
+	}
+	key := w.tenantSem.Key(job.Tenant)
+	holder := job.ID + ":" + token
+	ok, err := w.tenantSem.Acquire(ctx, key, holder, w.tenantMax, w.lockTTL)
+	if err != nil {
+		// transient: keep pending
+		return noop, err
+	}
+	if !ok {
+		return noop, streamq.Requeue(fmt.Errorf("tenant %s 运行中任务已达上限 %d", job.Tenant, w.tenantMax))
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(w.lockKick)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if _, err := w.tenantSem.Refresh(context.Background(), key, holder, w.lockTTL); err != nil {
+					log.Printf("tenant slot refresh failed job=%s: %v", job.ID, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		_ = w.tenantSem.Release(context.Background(), key, holder)
+	}, nil
+}
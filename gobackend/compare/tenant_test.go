@@ -0,0 +1,76 @@
+package compare
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"gobackend/domain"
+	"gobackend/redislock"
+	"gobackend/streamq"
+)
+
+func newTenantTest(t *testing.T, max int) (*Worker, *redis.Client, *fakeObjStore) {
+	t.Helper()
+	w, st, oss, _ := newCancelTest(t)
+	if _, _, err := st.Update("job_1", func(j *domain.CompareJob) { j.Tenant = "u1" }); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	w.lock = redislock.New(rdb, "")
+	w.SetTenantLimit(redislock.NewSemaphore(rdb, ""), max)
+	return w, rdb, oss
+}
+
+func TestTenantCapRequeuesBeforeLocking(t *testing.T) {
+	w, rdb, oss := newTenantTest(t, 1)
+	ctx := context.Background()
+	sem := w.tenantSem
+	if ok, err := sem.Acquire(ctx, sem.Key("u1"), "job_0:x", 1, time.Hour); err != nil || !ok {
+		t.Fatalf("fill slot: %v %v", ok, err)
+	}
+	// Another delivery of job_1 holds the job lock; over the cap we back off without touching it.
+	if ok, err := w.lock.Acquire(ctx, w.lock.Key("job_1"), "other", time.Hour); err != nil || !ok {
+		t.Fatalf("lock: %v %v", ok, err)
+	}
+	oss.onGet = func(key string) { t.Errorf("downloaded %s over the tenant cap", key) }
+
+	err := w.Process(ctx, "job_1")
+	if !streamq.IsRequeue(err) || !strings.Contains(err.Error(), "上限") {
+		t.Fatalf("Process = %v, want a requeue for the tenant cap", err)
+	}
+	if tok, _ := rdb.Get(ctx, w.lock.Key("job_1")).Result(); tok != "other" {
+		t.Fatalf("job lock = %q, want it untouched", tok)
+	}
+	if holders, _ := rdb.ZRange(ctx, sem.Key("u1"), 0, -1).Result(); len(holders) != 1 || holders[0] != "job_0:x" {
+		t.Fatalf("slots = %v", holders)
+	}
+}
+
+// A duplicate delivery that finds the job locked frees only its own slot.
+func TestTenantSlotOfDuplicateDelivery(t *testing.T) {
+	w, rdb, _ := newTenantTest(t, 2)
+	ctx := context.Background()
+	sem := w.tenantSem
+	// The worker running job_1 holds a slot and the lock.
+	if ok, err := sem.Acquire(ctx, sem.Key("u1"), "job_1:running", 2, time.Hour); err != nil || !ok {
+		t.Fatalf("slot: %v %v", ok, err)
+	}
+	if ok, err := w.lock.Acquire(ctx, w.lock.Key("job_1"), "running", time.Hour); err != nil || !ok {
+		t.Fatalf("lock: %v %v", ok, err)
+	}
+
+	err := w.Process(ctx, "job_1")
+	if !streamq.IsTerminal(err) || !strings.Contains(err.Error(), "job locked") {
+		t.Fatalf("Process = %v, want the duplicate ACKed", err)
+	}
+	if holders, _ := rdb.ZRange(ctx, sem.Key("u1"), 0, -1).Result(); len(holders) != 1 || holders[0] != "job_1:running" {
+		t.Fatalf("slots = %v, want only the running worker's", holders)
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 	"gobackend/domain"
 	"gobackend/excelcmp"
-	"gobackend/ossstore"
+	"gobackend/objstore"
 	"gobackend/redislock"
 	"gobackend/store"
 	"gobackend/streamq"
@@ -22,15 +22,22 @@ import (
 type Worker struct {
 	store    store.CompareJobStore
 	tmpRoot  string
-	oss      *ossstore.Store
+	oss      objstore.Store
 	payq     streamq.CompareQueue
 	lock     *redislock.Client
 	lockTTL  time.Duration
 	lockKick time.Duration
 	inflight chan struct{}
+	// progressEvery throttles progress writes to the job store.
+	progressEvery time.Duration
+	// cancelPoll is how often a running job re-reads its status to notice a cancel.
+	cancelPoll time.Duration
+	// Per-tenant running cap (see SetTenantLimit).
+	tenantSem *redislock.Semaphore
+	tenantMax int
 }
 
-func NewWorker(st store.CompareJobStore, tmpRoot string, oss *ossstore.Store, payq streamq.CompareQueue, lock *redislock.Client) *Worker {
+func NewWorker(st store.CompareJobStore, tmpRoot string, oss objstore.Store, payq streamq.CompareQueue, lock *redislock.Client) *Worker {
 	maxInflight := readEnvIntDefault("COMPARE_MAX_INFLIGHT", 4)
 	if maxInflight <= 0 {
 		maxInflight = 1
@@ -41,14 +48,16 @@ func NewWorker(st store.CompareJobStore, tmpRoot string, oss *ossstore.Store, pa
 		lockKick = 30 * time.Second
 	}
 	return &Worker{
-		store:    st,
-		tmpRoot:  tmpRoot,
-		oss:      oss,
-		payq:     payq,
-		lock:     lock,
-		lockTTL:  lockTTL,
-		lockKick: lockKick,
-		inflight: make(chan struct{}, maxInflight),
+		store:         st,
+		tmpRoot:       tmpRoot,
+		oss:           oss,
+		payq:          payq,
+		lock:          lock,
+		lockTTL:       lockTTL,
+		lockKick:      lockKick,
+		inflight:      make(chan struct{}, maxInflight),
+		progressEvery: readEnvDurationSecondsDefault("COMPARE_PROGRESS_INTERVAL_SECONDS", time.Second),
+		cancelPoll:    readEnvDurationSecondsDefault("COMPARE_CANCEL_POLL_SECONDS", 5*time.Second),
 	}
 }
 
@@ -80,6 +89,23 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 		ctx = context.Background()
 	}
 
+	job, ok, err := w.store.Get(jobID)
+	if err != nil || !ok {
+		return err
+	}
+	if jobFinished(job) {
+		return streamq.Terminal(nil)
+	}
+	// The tenant cap is checked before the job lock, so a throttled job backs off (streamq.Requeue)
+	// without taking and releasing the lock on every try. Jobs with a result only need the paygate.
+	if strings.TrimSpace(job.ResultOSSKey) == "" {
+		releaseSlot, err := w.holdTenantSlot(ctx, job)
+		if err != nil {
+			return err
+		}
+		defer releaseSlot()
+	}
+
 	// Distributed lock: prevent duplicate processing across multiple compare-worker replicas.
 	if w.lock != nil {
 		token, err := redislock.Token()
@@ -122,14 +148,12 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 		}()
 	}
 
-	job, ok, err := w.store.Get(jobID)
+	// Re-read under the lock: another worker may have finished the job meanwhile.
+	job, ok, err = w.store.Get(jobID)
 	if err != nil || !ok {
 		return err
 	}
-	if job.Status == domain.CompareJobStatusCancelled {
-		return streamq.Terminal(nil)
-	}
-	if job.Status == domain.CompareJobStatusReady || job.Status == domain.CompareJobStatusFailed {
+	if jobFinished(job) {
 		return streamq.Terminal(nil)
 	}
 	// If result already exists, only enqueue pay-gate stage (idempotent).
@@ -151,62 +175,149 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 	if job.File1OSSKey == "" || job.File2OSSKey == "" {
 		return streamq.Terminal(w.fail(jobID, errors.New("输入文件 OSSKey 为空")))
 	}
+	threeWay := job.Mode == domain.CompareModeThreeWay
+	if threeWay && job.BaseOSSKey == "" {
+		return streamq.Terminal(w.fail(jobID, errors.New("基准文件 OSSKey 为空")))
+	}
 
-	// Mark as processing (best-effort).
-	_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
-		if j.Status == domain.CompareJobStatusCancelled {
-			return
+	// Stop early when the user cancels: jobCtx is cancelled and every step below checks it.
+	jobCtx, stopWatch := watchCancel(ctx, w.store, jobID, w.cancelPoll)
+	defer stopWatch()
+	jobDir := filepath.Join(w.tmpRoot, "compare_jobs", jobID)
+	// abort maps a failed step to the stream result: a user cancel cleans up and ACKs, a worker
+	// shutdown keeps the message pending for another worker, anything else fails the job.
+	abort := func(err error) error {
+		if jobCancelled(jobCtx) {
+			log.Printf("compare job cancelled, stopping job=%s", jobID)
+			w.cleanupCancelled(job, jobDir)
+			return streamq.Terminal(nil)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		j.Status = domain.CompareJobStatusProcessing
+		return streamq.Terminal(w.fail(jobID, err))
+	}
+
+	// Mark as processing (best-effort; a no-op for a job that already is).
+	_, _, _ = store.Transition(w.store, jobID, domain.CompareJobStatusProcessing, domain.ActorCompareWorker, "", func(j *domain.CompareJob) {
 		j.Error = ""
 	})
 
-	jobDir := filepath.Join(w.tmpRoot, "compare_jobs", jobID)
 	if err := os.MkdirAll(jobDir, 0o755); err != nil {
-		return streamq.Terminal(w.fail(jobID, fmt.Errorf("创建 jobDir 失败: %w", err)))
+		return abort(fmt.Errorf("创建 jobDir 失败: %w", err))
+	}
+
+	progress := newProgressReporter(w.store, jobID, w.progressEvery)
+	nInputs := int64(2)
+	if threeWay {
+		nInputs = 3
 	}
+	progress.set(domain.CompareJobPhaseDownloading, 0, nInputs)
+	stats := &statsCollector{}
 
 	f1name := safeBaseNameFromName(job.File1Name)
 	f2name := safeBaseNameFromName(job.File2Name)
 	local1 := filepath.Join(jobDir, "file1_"+f1name)
 	local2 := filepath.Join(jobDir, "file2_"+f2name)
 
+	// OSS transfers and the xls conversion take no context: check between steps.
+	if err := jobCtx.Err(); err != nil {
+		return abort(err)
+	}
 	if err := w.oss.GetObjectToFile(job.File1OSSKey, local1); err != nil {
-		return streamq.Terminal(w.fail(jobID, fmt.Errorf("下载输入文件1失败: %w", err)))
+		return abort(fmt.Errorf("下载输入文件1失败: %w", err))
+	}
+	stats.addInputSize(local1)
+	progress.set(domain.CompareJobPhaseDownloading, 1, nInputs)
+	if err := jobCtx.Err(); err != nil {
+		return abort(err)
 	}
 	if err := w.oss.GetObjectToFile(job.File2OSSKey, local2); err != nil {
-		return streamq.Terminal(w.fail(jobID, fmt.Errorf("下载输入文件2失败: %w", err)))
+		return abort(fmt.Errorf("下载输入文件2失败: %w", err))
+	}
+	stats.addInputSize(local2)
+	progress.set(domain.CompareJobPhaseDownloading, 2, nInputs)
+	var localBase string
+	if err := jobCtx.Err(); err != nil {
+		return abort(err)
+	}
+	if threeWay {
+		localBase = filepath.Join(jobDir, "base_"+safeBaseNameFromName(job.BaseName))
+		if err := w.oss.GetObjectToFile(job.BaseOSSKey, localBase); err != nil {
+			return abort(fmt.Errorf("下载基准文件失败: %w", err))
+		}
+		stats.addInputSize(localBase)
+		progress.set(domain.CompareJobPhaseDownloading, 3, nInputs)
 	}
 
 	// .xls -> .xlsx conversion if needed
+	progress.set(domain.CompareJobPhaseConverting, 0, nInputs)
 	new1, _, err := convertXLSIfNeeded(local1)
 	if err != nil {
-		return streamq.Terminal(w.fail(jobID, err))
+		return abort(err)
+	}
+	progress.set(domain.CompareJobPhaseConverting, 1, nInputs)
+	if err := jobCtx.Err(); err != nil {
+		return abort(err)
 	}
 	new2, _, err := convertXLSIfNeeded(local2)
 	if err != nil {
-		return streamq.Terminal(w.fail(jobID, err))
+		return abort(err)
 	}
+	progress.set(domain.CompareJobPhaseConverting, 2, nInputs)
 	local1, local2 = new1, new2
+	stats.addSheets(local1)
+	stats.addSheets(local2)
+	if threeWay {
+		newBase, _, err := convertXLSIfNeeded(localBase)
+		if err != nil {
+			return abort(err)
+		}
+		localBase = newBase
+		stats.addSheets(localBase)
+		progress.set(domain.CompareJobPhaseConverting, 3, nInputs)
+	}
 
+	if err := jobCtx.Err(); err != nil {
+		return abort(err)
+	}
 	resultPath := filepath.Join(jobDir, "comparison_result.xlsx")
-	if err := excelcmp.GenerateCompareExportXLSX(local1, local2, job.File1Name, job.File2Name, resultPath); err != nil {
-		return streamq.Terminal(w.fail(jobID, err))
+	opts := excelcmp.Options{Progress: func(ev excelcmp.Progress) {
+		stats.observe(ev)
+		progress.excelcmpHook(ev)
+	}}
+	switch {
+	case threeWay:
+		err = excelcmp.GenerateThreeWayExportXLSXWithOptions(jobCtx, localBase, local1, local2, job.BaseName, job.File1Name, job.File2Name, resultPath, opts)
+	case job.Mode == domain.CompareModeMerge:
+		err = excelcmp.GenerateMergedExportXLSXWithOptions(jobCtx, local1, local2, job.File1Name, job.File2Name, resultPath, excelcmp.MergePrecedence(job.MergePrecedence), opts)
+	default:
+		err = excelcmp.GenerateCompareExportXLSXWithOptions(jobCtx, local1, local2, job.File1Name, job.File2Name, resultPath, opts)
+	}
+	if err != nil {
+		return abort(err)
 	}
 
+	if err := jobCtx.Err(); err != nil {
+		return abort(err)
+	}
+	progress.set(domain.CompareJobPhaseUploading, 0, 1)
 	ossKey := w.oss.ObjectKeyForJob(jobID)
 	if err := w.oss.PutResultFile(ossKey, resultPath); err != nil {
-		return streamq.Terminal(w.fail(jobID, fmt.Errorf("上传 OSS 失败: %w", err)))
+		return abort(fmt.Errorf("上传 OSS 失败: %w", err))
 	}
+	progress.set(domain.CompareJobPhaseUploading, 1, 1)
 	_ = os.Remove(resultPath)
 
-	// Persist result location early.
+	// Persist result location (and the stats the paygate prices from) early.
+	jobStats := stats.snapshot()
 	_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
 		if j.Status == domain.CompareJobStatusCancelled {
 			return
 		}
 		j.ResultOSSKey = ossKey
 		j.ResultPath = ""
+		j.Stats = jobStats
 	})
 
 	// Refresh job state after generating result (Paid/Cancelled may change concurrently).
@@ -215,6 +326,8 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 		return err
 	}
 	if job.Status == domain.CompareJobStatusCancelled {
+		// Cancelled while uploading: the result must not outlive the job.
+		w.cleanupCancelled(job, jobDir)
 		return streamq.Terminal(nil)
 	}
 	if w.payq == nil {
@@ -229,6 +342,15 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 	return streamq.Terminal(nil)
 }
 
+// jobFinished reports whether there is nothing left to do for the job.
+func jobFinished(job *domain.CompareJob) bool {
+	switch job.Status {
+	case domain.CompareJobStatusCancelled, domain.CompareJobStatusReady, domain.CompareJobStatusFailed:
+		return true
+	}
+	return false
+}
+
 func (w *Worker) fail(jobID string, err error) error {
 	if strings.TrimSpace(jobID) == "" {
 		return err
@@ -237,8 +359,8 @@ func (w *Worker) fail(jobID string, err error) error {
 	if err != nil {
 		msg = err.Error()
 	}
-	_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
-		j.Status = domain.CompareJobStatusFailed
+	// Rejected for jobs that were cancelled (or finished) meanwhile.
+	_, _, _ = store.Transition(w.store, jobID, domain.CompareJobStatusFailed, domain.ActorCompareWorker, msg, func(j *domain.CompareJob) {
 		j.Error = msg
 	})
 	return err
@@ -0,0 +1,232 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/streamq"
+)
+
+// fakeObjStore keeps objects in memory; onGet/onPut run before a download/upload.
+type fakeObjStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	deleted []string
+	onGet   func(key string)
+	onPut   func(key string)
+}
+
+func (f *fakeObjStore) Enabled() bool                       { return true }
+func (f *fakeObjStore) ObjectKeyForJob(jobID string) string { return "results/" + jobID + ".xlsx" }
+func (f *fakeObjStore) ObjectKeyForInput(jobID, which, name string) string {
+	return "inputs/" + jobID + "/" + which + "_" + name
+}
+func (f *fakeObjStore) PutFileFromPath(key, localPath, _ string) error {
+	return f.PutResultFile(key, localPath)
+}
+
+func (f *fakeObjStore) PutResultFile(key, localPath string) error {
+	if f.onPut != nil {
+		f.onPut(key)
+	}
+	b, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = b
+	return nil
+}
+
+func (f *fakeObjStore) GetObjectToFile(key, localPath string) error {
+	if f.onGet != nil {
+		f.onGet(key)
+	}
+	f.mu.Lock()
+	b, ok := f.objects[key]
+	f.mu.Unlock()
+	if !ok {
+		return errors.New("no such object: " + key)
+	}
+	return os.WriteFile(localPath, b, 0o600)
+}
+
+func (f *fakeObjStore) GetObject(string) (io.ReadCloser, error) { return nil, errors.New("unused") }
+
+func (f *fakeObjStore) DeleteObject(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, key)
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+func (f *fakeObjStore) SignDownloadURL(string, string) (string, error) {
+	return "", errors.New("unused")
+}
+
+func (f *fakeObjStore) has(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[key]
+	return ok
+}
+
+// ctxStore records the context the worker watches the job with; it is the job context every
+// compare step (and excelcmp) runs under.
+type ctxStore struct {
+	*store.InMemoryCompareJobStore
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (s *ctxStore) Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+	return s.InMemoryCompareJobStore.Watch(ctx, id)
+}
+
+func (s *ctxStore) jobCtx() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ctx
+}
+
+type recordingQueue struct{ jobs []string }
+
+func (q *recordingQueue) Enqueue(_ context.Context, jobID string) error {
+	q.jobs = append(q.jobs, jobID)
+	return nil
+}
+
+// newCancelTest stores a queued job whose inputs are in a fake object store.
+func newCancelTest(t *testing.T) (*Worker, *ctxStore, *fakeObjStore, *recordingQueue) {
+	t.Helper()
+	st := &ctxStore{InMemoryCompareJobStore: store.NewInMemoryCompareJobStore()}
+	oss := &fakeObjStore{objects: map[string][]byte{
+		"inputs/job_1/file1_a.xlsx": []byte("a"),
+		"inputs/job_1/file2_b.xlsx": []byte("b"),
+	}}
+	job := &domain.CompareJob{
+		ID: "job_1", OwnerID: "u1", CreatedAt: time.Now(),
+		File1Name: "a.xlsx", File2Name: "b.xlsx",
+		File1OSSKey: "inputs/job_1/file1_a.xlsx", File2OSSKey: "inputs/job_1/file2_b.xlsx",
+	}
+	if err := st.Create(job); err != nil {
+		t.Fatal(err)
+	}
+	q := &recordingQueue{}
+	w := NewWorker(st, t.TempDir(), oss, q, nil)
+	w.cancelPoll = time.Hour // only the watch may notice the cancel
+	return w, st, oss, q
+}
+
+func cancelJob(t *testing.T, st store.CompareJobStore) {
+	t.Helper()
+	if _, _, err := store.Transition(st, "job_1", domain.CompareJobStatusCancelled, domain.ActorAPI, "user", func(j *domain.CompareJob) {
+		now := time.Now()
+		j.CancelledAt = &now
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func assertCancelled(t *testing.T, w *Worker, st store.CompareJobStore, oss *fakeObjStore, q *recordingQueue) {
+	t.Helper()
+	job, _, _ := st.Get("job_1")
+	if job.Status != domain.CompareJobStatusCancelled || job.ResultOSSKey != "" {
+		t.Fatalf("job: status=%s result=%q, want cancelled without a result", job.Status, job.ResultOSSKey)
+	}
+	for _, ev := range job.Events {
+		if ev.To == domain.CompareJobStatusFailed || ev.To == domain.CompareJobStatusReady {
+			t.Fatalf("job went %s: %+v", ev.To, job.Events)
+		}
+	}
+	for _, k := range []string{"inputs/job_1/file1_a.xlsx", "inputs/job_1/file2_b.xlsx", "results/job_1.xlsx"} {
+		if oss.has(k) {
+			t.Errorf("%s left behind", k)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(w.tmpRoot, "compare_jobs", "job_1")); !os.IsNotExist(err) {
+		t.Errorf("job dir left behind: %v", err)
+	}
+	if len(q.jobs) != 0 {
+		t.Errorf("enqueued to the paygate: %v", q.jobs)
+	}
+}
+
+func TestWorkerStopsOnCancelMidRun(t *testing.T) {
+	w, st, oss, q := newCancelTest(t)
+	var jobCtx context.Context
+	oss.onGet = func(key string) {
+		if key != "inputs/job_1/file2_b.xlsx" {
+			return
+		}
+		// The user cancels while the inputs are downloading.
+		jobCtx = st.jobCtx()
+		cancelJob(t, st)
+		select {
+		case <-jobCtx.Done():
+		case <-time.After(5 * time.Second):
+			t.Error("job context not cancelled")
+		}
+	}
+	oss.onPut = func(key string) { t.Errorf("uploaded %s after the cancel", key) }
+
+	err := w.Process(context.Background(), "job_1")
+	if !streamq.IsTerminal(err) || errors.Unwrap(err) != nil {
+		t.Fatalf("Process = %v, want Terminal(nil)", err)
+	}
+	if jobCtx == nil || !errors.Is(context.Cause(jobCtx), errJobCancelled) {
+		t.Fatalf("job context cause = %v, want errJobCancelled", context.Cause(jobCtx))
+	}
+	assertCancelled(t, w, st, oss, q)
+}
+
+// testXLSX returns a one-sheet workbook with the given rows.
+func testXLSX(t *testing.T, rows ...[]interface{}) []byte {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// A cancel that lands after the compare finished still removes the uploaded result.
+func TestWorkerCancelledWhileUploading(t *testing.T) {
+	w, st, oss, q := newCancelTest(t)
+	oss.objects["inputs/job_1/file1_a.xlsx"] = testXLSX(t, []interface{}{"id", "name"}, []interface{}{1, "a"})
+	oss.objects["inputs/job_1/file2_b.xlsx"] = testXLSX(t, []interface{}{"id", "name"}, []interface{}{1, "b"})
+	oss.onPut = func(key string) { cancelJob(t, st) }
+
+	err := w.Process(context.Background(), "job_1")
+	if !streamq.IsTerminal(err) || errors.Unwrap(err) != nil {
+		t.Fatalf("Process = %v, want Terminal(nil)", err)
+	}
+	assertCancelled(t, w, st, oss, q)
+	if !slices.Contains(oss.deleted, "results/job_1.xlsx") {
+		t.Fatalf("deleted %v, want the uploaded result removed", oss.deleted)
+	}
+}
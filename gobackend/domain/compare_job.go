@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type CompareJobStatus string
 
@@ -12,10 +15,63 @@ const (
 	CompareJobStatusCancelled       CompareJobStatus = "cancelled"
 )
 
+// CompareMode selects which export a job produces.
+type CompareMode string
+
+const (
+	// CompareModeDiff is the default two-way diff (empty Mode is treated the same).
+	CompareModeDiff CompareMode = "diff"
+	// CompareModeThreeWay compares file1 (left) and file2 (right) against a common base file.
+	CompareModeThreeWay CompareMode = "threeway"
+	// CompareModeMerge writes one merged workbook instead of a diff (see MergePrecedence).
+	CompareModeMerge CompareMode = "merge"
+)
+
+// CompareLane is the priority lane (stream) a job is queued on; compare-worker reads lanes with
+// weighted fairness so large uploads cannot starve small ones.
+type CompareLane string
+
+const (
+	CompareLaneStandard CompareLane = "standard"
+	CompareLaneLarge    CompareLane = "large"
+)
+
+// CompareJobPhase is the step a processing job is currently in (see CompareJobProgress).
+type CompareJobPhase string
+
+const (
+	CompareJobPhaseDownloading   CompareJobPhase = "downloading"
+	CompareJobPhaseConverting    CompareJobPhase = "converting"
+	CompareJobPhaseReadingFile1  CompareJobPhase = "reading_file1"
+	CompareJobPhaseReadingFile2  CompareJobPhase = "reading_file2"
+	CompareJobPhaseDiffing       CompareJobPhase = "diffing"
+	CompareJobPhaseWritingExport CompareJobPhase = "writing_export"
+	CompareJobPhaseUploading     CompareJobPhase = "uploading"
+)
+
+// CompareJobProgress is written by compare-worker while a job is processing.
+type CompareJobProgress struct {
+	Phase     CompareJobPhase `json:"phase"`
+	Percent   int             `json:"percent"` // 0-100 over all phases
+	RowsDone  int64           `json:"rowsDone,omitempty"`
+	RowsTotal int64           `json:"rowsTotal,omitempty"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// WebhookAttempt records one callback delivery for a job (see package webhook).
+type WebhookAttempt struct {
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
 type CompareJob struct {
 	ID        string           `json:"jobId"`
 	Status    CompareJobStatus `json:"status"`
 	CreatedAt time.Time        `json:"createdAt"`
+	Mode      CompareMode      `json:"mode,omitempty"`
 
 	// Inputs (saved on disk)
 	File1Path string `json:"-"`
@@ -26,19 +82,91 @@ type CompareJob struct {
 	// Original upload filenames (for export sheet names / headers)
 	File1Name string `json:"-"`
 	File2Name string `json:"-"`
+	// Base file (three-way mode only)
+	BaseOSSKey string `json:"-"`
+	BaseName   string `json:"-"`
+	// Merge mode only: "file1" / "file2" / "nonempty"
+	MergePrecedence string `json:"-"`
+
+	// Scheduling: lane picked from the upload size; Tenant (client identity) is capped on concurrent runs
+	Lane   CompareLane `json:"lane,omitempty"`
+	Tenant string      `json:"-"`
+
+	// OwnerID is the logged-in user who created the job ("" = anonymous: anyone with the ID may read it).
+	OwnerID string `json:"-"`
+
+	// Events is the status history, appended by Transition.
+	Events []CompareJobEvent `json:"-"`
+
+	// Progress of the compare stage (nil until compare-worker picks the job up)
+	Progress *CompareJobProgress `json:"progress,omitempty"`
 
 	// Result (saved on disk or OSS)
 	ResultPath string `json:"-"`
 	// ResultOSSKey is the OSS object key (bucket is configured separately).
 	ResultOSSKey string `json:"-"`
 
+	// Pricing: Coupon is the discount code given at upload; Stats are measured by compare-worker
+	// and Price is the paygate's quote from them (AmountYuan = Price.TotalFen / 100).
+	Coupon string           `json:"-"`
+	Stats  *CompareJobStats `json:"-"`
+	Price  *PriceBreakdown  `json:"-"`
+
 	// Payment gating
 	AmountYuan  float64    `json:"amount,omitempty"` // 单位：元（AwaitingPayment 时返回给前端展示）
 	CodeURL     string     `json:"code_url,omitempty"`
 	Paid        bool       `json:"paid"`
 	PaidAt      *time.Time `json:"paidAt,omitempty"`
+	PaidVia     string     `json:"paidVia,omitempty"` // PaidViaWeChat / PaidViaWallet / PaidViaSubscription / PaidViaFree
 	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
+	// Refunds of the WeChat Pay payment, oldest first (see RefundState).
+	Refunds []CompareJobRefund `json:"-"`
+
+	// Outbound webhook (optional): POSTed when the job becomes ready / failed / awaiting_payment
+	CallbackURL     string           `json:"-"`
+	WebhookAttempts []WebhookAttempt `json:"-"`
 
 	// Diagnostics (non-sensitive)
 	Error string `json:"error,omitempty"`
 }
+
+// CompareJob.PaidVia values.
+const (
+	PaidViaWeChat       = "wechat"
+	PaidViaWallet       = "wallet"
+	PaidViaSubscription = "subscription"
+	PaidViaFree         = "free"
+)
+
+// Clone returns a deep copy of j: the copy shares no slices or pointers with j, so either can be
+// changed (e.g. by Transition or Refund) without the other seeing it.
+func (j *CompareJob) Clone() *CompareJob {
+	if j == nil {
+		return nil
+	}
+	cp := *j
+	cp.Events = slices.Clone(j.Events)
+	cp.Progress = clonePtr(j.Progress)
+	cp.Stats = clonePtr(j.Stats)
+	if j.Price != nil {
+		p := *j.Price
+		p.Lines = slices.Clone(j.Price.Lines)
+		cp.Price = &p
+	}
+	cp.PaidAt = clonePtr(j.PaidAt)
+	cp.CancelledAt = clonePtr(j.CancelledAt)
+	cp.Refunds = slices.Clone(j.Refunds)
+	for i := range cp.Refunds {
+		cp.Refunds[i].SucceededAt = clonePtr(cp.Refunds[i].SucceededAt)
+	}
+	cp.WebhookAttempts = slices.Clone(j.WebhookAttempts)
+	return &cp
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Job status state machine. Every status change goes through CompareJob.Transition, which rejects
+// moves not listed here and records the move in CompareJob.Events:
+//
+//	(new)            -> processing
+//	processing       -> awaiting_payment | ready | failed | cancelled
+//	awaiting_payment -> processing (paid before the result was stored) | ready | failed | cancelled
+//	ready, failed, cancelled are final
+var compareJobTransitions = map[CompareJobStatus][]CompareJobStatus{
+	"":                              {CompareJobStatusProcessing},
+	CompareJobStatusProcessing:      {CompareJobStatusAwaitingPayment, CompareJobStatusReady, CompareJobStatusFailed, CompareJobStatusCancelled},
+	CompareJobStatusAwaitingPayment: {CompareJobStatusProcessing, CompareJobStatusReady, CompareJobStatusFailed, CompareJobStatusCancelled},
+}
+
+// Actors recorded on job events. Requests from clients use their tenant ("user:<id>" / "ip:<addr>").
+const (
+	ActorAPI           = "api"
+	ActorCompareWorker = "compare-worker"
+	ActorPaymentWorker = "payment-worker"
+	ActorWeChatNotify  = "wechat-notify"
+	// ActorPaymentReconciler applies payments found by querying WeChat Pay (missed notifies).
+	ActorPaymentReconciler = "payment-reconciler"
+)
+
+// CompareJobEvent is one status change in a job's history.
+type CompareJobEvent struct {
+	From   CompareJobStatus `json:"from,omitempty"`
+	To     CompareJobStatus `json:"to"`
+	Actor  string           `json:"actor"`
+	Reason string           `json:"reason,omitempty"`
+	At     time.Time        `json:"at"`
+}
+
+// ErrIllegalTransition matches every *TransitionError (errors.Is).
+var ErrIllegalTransition = errors.New("illegal job status transition")
+
+// TransitionError is a rejected status change; the job is left untouched.
+type TransitionError struct {
+	From, To CompareJobStatus
+	// Why is set when the move is allowed by status but not in the job's current state.
+	Why string
+}
+
+func (e *TransitionError) Error() string {
+	from := string(e.From)
+	if from == "" {
+		from = "(new)"
+	}
+	if e.Why != "" {
+		return fmt.Sprintf("任务状态不能从 %s 变为 %s: %s", from, e.To, e.Why)
+	}
+	return fmt.Sprintf("任务状态不能从 %s 变为 %s", from, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool { return target == ErrIllegalTransition }
+
+// CanTransition reports whether the state machine allows from -> to.
+func CanTransition(from, to CompareJobStatus) bool {
+	for _, s := range compareJobTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves j to status to and appends the event. Staying in the same status is a no-op.
+// Besides the status rules, a paid job can't be cancelled or sent back to awaiting_payment.
+func (j *CompareJob) Transition(to CompareJobStatus, actor, reason string) error {
+	from := j.Status
+	if from == to {
+		return nil
+	}
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	if j.Paid && (to == CompareJobStatusCancelled || to == CompareJobStatusAwaitingPayment) {
+		return &TransitionError{From: from, To: to, Why: "已支付"}
+	}
+	j.Status = to
+	j.Events = append(j.Events, CompareJobEvent{From: from, To: to, Actor: actor, Reason: reason, At: time.Now()})
+	return nil
+}
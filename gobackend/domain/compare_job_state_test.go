@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompareJobTransition(t *testing.T) {
+	const (
+		none     = CompareJobStatus("")
+		proc     = CompareJobStatusProcessing
+		awaiting = CompareJobStatusAwaitingPayment
+		ready    = CompareJobStatusReady
+		failed   = CompareJobStatusFailed
+		canc     = CompareJobStatusCancelled
+	)
+	cases := []struct {
+		from, to CompareJobStatus
+		paid     bool
+		ok       bool
+	}{
+		{none, proc, false, true},
+		{none, ready, false, false},
+		{none, awaiting, false, false},
+		{proc, awaiting, false, true},
+		{proc, ready, false, true},
+		{proc, failed, false, true},
+		{proc, canc, false, true},
+		{proc, none, false, false},
+		{awaiting, proc, false, true},
+		{awaiting, ready, false, true},
+		{awaiting, failed, false, true},
+		{awaiting, canc, false, true},
+		// Final states.
+		{ready, proc, false, false},
+		{ready, awaiting, false, false},
+		{ready, canc, false, false},
+		{failed, proc, false, false},
+		{failed, ready, false, false},
+		{canc, ready, false, false},
+		{canc, proc, false, false},
+		{canc, awaiting, false, false},
+		// A paid job can't be cancelled or sent back to awaiting_payment.
+		{proc, canc, true, false},
+		{proc, awaiting, true, false},
+		{awaiting, canc, true, false},
+		{proc, ready, true, true},
+		{awaiting, proc, true, true},
+	}
+	for _, tc := range cases {
+		prior := []CompareJobEvent{{To: tc.from, Actor: "seed"}}
+		j := &CompareJob{Status: tc.from, Paid: tc.paid, Events: append([]CompareJobEvent(nil), prior...)}
+		before := time.Now()
+		err := j.Transition(tc.to, ActorPaymentWorker, "why")
+		after := time.Now()
+		name := string(tc.from) + "->" + string(tc.to)
+		if tc.paid {
+			name += " (paid)"
+		}
+
+		if !tc.ok {
+			if !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("%s: err = %v, want ErrIllegalTransition", name, err)
+			}
+			if j.Status != tc.from || len(j.Events) != len(prior) {
+				t.Errorf("%s: rejected move changed the job: status=%s events=%d", name, j.Status, len(j.Events))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if j.Status != tc.to || len(j.Events) != len(prior)+1 {
+			t.Errorf("%s: status=%s events=%d", name, j.Status, len(j.Events))
+			continue
+		}
+		ev := j.Events[len(j.Events)-1]
+		if ev.From != tc.from || ev.To != tc.to || ev.Actor != ActorPaymentWorker || ev.Reason != "why" {
+			t.Errorf("%s: event = %+v", name, ev)
+		}
+		if ev.At.Before(before) || ev.At.After(after) {
+			t.Errorf("%s: event time %s outside [%s, %s]", name, ev.At, before, after)
+		}
+	}
+}
+
+func TestCompareJobTransitionSameStatusIsNoop(t *testing.T) {
+	for _, s := range []CompareJobStatus{CompareJobStatusProcessing, CompareJobStatusReady, CompareJobStatusCancelled} {
+		j := &CompareJob{Status: s}
+		if err := j.Transition(s, ActorAPI, ""); err != nil || len(j.Events) != 0 {
+			t.Errorf("%s -> %s: err=%v events=%d", s, s, err, len(j.Events))
+		}
+	}
+}
+
+func TestTransitionErrorMessage(t *testing.T) {
+	j := &CompareJob{Status: CompareJobStatusProcessing, Paid: true}
+	err := j.Transition(CompareJobStatusCancelled, ActorAPI, "")
+	var te *TransitionError
+	if !errors.As(err, &te) || te.Why == "" || err.Error() != "任务状态不能从 processing 变为 cancelled: 已支付" {
+		t.Fatalf("err = %v", err)
+	}
+	err = (&CompareJob{}).Transition(CompareJobStatusReady, ActorAPI, "")
+	if err == nil || err.Error() != "任务状态不能从 (new) 变为 ready" {
+		t.Fatalf("err = %v", err)
+	}
+}
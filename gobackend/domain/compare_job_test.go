@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareJobClone(t *testing.T) {
+	now := time.Now()
+	orig := &CompareJob{
+		ID:          "job_1",
+		Status:      CompareJobStatusProcessing,
+		Events:      append(make([]CompareJobEvent, 0, 8), CompareJobEvent{To: CompareJobStatusProcessing, At: now}),
+		Progress:    &CompareJobProgress{Phase: CompareJobPhaseDiffing, Percent: 40},
+		Stats:       &CompareJobStats{RowsFile1: 10},
+		Price:       &PriceBreakdown{Lines: []PriceLine{{Code: PriceLineBase, AmountFen: 100}}, TotalFen: 100},
+		PaidAt:      &now,
+		CancelledAt: &now,
+		Refunds: append(make([]CompareJobRefund, 0, 4), CompareJobRefund{
+			ID: "rf_job_1_1", Status: RefundStatusProcessing, SucceededAt: &now,
+		}),
+		WebhookAttempts: append(make([]WebhookAttempt, 0, 4), WebhookAttempt{Event: "ready", Attempt: 1}),
+	}
+	want := *orig
+	wantEvents, wantRefunds := orig.Events[0], orig.Refunds[0]
+
+	cp := orig.Clone()
+	if !reflect.DeepEqual(cp, orig) {
+		t.Fatalf("clone differs:\n%+v\n%+v", cp, orig)
+	}
+
+	// Change everything reachable from the clone, including through spare slice capacity.
+	if err := cp.Transition(CompareJobStatusFailed, ActorAPI, "boom"); err != nil {
+		t.Fatal(err)
+	}
+	cp.Events[0].Reason = "changed"
+	cp.Progress.Percent = 99
+	cp.Stats.RowsFile1 = 99
+	cp.Price.Lines[0].AmountFen = 1
+	cp.Price.TotalFen = 1
+	*cp.PaidAt = time.Time{}
+	*cp.CancelledAt = time.Time{}
+	r, _ := cp.Refund("rf_job_1_1")
+	r.Status = RefundStatusSucceeded
+	*r.SucceededAt = time.Time{}
+	cp.Refunds = append(cp.Refunds, CompareJobRefund{ID: "rf_job_1_2"})
+	cp.WebhookAttempts[0].StatusCode = 500
+	cp.WebhookAttempts = append(cp.WebhookAttempts, WebhookAttempt{Event: "failed"})
+
+	if orig.Status != want.Status || len(orig.Events) != 1 || orig.Events[0] != wantEvents ||
+		orig.Events[:2][1] != (CompareJobEvent{}) {
+		t.Fatalf("events changed through the clone: %+v", orig.Events[:2])
+	}
+	if orig.Progress.Percent != 40 || orig.Stats.RowsFile1 != 10 || orig.Price.Lines[0].AmountFen != 100 || orig.Price.TotalFen != 100 {
+		t.Fatalf("progress/stats/price changed through the clone: %+v %+v %+v", orig.Progress, orig.Stats, orig.Price)
+	}
+	if !orig.PaidAt.Equal(now) || !orig.CancelledAt.Equal(now) {
+		t.Fatal("timestamps changed through the clone")
+	}
+	if len(orig.Refunds) != 1 || orig.Refunds[0].Status != wantRefunds.Status || !orig.Refunds[0].SucceededAt.Equal(now) ||
+		orig.Refunds[:2][1].ID != "" {
+		t.Fatalf("refunds changed through the clone: %+v", orig.Refunds[:2])
+	}
+	if len(orig.WebhookAttempts) != 1 || orig.WebhookAttempts[0].StatusCode != 0 || orig.WebhookAttempts[:2][1].Event != "" {
+		t.Fatalf("webhook attempts changed through the clone: %+v", orig.WebhookAttempts[:2])
+	}
+
+	var nilJob *CompareJob
+	if nilJob.Clone() != nil {
+		t.Fatal("nil job cloned to non-nil")
+	}
+	if cp := (&CompareJob{ID: "x"}).Clone(); cp.Events != nil || cp.Refunds != nil || cp.Progress != nil || cp.Price != nil {
+		t.Fatalf("empty fields not kept nil: %+v", cp)
+	}
+}
@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Wallet ledger (double entry, amounts in fen). Every money movement is a journal whose postings
+// sum to zero; an account's balance is the sum of its postings. User accounts never go negative:
+//
+//	user:<id>        spendable wallet balance
+//	user:<id>:held   funds reserved by holds until they are captured or released
+//	sys:wechat       money received through WeChat Pay (goes negative as users top up)
+//	sys:revenue      captured charges
+const (
+	LedgerAccountWeChat  = "sys:wechat"
+	LedgerAccountRevenue = "sys:revenue"
+)
+
+// Journal kinds.
+const (
+	LedgerKindTopUp   = "topup"
+	LedgerKindHold    = "hold"
+	LedgerKindCapture = "capture"
+	LedgerKindRelease = "release"
+	LedgerKindCharge  = "charge"
+	LedgerKindRefund  = "refund"
+)
+
+// WalletAccount is the user's spendable balance account.
+func WalletAccount(userID string) string { return "user:" + userID }
+
+// WalletHeldAccount holds the user's reserved funds.
+func WalletHeldAccount(userID string) string { return "user:" + userID + ":held" }
+
+// LedgerAccountGuarded reports whether the account must never go negative (all user accounts).
+func LedgerAccountGuarded(account string) bool { return strings.HasPrefix(account, "user:") }
+
+type LedgerPosting struct {
+	Account   string `json:"account"`
+	AmountFen int64  `json:"amountFen"`
+}
+
+// LedgerJournal is one atomic, balanced money movement. ID is the idempotency key: a journal is
+// applied at most once.
+type LedgerJournal struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	UserID    string          `json:"userId,omitempty"`
+	Memo      string          `json:"memo,omitempty"`
+	Postings  []LedgerPosting `json:"postings"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+var (
+	ErrUnbalancedJournal = errors.New("分录借贷不平衡")
+	ErrInsufficientFunds = errors.New("余额不足")
+)
+
+// Validate checks the journal is well-formed: an ID, at least two non-zero postings summing to 0.
+func (j *LedgerJournal) Validate() error {
+	if strings.TrimSpace(j.ID) == "" {
+		return errors.New("分录 ID 为空")
+	}
+	if len(j.Postings) < 2 {
+		return ErrUnbalancedJournal
+	}
+	var sum int64
+	for _, p := range j.Postings {
+		if p.Account == "" || p.AmountFen == 0 {
+			return ErrUnbalancedJournal
+		}
+		sum += p.AmountFen
+	}
+	if sum != 0 {
+		return ErrUnbalancedJournal
+	}
+	return nil
+}
+
+// AmountFor is the journal's net effect on account.
+func (j *LedgerJournal) AmountFor(account string) int64 {
+	var n int64
+	for _, p := range j.Postings {
+		if p.Account == account {
+			n += p.AmountFen
+		}
+	}
+	return n
+}
+
+// WalletTopUp is a WeChat Native order that credits the wallet once paid. Its ID is the
+// out_trade_no (TopUpTradePrefix + random) so the payment notify can tell it from a job.
+type WalletTopUp struct {
+	ID        string    `json:"topUpId"`
+	UserID    string    `json:"-"`
+	AmountFen int64     `json:"amountFen"`
+	CodeURL   string    `json:"code_url,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const TopUpTradePrefix = "topup_"
@@ -0,0 +1,11 @@
+package domain
+
+// Stream message types (streamq.Envelope.Type).
+const (
+	// MessageCompareRun asks compare-worker to run a job (compare stream, both lanes).
+	MessageCompareRun = "compare.run"
+	// MessagePaygateCheck asks payment-worker to release a finished job or create its payment order.
+	MessagePaygateCheck = "paygate.check"
+	// MessageWebhookDeliver asks payment-worker to POST the job's pending callback.
+	MessageWebhookDeliver = "webhook.deliver"
+)
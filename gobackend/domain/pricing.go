@@ -0,0 +1,43 @@
+package domain
+
+// CompareJobStats is what compare-worker measured on a job's inputs; the paygate prices from it.
+type CompareJobStats struct {
+	RowsFile1 int64 `json:"rowsFile1"`
+	RowsFile2 int64 `json:"rowsFile2"`
+	// Sheets is the largest worksheet count among the inputs (only the first sheet is compared).
+	Sheets     int   `json:"sheets"`
+	InputBytes int64 `json:"inputBytes"` // all uploaded inputs, as stored
+}
+
+// BillableRows is the row count a job is priced on: the larger of the two compared sheets.
+func (s CompareJobStats) BillableRows() int64 {
+	return max(s.RowsFile1, s.RowsFile2)
+}
+
+// PriceLine is one item of a price breakdown (negative for discounts).
+type PriceLine struct {
+	Code      string `json:"code"`
+	Label     string `json:"label"`
+	AmountFen int64  `json:"amountFen"`
+}
+
+// Price line codes.
+const (
+	PriceLineBase       = "base"
+	PriceLineFreeTier   = "free_tier"
+	PriceLineRows       = "rows"
+	PriceLineMultiSheet = "multi_sheet"
+	PriceLineLargeFile  = "large_file"
+	PriceLineMode       = "mode"
+	PriceLineCoupon     = "coupon"
+)
+
+// PriceBreakdown is how a job's price was computed; TotalFen is what the customer pays.
+type PriceBreakdown struct {
+	BillableRows int64       `json:"billableRows"`
+	Lines        []PriceLine `json:"lines"`
+	SubtotalFen  int64       `json:"subtotalFen"`
+	DiscountFen  int64       `json:"discountFen,omitempty"`
+	Coupon       string      `json:"coupon,omitempty"`
+	TotalFen     int64       `json:"totalFen"`
+}
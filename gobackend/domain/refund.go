@@ -0,0 +1,96 @@
+package domain
+
+import "time"
+
+// RefundStatus is the state of one WeChat Pay refund (REFUND.* notify / refund_status).
+type RefundStatus string
+
+const (
+	// RefundStatusProcessing: requested (or the request's outcome is unknown); the refund notify settles it.
+	RefundStatusProcessing RefundStatus = "processing"
+	RefundStatusSucceeded  RefundStatus = "succeeded"
+	// RefundStatusAbnormal: WeChat could not return the money (e.g. the card was closed) and it
+	// has to be handled in the merchant platform; the amount stays reserved.
+	RefundStatusAbnormal RefundStatus = "abnormal"
+	// RefundStatusFailed: rejected by WeChat Pay or closed; the amount can be refunded again.
+	RefundStatusFailed RefundStatus = "failed"
+)
+
+// CompareJobRefund is one refund of a job's WeChat Pay payment.
+type CompareJobRefund struct {
+	ID        string `json:"refundId"` // out_refund_no
+	AmountFen int64  `json:"amountFen"`
+	// TotalFen is the amount paid for the order when the refund was made.
+	TotalFen    int64        `json:"totalFen"`
+	Reason      string       `json:"reason,omitempty"`
+	Status      RefundStatus `json:"status"`
+	WeChatID    string       `json:"wechatRefundId,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	SucceededAt *time.Time   `json:"succeededAt,omitempty"`
+}
+
+// RefundTradePrefix starts every out_refund_no (followed by the job ID).
+const RefundTradePrefix = "rf_"
+
+// Job refund states (CompareJob.RefundState).
+const (
+	JobRefundNone       = ""
+	JobRefundProcessing = "processing"
+	JobRefundPartial    = "partial"
+	JobRefundFull       = "full"
+)
+
+// RefundedFen is the amount refunded so far.
+func (j *CompareJob) RefundedFen() int64 {
+	var n int64
+	for _, r := range j.Refunds {
+		if r.Status == RefundStatusSucceeded {
+			n += r.AmountFen
+		}
+	}
+	return n
+}
+
+// RefundableFen is what can still be refunded of a payment of totalFen: refunds that are not
+// failed count against it.
+func (j *CompareJob) RefundableFen(totalFen int64) int64 {
+	n := totalFen
+	for _, r := range j.Refunds {
+		if r.Status != RefundStatusFailed {
+			n -= r.AmountFen
+		}
+	}
+	return max(n, 0)
+}
+
+// Refund returns the refund with out_refund_no id.
+func (j *CompareJob) Refund(id string) (*CompareJobRefund, bool) {
+	for i := range j.Refunds {
+		if j.Refunds[i].ID == id {
+			return &j.Refunds[i], true
+		}
+	}
+	return nil, false
+}
+
+// RefundState summarizes the job's refunds: JobRefundProcessing while one is in flight, then
+// JobRefundFull once the whole payment is back, JobRefundPartial for less, JobRefundNone if nothing was refunded.
+func (j *CompareJob) RefundState() string {
+	var total int64
+	for _, r := range j.Refunds {
+		if r.Status == RefundStatusProcessing {
+			return JobRefundProcessing
+		}
+		total = max(total, r.TotalFen)
+	}
+	refunded := j.RefundedFen()
+	switch {
+	case refunded == 0:
+		return JobRefundNone
+	case refunded >= total:
+		return JobRefundFull
+	default:
+		return JobRefundPartial
+	}
+}
@@ -0,0 +1,81 @@
+package domain
+
+import "time"
+
+// SubscriptionPlan is a monthly plan bought through WeChat Native Pay. Each billing period covers
+// up to JobQuota paid jobs and RowQuota billable rows (0 = unlimited).
+type SubscriptionPlan struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	PriceFen int64  `json:"priceFen"`
+	JobQuota int64  `json:"jobQuota"`
+	RowQuota int64  `json:"rowQuota"`
+}
+
+// Subscription is a user's plan. Billing periods are whole months counted from StartedAt; the
+// plan lapses at ExpiresAt unless it is bought again.
+type Subscription struct {
+	UserID    string    `json:"-"`
+	PlanID    string    `json:"planId"`
+	StartedAt time.Time `json:"startedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Active reports whether the subscription covers now.
+func (s *Subscription) Active(now time.Time) bool {
+	return s != nil && !now.Before(s.StartedAt) && now.Before(s.ExpiresAt)
+}
+
+// Period returns the billing period containing now (usage counters reset at end).
+func (s *Subscription) Period(now time.Time) (start, end time.Time) {
+	k := 0
+	for !s.StartedAt.AddDate(0, k+1, 0).After(now) {
+		k++
+	}
+	start = s.StartedAt.AddDate(0, k, 0)
+	end = s.StartedAt.AddDate(0, k+1, 0)
+	if end.After(s.ExpiresAt) {
+		end = s.ExpiresAt
+	}
+	return start, end
+}
+
+// RenewSubscription applies one purchased month of planID to cur (nil = none): buying the active
+// plan again extends it by a month; otherwise a new plan starts now (fresh usage counters). Switching
+// plans replaces the active one at once: the rest of its paid time is forfeited, not credited.
+func RenewSubscription(cur *Subscription, userID, planID string, now time.Time) *Subscription {
+	if cur.Active(now) && cur.PlanID == planID {
+		next := *cur
+		next.ExpiresAt = cur.StartedAt.AddDate(0, monthsBetween(cur.StartedAt, cur.ExpiresAt)+1, 0)
+		return &next
+	}
+	return &Subscription{UserID: userID, PlanID: planID, StartedAt: now, ExpiresAt: now.AddDate(0, 1, 0)}
+}
+
+// monthsBetween counts whole months from start to end (end is start plus some months).
+func monthsBetween(start, end time.Time) int {
+	n := 0
+	for start.AddDate(0, n+1, 0).Compare(end) <= 0 {
+		n++
+	}
+	return n
+}
+
+// SubscriptionUsage is what a billing period has used so far.
+type SubscriptionUsage struct {
+	Jobs int64 `json:"jobs"`
+	Rows int64 `json:"rows"`
+}
+
+// SubscriptionOrder is a plan purchase; the plan is applied when WeChat Pay reports it paid.
+type SubscriptionOrder struct {
+	ID        string    `json:"orderId"`
+	UserID    string    `json:"-"`
+	PlanID    string    `json:"planId"`
+	AmountFen int64     `json:"amountFen"`
+	CodeURL   string    `json:"code_url,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SubscriptionTradePrefix marks plan purchases among WeChat Pay out_trade_no values.
+const SubscriptionTradePrefix = "plan_"
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// User is an account. WeChat (OAuth) is the only identity provider for now.
+type User struct {
+	ID            string    `json:"id"`
+	WeChatOpenID  string    `json:"-"`
+	WeChatUnionID string    `json:"-"`
+	Nickname      string    `json:"nickname,omitempty"`
+	AvatarURL     string    `json:"avatarUrl,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
@@ -159,27 +159,32 @@ func compareArtifactsFromMaps(headers1, headers2 []string, m1, m2 map[string][]s
 	sort.Strings(only2)
 	sort.Strings(common)
 
+	art := newArtifactsLayout(headers1, headers2, key)
+	art.ReducedKeys = only1
+	art.IncKeys = only2
+	art.CommonKeys = common
+	art.LeftByKey = m1
+	art.RightByKey = m2
+	return art, nil
+}
+
+// newArtifactsLayout fills only the column side of Artifacts (headers and aligned indices).
+// The external-sort path uses it directly since it never materializes key sets or row maps.
+func newArtifactsLayout(headers1, headers2 []string, key string) *Artifacts {
 	orderedCols := orderedUnionCols(headers1, headers2, key)
 
 	hidx1 := headerIndexMap(headers1)
 	hidx2 := headerIndexMap(headers2)
 	colIdx1, colIdx2 := alignedColumnIndices(orderedCols, hidx1, hidx2)
 
-	art := &Artifacts{
+	return &Artifacts{
 		Key:         key,
-		ReducedKeys: only1,
-		IncKeys:     only2,
 		RedHeaders:  append([]string(nil), headers1...),
 		IncHeaders:  append([]string(nil), headers2...),
 		OrderedCols: orderedCols,
-		CommonKeys:  common,
-		LeftByKey:   m1,
-		RightByKey:  m2,
 		ColIdx1:     colIdx1,
 		ColIdx2:     colIdx2,
 	}
-
-	return art, nil
 }
 
 func diffMaskWords(nCols int) int {
@@ -1,8 +1,12 @@
 package excelcmp
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/xuri/excelize/v2"
@@ -155,6 +159,478 @@ func TestExportThreeSheetsAndRedStyle(t *testing.T) {
 	}
 }
 
+func TestThreeWayClassification(t *testing.T) {
+	headers := []string{"编号", "名称", "数量"}
+	base := map[string][]string{
+		"1": {"1", "a", "10"},
+		"2": {"2", "b", "20"},
+		"3": {"3", "c", "30"},
+		"4": {"4", "d", "40"},
+		"5": {"5", "e", "50"},
+	}
+	left := map[string][]string{
+		"1": {"1", "a", "11"}, // left only
+		"2": {"2", "b", "20"},
+		"3": {"3", "c2", "30"}, // both same
+		"4": {"4", "dL", "40"}, // conflict
+		// 5 removed in left (right unchanged)
+	}
+	right := map[string][]string{
+		"1": {"1", "a", "10"},
+		"2": {"2", "b", "21"}, // right only
+		"3": {"3", "c2", "30"},
+		"4": {"4", "dR", "40"},
+		"5": {"5", "e", "50"},
+		"6": {"6", "f", "60"}, // added in right
+	}
+	art, err := compareThreeWayFromMaps(headers, headers, headers, base, left, right, "编号")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]ThreeWayRow, len(art.Rows))
+	for _, r := range art.Rows {
+		got[r.Key] = r
+	}
+	want := map[string]ChangeKind{
+		"1": ChangeLeftOnly,
+		"2": ChangeRightOnly,
+		"3": ChangeBothSame,
+		"4": ChangeConflict,
+		"5": ChangeLeftOnly,
+		"6": ChangeRightOnly,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("rows=%v", art.Rows)
+	}
+	for k, kind := range want {
+		if got[k].Kind != kind {
+			t.Fatalf("key=%s kind=%d want=%d", k, got[k].Kind, kind)
+		}
+	}
+	// 名称 column: conflict cell for key=4, 数量 unchanged.
+	if got["4"].Cells[0] != ChangeConflict || got["4"].Cells[1] != ChangeNone {
+		t.Fatalf("unexpected cells for key=4: %v", got["4"].Cells)
+	}
+	if got["5"].Left != RowRemoved || got["6"].Right != RowAdded {
+		t.Fatalf("unexpected row states: 5=%v 6=%v", got["5"], got["6"])
+	}
+	if c := art.Conflicts(); len(c) != 1 || c[0].Key != "4" {
+		t.Fatalf("unexpected conflicts: %v", c)
+	}
+}
+
+func TestThreeWayExportConflictSheet(t *testing.T) {
+	dir := t.TempDir()
+	fb := filepath.Join(dir, "base.xlsx")
+	fl := filepath.Join(dir, "a.xlsx")
+	fr := filepath.Join(dir, "b.xlsx")
+	out := filepath.Join(dir, "out.xlsx")
+	writeXLSX(t, fb, []string{"编号", "姓名"}, [][]string{{"1", "张三"}, {"2", "李四"}})
+	writeXLSX(t, fl, []string{"编号", "姓名"}, [][]string{{"1", "张三丰"}, {"2", "李四"}})
+	writeXLSX(t, fr, []string{"编号", "姓名"}, [][]string{{"1", "张三"}, {"2", "李四光"}})
+
+	if err := GenerateThreeWayExportXLSX(fb, fl, fr, "base.xlsx", "a.xlsx", "b.xlsx", out); err != nil {
+		t.Fatalf("GenerateThreeWayExportXLSX err=%v", err)
+	}
+	of, err := excelize.OpenFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = of.Close() }()
+	sheets := of.GetSheetList()
+	if len(sheets) != 2 || sheets[0] != "三方变动" || sheets[1] != "冲突项" {
+		t.Fatalf("unexpected sheets: %v", sheets)
+	}
+	k1, _ := of.GetCellValue(sheets[0], "A2")
+	k2, _ := of.GetCellValue(sheets[0], "A3")
+	if k1 != "1" || k2 != "2" {
+		t.Fatalf("unexpected keys: %q %q", k1, k2)
+	}
+	v, _ := of.GetCellValue(sheets[1], "A1")
+	if v != "无冲突项" {
+		t.Fatalf("expected empty conflict sheet, got %q", v)
+	}
+}
+
+func TestMergedExportPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "old.xlsx")
+	f2 := filepath.Join(dir, "new.xlsx")
+	writeXLSX(t, f1,
+		[]string{"编号", "姓名", "备注"},
+		[][]string{
+			{"1", "张三", "旧备注"},
+			{"2", "李四", "x"},
+		},
+	)
+	writeXLSX(t, f2,
+		[]string{"编号", "年龄", "姓名"},
+		[][]string{
+			{"3", "22", "王五"},
+			{"1", "19", ""},
+		},
+	)
+
+	cases := []struct {
+		prec      MergePrecedence
+		header    []string
+		firstKey  string
+		name1     string // 姓名 for key=1
+		name1Mark bool
+	}{
+		{MergeFile2Wins, []string{"编号", "年龄", "姓名", "备注"}, "3", "", false},
+		{MergeNonEmptyWins, []string{"编号", "年龄", "姓名", "备注"}, "3", "张三", true},
+		{MergeFile1Wins, []string{"编号", "姓名", "备注", "年龄"}, "1", "张三", false},
+	}
+	for _, tc := range cases {
+		out := filepath.Join(dir, string(tc.prec)+".xlsx")
+		if err := GenerateMergedExportXLSX(f1, f2, "old.xlsx", "new.xlsx", out, tc.prec); err != nil {
+			t.Fatalf("%s: err=%v", tc.prec, err)
+		}
+		of, err := excelize.OpenFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet := of.GetSheetList()[0]
+		rows, _ := of.GetRows(sheet)
+		for i, h := range tc.header {
+			if i >= len(rows[0]) || rows[0][i] != h {
+				t.Fatalf("%s: header=%v want=%v", tc.prec, rows[0], tc.header)
+			}
+		}
+		if rows[1][0] != tc.firstKey {
+			t.Fatalf("%s: first key=%q want=%q", tc.prec, rows[1][0], tc.firstKey)
+		}
+		if len(rows) != 4 {
+			t.Fatalf("%s: expected 3 merged rows, got %v", tc.prec, rows)
+		}
+		// locate key=1 and 姓名 column
+		nameCol := -1
+		for i, h := range rows[0] {
+			if h == "姓名" {
+				nameCol = i
+			}
+		}
+		for r := 1; r < len(rows); r++ {
+			if rows[r][0] != "1" {
+				continue
+			}
+			got := ""
+			if nameCol < len(rows[r]) {
+				got = rows[r][nameCol]
+			}
+			if got != tc.name1 {
+				t.Fatalf("%s: 姓名(1)=%q want=%q", tc.prec, got, tc.name1)
+			}
+			axis, _ := excelize.CoordinatesToCellName(nameCol+1, r+1)
+			st, _ := of.GetCellStyle(sheet, axis)
+			if (st != 0) != tc.name1Mark {
+				t.Fatalf("%s: 姓名(1) style=%d want marked=%v", tc.prec, st, tc.name1Mark)
+			}
+		}
+		_ = of.Close()
+	}
+}
+
+func TestExternalCompareMatchesInMemory(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "old.xlsx")
+	f2 := filepath.Join(dir, "new.xlsx")
+	var rows1, rows2 [][]string
+	for i := 0; i < 300; i++ {
+		id := fmt.Sprintf("%d", (i*37)%300+1) // unsorted input order
+		rows1 = append(rows1, []string{id, "名称" + id, fmt.Sprintf("%d", i%7)})
+		if i%11 == 0 {
+			continue // reduced in file2
+		}
+		v := fmt.Sprintf("%d", i%7)
+		if i%5 == 0 {
+			v = "changed"
+		}
+		rows2 = append(rows2, []string{"备注", id, v})
+	}
+	for i := 301; i < 320; i++ {
+		rows2 = append(rows2, []string{"新增", fmt.Sprintf("%d", i), "0"})
+	}
+	// Reorder file2 columns to exercise column alignment.
+	writeXLSX(t, f1, []string{"编号", "名称", "数量"}, rows1)
+	writeXLSX(t, f2, []string{"备注", "编号", "数量"}, rows2)
+
+	memOut := filepath.Join(dir, "mem.xlsx")
+	extOut := filepath.Join(dir, "ext.xlsx")
+	t.Setenv("COMPARE_EXTERNAL_SORT_THRESHOLD_MB", "1024")
+	if err := GenerateCompareExportXLSX(f1, f2, "old.xlsx", "new.xlsx", memOut); err != nil {
+		t.Fatal(err)
+	}
+	// Tiny run budget: forces many runs and a real k-way merge.
+	if err := generateCompareExportExternal(context.Background(), f1, f2, "old.xlsx", "new.xlsx", extOut, 512, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mf, err := excelize.OpenFile(memOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mf.Close()
+	ef, err := excelize.OpenFile(extOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	ms, es := mf.GetSheetList(), ef.GetSheetList()
+	if fmt.Sprint(ms) != fmt.Sprint(es) {
+		t.Fatalf("sheets differ: %v vs %v", ms, es)
+	}
+	for _, sh := range ms {
+		mr, _ := mf.GetRows(sh)
+		er, _ := ef.GetRows(sh)
+		if fmt.Sprint(mr) != fmt.Sprint(er) {
+			t.Fatalf("sheet %s differs:\nmem=%v\next=%v", sh, mr, er)
+		}
+		if len(mr) < 2 {
+			t.Fatalf("sheet %s unexpectedly empty: %v", sh, mr)
+		}
+	}
+	// Styles on the diff sheet must match too.
+	diff := ms[2]
+	mr, _ := mf.GetRows(diff)
+	for r := range mr {
+		for c := range mr[r] {
+			axis, _ := excelize.CoordinatesToCellName(c+1, r+1)
+			s1, _ := mf.GetCellStyle(diff, axis)
+			s2, _ := ef.GetCellStyle(diff, axis)
+			if (s1 != 0) != (s2 != 0) {
+				t.Fatalf("style differs at %s: %d vs %d", axis, s1, s2)
+			}
+		}
+	}
+}
+
+func TestExternalCompareDuplicateKeyError(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "a.xlsx")
+	f2 := filepath.Join(dir, "b.xlsx")
+	writeXLSX(t, f1, []string{"编号", "值"}, [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}, {"4", "d"}, {"5", "e"}, {"6", "f"}, {"2", "g"}})
+	writeXLSX(t, f2, []string{"编号", "值"}, [][]string{{"1", "a"}})
+	err := generateCompareExportExternal(context.Background(), f1, f2, "a.xlsx", "b.xlsx", filepath.Join(dir, "out.xlsx"), 64, Options{})
+	if err == nil || !contains(err.Error(), "文件1主键列") {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+}
+
+func TestParallelDiffMatchesSerial(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "a.xlsx")
+	f2 := filepath.Join(dir, "b.xlsx")
+	var rows1, rows2 [][]string
+	for i := 1; i <= 5000; i++ {
+		id := fmt.Sprintf("%d", i)
+		rows1 = append(rows1, []string{id, fmt.Sprintf("%d", i%13)})
+		v := fmt.Sprintf("%d", i%13)
+		if i%17 == 0 {
+			v = "x"
+		}
+		rows2 = append(rows2, []string{id, v})
+	}
+	writeXLSX(t, f1, []string{"编号", "值"}, rows1)
+	writeXLSX(t, f2, []string{"编号", "值"}, rows2)
+
+	sheetRows := func(workers string) [][]string {
+		t.Setenv("COMPARE_DIFF_WORKERS", workers)
+		out := filepath.Join(dir, "out_"+workers+".xlsx")
+		if err := GenerateCompareExportXLSX(f1, f2, "a.xlsx", "b.xlsx", out); err != nil {
+			t.Fatal(err)
+		}
+		of, err := excelize.OpenFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer of.Close()
+		rows, _ := of.GetRows(of.GetSheetList()[2])
+		return rows
+	}
+	serial := sheetRows("1")
+	parallel := sheetRows("4")
+	if len(serial) != 5000/17+1 {
+		t.Fatalf("unexpected diff rows: %d", len(serial))
+	}
+	if fmt.Sprint(serial) != fmt.Sprint(parallel) {
+		t.Fatalf("parallel diff differs from serial")
+	}
+}
+
+// Benchmarks over loadtest/01.xlsx and 02.xlsx (skipped when the fixtures are absent):
+//
+//	go test ./excelcmp -run '^$' -bench . -benchmem
+func loadtestFixtures(b *testing.B) (string, string) {
+	b.Helper()
+	f1 := filepath.Join("..", "..", "loadtest", "01.xlsx")
+	f2 := filepath.Join("..", "..", "loadtest", "02.xlsx")
+	for _, p := range []string{f1, f2} {
+		if _, err := os.Stat(p); err != nil {
+			b.Skipf("fixture %s not found", p)
+		}
+	}
+	return f1, f2
+}
+
+func BenchmarkLoadInputsSerial(b *testing.B) {
+	f1, f2 := loadtestFixtures(b)
+	for i := 0; i < b.N; i++ {
+		s1, _, err := loadKeyedSheetXLSX(context.Background(), f1, 5, "", true, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := loadKeyedSheetXLSX(context.Background(), f2, 0, s1.Key, false, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadInputsParallel(b *testing.B) {
+	f1, f2 := loadtestFixtures(b)
+	for i := 0; i < b.N; i++ {
+		if _, _, err := loadKeyedPairXLSX(context.Background(), f1, f2, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDiffSheet(b *testing.B, workers string) {
+	f1, f2 := loadtestFixtures(b)
+	s1, s2, err := loadKeyedPairXLSX(context.Background(), f1, f2, Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	art, err := compareArtifactsFromMaps(s1.Headers, s2.Headers, s1.RowsByKey, s2.RowsByKey, s1.Key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Setenv("COMPARE_DIFF_WORKERS", workers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := excelize.NewFile()
+		if err := writeDiffSideBySideStream(context.Background(), f, f.GetSheetName(0), art, "01.xlsx", "02.xlsx", 0, nil); err != nil {
+			b.Fatal(err)
+		}
+		_ = f.Close()
+	}
+}
+
+func BenchmarkDiffSheetSerial(b *testing.B)   { benchmarkDiffSheet(b, "1") }
+func BenchmarkDiffSheetParallel(b *testing.B) { benchmarkDiffSheet(b, "8") }
+
+func benchmarkCompareExport(b *testing.B, workers string) {
+	f1, f2 := loadtestFixtures(b)
+	b.Setenv("COMPARE_DIFF_WORKERS", workers)
+	out := filepath.Join(b.TempDir(), "out.xlsx")
+	for i := 0; i < b.N; i++ {
+		if err := GenerateCompareExportXLSX(f1, f2, "01.xlsx", "02.xlsx", out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompareExportSerialDiff(b *testing.B)   { benchmarkCompareExport(b, "1") }
+func BenchmarkCompareExportParallelDiff(b *testing.B) { benchmarkCompareExport(b, "8") }
+
+func TestCompareExportProgressPhases(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "a.xlsx")
+	f2 := filepath.Join(dir, "b.xlsx")
+	var rows [][]string
+	for i := 1; i <= 4500; i++ {
+		rows = append(rows, []string{fmt.Sprintf("%d", i), "v"})
+	}
+	writeXLSX(t, f1, []string{"编号", "值"}, rows)
+	writeXLSX(t, f2, []string{"编号", "值"}, rows[:4000])
+
+	var mu sync.Mutex
+	last := map[string]Progress{}
+	var order []string
+	opts := Options{Progress: func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, seen := last[p.Phase]; !seen {
+			order = append(order, p.Phase)
+		}
+		if prev, ok := last[p.Phase]; ok && p.Done < prev.Done {
+			t.Errorf("%s went backwards: %d -> %d", p.Phase, prev.Done, p.Done)
+		}
+		last[p.Phase] = p
+	}}
+	if err := GenerateCompareExportXLSXWithOptions(context.Background(), f1, f2, "a.xlsx", "b.xlsx", filepath.Join(dir, "out.xlsx"), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, ph := range []string{PhaseReadFile1, PhaseReadFile2, PhaseDiff, PhaseWriteExport} {
+		p, ok := last[ph]
+		if !ok {
+			t.Fatalf("phase %s not reported (got %v)", ph, order)
+		}
+		if p.Done != p.Total {
+			t.Fatalf("phase %s did not finish: %+v", ph, p)
+		}
+	}
+	if last[PhaseReadFile1].Total != 4500 || last[PhaseDiff].Total != 4000 {
+		t.Fatalf("unexpected totals: file1=%+v diff=%+v", last[PhaseReadFile1], last[PhaseDiff])
+	}
+	if order[len(order)-2] != PhaseDiff || order[len(order)-1] != PhaseWriteExport {
+		t.Fatalf("unexpected phase order: %v", order)
+	}
+}
+
+func TestCompareExportCancelled(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "a.xlsx")
+	f2 := filepath.Join(dir, "b.xlsx")
+	var rows1, rows2 [][]string
+	for i := 1; i <= 5000; i++ {
+		rows1 = append(rows1, []string{fmt.Sprintf("%d", i), "old"})
+		rows2 = append(rows2, []string{fmt.Sprintf("%d", i), "new"})
+	}
+	writeXLSX(t, f1, []string{"编号", "值"}, rows1)
+	writeXLSX(t, f2, []string{"编号", "值"}, rows2)
+
+	generate := func(ctx context.Context, out string, opts Options) error {
+		return GenerateCompareExportXLSXWithOptions(ctx, f1, f2, "a.xlsx", "b.xlsx", out, opts)
+	}
+	external := func(ctx context.Context, out string, opts Options) error {
+		return generateCompareExportExternal(ctx, f1, f2, "a.xlsx", "b.xlsx", out, 4096, opts)
+	}
+	t.Setenv("COMPARE_DIFF_WORKERS", "4")
+	for _, tc := range []struct {
+		name  string
+		run   func(ctx context.Context, out string, opts Options) error
+		phase string
+	}{
+		{"read", generate, PhaseReadFile2},
+		{"diff", generate, PhaseDiff},
+		{"external_read", external, PhaseReadFile2},
+		{"external_merge", external, PhaseDiff},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var wrote bool
+			opts := Options{Progress: func(p Progress) {
+				if p.Phase == tc.phase {
+					cancel()
+				}
+				if p.Phase == PhaseWriteExport {
+					wrote = true
+				}
+			}}
+			err := tc.run(ctx, filepath.Join(dir, tc.name+".xlsx"), opts)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+			if wrote {
+				t.Fatalf("export was written after cancel")
+			}
+		})
+	}
+}
+
 func contains(s, sub string) bool {
 	return len(sub) == 0 || (len(s) >= len(sub) && (func() bool { return (stringIndex(s, sub) >= 0) })())
 }
@@ -1,17 +1,26 @@
 package excelcmp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/xuri/excelize/v2"
 )
 
 // GenerateCompareExportXLSX implements the same 3-sheet export format as the current Python version.
 func GenerateCompareExportXLSX(file1Path, file2Path, file1Name, file2Name, outPath string) error {
+	return GenerateCompareExportXLSXWithOptions(context.Background(), file1Path, file2Path, file1Name, file2Name, outPath, Options{})
+}
+
+// GenerateCompareExportXLSXWithOptions is GenerateCompareExportXLSX with progress reporting.
+// Cancelling ctx aborts reading and diffing with ctx.Err(); outPath may then be partial or missing.
+func GenerateCompareExportXLSXWithOptions(ctx context.Context, file1Path, file2Path, file1Name, file2Name, outPath string, opts Options) error {
 	if strings.TrimSpace(file1Path) == "" || strings.TrimSpace(file2Path) == "" {
 		return errors.New("输入文件路径为空")
 	}
@@ -19,26 +28,54 @@ func GenerateCompareExportXLSX(file1Path, file2Path, file1Name, file2Name, outPa
 		return errors.New("输出路径为空")
 	}
 
-	// Stream-read xlsx: only peek first 5 rows to guess key, then build key->row map.
-	s1, dup1, err := loadKeyedSheetXLSX(file1Path, 5, "", true)
-	if err != nil {
-		return fmt.Errorf("读取文件1失败: %w", err)
+	// Big inputs: sort rows into run files on disk and merge-join instead of holding both maps.
+	if useExternalCompare(file1Path, file2Path) {
+		return generateCompareExportExternal(ctx, file1Path, file2Path, file1Name, file2Name, outPath, externalRunBytes(), opts)
 	}
-	if len(dup1) > 0 {
-		return fmt.Errorf("文件1主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", s1.Key, dup1)
-	}
-	s2, dup2, err := loadKeyedSheetXLSX(file2Path, 0, s1.Key, false)
+
+	// Stream-read xlsx: only peek first 5 rows of file1 to guess key, then build both key->row maps in parallel.
+	s1, s2, err := loadKeyedPairXLSX(ctx, file1Path, file2Path, opts)
 	if err != nil {
-		return fmt.Errorf("读取文件2失败: %w", err)
-	}
-	if len(dup2) > 0 {
-		return fmt.Errorf("文件2主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", s1.Key, dup2)
+		return err
 	}
 	art, err := compareArtifactsFromMaps(s1.Headers, s2.Headers, s1.RowsByKey, s2.RowsByKey, s1.Key)
 	if err != nil {
 		return err
 	}
 
+	// Sheets already exist, so the diff sheet can be streamed first (the "diffing" phase);
+	// the plain added/removed sheets and the final zip are the "writing_export" phase.
+	f, sheets, redStyle := newCompareWorkbook(file1Name, file2Name)
+	if err := writeDiffSideBySideStream(ctx, f, sheets.diff, art, file1Name, file2Name, redStyle, opts.rowReporter(PhaseDiff)); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	total := int64(len(art.IncKeys) + len(art.ReducedKeys))
+	opts.report(PhaseWriteExport, 0, total)
+	if err := writeSimpleKeyedSheetStream(f, sheets.inc, art.IncHeaders, art.IncKeys, art.RightByKey, "无增加项"); err != nil {
+		return err
+	}
+	opts.report(PhaseWriteExport, int64(len(art.IncKeys)), total)
+	if err := writeSimpleKeyedSheetStream(f, sheets.red, art.RedHeaders, art.ReducedKeys, art.LeftByKey, "无减少项"); err != nil {
+		return err
+	}
+	if err := saveWorkbook(f, outPath); err != nil {
+		return err
+	}
+	opts.report(PhaseWriteExport, total, total)
+	return nil
+}
+
+type compareSheets struct {
+	inc  string
+	red  string
+	diff string
+}
+
+// newCompareWorkbook creates the three-sheet skeleton shared by the in-memory and external-sort paths.
+func newCompareWorkbook(file1Name, file2Name string) (*excelize.File, compareSheets, int) {
 	f := excelize.NewFile()
 	// Reuse default sheet as the first one to keep sheet order stable and avoid extra sheets.
 	defSheet := f.GetSheetName(0)
@@ -47,16 +84,18 @@ func GenerateCompareExportXLSX(file1Path, file2Path, file1Name, file2Name, outPa
 	base2 := sheetBaseName(file2Name)
 	used := make(map[string]struct{}, 3)
 
-	incName := uniqueSheetName(fmt.Sprintf("%s相比%s增加", base2, base1), used)
-	redName := uniqueSheetName(fmt.Sprintf("%s相比%s减少", base2, base1), used)
-	diffName := uniqueSheetName("变动项目", used)
+	sheets := compareSheets{
+		inc:  uniqueSheetName(fmt.Sprintf("%s相比%s增加", base2, base1), used),
+		red:  uniqueSheetName(fmt.Sprintf("%s相比%s减少", base2, base1), used),
+		diff: uniqueSheetName("变动项目", used),
+	}
 
 	if defSheet == "" {
 		defSheet = "Sheet1"
 	}
-	_ = f.SetSheetName(defSheet, incName)
-	f.NewSheet(redName)
-	f.NewSheet(diffName)
+	_ = f.SetSheetName(defSheet, sheets.inc)
+	f.NewSheet(sheets.red)
+	f.NewSheet(sheets.diff)
 	f.SetActiveSheet(0)
 
 	// Styles: light red fill + dark red font
@@ -64,17 +103,10 @@ func GenerateCompareExportXLSX(file1Path, file2Path, file1Name, file2Name, outPa
 		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}},
 		Font: &excelize.Font{Color: "9C0006"},
 	})
+	return f, sheets, redStyle
+}
 
-	if err := writeSimpleKeyedSheetStream(f, incName, art.IncHeaders, art.IncKeys, art.RightByKey, "无增加项"); err != nil {
-		return err
-	}
-	if err := writeSimpleKeyedSheetStream(f, redName, art.RedHeaders, art.ReducedKeys, art.LeftByKey, "无减少项"); err != nil {
-		return err
-	}
-	if err := writeDiffSideBySideStream(f, diffName, art, file1Name, file2Name, redStyle); err != nil {
-		return err
-	}
-
+func saveWorkbook(f *excelize.File, outPath string) error {
 	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
 		return fmt.Errorf("创建输出目录失败: %w", err)
 	}
@@ -134,61 +166,220 @@ func writeSimpleTableSheetStream(f *excelize.File, sheet string, tbl *Table, emp
 }
 
 func writeSimpleKeyedSheetStream(f *excelize.File, sheet string, headers []string, keys []string, byKey map[string][]string, emptyMsg string) error {
-	sw, err := f.NewStreamWriter(sheet)
+	kw, err := newKeyedSheetWriter(f, sheet, headers, emptyMsg)
 	if err != nil {
 		return err
 	}
-	rowNum := 1
-	if len(headers) == 0 || len(keys) == 0 || byKey == nil {
-		if err := sw.SetRow("A1", []interface{}{emptyMsg}); err != nil {
+	if byKey != nil {
+		for _, k := range keys {
+			r, ok := byKey[k]
+			if !ok {
+				continue
+			}
+			if err := kw.add(r); err != nil {
+				return err
+			}
+		}
+	}
+	return kw.finish()
+}
+
+// keyedSheetWriter streams whole rows under a header that is written before the first row;
+// emptyMsg is written instead when no row is added.
+type keyedSheetWriter struct {
+	sw       *excelize.StreamWriter
+	headers  []string
+	emptyMsg string
+	row      []interface{}
+	rowNum   int
+}
+
+func newKeyedSheetWriter(f *excelize.File, sheet string, headers []string, emptyMsg string) (*keyedSheetWriter, error) {
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	return &keyedSheetWriter{sw: sw, headers: headers, emptyMsg: emptyMsg, row: make([]interface{}, len(headers)), rowNum: 1}, nil
+}
+
+func (w *keyedSheetWriter) add(r []string) error {
+	if len(w.headers) == 0 {
+		return nil
+	}
+	if w.rowNum == 1 {
+		headerRow := make([]interface{}, len(w.headers))
+		for i, h := range w.headers {
+			headerRow[i] = h
+		}
+		if err := w.sw.SetRow(cellAxis(w.rowNum, 1), headerRow); err != nil {
 			return err
 		}
-		return sw.Flush()
+		w.rowNum++
 	}
-	// header
-	headerRow := make([]interface{}, len(headers))
-	for i, h := range headers {
-		headerRow[i] = h
+	for i := 0; i < len(w.headers); i++ {
+		if i < len(r) {
+			w.row[i] = safeCellValue(r[i])
+		} else {
+			w.row[i] = ""
+		}
 	}
-	if err := sw.SetRow(cellAxis(rowNum, 1), headerRow); err != nil {
+	if err := w.sw.SetRow(cellAxis(w.rowNum, 1), w.row); err != nil {
 		return err
 	}
-	rowNum++
+	w.rowNum++
+	return nil
+}
 
-	row := make([]interface{}, len(headers))
-	for _, k := range keys {
-		r, ok := byKey[k]
-		if !ok {
-			continue
-		}
-		for i := 0; i < len(headers); i++ {
-			if i < len(r) {
-				row[i] = safeCellValue(r[i])
-			} else {
-				row[i] = ""
-			}
-		}
-		if err := sw.SetRow(cellAxis(rowNum, 1), row); err != nil {
+func (w *keyedSheetWriter) finish() error {
+	if w.rowNum == 1 {
+		if err := w.sw.SetRow("A1", []interface{}{w.emptyMsg}); err != nil {
 			return err
 		}
-		rowNum++
 	}
-	return sw.Flush()
+	return w.sw.Flush()
 }
 
-func writeDiffSideBySideStream(f *excelize.File, sheet string, art *Artifacts, file1Name, file2Name string, redStyle int) error {
+// report (optional) receives common keys processed so far and len(art.CommonKeys).
+func writeDiffSideBySideStream(ctx context.Context, f *excelize.File, sheet string, art *Artifacts, file1Name, file2Name string, redStyle int, report func(done, total int64)) error {
+	if report == nil {
+		report = func(int64, int64) {}
+	}
 	sw, err := f.NewStreamWriter(sheet)
 	if err != nil {
 		return err
 	}
-	rowNum := 1
 	if art == nil || len(art.CommonKeys) == 0 {
 		if err := sw.SetRow("A1", []interface{}{"无变动项目"}); err != nil {
 			return err
 		}
+		report(0, 0)
 		return sw.Flush()
 	}
 
+	total := int64(len(art.CommonKeys))
+	report(0, total)
+	dw := newDiffSheetWriter(sw, art, file1Name, file2Name, redStyle)
+	workers := diffWorkers()
+	if workers <= 1 || len(art.CommonKeys) < 2*diffChunkSize {
+		for i, k := range art.CommonKeys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := dw.add(k, art.LeftByKey[k], art.RightByKey[k]); err != nil {
+				return err
+			}
+			if (i+1)%progressEvery == 0 {
+				report(int64(i+1), total)
+			}
+		}
+	} else if err := writeDiffRowsParallel(ctx, dw, art, redStyle, workers, report); err != nil {
+		return err
+	}
+	report(total, total)
+	return dw.finish()
+}
+
+// diffChunkSize is the number of common keys one diff worker handles per task.
+const diffChunkSize = 1024
+
+// diffWorkers: COMPARE_DIFF_WORKERS, default GOMAXPROCS capped at 8.
+func diffWorkers() int {
+	n := runtime.GOMAXPROCS(0)
+	if n > 8 {
+		n = 8
+	}
+	return readEnvIntDefault("COMPARE_DIFF_WORKERS", n)
+}
+
+// writeDiffRowsParallel shards CommonKeys into chunks that workers diff and render concurrently
+// (each with its own diffRowBuilder), while this goroutine writes the chunks in key order.
+// At most workers*4 chunks are in flight so a slow writer does not buffer the whole sheet.
+// Cancelling ctx stops handing out chunks; workers finish their current chunk.
+func writeDiffRowsParallel(ctx context.Context, dw *diffSheetWriter, art *Artifacts, redStyle int, workers int, report func(done, total int64)) error {
+	keys := art.CommonKeys
+	nChunks := (len(keys) + diffChunkSize - 1) / diffChunkSize
+	results := make([]chan [][]interface{}, nChunks)
+	for i := range results {
+		results[i] = make(chan [][]interface{}, 1)
+	}
+	window := make(chan struct{}, workers*4)
+	tasks := make(chan int)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(tasks)
+		for i := 0; i < nChunks; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case tasks <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := newDiffRowBuilder(art, redStyle)
+			for i := range tasks {
+				lo := i * diffChunkSize
+				hi := lo + diffChunkSize
+				if hi > len(keys) {
+					hi = len(keys)
+				}
+				rows := make([][]interface{}, 0, 16)
+				for _, k := range keys[lo:hi] {
+					if row := b.build(k, art.LeftByKey[k], art.RightByKey[k]); row != nil {
+						rows = append(rows, row)
+					}
+				}
+				results[i] <- rows
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < nChunks && err == nil; i++ {
+		var rows [][]interface{}
+		select {
+		case rows = <-results[i]:
+		case <-ctx.Done():
+			err = ctx.Err()
+			continue
+		}
+		<-window
+		for _, row := range rows {
+			if err = dw.writeRow(row); err != nil {
+				break
+			}
+		}
+		if done := (i + 1) * diffChunkSize; done < len(keys) {
+			report(int64(done), int64(len(keys)))
+		}
+	}
+	close(stop)
+	wg.Wait()
+	return err
+}
+
+// diffSheetWriter writes the "变动项目" sheet one common key at a time: the header is written
+// lazily before the first differing row, and "无变动项目" is written if no row differs.
+// Both the in-memory and the external-sort paths feed it in sorted key order.
+type diffSheetWriter struct {
+	sw     *excelize.StreamWriter
+	b      *diffRowBuilder
+	header []interface{}
+	rowNum int
+}
+
+func newDiffSheetWriter(sw *excelize.StreamWriter, art *Artifacts, file1Name, file2Name string, redStyle int) *diffSheetWriter {
 	fn1 := strings.TrimSpace(file1Name)
 	fn2 := strings.TrimSpace(file2Name)
 	if fn1 == "" {
@@ -197,157 +388,168 @@ func writeDiffSideBySideStream(f *excelize.File, sheet string, art *Artifacts, f
 	if fn2 == "" {
 		fn2 = "文件2"
 	}
-
-	type normFP struct {
-		norm string
-		fp   uint64
+	// header: [key, col1(file1), col1(file2), ...]
+	header := make([]interface{}, 0, 1+len(art.OrderedCols)*2)
+	header = append(header, art.Key)
+	for _, c := range art.OrderedCols {
+		header = append(header, fmt.Sprintf("%s（%s）", c, fn1))
+		header = append(header, fmt.Sprintf("%s（%s）", c, fn2))
 	}
-	type normCache struct {
-		m   map[string]normFP
-		max int
+	return &diffSheetWriter{sw: sw, b: newDiffRowBuilder(art, redStyle), header: header, rowNum: 1}
+}
+
+func (d *diffSheetWriter) add(k string, left, right []string) error {
+	row := d.b.build(k, left, right)
+	if row == nil {
+		return nil
 	}
-	cache := &normCache{m: make(map[string]normFP, 2048), max: 80000}
-	cachedNormalizeFP := func(raw string) (string, uint64) {
-		if len(raw) <= 64 {
-			if v, ok := cache.m[raw]; ok {
-				return v.norm, v.fp
-			}
-			n := normalizeScalarForCompare(raw)
-			fp := fingerprint64(n)
-			if len(cache.m) < cache.max {
-				cache.m[raw] = normFP{norm: n, fp: fp}
-			}
-			return n, fp
+	return d.writeRow(row)
+}
+
+func (d *diffSheetWriter) writeRow(row []interface{}) error {
+	if d.rowNum == 1 {
+		if err := d.sw.SetRow(cellAxis(d.rowNum, 1), d.header); err != nil {
+			return err
 		}
-		n := normalizeScalarForCompare(raw)
-		return n, fingerprint64(n)
+		d.rowNum++
 	}
+	if err := d.sw.SetRow(cellAxis(d.rowNum, 1), row); err != nil {
+		return err
+	}
+	d.rowNum++
+	return nil
+}
 
-	words := diffMaskWords(len(art.OrderedCols))
-	mask := make([]uint64, words)
-	dirty := make([]int, 0, 64)
-	setDiff := func(i int) {
-		if i < 0 {
-			return
-		}
-		w := i >> 6
-		if w < 0 || w >= len(mask) {
-			return
-		}
-		before := mask[w]
-		mask[w] |= 1 << uint(i&63)
-		if before == 0 {
-			dirty = append(dirty, w)
+func (d *diffSheetWriter) finish() error {
+	if d.rowNum == 1 {
+		if err := d.sw.SetRow("A1", []interface{}{"无变动项目"}); err != nil {
+			return err
 		}
 	}
-	resetMask := func() {
-		for _, w := range dirty {
-			mask[w] = 0
-		}
-		dirty = dirty[:0]
+	return d.sw.Flush()
+}
+
+type normFP struct {
+	norm string
+	fp   uint64
+}
+
+// diffRowBuilder compares the aligned cells of one common key and renders the side-by-side row.
+// It owns a normalization cache and a diff bitmask, so it must not be shared across goroutines.
+type diffRowBuilder struct {
+	nCols   int
+	colIdx1 []int
+	colIdx2 []int
+	red     int
+
+	cache    map[string]normFP
+	cacheMax int
+	mask     []uint64
+	dirty    []int
+}
+
+func newDiffRowBuilder(art *Artifacts, redStyle int) *diffRowBuilder {
+	return &diffRowBuilder{
+		nCols:    len(art.OrderedCols),
+		colIdx1:  art.ColIdx1,
+		colIdx2:  art.ColIdx2,
+		red:      redStyle,
+		cache:    make(map[string]normFP, 2048),
+		cacheMax: 80000,
+		mask:     make([]uint64, diffMaskWords(len(art.OrderedCols))),
+		dirty:    make([]int, 0, 64),
 	}
+}
 
-	firstWritten := false
-	writeHeader := func() error {
-		// header: [key, col1(file1), col1(file2), ...]
-		header := make([]interface{}, 0, 1+len(art.OrderedCols)*2)
-		header = append(header, art.Key)
-		for _, c := range art.OrderedCols {
-			header = append(header, fmt.Sprintf("%s（%s）", c, fn1))
-			header = append(header, fmt.Sprintf("%s（%s）", c, fn2))
+func (b *diffRowBuilder) normalizeFP(raw string) (string, uint64) {
+	if len(raw) <= 64 {
+		if v, ok := b.cache[raw]; ok {
+			return v.norm, v.fp
 		}
-		return sw.SetRow(cellAxis(rowNum, 1), header)
-	}
-
-	for _, k := range art.CommonKeys {
-		left := art.LeftByKey[k]
-		right := art.RightByKey[k]
-		hasDiff := false
-		for i := 0; i < len(art.OrderedCols); i++ {
-			i1 := -1
-			i2 := -1
-			if i < len(art.ColIdx1) {
-				i1 = art.ColIdx1[i]
-			}
-			if i < len(art.ColIdx2) {
-				i2 = art.ColIdx2[i]
-			}
-			va := ""
-			vb := ""
-			if i1 >= 0 && i1 < len(left) {
-				va = left[i1]
-			}
-			if i2 >= 0 && i2 < len(right) {
-				vb = right[i2]
-			}
-			n1, h1 := cachedNormalizeFP(va)
-			n2, h2 := cachedNormalizeFP(vb)
-			isDiff := false
-			if h1 != h2 {
-				isDiff = true
-			} else if n1 != n2 {
-				isDiff = true
-			}
-			if isDiff {
-				hasDiff = true
-				setDiff(i)
-			}
-		}
-		if !hasDiff {
-			resetMask()
-			continue
+		n := normalizeScalarForCompare(raw)
+		fp := fingerprint64(n)
+		if len(b.cache) < b.cacheMax {
+			b.cache[raw] = normFP{norm: n, fp: fp}
 		}
+		return n, fp
+	}
+	n := normalizeScalarForCompare(raw)
+	return n, fingerprint64(n)
+}
 
-		if !firstWritten {
-			if err := writeHeader(); err != nil {
-				return err
-			}
-			rowNum++
-			firstWritten = true
-		}
+func (b *diffRowBuilder) setDiff(i int) {
+	if i < 0 {
+		return
+	}
+	w := i >> 6
+	if w < 0 || w >= len(b.mask) {
+		return
+	}
+	before := b.mask[w]
+	b.mask[w] |= 1 << uint(i&63)
+	if before == 0 {
+		b.dirty = append(b.dirty, w)
+	}
+}
 
-		row := make([]interface{}, 0, 1+len(art.OrderedCols)*2)
-		row = append(row, safeCellValue(k))
-		// build row cells using computed diff bitset
-		for i := 0; i < len(art.OrderedCols); i++ {
-			i1 := -1
-			i2 := -1
-			if i < len(art.ColIdx1) {
-				i1 = art.ColIdx1[i]
-			}
-			if i < len(art.ColIdx2) {
-				i2 = art.ColIdx2[i]
-			}
-			va := ""
-			vb := ""
-			if i1 >= 0 && i1 < len(left) {
-				va = left[i1]
-			}
-			if i2 >= 0 && i2 < len(right) {
-				vb = right[i2]
-			}
-			isDiff := diffMaskGet(mask, i)
+func (b *diffRowBuilder) resetMask() {
+	for _, w := range b.dirty {
+		b.mask[w] = 0
+	}
+	b.dirty = b.dirty[:0]
+}
 
-			ca := excelize.Cell{Value: safeCellValue(va)}
-			cb := excelize.Cell{Value: safeCellValue(vb)}
-			if isDiff && redStyle > 0 {
-				ca.StyleID = redStyle
-				cb.StyleID = redStyle
-			}
-			row = append(row, ca, cb)
-		}
-		if err := sw.SetRow(cellAxis(rowNum, 1), row); err != nil {
-			return err
+func (b *diffRowBuilder) values(i int, left, right []string) (string, string) {
+	i1 := -1
+	i2 := -1
+	if i < len(b.colIdx1) {
+		i1 = b.colIdx1[i]
+	}
+	if i < len(b.colIdx2) {
+		i2 = b.colIdx2[i]
+	}
+	va := ""
+	vb := ""
+	if i1 >= 0 && i1 < len(left) {
+		va = left[i1]
+	}
+	if i2 >= 0 && i2 < len(right) {
+		vb = right[i2]
+	}
+	return va, vb
+}
+
+// build returns the rendered diff row for key k, or nil when the two rows are equal after normalization.
+func (b *diffRowBuilder) build(k string, left, right []string) []interface{} {
+	defer b.resetMask()
+	hasDiff := false
+	for i := 0; i < b.nCols; i++ {
+		va, vb := b.values(i, left, right)
+		n1, h1 := b.normalizeFP(va)
+		n2, h2 := b.normalizeFP(vb)
+		if h1 != h2 || n1 != n2 {
+			hasDiff = true
+			b.setDiff(i)
 		}
-		rowNum++
-		resetMask()
 	}
-	if !firstWritten {
-		if err := sw.SetRow("A1", []interface{}{"无变动项目"}); err != nil {
-			return err
+	if !hasDiff {
+		return nil
+	}
+
+	row := make([]interface{}, 0, 1+b.nCols*2)
+	row = append(row, safeCellValue(k))
+	// build row cells using computed diff bitset
+	for i := 0; i < b.nCols; i++ {
+		va, vb := b.values(i, left, right)
+		ca := excelize.Cell{Value: safeCellValue(va)}
+		cb := excelize.Cell{Value: safeCellValue(vb)}
+		if diffMaskGet(b.mask, i) && b.red > 0 {
+			ca.StyleID = b.red
+			cb.StyleID = b.red
 		}
+		row = append(row, ca, cb)
 	}
-	return sw.Flush()
+	return row
 }
 
 func safeCellValue(v string) interface{} {
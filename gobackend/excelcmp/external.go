@@ -0,0 +1,432 @@
+package excelcmp
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// External-memory compare path.
+//
+// loadKeyedSheetXLSX keeps both sheets in key->row maps, so memory grows with the inputs.
+// For big inputs each file is instead streamed into sorted run files (ordered by normalized key),
+// the runs are k-way merged, and the two merged streams are merge-joined. Because the in-memory
+// path also sorts ReducedKeys / IncKeys / CommonKeys byte-wise, both paths produce the same export.
+
+const (
+	defaultExternalThresholdMB = 32 // total size of both inputs (xlsx is compressed ~10x)
+	defaultExternalRunMB       = 64 // approximate in-memory size of one sorted run before spilling
+)
+
+// useExternalCompare reports whether the inputs are big enough to switch to the spill-to-disk path.
+// Threshold: COMPARE_EXTERNAL_SORT_THRESHOLD_MB (sum of both file sizes).
+func useExternalCompare(paths ...string) bool {
+	threshold := int64(readEnvIntDefault("COMPARE_EXTERNAL_SORT_THRESHOLD_MB", defaultExternalThresholdMB)) << 20
+	var total int64
+	for _, p := range paths {
+		if st, err := os.Stat(p); err == nil {
+			total += st.Size()
+		}
+	}
+	return total >= threshold
+}
+
+func externalRunBytes() int64 {
+	return int64(readEnvIntDefault("COMPARE_EXTERNAL_RUN_MB", defaultExternalRunMB)) << 20
+}
+
+func generateCompareExportExternal(ctx context.Context, file1Path, file2Path, file1Name, file2Name, outPath string, runBytes int64, opts Options) error {
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return fmt.Errorf("创建输出目录失败: %w", err)
+	}
+	// Runs live next to the result (inside the job dir) and are always removed.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(outPath), ".extsort-")
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	sp1 := newRunSpiller(tmpDir, "file1", runBytes)
+	c1 := newKeyedCounter(opts.rowReporter(PhaseReadFile1), sp1.add)
+	headers1, key, err := scanKeyedSheetXLSX(ctx, file1Path, 5, "", true, c1.start, c1.add)
+	if err == nil {
+		err = sp1.flush()
+	}
+	if err != nil {
+		return fmt.Errorf("读取文件1失败: %w", err)
+	}
+	c1.finish()
+	sp2 := newRunSpiller(tmpDir, "file2", runBytes)
+	c2 := newKeyedCounter(opts.rowReporter(PhaseReadFile2), sp2.add)
+	headers2, _, err := scanKeyedSheetXLSX(ctx, file2Path, 0, key, false, c2.start, c2.add)
+	if err == nil {
+		err = sp2.flush()
+	}
+	if err == nil {
+		c2.finish()
+	}
+	if err != nil {
+		return fmt.Errorf("读取文件2失败: %w", err)
+	}
+	if strings.TrimSpace(key) == "" {
+		return errors.New("主键列为空")
+	}
+
+	it1, err := openMergedRuns(sp1.runs)
+	if err != nil {
+		return err
+	}
+	defer it1.close()
+	it2, err := openMergedRuns(sp2.runs)
+	if err != nil {
+		return err
+	}
+	defer it2.close()
+
+	art := newArtifactsLayout(headers1, headers2, key)
+	f, sheets, redStyle := newCompareWorkbook(file1Name, file2Name)
+	incW, err := newKeyedSheetWriter(f, sheets.inc, art.IncHeaders, "无增加项")
+	if err != nil {
+		return err
+	}
+	redW, err := newKeyedSheetWriter(f, sheets.red, art.RedHeaders, "无减少项")
+	if err != nil {
+		return err
+	}
+	diffSW, err := f.NewStreamWriter(sheets.diff)
+	if err != nil {
+		return err
+	}
+	diffW := newDiffSheetWriter(diffSW, art, file1Name, file2Name, redStyle)
+
+	// Merge-join: both streams are sorted by key, duplicates are dropped (and reported) by the iterators.
+	// Progress counts records consumed from both sides.
+	joinTotal := c1.n + c2.n
+	var joined int64
+	report := opts.rowReporter(PhaseDiff)
+	advanced := func(n int64) {
+		joined += n
+		if report != nil && joined/progressEvery != (joined-n)/progressEvery {
+			report(joined, joinTotal)
+		}
+	}
+	if report != nil {
+		report(0, joinTotal)
+	}
+	a, okA, err := it1.next()
+	if err != nil {
+		return err
+	}
+	b, okB, err := it2.next()
+	if err != nil {
+		return err
+	}
+	for okA || okB {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch {
+		case okA && (!okB || a.key < b.key):
+			if err := redW.add(a.row); err != nil {
+				return err
+			}
+			advanced(1)
+			if a, okA, err = it1.next(); err != nil {
+				return err
+			}
+		case okB && (!okA || b.key < a.key):
+			if err := incW.add(b.row); err != nil {
+				return err
+			}
+			advanced(1)
+			if b, okB, err = it2.next(); err != nil {
+				return err
+			}
+		default:
+			if err := diffW.add(a.key, a.row, b.row); err != nil {
+				return err
+			}
+			advanced(2)
+			if a, okA, err = it1.next(); err != nil {
+				return err
+			}
+			if b, okB, err = it2.next(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(it1.dups) > 0 {
+		return fmt.Errorf("文件1主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", key, it1.dups)
+	}
+	if len(it2.dups) > 0 {
+		return fmt.Errorf("文件2主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", key, it2.dups)
+	}
+
+	if report != nil {
+		report(joinTotal, joinTotal)
+	}
+
+	opts.report(PhaseWriteExport, 0, 1)
+	if err := incW.finish(); err != nil {
+		return err
+	}
+	if err := redW.finish(); err != nil {
+		return err
+	}
+	if err := diffW.finish(); err != nil {
+		return err
+	}
+	if err := saveWorkbook(f, outPath); err != nil {
+		return err
+	}
+	opts.report(PhaseWriteExport, 1, 1)
+	return nil
+}
+
+type spillRecord struct {
+	key string
+	row []string
+}
+
+// runSpiller buffers rows until ~budget bytes, then writes them sorted by key as one run file.
+type runSpiller struct {
+	dir    string
+	prefix string
+	budget int64
+
+	buf   []spillRecord
+	bytes int64
+	runs  []string
+}
+
+func newRunSpiller(dir, prefix string, budget int64) *runSpiller {
+	if budget <= 0 {
+		budget = int64(defaultExternalRunMB) << 20
+	}
+	return &runSpiller{dir: dir, prefix: prefix, budget: budget}
+}
+
+func (s *runSpiller) add(k string, row []string) error {
+	s.buf = append(s.buf, spillRecord{key: k, row: row})
+	// Rough heap cost: string headers + bytes.
+	size := int64(len(k)) + 16*int64(len(row)+2)
+	for _, c := range row {
+		size += int64(len(c))
+	}
+	s.bytes += size
+	if s.bytes >= s.budget {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *runSpiller) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	// Stable: equal keys keep sheet order, so the first occurrence wins like the in-memory path.
+	sort.SliceStable(s.buf, func(i, j int) bool { return s.buf[i].key < s.buf[j].key })
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%s_run%04d", s.prefix, len(s.runs)))
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(fh, 1<<20)
+	for _, r := range s.buf {
+		if err := writeSpillRecord(bw, r); err != nil {
+			_ = fh.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		_ = fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	s.runs = append(s.runs, path)
+	s.buf = s.buf[:0]
+	s.bytes = 0
+	return nil
+}
+
+// Record layout: uvarint(len key) key uvarint(ncols) { uvarint(len cell) cell }...
+func writeSpillRecord(w *bufio.Writer, r spillRecord) error {
+	var tmp [binary.MaxVarintLen64]byte
+	putString := func(s string) error {
+		n := binary.PutUvarint(tmp[:], uint64(len(s)))
+		if _, err := w.Write(tmp[:n]); err != nil {
+			return err
+		}
+		_, err := w.WriteString(s)
+		return err
+	}
+	if err := putString(r.key); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(tmp[:], uint64(len(r.row)))
+	if _, err := w.Write(tmp[:n]); err != nil {
+		return err
+	}
+	for _, c := range r.row {
+		if err := putString(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSpillRecord(r *bufio.Reader) (spillRecord, error) {
+	getString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	k, err := getString()
+	if err != nil {
+		return spillRecord{}, err // io.EOF on a clean record boundary
+	}
+	ncols, err := binary.ReadUvarint(r)
+	if err != nil {
+		return spillRecord{}, io.ErrUnexpectedEOF
+	}
+	row := make([]string, ncols)
+	for i := range row {
+		if row[i], err = getString(); err != nil {
+			return spillRecord{}, io.ErrUnexpectedEOF
+		}
+	}
+	return spillRecord{key: k, row: row}, nil
+}
+
+type runReader struct {
+	idx int
+	fh  *os.File
+	r   *bufio.Reader
+	cur spillRecord
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].cur.key != h[j].cur.key {
+		return h[i].cur.key < h[j].cur.key
+	}
+	return h[i].idx < h[j].idx // earlier run == earlier in sheet
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// mergedRuns yields records from all runs in key order, skipping (and recording) duplicate keys.
+type mergedRuns struct {
+	h       runHeap
+	all     []*runReader
+	lastKey string
+	started bool
+	dups    []string
+}
+
+func openMergedRuns(paths []string) (*mergedRuns, error) {
+	m := &mergedRuns{}
+	for i, p := range paths {
+		fh, err := os.Open(p)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		rr := &runReader{idx: i, fh: fh, r: bufio.NewReaderSize(fh, 256<<10)}
+		m.all = append(m.all, rr)
+		rec, err := readSpillRecord(rr.r)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			m.close()
+			return nil, fmt.Errorf("读取临时文件失败: %w", err)
+		}
+		rr.cur = rec
+		m.h = append(m.h, rr)
+	}
+	heap.Init(&m.h)
+	return m, nil
+}
+
+func (m *mergedRuns) pop() (spillRecord, bool, error) {
+	if len(m.h) == 0 {
+		return spillRecord{}, false, nil
+	}
+	top := m.h[0]
+	rec := top.cur
+	next, err := readSpillRecord(top.r)
+	switch {
+	case err == io.EOF:
+		heap.Pop(&m.h)
+	case err != nil:
+		return spillRecord{}, false, fmt.Errorf("读取临时文件失败: %w", err)
+	default:
+		top.cur = next
+		heap.Fix(&m.h, 0)
+	}
+	return rec, true, nil
+}
+
+func (m *mergedRuns) next() (spillRecord, bool, error) {
+	for {
+		rec, ok, err := m.pop()
+		if err != nil || !ok {
+			return rec, ok, err
+		}
+		if m.started && rec.key == m.lastKey {
+			if len(m.dups) < 10 && (len(m.dups) == 0 || m.dups[len(m.dups)-1] != rec.key) {
+				m.dups = append(m.dups, rec.key)
+			}
+			continue
+		}
+		m.started = true
+		m.lastKey = rec.key
+		return rec, true, nil
+	}
+}
+
+func (m *mergedRuns) close() {
+	for _, rr := range m.all {
+		_ = rr.fh.Close()
+	}
+}
+
+func readEnvIntDefault(key string, defaultVal int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return defaultVal
+	}
+	return n
+}
@@ -1,6 +1,7 @@
 package excelcmp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -12,34 +13,187 @@ type keyedSheet struct {
 	Headers   []string
 	Key       string
 	RowsByKey map[string][]string // normalized key -> full row (len == len(Headers))
+	Order     []string            // normalized keys in sheet order (first occurrence)
 }
 
-func loadKeyedSheetXLSX(path string, checkRows int, key string, allowGuess bool) (*keyedSheet, []string, error) {
-	f, err := excelize.OpenFile(path)
+// report (optional) receives rows read so far and the estimated total.
+func loadKeyedSheetXLSX(ctx context.Context, path string, checkRows int, key string, allowGuess bool, report func(done, total int64)) (*keyedSheet, []string, error) {
+	c := newKeyedCollector()
+	cnt := newKeyedCounter(report, c.add)
+	headers, keyUsed, err := scanKeyedSheetXLSX(ctx, path, checkRows, key, allowGuess, cnt.start, cnt.add)
 	if err != nil {
 		return nil, nil, err
 	}
+	cnt.finish()
+	return c.sheet(headers, keyUsed), c.dups, nil
+}
+
+// loadKeyedPairXLSX loads file1 and file2 concurrently. file2 needs the key guessed from file1's
+// first rows, so it starts as soon as file1's peek resolves the key rather than after file1 is done.
+// Errors keep the serial order: file1 read error, file1 duplicates, then file2.
+func loadKeyedPairXLSX(ctx context.Context, file1Path, file2Path string, opts Options) (*keyedSheet, *keyedSheet, error) {
+	keyCh := make(chan string, 1)
+	done1 := make(chan struct{})
+	var (
+		s1    *keyedSheet
+		dup1  []string
+		err1  error
+		key   string
+		found bool
+	)
+	go func() {
+		defer close(done1)
+		c := newKeyedCollector()
+		cnt := newKeyedCounter(opts.rowReporter(PhaseReadFile1), c.add)
+		onStart := func(k string, total int64) {
+			cnt.start(k, total)
+			keyCh <- k
+		}
+		headers, keyUsed, err := scanKeyedSheetXLSX(ctx, file1Path, 5, "", true, onStart, cnt.add)
+		if err != nil {
+			err1 = err
+			return
+		}
+		cnt.finish()
+		s1, dup1 = c.sheet(headers, keyUsed), c.dups
+	}()
+	select {
+	case key = <-keyCh:
+		found = true
+	case <-done1:
+	}
+
+	var (
+		s2   *keyedSheet
+		dup2 []string
+		err2 error
+	)
+	if found || err1 == nil {
+		if !found {
+			key = s1.Key // empty file1: let file2 report the missing key like the serial path
+		}
+		s2, dup2, err2 = loadKeyedSheetXLSX(ctx, file2Path, 0, key, false, opts.rowReporter(PhaseReadFile2))
+	}
+	<-done1
+
+	if err1 != nil {
+		return nil, nil, fmt.Errorf("读取文件1失败: %w", err1)
+	}
+	if len(dup1) > 0 {
+		return nil, nil, fmt.Errorf("文件1主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", s1.Key, dup1)
+	}
+	if err2 != nil {
+		return nil, nil, fmt.Errorf("读取文件2失败: %w", err2)
+	}
+	if len(dup2) > 0 {
+		return nil, nil, fmt.Errorf("文件2主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", s1.Key, dup2)
+	}
+	return s1, s2, nil
+}
+
+// keyedCollector builds the key->row map; the first occurrence of a key wins and
+// up to 10 duplicate keys are reported.
+type keyedCollector struct {
+	rowsByKey map[string][]string
+	order     []string
+	dups      []string
+	seenDup   map[string]struct{}
+}
+
+func newKeyedCollector() *keyedCollector {
+	return &keyedCollector{
+		rowsByKey: make(map[string][]string, 1024),
+		order:     make([]string, 0, 1024),
+		dups:      make([]string, 0, 10),
+		seenDup:   make(map[string]struct{}),
+	}
+}
+
+func (c *keyedCollector) add(k string, row []string) error {
+	if _, ok := c.rowsByKey[k]; ok {
+		if _, already := c.seenDup[k]; !already {
+			c.seenDup[k] = struct{}{}
+			if len(c.dups) < 10 {
+				c.dups = append(c.dups, k)
+			}
+		}
+		return nil
+	}
+	c.rowsByKey[k] = row
+	c.order = append(c.order, k)
+	return nil
+}
+
+func (c *keyedCollector) sheet(headers []string, key string) *keyedSheet {
+	return &keyedSheet{Headers: headers, Key: key, RowsByKey: c.rowsByKey, Order: c.order}
+}
+
+// keyedCounter forwards rows to emit and reports read progress every progressEvery rows.
+type keyedCounter struct {
+	report func(done, total int64)
+	emit   func(k string, row []string) error
+	n      int64
+	total  int64
+}
+
+func newKeyedCounter(report func(done, total int64), emit func(k string, row []string) error) *keyedCounter {
+	return &keyedCounter{report: report, emit: emit}
+}
+
+func (c *keyedCounter) start(_ string, total int64) {
+	c.total = total
+	if c.report != nil {
+		c.report(0, total)
+	}
+}
+
+func (c *keyedCounter) add(k string, row []string) error {
+	c.n++
+	if c.report != nil && c.n%progressEvery == 0 {
+		c.report(c.n, max(c.total, c.n))
+	}
+	return c.emit(k, row)
+}
+
+func (c *keyedCounter) finish() {
+	if c.report != nil {
+		c.report(c.n, c.n)
+	}
+}
+
+// scanKeyedSheetXLSX streams the first sheet and calls emit for every data row with a non-empty
+// normalized key (rows are padded to len(headers)). It does not keep rows itself, so callers
+// decide whether rows go to a map (loadKeyedSheetXLSX) or to disk (external sort).
+//
+// onStart (optional) is called once the key column is resolved, before any row is emitted, with the
+// estimated data row count. An empty sheet returns (nil, key, nil) without calling onStart or emit.
+// Cancelling ctx stops the scan with ctx.Err().
+func scanKeyedSheetXLSX(ctx context.Context, path string, checkRows int, key string, allowGuess bool, onStart func(key string, totalRows int64), emit func(k string, row []string) error) ([]string, string, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, "", err
+	}
 	defer func() { _ = f.Close() }()
 
 	sheets := f.GetSheetList()
 	if len(sheets) == 0 {
-		return &keyedSheet{Headers: nil, Key: key, RowsByKey: map[string][]string{}}, nil, nil
+		return nil, key, nil
 	}
 	sheet := sheets[0]
 
 	rowsIter, err := f.Rows(sheet)
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
 	defer func() { _ = rowsIter.Close() }()
 
 	// header
 	if !rowsIter.Next() {
-		return &keyedSheet{Headers: nil, Key: key, RowsByKey: map[string][]string{}}, nil, nil
+		return nil, key, nil
 	}
 	rawHeader, err := rowsIter.Columns()
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
 	headers := normalizeHeaders(rawHeader)
 
@@ -51,7 +205,7 @@ func loadKeyedSheetXLSX(path string, checkRows int, key string, allowGuess bool)
 	for len(peek) < checkRows && rowsIter.Next() {
 		cols, err := rowsIter.Columns()
 		if err != nil {
-			return nil, nil, err
+			return nil, "", err
 		}
 		peek = append(peek, padRow(cols, len(headers)))
 	}
@@ -61,54 +215,50 @@ func loadKeyedSheetXLSX(path string, checkRows int, key string, allowGuess bool)
 		tbl := &Table{Headers: headers, Rows: peek}
 		k, ok := GuessPrimaryKeyColumn(tbl, checkRows)
 		if !ok {
-			return nil, nil, errors.New("无法猜测主键列，请确保包含明显的编号列")
+			return nil, "", errors.New("无法猜测主键列，请确保包含明显的编号列")
 		}
 		keyUsed = k
 	}
 	if keyUsed == "" {
-		return nil, nil, errors.New("主键列为空")
+		return nil, "", errors.New("主键列为空")
 	}
 	keyIdx := indexOfHeader(headers, keyUsed)
 	if keyIdx < 0 {
-		return nil, nil, fmt.Errorf("Excel文件中必须同时包含%q列", keyUsed)
+		return nil, "", fmt.Errorf("Excel文件中必须同时包含%q列", keyUsed)
+	}
+	if onStart != nil {
+		onStart(keyUsed, sheetDataRowEstimate(f, sheet))
 	}
 
-	rowsByKey := make(map[string][]string, 1024)
-	dups := make([]string, 0, 10)
-	seenDup := make(map[string]struct{})
-
-	add := func(row []string) {
+	add := func(row []string) error {
 		if keyIdx >= len(row) {
-			return
+			return nil
 		}
 		k := normalizeScalarForCompare(row[keyIdx])
 		if strings.TrimSpace(k) == "" {
-			return
+			return nil
 		}
-		if _, ok := rowsByKey[k]; ok {
-			if _, already := seenDup[k]; !already {
-				seenDup[k] = struct{}{}
-				if len(dups) < 10 {
-					dups = append(dups, k)
-				}
-			}
-			return
-		}
-		rowsByKey[k] = row
+		return emit(k, row)
 	}
 
 	for _, r := range peek {
-		add(r)
+		if err := add(r); err != nil {
+			return nil, "", err
+		}
 	}
 	for rowsIter.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
 		cols, err := rowsIter.Columns()
 		if err != nil {
-			return nil, nil, err
+			return nil, "", err
+		}
+		if err := add(padRow(cols, len(headers))); err != nil {
+			return nil, "", err
 		}
-		add(padRow(cols, len(headers)))
 	}
-
-	return &keyedSheet{Headers: headers, Key: keyUsed, RowsByKey: rowsByKey}, dups, nil
+	return headers, keyUsed, nil
 }
 
 func padRow(cols []string, n int) []string {
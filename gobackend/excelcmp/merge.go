@@ -0,0 +1,242 @@
+package excelcmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// MergePrecedence decides which file's value is kept when both files have a value for the same cell.
+type MergePrecedence string
+
+const (
+	MergeFile1Wins    MergePrecedence = "file1"
+	MergeFile2Wins    MergePrecedence = "file2"
+	MergeNonEmptyWins MergePrecedence = "nonempty" // prefer file2, fall back to file1 when file2 is empty
+)
+
+// ParseMergePrecedence accepts the API spelling of a precedence ("" defaults to file2).
+func ParseMergePrecedence(s string) (MergePrecedence, error) {
+	switch MergePrecedence(strings.ToLower(strings.TrimSpace(s))) {
+	case "", MergeFile2Wins:
+		return MergeFile2Wins, nil
+	case MergeFile1Wins:
+		return MergeFile1Wins, nil
+	case MergeNonEmptyWins:
+		return MergeNonEmptyWins, nil
+	default:
+		return "", fmt.Errorf("不支持的合并优先级 %q（可选 file1 / file2 / nonempty）", s)
+	}
+}
+
+// GenerateMergedExportXLSX writes a single merged ("golden") table built from both files.
+//
+// The winning file (file1 for MergeFile1Wins, file2 otherwise) defines the workbook shape:
+// its column order comes first (RedHeaders / IncHeaders), the other file's extra columns are
+// appended, and rows keep the winning file's order with the other file's extra rows appended.
+// Cells whose value was taken from the other file are highlighted.
+func GenerateMergedExportXLSX(file1Path, file2Path, file1Name, file2Name, outPath string, prec MergePrecedence) error {
+	return GenerateMergedExportXLSXWithOptions(context.Background(), file1Path, file2Path, file1Name, file2Name, outPath, prec, Options{})
+}
+
+// GenerateMergedExportXLSXWithOptions is GenerateMergedExportXLSX with progress reporting and cancellation.
+func GenerateMergedExportXLSXWithOptions(ctx context.Context, file1Path, file2Path, file1Name, file2Name, outPath string, prec MergePrecedence, opts Options) error {
+	if strings.TrimSpace(file1Path) == "" || strings.TrimSpace(file2Path) == "" {
+		return errors.New("输入文件路径为空")
+	}
+	if strings.TrimSpace(outPath) == "" {
+		return errors.New("输出路径为空")
+	}
+	prec, err := ParseMergePrecedence(string(prec))
+	if err != nil {
+		return err
+	}
+
+	s1, s2, err := loadKeyedPairXLSX(ctx, file1Path, file2Path, opts)
+	if err != nil {
+		return err
+	}
+	opts.report(PhaseDiff, 0, 1)
+	art, err := compareArtifactsFromMaps(s1.Headers, s2.Headers, s1.RowsByKey, s2.RowsByKey, s1.Key)
+	if err != nil {
+		return err
+	}
+	plan := newMergePlan(art, s1.Order, s2.Order, prec)
+	opts.report(PhaseDiff, 1, 1)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f := excelize.NewFile()
+	defSheet := f.GetSheetName(0)
+	if defSheet == "" {
+		defSheet = "Sheet1"
+	}
+	used := make(map[string]struct{}, 1)
+	sheet := uniqueSheetName(fmt.Sprintf("%s合并结果", sheetBaseName(plan.primaryName(file1Name, file2Name))), used)
+	_ = f.SetSheetName(defSheet, sheet)
+
+	// Light yellow: value taken from the other file.
+	otherStyle, _ := f.NewStyle(&excelize.Style{
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFEB9C"}},
+		Font: &excelize.Font{Color: "9C5700"},
+	})
+	total := int64(len(plan.keys))
+	opts.report(PhaseWriteExport, 0, total)
+	if err := writeMergedSheetStream(f, sheet, plan, otherStyle); err != nil {
+		return err
+	}
+	if err := saveWorkbook(f, outPath); err != nil {
+		return err
+	}
+	opts.report(PhaseWriteExport, total, total)
+	return nil
+}
+
+// mergePlan resolves the merged layout once so the writer only does lookups.
+type mergePlan struct {
+	prec        MergePrecedence
+	file1Shaped bool
+
+	headers []string
+	idx1    []int // aligned with headers: index into file1 row (or -1)
+	idx2    []int // aligned with headers: index into file2 row (or -1)
+	keys    []string
+
+	byKey1 map[string][]string
+	byKey2 map[string][]string
+}
+
+func newMergePlan(art *Artifacts, order1, order2 []string, prec MergePrecedence) *mergePlan {
+	p := &mergePlan{
+		prec:        prec,
+		file1Shaped: prec == MergeFile1Wins,
+		byKey1:      art.LeftByKey,
+		byKey2:      art.RightByKey,
+	}
+	primaryHeaders, otherHeaders := art.IncHeaders, art.RedHeaders
+	primaryOrder, otherOrder := order2, order1
+	primaryMap, otherMap := art.RightByKey, art.LeftByKey
+	if p.file1Shaped {
+		primaryHeaders, otherHeaders = art.RedHeaders, art.IncHeaders
+		primaryOrder, otherOrder = order1, order2
+		primaryMap, otherMap = art.LeftByKey, art.RightByKey
+	}
+
+	seen := make(map[string]struct{}, len(primaryHeaders)+len(otherHeaders))
+	p.headers = make([]string, 0, len(primaryHeaders)+len(otherHeaders))
+	for _, h := range primaryHeaders {
+		seen[h] = struct{}{}
+		p.headers = append(p.headers, h)
+	}
+	for _, h := range otherHeaders {
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		p.headers = append(p.headers, h)
+	}
+	p.idx1, p.idx2 = alignedColumnIndices(p.headers, headerIndexMap(art.RedHeaders), headerIndexMap(art.IncHeaders))
+
+	p.keys = make([]string, 0, len(primaryMap)+len(otherMap))
+	p.keys = append(p.keys, primaryOrder...)
+	for _, k := range otherOrder {
+		if _, ok := primaryMap[k]; ok {
+			continue
+		}
+		p.keys = append(p.keys, k)
+	}
+	return p
+}
+
+func (p *mergePlan) primaryName(file1Name, file2Name string) string {
+	if p.file1Shaped {
+		return file1Name
+	}
+	return file2Name
+}
+
+// cell returns the merged value and whether it was taken from the non-primary file.
+func (p *mergePlan) cell(row1, row2 []string, col int) (string, bool) {
+	has1 := row1 != nil && p.idx1[col] >= 0
+	has2 := row2 != nil && p.idx2[col] >= 0
+	v1 := ""
+	v2 := ""
+	if has1 {
+		v1 = cellAt(row1, p.idx1[col])
+	}
+	if has2 {
+		v2 = cellAt(row2, p.idx2[col])
+	}
+
+	use1 := false
+	switch {
+	case has1 && !has2:
+		use1 = true
+	case has2 && !has1:
+		use1 = false
+	case p.prec == MergeFile1Wins:
+		use1 = true
+	case p.prec == MergeNonEmptyWins:
+		use1 = normalizeScalarForCompare(v2) == "" && normalizeScalarForCompare(v1) != ""
+	}
+
+	val := v2
+	if use1 {
+		val = v1
+	}
+	// "Taken from the other file" only matters when it changes what the primary file had.
+	fromOther := use1 != p.file1Shaped
+	if fromOther {
+		primary := v2
+		if p.file1Shaped {
+			primary = v1
+		}
+		fromOther = normalizeScalarForCompare(primary) != normalizeScalarForCompare(val)
+	}
+	return val, fromOther
+}
+
+func writeMergedSheetStream(f *excelize.File, sheet string, p *mergePlan, otherStyle int) error {
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	if len(p.headers) == 0 || len(p.keys) == 0 {
+		if err := sw.SetRow("A1", []interface{}{"无数据"}); err != nil {
+			return err
+		}
+		return sw.Flush()
+	}
+	rowNum := 1
+	header := make([]interface{}, len(p.headers))
+	for i, h := range p.headers {
+		header[i] = h
+	}
+	if err := sw.SetRow(cellAxis(rowNum, 1), header); err != nil {
+		return err
+	}
+	rowNum++
+
+	for _, k := range p.keys {
+		row1 := p.byKey1[k]
+		row2 := p.byKey2[k]
+		row := make([]interface{}, len(p.headers))
+		for i := range p.headers {
+			v, fromOther := p.cell(row1, row2, i)
+			c := excelize.Cell{Value: safeCellValue(v)}
+			if fromOther && otherStyle > 0 {
+				c.StyleID = otherStyle
+			}
+			row[i] = c
+		}
+		if err := sw.SetRow(cellAxis(rowNum, 1), row); err != nil {
+			return err
+		}
+		rowNum++
+	}
+	return sw.Flush()
+}
@@ -0,0 +1,65 @@
+package excelcmp
+
+import (
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Phases reported through Options.Progress.
+const (
+	PhaseReadFile1   = "reading_file1"
+	PhaseReadFile2   = "reading_file2"
+	PhaseDiff        = "diffing"
+	PhaseWriteExport = "writing_export"
+)
+
+// Progress is one progress event. For row-based phases Done/Total count data rows; Total is
+// estimated from the sheet dimension and is 0 when the workbook does not record one.
+// Every phase ends with an event where Done == Total.
+type Progress struct {
+	Phase string
+	Done  int64
+	Total int64
+}
+
+// Options holds optional hooks for the Generate*WithOptions functions (zero value: none).
+type Options struct {
+	// Progress may be called from several goroutines (both inputs are read concurrently).
+	Progress func(Progress)
+}
+
+func (o Options) report(phase string, done, total int64) {
+	if o.Progress != nil {
+		o.Progress(Progress{Phase: phase, Done: done, Total: total})
+	}
+}
+
+// rowReporter returns a callback for loaders, or nil when progress is disabled.
+func (o Options) rowReporter(phase string) func(done, total int64) {
+	if o.Progress == nil {
+		return nil
+	}
+	return func(done, total int64) { o.report(phase, done, total) }
+}
+
+// progressEvery is how many rows pass between two progress events inside a phase.
+const progressEvery = 2000
+
+// sheetDataRowEstimate reads the <dimension ref="A1:K1001"> of a sheet (no row scan) and
+// returns the data row count below the header, or 0 when unknown.
+func sheetDataRowEstimate(f *excelize.File, sheet string) int64 {
+	dim, err := f.GetSheetDimension(sheet)
+	if err != nil || dim == "" {
+		return 0
+	}
+	last := dim
+	if i := strings.LastIndex(dim, ":"); i >= 0 {
+		last = dim[i+1:]
+	}
+	_, row, err := excelize.CellNameToCoordinates(last)
+	if err != nil || row <= 1 {
+		return 0
+	}
+	return int64(row - 1)
+}
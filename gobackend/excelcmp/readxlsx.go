@@ -1,6 +1,7 @@
 package excelcmp
 
 import (
+	"archive/zip"
 	"fmt"
 	"strings"
 
@@ -90,3 +91,21 @@ func normalizeHeaders(raw []string) []string {
 	}
 	return out
 }
+
+// SheetCount returns how many worksheets an .xlsx holds, from the zip directory alone (no sheet
+// is parsed, so it is cheap for large files).
+func SheetCount(path string) (int, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = zr.Close() }()
+	n := 0
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(f.Name, "/")
+		if strings.HasPrefix(name, "xl/worksheets/") && strings.HasSuffix(name, ".xml") && !strings.Contains(name[len("xl/worksheets/"):], "/") {
+			n++
+		}
+	}
+	return n, nil
+}
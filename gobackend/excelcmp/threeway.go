@@ -0,0 +1,484 @@
+package excelcmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ChangeKind classifies a three-way difference (base -> left, base -> right).
+type ChangeKind int
+
+const (
+	ChangeNone      ChangeKind = iota
+	ChangeLeftOnly             // only left differs from base
+	ChangeRightOnly            // only right differs from base
+	ChangeBothSame             // both differ from base in the same way
+	ChangeConflict             // both differ from base, and differ from each other
+	// ChangeBothMerged is row-level only: both sides edited the row, but on disjoint cells
+	// (or with identical values), so the row can be merged without a conflict.
+	ChangeBothMerged
+)
+
+// RowState describes what happened to a row on one side relative to base.
+type RowState int
+
+const (
+	RowUnchanged RowState = iota
+	RowAdded
+	RowRemoved
+	RowModified
+)
+
+type ThreeWayRow struct {
+	Key   string
+	Kind  ChangeKind
+	Left  RowState
+	Right RowState
+	// Cells is aligned with ThreeWayArtifacts.Cols.
+	Cells []ChangeKind
+}
+
+type ThreeWayArtifacts struct {
+	Key  string
+	Cols []string // union of base/left/right columns (key excluded), base order first
+
+	// Pairwise artifacts (base vs left, base vs right) built on the regular two-way alignment.
+	BaseLeft  *Artifacts
+	BaseRight *Artifacts
+
+	BaseByKey  map[string][]string
+	LeftByKey  map[string][]string
+	RightByKey map[string][]string
+	ColIdxBase []int // aligned with Cols (or -1)
+	ColIdxL    []int
+	ColIdxR    []int
+
+	// Rows holds every changed row (ChangeNone rows are dropped), sorted by key.
+	Rows []ThreeWayRow
+}
+
+// Conflicts returns the rows classified as ChangeConflict.
+func (a *ThreeWayArtifacts) Conflicts() []ThreeWayRow {
+	if a == nil {
+		return nil
+	}
+	out := make([]ThreeWayRow, 0)
+	for _, r := range a.Rows {
+		if r.Kind == ChangeConflict {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func compareThreeWayFromMaps(baseHeaders, leftHeaders, rightHeaders []string, mb, ml, mr map[string][]string, key string) (*ThreeWayArtifacts, error) {
+	artL, err := compareArtifactsFromMaps(baseHeaders, leftHeaders, mb, ml, key)
+	if err != nil {
+		return nil, err
+	}
+	artR, err := compareArtifactsFromMaps(baseHeaders, rightHeaders, mb, mr, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Column union: base∪left order, then right-only columns.
+	cols := append([]string(nil), artL.OrderedCols...)
+	seen := make(map[string]struct{}, len(cols))
+	for _, c := range cols {
+		seen[c] = struct{}{}
+	}
+	for _, c := range artR.OrderedCols {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		cols = append(cols, c)
+	}
+	hb := headerIndexMap(baseHeaders)
+	idxB, idxL := alignedColumnIndices(cols, hb, headerIndexMap(leftHeaders))
+	_, idxR := alignedColumnIndices(cols, hb, headerIndexMap(rightHeaders))
+
+	// Row states per side, derived from the pairwise artifacts.
+	leftState := make(map[string]RowState, len(artL.ReducedKeys)+len(artL.IncKeys))
+	for _, k := range artL.ReducedKeys {
+		leftState[k] = RowRemoved
+	}
+	for _, k := range artL.IncKeys {
+		leftState[k] = RowAdded
+	}
+	rightState := make(map[string]RowState, len(artR.ReducedKeys)+len(artR.IncKeys))
+	for _, k := range artR.ReducedKeys {
+		rightState[k] = RowRemoved
+	}
+	for _, k := range artR.IncKeys {
+		rightState[k] = RowAdded
+	}
+
+	keys := make([]string, 0, len(mb)+len(artL.IncKeys)+len(artR.IncKeys))
+	for k := range mb {
+		keys = append(keys, k)
+	}
+	for _, k := range artL.IncKeys {
+		keys = append(keys, k)
+	}
+	for _, k := range artR.IncKeys {
+		if _, ok := ml[k]; ok {
+			continue // already added via left
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	art := &ThreeWayArtifacts{
+		Key:        key,
+		Cols:       cols,
+		BaseLeft:   artL,
+		BaseRight:  artR,
+		BaseByKey:  mb,
+		LeftByKey:  ml,
+		RightByKey: mr,
+		ColIdxBase: idxB,
+		ColIdxL:    idxL,
+		ColIdxR:    idxR,
+	}
+
+	for _, k := range keys {
+		b, inB := mb[k]
+		l, inL := ml[k]
+		r, inR := mr[k]
+		ls := leftState[k]
+		rs := rightState[k]
+
+		cells := make([]ChangeKind, len(cols))
+		lChanged := false
+		rChanged := false
+		for i := range cols {
+			vb := ""
+			if inB {
+				vb = normalizeScalarForCompare(cellAt(b, idxB[i]))
+			}
+			vl := vb
+			if inL {
+				vl = normalizeScalarForCompare(cellAt(l, idxL[i]))
+			} else if ls == RowRemoved {
+				vl = ""
+			}
+			vr := vb
+			if inR {
+				vr = normalizeScalarForCompare(cellAt(r, idxR[i]))
+			} else if rs == RowRemoved {
+				vr = ""
+			}
+			cells[i] = classifyCell(vb, vl, vr)
+			switch cells[i] {
+			case ChangeLeftOnly:
+				lChanged = true
+			case ChangeRightOnly:
+				rChanged = true
+			case ChangeBothSame, ChangeConflict:
+				lChanged, rChanged = true, true
+			}
+		}
+		if ls == RowUnchanged && lChanged {
+			ls = RowModified
+		}
+		if rs == RowUnchanged && rChanged {
+			rs = RowModified
+		}
+
+		kind := classifyRow(ls, rs, cells)
+		if kind == ChangeNone {
+			continue
+		}
+		art.Rows = append(art.Rows, ThreeWayRow{Key: k, Kind: kind, Left: ls, Right: rs, Cells: cells})
+	}
+	return art, nil
+}
+
+func classifyCell(base, left, right string) ChangeKind {
+	lc := left != base
+	rc := right != base
+	switch {
+	case !lc && !rc:
+		return ChangeNone
+	case lc && !rc:
+		return ChangeLeftOnly
+	case !lc && rc:
+		return ChangeRightOnly
+	case left == right:
+		return ChangeBothSame
+	default:
+		return ChangeConflict
+	}
+}
+
+func classifyRow(ls, rs RowState, cells []ChangeKind) ChangeKind {
+	if ls == RowUnchanged && rs == RowUnchanged {
+		return ChangeNone
+	}
+	if rs == RowUnchanged {
+		return ChangeLeftOnly
+	}
+	if ls == RowUnchanged {
+		return ChangeRightOnly
+	}
+	// Both sides touched the row.
+	if ls == RowRemoved && rs == RowRemoved {
+		return ChangeBothSame
+	}
+	if ls == RowRemoved || rs == RowRemoved {
+		// delete vs edit
+		return ChangeConflict
+	}
+	allSame := true
+	for _, c := range cells {
+		switch c {
+		case ChangeConflict:
+			return ChangeConflict
+		case ChangeLeftOnly, ChangeRightOnly:
+			allSame = false
+		}
+	}
+	if allSame {
+		return ChangeBothSame
+	}
+	return ChangeBothMerged
+}
+
+func cellAt(row []string, idx int) string {
+	if idx < 0 || idx >= len(row) {
+		return ""
+	}
+	return row[idx]
+}
+
+// GenerateThreeWayExportXLSX compares two edited copies (left/right) against a common base file.
+// The export contains an overview sheet of every changed row and a dedicated conflict sheet.
+func GenerateThreeWayExportXLSX(basePath, leftPath, rightPath, baseName, leftName, rightName, outPath string) error {
+	return GenerateThreeWayExportXLSXWithOptions(context.Background(), basePath, leftPath, rightPath, baseName, leftName, rightName, outPath, Options{})
+}
+
+// GenerateThreeWayExportXLSXWithOptions is GenerateThreeWayExportXLSX with progress reporting and
+// cancellation. Left/right are reported as file1/file2; reading the base (which fixes the key) is not reported.
+func GenerateThreeWayExportXLSXWithOptions(ctx context.Context, basePath, leftPath, rightPath, baseName, leftName, rightName, outPath string, opts Options) error {
+	if strings.TrimSpace(basePath) == "" || strings.TrimSpace(leftPath) == "" || strings.TrimSpace(rightPath) == "" {
+		return errors.New("输入文件路径为空")
+	}
+	if strings.TrimSpace(outPath) == "" {
+		return errors.New("输出路径为空")
+	}
+
+	sb, dupB, err := loadKeyedSheetXLSX(ctx, basePath, 5, "", true, nil)
+	if err != nil {
+		return fmt.Errorf("读取基准文件失败: %w", err)
+	}
+	if len(dupB) > 0 {
+		return fmt.Errorf("基准文件主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", sb.Key, dupB)
+	}
+	sl, dupL, err := loadKeyedSheetXLSX(ctx, leftPath, 0, sb.Key, false, opts.rowReporter(PhaseReadFile1))
+	if err != nil {
+		return fmt.Errorf("读取文件1失败: %w", err)
+	}
+	if len(dupL) > 0 {
+		return fmt.Errorf("文件1主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", sb.Key, dupL)
+	}
+	sr, dupR, err := loadKeyedSheetXLSX(ctx, rightPath, 0, sb.Key, false, opts.rowReporter(PhaseReadFile2))
+	if err != nil {
+		return fmt.Errorf("读取文件2失败: %w", err)
+	}
+	if len(dupR) > 0 {
+		return fmt.Errorf("文件2主键列“%s”存在重复值（示例: %v），请先去重或修正后再比对", sb.Key, dupR)
+	}
+
+	opts.report(PhaseDiff, 0, 1)
+	art, err := compareThreeWayFromMaps(sb.Headers, sl.Headers, sr.Headers, sb.RowsByKey, sl.RowsByKey, sr.RowsByKey, sb.Key)
+	if err != nil {
+		return err
+	}
+	opts.report(PhaseDiff, 1, 1)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	names := threeWayNames{
+		base:  displayName(baseName, "基准"),
+		left:  displayName(leftName, "文件1"),
+		right: displayName(rightName, "文件2"),
+	}
+
+	f := excelize.NewFile()
+	defSheet := f.GetSheetName(0)
+	if defSheet == "" {
+		defSheet = "Sheet1"
+	}
+	used := make(map[string]struct{}, 2)
+	allName := uniqueSheetName("三方变动", used)
+	conflictName := uniqueSheetName("冲突项", used)
+	_ = f.SetSheetName(defSheet, allName)
+	f.NewSheet(conflictName)
+	f.SetActiveSheet(0)
+
+	styles := newThreeWayStyles(f)
+	total := int64(len(art.Rows))
+	opts.report(PhaseWriteExport, 0, total)
+	if err := writeThreeWaySheetStream(f, allName, art, art.Rows, names, styles, "无变动项目"); err != nil {
+		return err
+	}
+	if err := writeThreeWaySheetStream(f, conflictName, art, art.Conflicts(), names, styles, "无冲突项"); err != nil {
+		return err
+	}
+	if err := saveWorkbook(f, outPath); err != nil {
+		return err
+	}
+	opts.report(PhaseWriteExport, total, total)
+	return nil
+}
+
+type threeWayNames struct {
+	base  string
+	left  string
+	right string
+}
+
+func displayName(name, fallback string) string {
+	if s := strings.TrimSpace(name); s != "" {
+		return s
+	}
+	return fallback
+}
+
+func (n threeWayNames) kindLabel(k ChangeKind) string {
+	switch k {
+	case ChangeLeftOnly:
+		return "仅" + n.left + "变动"
+	case ChangeRightOnly:
+		return "仅" + n.right + "变动"
+	case ChangeBothSame:
+		return "两侧相同变动"
+	case ChangeBothMerged:
+		return "两侧均变动（无冲突）"
+	case ChangeConflict:
+		return "冲突"
+	default:
+		return ""
+	}
+}
+
+func rowStateLabel(s RowState) string {
+	switch s {
+	case RowAdded:
+		return "新增"
+	case RowRemoved:
+		return "删除"
+	case RowModified:
+		return "修改"
+	default:
+		return "未变"
+	}
+}
+
+type threeWayStyles struct {
+	left     int
+	right    int
+	same     int
+	conflict int
+}
+
+func newThreeWayStyles(f *excelize.File) threeWayStyles {
+	mk := func(fill, font string) int {
+		id, _ := f.NewStyle(&excelize.Style{
+			Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{fill}},
+			Font: &excelize.Font{Color: font},
+		})
+		return id
+	}
+	return threeWayStyles{
+		left:     mk("DDEBF7", "1F4E78"), // blue
+		right:    mk("E2EFDA", "375623"), // green
+		same:     mk("FFF2CC", "7F6000"), // yellow
+		conflict: mk("FFC7CE", "9C0006"), // red (same as two-way diff)
+	}
+}
+
+func (s threeWayStyles) forKind(k ChangeKind) int {
+	switch k {
+	case ChangeLeftOnly:
+		return s.left
+	case ChangeRightOnly:
+		return s.right
+	case ChangeBothSame:
+		return s.same
+	case ChangeConflict:
+		return s.conflict
+	default:
+		return 0
+	}
+}
+
+func writeThreeWaySheetStream(f *excelize.File, sheet string, art *ThreeWayArtifacts, rows []ThreeWayRow, names threeWayNames, styles threeWayStyles, emptyMsg string) error {
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	if art == nil || len(rows) == 0 {
+		if err := sw.SetRow("A1", []interface{}{emptyMsg}); err != nil {
+			return err
+		}
+		return sw.Flush()
+	}
+
+	// header: [key, 变动类型, left 行状态, right 行状态, col(base), col(left), col(right), ...]
+	header := make([]interface{}, 0, 4+len(art.Cols)*3)
+	header = append(header, art.Key, "变动类型", names.left+"行状态", names.right+"行状态")
+	for _, c := range art.Cols {
+		header = append(header,
+			fmt.Sprintf("%s（%s）", c, names.base),
+			fmt.Sprintf("%s（%s）", c, names.left),
+			fmt.Sprintf("%s（%s）", c, names.right),
+		)
+	}
+	rowNum := 1
+	if err := sw.SetRow(cellAxis(rowNum, 1), header); err != nil {
+		return err
+	}
+	rowNum++
+
+	for _, tr := range rows {
+		b := art.BaseByKey[tr.Key]
+		l := art.LeftByKey[tr.Key]
+		r := art.RightByKey[tr.Key]
+		row := make([]interface{}, 0, 4+len(art.Cols)*3)
+		kindCell := excelize.Cell{Value: names.kindLabel(tr.Kind)}
+		if tr.Kind == ChangeConflict {
+			kindCell.StyleID = styles.conflict
+		}
+		row = append(row, safeCellValue(tr.Key), kindCell, rowStateLabel(tr.Left), rowStateLabel(tr.Right))
+		for i := range art.Cols {
+			cb := excelize.Cell{Value: safeCellValue(cellAt(b, art.ColIdxBase[i]))}
+			cl := excelize.Cell{Value: safeCellValue(cellAt(l, art.ColIdxL[i]))}
+			cr := excelize.Cell{Value: safeCellValue(cellAt(r, art.ColIdxR[i]))}
+			if st := styles.forKind(tr.Cells[i]); st > 0 {
+				switch tr.Cells[i] {
+				case ChangeLeftOnly:
+					cl.StyleID = st
+				case ChangeRightOnly:
+					cr.StyleID = st
+				default:
+					cl.StyleID = st
+					cr.StyleID = st
+				}
+			}
+			row = append(row, cb, cl, cr)
+		}
+		if err := sw.SetRow(cellAxis(rowNum, 1), row); err != nil {
+			return err
+		}
+		rowNum++
+	}
+	return sw.Flush()
+}
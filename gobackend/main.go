@@ -2,260 +2,216 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
-	"errors"
-	"fmt"
+	"flag"
 	"log"
-	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
+	"gobackend/admin"
+	"gobackend/auth"
 	"gobackend/compare"
+	"gobackend/domain"
+	"gobackend/objstore"
 	"gobackend/obs"
-	"gobackend/ossstore"
 	"gobackend/store"
 	"gobackend/streamq"
+	"gobackend/subscription"
+	"gobackend/wallet"
+	"gobackend/webhook"
 	"gobackend/wechat"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
-// BillingEvent 代表一次计费事件或扣费占位
-type BillingEvent struct {
-	IdempotencyKey string                 `json:"idempotencyKey"`
-	Amount         float64                `json:"amount"`
-	ApiCall        string                 `json:"apiCall,omitempty"`
-	Metadata       map[string]interface{} `json:"metadata,omitempty"`
-	CreatedAt      time.Time              `json:"createdAt"`
-	Confirmed      bool                   `json:"confirmed"`
-	Deducted       bool                   `json:"deducted"`
-}
-
-type memoryStore struct {
-	mu            sync.Mutex
-	events        map[string]*BillingEvent
-	baseBalance   float64
-	totalDeducted float64
-}
-
-func newMemoryStore() *memoryStore {
-	return &memoryStore{
-		events:      make(map[string]*BillingEvent),
-		baseBalance: 100.0, // 初始虚拟余额
-	}
-}
-
-func (s *memoryStore) ensurePending(key string, amount float64, apiCall string, metadata map[string]interface{}) *BillingEvent {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if ev, ok := s.events[key]; ok {
-		// 已存在则直接返回，保证幂等
-		return ev
-	}
-	ev := &BillingEvent{
-		IdempotencyKey: key,
-		Amount:         amount,
-		ApiCall:        apiCall,
-		Metadata:       metadata,
-		CreatedAt:      time.Now(),
-		Confirmed:      false,
-		Deducted:       false,
-	}
-	s.events[key] = ev
-	return ev
-}
-
-func (s *memoryStore) markDeducted(key string, amount float64, apiCall string) (*BillingEvent, error) {
-	if key == "" {
-		return nil, errors.New("idempotencyKey 不能为空")
-	}
-	if amount <= 0 {
-		return nil, errors.New("amount 必须为正数")
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	ev, ok := s.events[key]
-	if !ok {
-		// 未提前创建也允许直接扣费，仍然记录
-		ev = &BillingEvent{
-			IdempotencyKey: key,
-			CreatedAt:      time.Now(),
-		}
-		s.events[key] = ev
-	}
-
-	// 幂等：如果已经扣费过直接返回
-	if ev.Deducted {
-		return ev, nil
-	}
-
-	ev.Amount = amount
-	if apiCall != "" {
-		ev.ApiCall = apiCall
-	}
-	ev.Confirmed = true
-	ev.Deducted = true
-	s.totalDeducted += amount
-	return ev, nil
-}
-
-func (s *memoryStore) balance() float64 {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return math.Max(0, s.baseBalance-s.totalDeducted)
-}
-
-var billingStore = newMemoryStore()
-
 func main() {
+	standalone := flag.Bool("standalone", false, "run API, compare worker and paygate worker in one process (in-memory queues and jobs, local files; no Redis/OSS)")
+	flag.Parse()
+
 	shutdownObs, _ := obs.Init("go-api")
 	defer func() { _ = shutdownObs(context.Background()) }()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", handleHealth)
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/profile", handleProfile)
-	mux.HandleFunc("/billing/pending", handleCreatePending)
-	mux.HandleFunc("/billing/deduct", handleDeduct)
+
+	// Session tokens (Bearer or cookie); AUTH_JWT_SECRET must be shared by all API replicas.
+	tokens := auth.NewTokensFromEnv()
 
 	// Compare jobs (pay-gated export)
 	tmpRoot := readEnvDefault("TMP_ROOT", "./tmp")
+	if *standalone {
+		setupStandalone(mux, tmpRoot, tokens)
+	} else {
+		setupRedis(mux, tmpRoot, tokens)
+	}
+
+	addr := ":" + readEnvDefault("PORT", "8080")
+	log.Printf("Go API listening on %s", addr)
+	// Wrap order: cors -> otel/metrics -> auth -> mux
+	handler := corsMiddleware(obs.WrapHTTP("go-api", tokens.Middleware(mux)))
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+// setupRedis wires the production API: jobs in Redis, compare/paygate work queued on Redis Streams
+// for compare-worker and payment-worker, files in the object store.
+func setupRedis(mux *http.ServeMux, tmpRoot string, tokens *auth.Tokens) {
 	redisAddr := strings.TrimSpace(os.Getenv("REDIS_ADDR"))
 	if redisAddr == "" {
 		log.Fatalf("REDIS_ADDR 为空：Streams 队列模式必须启用 Redis")
 	}
-	jobStore, err := store.NewRedisCompareJobStore(redisAddr, os.Getenv("REDIS_PASSWORD"))
+	// Redis by default; COMPARE_JOB_STORE=sql / redis+sql keeps jobs in SQL (DATABASE_URL).
+	baseStore, err := store.NewCompareJobStoreFromEnv(redisAddr, os.Getenv("REDIS_PASSWORD"))
 	if err != nil {
-		log.Fatalf("init redis store failed: %v", err)
+		log.Fatalf("init job store failed: %v", err)
 	}
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: strings.TrimSpace(os.Getenv("REDIS_PASSWORD")),
 		DB:       readEnvIntDefault("REDIS_DB", 0),
 	})
-
-	var ossSt *ossstore.Store
-	if st, enabled, err := ossstore.NewFromEnv(); err != nil {
-		if enabled {
-			log.Fatalf("init oss store failed: %v", err)
-		}
-	} else if enabled {
-		ossSt = st
-		log.Printf("oss store enabled bucket=%s prefix=%s", strings.TrimSpace(os.Getenv("OSS_BUCKET")), strings.TrimSpace(os.Getenv("OSS_PREFIX")))
+	// Webhook stream (payment-worker delivers). Status changes on jobs with a callback_url enqueue to it.
+	hookStreamKey := readEnvDefault("WEBHOOK_STREAM_KEY", "gy:comparejobs:webhook")
+	hookGroup := readEnvDefault("WEBHOOK_STREAM_GROUP", "gy-webhook")
+	hookMaxLen := int64(readEnvIntDefault("WEBHOOK_STREAM_MAXLEN", 100000))
+	hookQ := streamq.NewRedisStreamQueue(rdb, hookStreamKey, hookGroup, hookMaxLen)
+	hookQ.SetMessageType(domain.MessageWebhookDeliver)
+	jobStore := webhook.NewNotifyingStore(baseStore, hookQ)
+	wlt, subs := billingFromEnv()
+	registerAuth(mux, store.NewRedisUserStore(rdb), tokens, wlt)
+
+	// OSS / S3 / local per OBJECT_STORE; nil when none is configured.
+	objSt, err := objstore.NewFromEnv("", filepath.Join(tmpRoot, "objects"))
+	if err != nil {
+		log.Fatalf("init object store failed: %v", err)
+	}
+	if local, ok := objSt.(*objstore.Local); ok {
+		local.RegisterRoutes(mux)
 	}
 
 	streamKey := readEnvDefault("COMPARE_STREAM_KEY", "gy:comparejobs:stream")
 	group := readEnvDefault("COMPARE_STREAM_GROUP", "gy-compare")
 	maxLen := int64(readEnvIntDefault("COMPARE_STREAM_MAXLEN", 100000))
 	q := streamq.NewRedisStreamQueue(rdb, streamKey, group, maxLen)
-
-	compareSvc := compare.NewService(jobStore, q, tmpRoot, ossSt)
+	q.SetMessageType(domain.MessageCompareRun)
+
+	compareSvc := compare.NewService(jobStore, q, tmpRoot, objSt)
+	compareSvc.SetRequireLogin(readEnvDefault("AUTH_REQUIRE_LOGIN", "") == "1")
+	// Large-upload lane: compare-worker reads it with a lower weight so big files can't starve small ones.
+	largeStreamKey := readEnvDefault("COMPARE_LARGE_STREAM_KEY", streamKey+":large")
+	largeMB := readEnvIntDefault("COMPARE_LARGE_LANE_MB", 16)
+	largeQ := streamq.NewRedisStreamQueue(rdb, largeStreamKey, group, maxLen)
+	largeQ.SetMessageType(domain.MessageCompareRun)
+	compareSvc.SetLargeLane(largeQ, int64(largeMB)<<20)
 	compareSvc.RegisterRoutes(mux)
-	wechat.RegisterNotifyRoutes(mux, jobStore)
-
-	addr := ":" + readEnvDefault("PORT", "8080")
-	log.Printf("Go billing stub listening on %s", addr)
-	// Wrap order: cors -> otel/metrics -> mux
-	handler := corsMiddleware(obs.WrapHTTP("go-api", mux))
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("server error: %v", err)
-	}
+	payments := registerBilling(mux, wlt, subs, compareSvc, jobStore)
+
+	// Operator endpoints: dead-lettered stream messages (list / replay) and job refunds.
+	payStreamKey := readEnvDefault("COMPARE_PAYGATE_STREAM_KEY", "gy:comparejobs:paygate")
+	adminSvc := admin.NewService(map[string]*streamq.DeadLetterQueue{
+		"compare":       streamq.NewDeadLetterQueue(rdb, streamKey),
+		"compare-large": streamq.NewDeadLetterQueue(rdb, largeStreamKey),
+		"paygate":       streamq.NewDeadLetterQueue(rdb, payStreamKey),
+		"webhook":       streamq.NewDeadLetterQueue(rdb, hookStreamKey),
+	})
+	adminSvc.SetPayments(payments)
+	adminSvc.RegisterRoutes(mux)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func handleProfile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	resp := map[string]interface{}{
-		"user_id": "demo-user",
-		"balance": fmt.Sprintf("%.2f", billingStore.balance()),
-	}
-	writeJSON(w, http.StatusOK, resp)
-}
-
-type pendingRequest struct {
-	Amount         float64                `json:"amount"`
-	ApiCall        string                 `json:"apiCall,omitempty"`
-	Metadata       map[string]interface{} `json:"metadata,omitempty"`
-	IdempotencyKey string                 `json:"idempotencyKey,omitempty"`
-}
-
-func handleCreatePending(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusNoContent)
-		return
+// billingFromEnv keeps the wallet ledger and subscriptions in SQL (DATABASE_URL); without a
+// database both are disabled (nil), and subscriptions also need SUBSCRIPTION_PLANS.
+func billingFromEnv() (*wallet.Wallet, *subscription.Service) {
+	if os.Getenv("DATABASE_URL") == "" {
+		log.Printf("wallet and subscriptions disabled: DATABASE_URL not set")
+		return nil, nil
 	}
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+	db, driver, err := store.OpenSQL()
+	if err != nil {
+		log.Fatalf("init wallet ledger failed: %v", err)
 	}
-
-	var req pendingRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
+	ledger, err := store.NewSQLLedgerStore(db, driver)
+	if err != nil {
+		log.Fatalf("init wallet ledger failed: %v", err)
 	}
-	if req.Amount <= 0 {
-		http.Error(w, "amount must be positive", http.StatusBadRequest)
-		return
+	if os.Getenv("SUBSCRIPTION_PLANS") == "" {
+		log.Printf("subscriptions disabled: SUBSCRIPTION_PLANS not set")
+		return wallet.New(ledger), nil
 	}
-	key := req.IdempotencyKey
-	if key == "" {
-		key = newKey("pending")
+	subStore, err := store.NewSQLSubscriptionStore(db, driver)
+	if err != nil {
+		log.Fatalf("init subscription store failed: %v", err)
 	}
-	ev := billingStore.ensurePending(key, req.Amount, req.ApiCall, req.Metadata)
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"idempotencyKey": ev.IdempotencyKey,
-		"status":         "pending",
-	})
+	return wallet.New(ledger), subscription.New(subStore)
 }
 
-type deductRequest struct {
-	IdempotencyKey string  `json:"idempotencyKey"`
-	Amount         float64 `json:"amount"`
-	ApiCall        string  `json:"apiCall,omitempty"`
-}
-
-func handleDeduct(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// registerBilling serves the wallet, billing and subscription routes (those enabled), wallet
+// payment of jobs and the WeChat Pay notify (jobs, plus top-ups and plan orders). It returns the
+// payments applier, which also backs the job "check payment" endpoint.
+func registerBilling(mux *http.ServeMux, wlt *wallet.Wallet, subs *subscription.Service, compareSvc *compare.Service, jobStore store.CompareJobStore) *wechat.Payments {
+	orders := make(map[string]wechat.OrderSettler)
+	if wlt != nil {
+		wlt.RegisterRoutes(mux)
+		compareSvc.SetWallet(wlt)
+		orders[domain.TopUpTradePrefix] = wlt.CreditTopUp
 	}
-
-	var req deductRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
+	if subs != nil {
+		subs.RegisterRoutes(mux)
+		orders[domain.SubscriptionTradePrefix] = subs.ApplyOrder
 	}
+	payments := wechat.NewPayments(jobStore, orders)
+	compareSvc.SetPayments(payments)
+	wechat.RegisterNotifyRoutes(mux, payments)
+	// Keeps the WeChat Pay platform certificates (notify verification) current; no-op without merchant keys.
+	go wechat.RunPlatformCertRefresher(context.Background())
+	return payments
+}
 
-	ev, err := billingStore.markDeducted(req.IdempotencyKey, req.Amount, req.ApiCall)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"idempotencyKey": ev.IdempotencyKey,
-		"deducted":       ev.Deducted,
-		"amount":         ev.Amount,
+// registerAuth serves login (/auth/...) and /profile for the logged-in user.
+func registerAuth(mux *http.ServeMux, users store.UserStore, tokens *auth.Tokens, wlt *wallet.Wallet) {
+	auth.NewService(users, tokens).RegisterRoutes(mux)
+	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := auth.UserID(r.Context())
+		if id == "" {
+			http.Error(w, "请先登录", http.StatusUnauthorized)
+			return
+		}
+		u, ok, err := users.Get(id)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "用户不存在", http.StatusUnauthorized)
+			return
+		}
+		var balance int64
+		if wlt != nil {
+			if balance, _, err = wlt.Balance(id); err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		resp := map[string]interface{}{
+			"user_id":  u.ID,
+			"nickname": u.Nickname,
+			"avatar":   u.AvatarURL,
+			"balance":  wallet.FormatYuan(balance),
+		}
+		writeJSON(w, http.StatusOK, resp)
 	})
 }
 
@@ -265,14 +221,6 @@ func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
-func newKey(prefix string) string {
-	buf := make([]byte, 12)
-	if _, err := rand.Read(buf); err == nil {
-		return fmt.Sprintf("%s_%s", prefix, hex.EncodeToString(buf))
-	}
-	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
-}
-
 func readEnvDefault(key, defaultVal string) string {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
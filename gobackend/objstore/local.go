@@ -0,0 +1,257 @@
+package objstore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files under a root directory, using the same key layout as OSS
+// (compare-inputs/<jobId>/..., compare-results/<jobId>/compare.xlsx). Downloads are signed,
+// expiring URLs under /objects/ served by the API (RegisterRoutes). Meant for standalone mode and
+// development: with several processes they must share the directory and LOCAL_STORE_SIGN_SECRET.
+type Local struct {
+	root        string
+	prefix      string
+	inputPrefix string
+
+	secret     []byte
+	signExpiry time.Duration
+	// publicBase is prepended to signed URLs ("" keeps them relative to the API base).
+	publicBase string
+}
+
+// localRoute is where the API serves signed local downloads.
+const localRoute = "/objects/"
+
+// NewLocal stores objects under root and signs URLs with secret (a random one when empty: URLs
+// then only verify in this process).
+func NewLocal(root string, secret []byte) (*Local, error) {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return nil, errors.New("local object store 根目录为空")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{
+		root:        abs,
+		prefix:      "compare-results",
+		inputPrefix: "compare-inputs",
+		secret:      secret,
+		signExpiry:  10 * time.Minute,
+	}, nil
+}
+
+// NewLocalFromEnv: LOCAL_STORE_DIR (default defaultDir), LOCAL_STORE_SIGN_SECRET,
+// LOCAL_STORE_SIGN_EXPIRE_SECONDS (600), LOCAL_STORE_PUBLIC_BASE.
+func NewLocalFromEnv(defaultDir string) (*Local, error) {
+	dir := strings.TrimSpace(os.Getenv("LOCAL_STORE_DIR"))
+	if dir == "" {
+		dir = defaultDir
+	}
+	secret := strings.TrimSpace(os.Getenv("LOCAL_STORE_SIGN_SECRET"))
+	if secret == "" {
+		log.Printf("LOCAL_STORE_SIGN_SECRET 为空：使用随机密钥，下载链接只在本进程内有效")
+	}
+	s, err := NewLocal(dir, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	if sec := readEnvInt64Default("LOCAL_STORE_SIGN_EXPIRE_SECONDS", 600); sec > 0 {
+		s.signExpiry = time.Duration(sec) * time.Second
+	}
+	s.publicBase = strings.TrimRight(strings.TrimSpace(os.Getenv("LOCAL_STORE_PUBLIC_BASE")), "/")
+	return s, nil
+}
+
+func (s *Local) Enabled() bool { return s != nil && s.root != "" }
+
+func (s *Local) ObjectKeyForJob(jobID string) string {
+	return path.Join(s.prefix, strings.TrimSpace(jobID), "compare.xlsx")
+}
+
+func (s *Local) ObjectKeyForInput(jobID, which, originalName string) string {
+	which = strings.TrimSpace(which)
+	if which == "" {
+		which = "file"
+	}
+	name := strings.TrimSpace(originalName)
+	if name == "" {
+		name = "upload"
+	}
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	return path.Join(s.inputPrefix, strings.TrimSpace(jobID), which+"_"+name)
+}
+
+// path maps an object key to a file under root (keys can't escape it).
+func (s *Local) path(objectKey string) (string, error) {
+	if !s.Enabled() {
+		return "", errors.New("local object store 未初始化")
+	}
+	key := strings.TrimLeft(path.Clean("/"+strings.TrimSpace(objectKey)), "/")
+	if key == "" || key == "." {
+		return "", errors.New("objectKey empty")
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *Local) PutFileFromPath(objectKey, localPath, _ string) error {
+	dst, err := s.path(objectKey)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(strings.TrimSpace(localPath))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	// Write to a temp file first so readers never see a partial object.
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s *Local) PutResultFile(objectKey, localPath string) error {
+	return s.PutFileFromPath(objectKey, localPath, "")
+}
+
+func (s *Local) GetObjectToFile(objectKey, localPath string) error {
+	rc, err := s.GetObject(objectKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, rc)
+	return err
+}
+
+func (s *Local) GetObject(objectKey string) (io.ReadCloser, error) {
+	p, err := s.path(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *Local) DeleteObject(objectKey string) error {
+	p, err := s.path(objectKey)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Drop the job directory once it is empty (best-effort).
+	_ = os.Remove(filepath.Dir(p))
+	return nil
+}
+
+// SignDownloadURL returns publicBase + /objects/<key>?exp=&name=&sig=, valid for signExpiry.
+func (s *Local) SignDownloadURL(objectKey, downloadFilename string) (string, error) {
+	p, err := s.path(objectKey)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.ToSlash(strings.TrimPrefix(p, s.root+string(filepath.Separator)))
+	name := strings.TrimSpace(downloadFilename)
+	exp := strconv.FormatInt(time.Now().Add(s.signExpiry).Unix(), 10)
+	q := url.Values{}
+	q.Set("exp", exp)
+	q.Set("name", name)
+	q.Set("sig", s.sign(key, exp, name))
+	return s.publicBase + localRoute + (&url.URL{Path: key}).EscapedPath() + "?" + q.Encode(), nil
+}
+
+func (s *Local) sign(key, exp, name string) string {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(key + "\n" + exp + "\n" + name))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// RegisterRoutes serves the signed download URLs.
+func (s *Local) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(localRoute, s.handleDownload)
+}
+
+func (s *Local) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, localRoute)
+	q := r.URL.Query()
+	exp, name, sig := q.Get("exp"), q.Get("name"), q.Get("sig")
+	if !hmac.Equal([]byte(sig), []byte(s.sign(key, exp, name))) {
+		http.Error(w, "下载链接无效", http.StatusForbidden)
+		return
+	}
+	if n, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().Unix() > n {
+		http.Error(w, "下载链接已过期", http.StatusForbidden)
+		return
+	}
+	p, err := s.path(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		http.Error(w, "文件不存在或已过期", http.StatusGone)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if name != "" {
+		w.Header().Set("Content-Disposition", contentDisposition(name))
+	}
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), f)
+}
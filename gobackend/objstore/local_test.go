@@ -0,0 +1,144 @@
+package objstore
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T) (*Local, *http.ServeMux) {
+	t.Helper()
+	s, err := NewLocal(t.TempDir(), []byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "result.xlsx")
+	if err := os.WriteFile(src, []byte("xlsx bytes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutResultFile(s.ObjectKeyForJob("job_1"), src); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	return s, mux
+}
+
+func get(mux *http.ServeMux, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	s, mux := newTestLocal(t)
+	signed, err := s.SignDownloadURL(s.ObjectKeyForJob("job_1"), "对比结果.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed, "/objects/compare-results/job_1/compare.xlsx?") {
+		t.Fatalf("url = %s", signed)
+	}
+	rec := get(mux, signed)
+	if rec.Code != http.StatusOK || rec.Body.String() != "xlsx bytes" {
+		t.Fatalf("download: %d %q", rec.Code, rec.Body)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+
+	u, _ := url.Parse(signed)
+	tamper := func(k, v string) string {
+		q := u.Query()
+		q.Set(k, v)
+		return u.Path + "?" + q.Encode()
+	}
+	sig := u.Query().Get("sig")
+	flipped := "0"
+	if sig[0] == '0' {
+		flipped = "1"
+	}
+	for name, target := range map[string]string{
+		"tampered signature": tamper("sig", flipped+sig[1:]),
+		"no signature":       tamper("sig", ""),
+		"changed filename":   tamper("name", "other.xlsx"),
+		"extended expiry":    tamper("exp", "99999999999"),
+		"other object":       strings.Replace(signed, "job_1", "job_2", 1),
+	} {
+		if rec := get(mux, target); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: %d, want 403", name, rec.Code)
+		}
+	}
+
+	// Another secret (another deployment) doesn't accept the URL.
+	other, _ := NewLocal(s.root, []byte("other-secret"))
+	otherMux := http.NewServeMux()
+	other.RegisterRoutes(otherMux)
+	if rec := get(otherMux, signed); rec.Code != http.StatusForbidden {
+		t.Fatalf("foreign secret: %d", rec.Code)
+	}
+}
+
+func TestLocalSignedURLExpires(t *testing.T) {
+	s, mux := newTestLocal(t)
+	s.signExpiry = -time.Second
+	signed, err := s.SignDownloadURL(s.ObjectKeyForJob("job_1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := get(mux, signed)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "已过期") {
+		t.Fatalf("expired url: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLocalKeysStayUnderRoot(t *testing.T) {
+	s, mux := newTestLocal(t)
+	secret := filepath.Join(filepath.Dir(s.root), "secret.txt")
+	if err := os.WriteFile(secret, []byte("outside"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret.txt", "/../../secret.txt", "compare-results/../../secret.txt", `..\secret.txt`} {
+		p, err := s.path(key)
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(p, s.root+string(filepath.Separator)) {
+			t.Errorf("path(%q) = %s, outside %s", key, p, s.root)
+		}
+		if rc, err := s.GetObject(key); err == nil {
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			t.Errorf("GetObject(%q) read %q", key, b)
+		}
+	}
+
+	// A URL signed for a traversing key is signed for the cleaned key, inside root.
+	signed, err := s.SignDownloadURL("../secret.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed, "/objects/secret.txt?") {
+		t.Fatalf("url = %s", signed)
+	}
+	if rec := get(mux, signed); rec.Code == http.StatusOK {
+		t.Fatalf("served %q from outside the root", rec.Body)
+	}
+
+	// Even a validly signed traversing key (the mux would redirect it; call the handler directly)
+	// resolves inside root.
+	q := url.Values{"exp": {"99999999999"}, "name": {""}}
+	q.Set("sig", s.sign("../secret.txt", "99999999999", ""))
+	rec := httptest.NewRecorder()
+	s.handleDownload(rec, httptest.NewRequest(http.MethodGet, "/objects/..%2fsecret.txt?"+q.Encode(), nil))
+	if rec.Code != http.StatusGone || strings.Contains(rec.Body.String(), "outside") {
+		t.Fatalf("escaped traversal: %d %q", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,86 @@
+package objstore
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Store holds compare inputs and results. Backends: Aliyun OSS (OSS), S3-compatible such as
+// MinIO (S3), and a local directory served by the API itself (Local).
+type Store interface {
+	Enabled() bool
+	ObjectKeyForJob(jobID string) string
+	ObjectKeyForInput(jobID, which, originalName string) string
+	PutFileFromPath(objectKey, localPath, contentType string) error
+	PutResultFile(objectKey, localPath string) error
+	GetObjectToFile(objectKey, localPath string) error
+	GetObject(objectKey string) (io.ReadCloser, error)
+	// DeleteObject removes an object; a missing object is not an error.
+	DeleteObject(objectKey string) error
+	// SignDownloadURL returns a time-limited URL the browser can download from directly. It may be
+	// relative to the API base (Local).
+	SignDownloadURL(objectKey, downloadFilename string) (string, error)
+}
+
+// NewFromEnv picks the backend from OBJECT_STORE (oss | s3 | local). When it is unset, OSS is used
+// if OSS_BUCKET is set, then S3 if S3_BUCKET is set, then fallback ("" = no object store, which
+// returns nil). localDir is the default LOCAL_STORE_DIR.
+func NewFromEnv(fallback, localDir string) (Store, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("OBJECT_STORE")))
+	if backend == "" {
+		switch {
+		case strings.TrimSpace(os.Getenv("OSS_BUCKET")) != "":
+			backend = "oss"
+		case strings.TrimSpace(os.Getenv("S3_BUCKET")) != "":
+			backend = "s3"
+		default:
+			backend = fallback
+		}
+	}
+	switch backend {
+	case "":
+		return nil, nil
+	case "oss":
+		st, enabled, err := NewOSSFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			return nil, fmt.Errorf("OBJECT_STORE=oss 但 OSS_BUCKET 为空")
+		}
+		log.Printf("object store: oss bucket=%s prefix=%s", st.bucketName, st.prefix)
+		return st, nil
+	case "s3":
+		st, enabled, err := NewS3FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			return nil, fmt.Errorf("OBJECT_STORE=s3 但 S3_BUCKET 为空")
+		}
+		log.Printf("object store: s3 endpoint=%s bucket=%s prefix=%s", st.endpoint.Host, st.bucket, st.prefix)
+		return st, nil
+	case "local":
+		st, err := NewLocalFromEnv(localDir)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("object store: local dir=%s", st.root)
+		return st, nil
+	default:
+		return nil, fmt.Errorf("未知 OBJECT_STORE: %q（可选 oss/s3/local）", backend)
+	}
+}
+
+// contentDisposition makes signed downloads save as downloadFilename (RFC 5987 filename* for UTF-8).
+func contentDisposition(downloadFilename string) string {
+	name := strings.TrimSpace(downloadFilename)
+	if name == "" {
+		name = "比对结果.xlsx"
+	}
+	return fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s", "compare.xlsx", url.PathEscape(name))
+}
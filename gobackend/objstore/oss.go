@@ -1,10 +1,9 @@
-package ossstore
+package objstore
 
 import (
 	"errors"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,7 +15,9 @@ import (
 	"github.com/aliyun/credentials-go/credentials"
 )
 
-type Store struct {
+// OSS stores objects in an Aliyun OSS bucket: uploads/downloads go through the internal endpoint,
+// signed URLs use the public one.
+type OSS struct {
 	bucketName string
 
 	uploadBucket *oss.Bucket
@@ -29,7 +30,8 @@ type Store struct {
 	signExpiry  time.Duration
 }
 
-func NewFromEnv() (*Store, bool, error) {
+// NewOSSFromEnv returns enabled=false when OSS_BUCKET is unset.
+func NewOSSFromEnv() (*OSS, bool, error) {
 	bucket := strings.TrimSpace(os.Getenv("OSS_BUCKET"))
 	if bucket == "" {
 		return nil, false, nil
@@ -101,7 +103,7 @@ func NewFromEnv() (*Store, bool, error) {
 		return nil, true, fmt.Errorf("open oss bucket(sign) failed: %w", err)
 	}
 
-	return &Store{
+	return &OSS{
 		bucketName:   bucket,
 		uploadBucket: ub,
 		signBucket:   sb,
@@ -169,14 +171,14 @@ func newOSSClient(endpoint, region string, provider oss.CredentialsProvider) (*o
 	return oss.New(endpoint, "", "", opts...)
 }
 
-func (s *Store) Enabled() bool { return s != nil && s.uploadBucket != nil && s.signBucket != nil }
+func (s *OSS) Enabled() bool { return s != nil && s.uploadBucket != nil && s.signBucket != nil }
 
-func (s *Store) ObjectKeyForJob(jobID string) string {
+func (s *OSS) ObjectKeyForJob(jobID string) string {
 	jobID = strings.TrimSpace(jobID)
 	return path.Join(s.prefix, jobID, "compare.xlsx")
 }
 
-func (s *Store) ObjectKeyForInput(jobID, which, originalName string) string {
+func (s *OSS) ObjectKeyForInput(jobID, which, originalName string) string {
 	jobID = strings.TrimSpace(jobID)
 	which = strings.TrimSpace(which)
 	if which == "" {
@@ -191,7 +193,7 @@ func (s *Store) ObjectKeyForInput(jobID, which, originalName string) string {
 	return path.Join(s.inputPrefix, jobID, which+"_"+name)
 }
 
-func (s *Store) ensureCred() error {
+func (s *OSS) ensureCred() error {
 	if s == nil || s.cred == nil {
 		return errors.New("阿里云凭证未初始化（RRSA/AK/STS 都不可用）")
 	}
@@ -199,7 +201,7 @@ func (s *Store) ensureCred() error {
 	return validateAlibabaCredential(s.cred)
 }
 
-func (s *Store) PutFileFromPath(objectKey, localPath, contentType string) error {
+func (s *OSS) PutFileFromPath(objectKey, localPath, contentType string) error {
 	if !s.Enabled() {
 		return errors.New("oss not enabled")
 	}
@@ -218,7 +220,7 @@ func (s *Store) PutFileFromPath(objectKey, localPath, contentType string) error
 	return s.uploadBucket.PutObjectFromFile(objectKey, localPath, opts...)
 }
 
-func (s *Store) GetObjectToFile(objectKey, localPath string) error {
+func (s *OSS) GetObjectToFile(objectKey, localPath string) error {
 	if !s.Enabled() {
 		return errors.New("oss not enabled")
 	}
@@ -247,7 +249,7 @@ func (s *Store) GetObjectToFile(objectKey, localPath string) error {
 	return err
 }
 
-func (s *Store) PutResultFile(objectKey, localPath string) error {
+func (s *OSS) PutResultFile(objectKey, localPath string) error {
 	if !s.Enabled() {
 		return errors.New("oss not enabled")
 	}
@@ -267,7 +269,7 @@ func (s *Store) PutResultFile(objectKey, localPath string) error {
 	)
 }
 
-func (s *Store) GetObject(objectKey string) (io.ReadCloser, error) {
+func (s *OSS) GetObject(objectKey string) (io.ReadCloser, error) {
 	if !s.Enabled() {
 		return nil, errors.New("oss not enabled")
 	}
@@ -282,7 +284,22 @@ func (s *Store) GetObject(objectKey string) (io.ReadCloser, error) {
 	return s.uploadBucket.GetObject(objectKey)
 }
 
-func (s *Store) SignDownloadURL(objectKey, downloadFilename string) (string, error) {
+// DeleteObject removes an object; a missing object is not an error.
+func (s *OSS) DeleteObject(objectKey string) error {
+	if !s.Enabled() {
+		return errors.New("oss not enabled")
+	}
+	if err := s.ensureCred(); err != nil {
+		return err
+	}
+	objectKey = strings.TrimLeft(strings.TrimSpace(objectKey), "/")
+	if objectKey == "" {
+		return errors.New("objectKey empty")
+	}
+	return s.uploadBucket.DeleteObject(objectKey)
+}
+
+func (s *OSS) SignDownloadURL(objectKey, downloadFilename string) (string, error) {
 	if !s.Enabled() {
 		return "", errors.New("oss not enabled")
 	}
@@ -294,12 +311,7 @@ func (s *Store) SignDownloadURL(objectKey, downloadFilename string) (string, err
 		return "", errors.New("objectKey empty")
 	}
 
-	name := strings.TrimSpace(downloadFilename)
-	if name == "" {
-		name = "比对结果.xlsx"
-	}
-	escaped := url.PathEscape(name)
-	disp := fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s", "compare.xlsx", escaped)
+	disp := contentDisposition(downloadFilename)
 
 	u, err := s.signBucket.SignURL(
 		objectKey,
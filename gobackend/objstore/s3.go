@@ -0,0 +1,418 @@
+package objstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores objects in an S3-compatible bucket (AWS S3, MinIO, ...). Requests are signed with
+// AWS Signature V4; uploads/downloads use S3_ENDPOINT, signed URLs S3_PUBLIC_ENDPOINT (e.g. MinIO
+// reached as http://minio:9000 inside docker but http://localhost:9000 from the browser).
+type S3 struct {
+	endpoint       *url.URL
+	publicEndpoint *url.URL
+	region         string
+	bucket         string
+	accessKey      string
+	secretKey      string
+	sessionToken   string
+	pathStyle      bool
+
+	prefix      string
+	inputPrefix string
+	signExpiry  time.Duration
+
+	client *http.Client
+}
+
+// NewS3FromEnv returns enabled=false when S3_BUCKET is unset.
+func NewS3FromEnv() (*S3, bool, error) {
+	bucket := strings.TrimSpace(os.Getenv("S3_BUCKET"))
+	if bucket == "" {
+		return nil, false, nil
+	}
+	rawEndpoint := strings.TrimSpace(os.Getenv("S3_ENDPOINT"))
+	if rawEndpoint == "" {
+		return nil, true, errors.New("已设置 S3_BUCKET，但缺少 S3_ENDPOINT")
+	}
+	endpoint, err := parseEndpoint(rawEndpoint)
+	if err != nil {
+		return nil, true, fmt.Errorf("S3_ENDPOINT 无效: %w", err)
+	}
+	publicEndpoint := endpoint
+	if raw := strings.TrimSpace(os.Getenv("S3_PUBLIC_ENDPOINT")); raw != "" {
+		if publicEndpoint, err = parseEndpoint(raw); err != nil {
+			return nil, true, fmt.Errorf("S3_PUBLIC_ENDPOINT 无效: %w", err)
+		}
+	}
+	accessKey := strings.TrimSpace(os.Getenv("S3_ACCESS_KEY_ID"))
+	secretKey := strings.TrimSpace(os.Getenv("S3_SECRET_ACCESS_KEY"))
+	if accessKey == "" || secretKey == "" {
+		return nil, true, errors.New("已设置 S3_BUCKET，但缺少 S3_ACCESS_KEY_ID/S3_SECRET_ACCESS_KEY")
+	}
+	region := strings.TrimSpace(os.Getenv("S3_REGION"))
+	if region == "" {
+		region = "us-east-1"
+	}
+	prefix := strings.Trim(strings.TrimSpace(os.Getenv("S3_PREFIX")), "/")
+	if prefix == "" {
+		prefix = "compare-results"
+	}
+	inputPrefix := strings.Trim(strings.TrimSpace(os.Getenv("S3_INPUT_PREFIX")), "/")
+	if inputPrefix == "" {
+		inputPrefix = "compare-inputs"
+	}
+	expirySec := readEnvInt64Default("S3_SIGN_EXPIRE_SECONDS", 600)
+	if expirySec <= 0 {
+		expirySec = 600
+	}
+	// MinIO needs path-style addressing unless it is set up with a wildcard domain.
+	pathStyle := strings.TrimSpace(os.Getenv("S3_FORCE_PATH_STYLE")) != "false"
+
+	return &S3{
+		endpoint:       endpoint,
+		publicEndpoint: publicEndpoint,
+		region:         region,
+		bucket:         bucket,
+		accessKey:      accessKey,
+		secretKey:      secretKey,
+		sessionToken:   strings.TrimSpace(os.Getenv("S3_SESSION_TOKEN")),
+		pathStyle:      pathStyle,
+		prefix:         prefix,
+		inputPrefix:    inputPrefix,
+		signExpiry:     time.Duration(expirySec) * time.Second,
+		client:         &http.Client{Timeout: 10 * time.Minute},
+	}, true, nil
+}
+
+func parseEndpoint(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("缺少 host")
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	return u, nil
+}
+
+func (s *S3) Enabled() bool { return s != nil && s.endpoint != nil && s.bucket != "" }
+
+func (s *S3) ObjectKeyForJob(jobID string) string {
+	return path.Join(s.prefix, strings.TrimSpace(jobID), "compare.xlsx")
+}
+
+func (s *S3) ObjectKeyForInput(jobID, which, originalName string) string {
+	which = strings.TrimSpace(which)
+	if which == "" {
+		which = "file"
+	}
+	name := strings.TrimSpace(originalName)
+	if name == "" {
+		name = "upload"
+	}
+	// prevent path traversal in object key
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	return path.Join(s.inputPrefix, strings.TrimSpace(jobID), which+"_"+name)
+}
+
+func (s *S3) PutFileFromPath(objectKey, localPath, contentType string) error {
+	if !s.Enabled() {
+		return errors.New("s3 not enabled")
+	}
+	objectKey = strings.TrimLeft(strings.TrimSpace(objectKey), "/")
+	localPath = strings.TrimSpace(localPath)
+	if objectKey == "" || localPath == "" {
+		return errors.New("invalid objectKey/localPath")
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Sign the payload hash: not every S3-compatible server accepts UNSIGNED-PAYLOAD over plain HTTP.
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(s.endpoint, objectKey).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	if ct := strings.TrimSpace(contentType); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	s.sign(req, hex.EncodeToString(h.Sum(nil)), time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(req, resp)
+	}
+	return nil
+}
+
+func (s *S3) PutResultFile(objectKey, localPath string) error {
+	return s.PutFileFromPath(objectKey, localPath, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+}
+
+func (s *S3) GetObjectToFile(objectKey, localPath string) error {
+	localPath = strings.TrimSpace(localPath)
+	if localPath == "" {
+		return errors.New("invalid objectKey/localPath")
+	}
+	rc, err := s.GetObject(objectKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, rc)
+	return err
+}
+
+func (s *S3) GetObject(objectKey string) (io.ReadCloser, error) {
+	if !s.Enabled() {
+		return nil, errors.New("s3 not enabled")
+	}
+	objectKey = strings.TrimLeft(strings.TrimSpace(objectKey), "/")
+	if objectKey == "" {
+		return nil, errors.New("objectKey empty")
+	}
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(s.endpoint, objectKey).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptyPayloadHash, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(req, resp)
+	}
+	return resp.Body, nil
+}
+
+// DeleteObject removes an object; a missing object is not an error.
+func (s *S3) DeleteObject(objectKey string) error {
+	if !s.Enabled() {
+		return errors.New("s3 not enabled")
+	}
+	objectKey = strings.TrimLeft(strings.TrimSpace(objectKey), "/")
+	if objectKey == "" {
+		return errors.New("objectKey empty")
+	}
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(s.endpoint, objectKey).String(), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, emptyPayloadHash, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(req, resp)
+	}
+	return nil
+}
+
+// SignDownloadURL returns a presigned GET URL on the public endpoint.
+func (s *S3) SignDownloadURL(objectKey, downloadFilename string) (string, error) {
+	if !s.Enabled() {
+		return "", errors.New("s3 not enabled")
+	}
+	objectKey = strings.TrimLeft(strings.TrimSpace(objectKey), "/")
+	if objectKey == "" {
+		return "", errors.New("objectKey empty")
+	}
+	extra := url.Values{}
+	extra.Set("response-content-disposition", contentDisposition(downloadFilename))
+	return s.presign(objectKey, extra, time.Now()).String(), nil
+}
+
+// presign builds a SigV4 query-string signed GET URL (signing only host) valid for signExpiry
+// from now; extra query parameters are signed too.
+func (s *S3) presign(objectKey string, extra url.Values, now time.Time) *url.URL {
+	u := s.objectURL(s.publicEndpoint, objectKey)
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	q := url.Values{}
+	for k, v := range extra {
+		q[k] = v
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(s.signExpiry.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if s.sessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		awsEscapePath(u.Path),
+		canonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	q.Set("X-Amz-Signature", s.signature(now, amzDate, scope, canonical))
+	u.RawQuery = canonicalQuery(q)
+	return u
+}
+
+func (s *S3) objectURL(endpoint *url.URL, objectKey string) *url.URL {
+	u := *endpoint
+	if s.pathStyle {
+		u.Path = endpoint.Path + "/" + s.bucket + "/" + objectKey
+	} else {
+		u.Host = s.bucket + "." + endpoint.Host
+		u.Path = endpoint.Path + "/" + objectKey
+	}
+	u.RawPath = awsEscapePath(u.Path)
+	return &u
+}
+
+// --- AWS Signature V4 ---
+
+// emptyPayloadHash is hex(sha256("")).
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds the SigV4 Authorization header (signing host and the x-amz-* headers).
+func (s *S3) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	scope := s.scope(t)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var ch strings.Builder
+	for _, k := range names {
+		ch.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		ch.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, s.signature(t, amzDate, scope, canonical)))
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.UTC().Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(t time.Time, amzDate, scope, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscapePath(p string) string { return awsEscape(p, false) }
+
+// awsEscape is the RFC 3986 encoding SigV4 expects (unreserved characters kept, '/' kept in paths).
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3Error(req *http.Request, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -108,6 +108,9 @@ func (r *statusRecorder) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap lets http.ResponseController reach Flush on the underlying writer (SSE).
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
 func RecordWorkerJob(worker string, start time.Time, err error) {
 	res := "ok"
 	if err != nil {
@@ -7,21 +7,19 @@ import (
 	"time"
 )
 
-// FeeFen returns fee in "fen" (1 yuan = 100 fen).
-// Default is 0 (free). You can override by setting env COMPARE_JOB_FEE_FEN (non-negative integer).
-func FeeFen() int64 {
-	raw := strings.TrimSpace(os.Getenv("COMPARE_JOB_FEE_FEN"))
+func readEnvDurationSecondsDefault(key string, defaultVal time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
 	if raw == "" {
-		return 0
+		return defaultVal
 	}
 	n, err := strconv.ParseInt(raw, 10, 64)
-	if err != nil || n < 0 {
-		return 0
+	if err != nil || n <= 0 {
+		return defaultVal
 	}
-	return n
+	return time.Duration(n) * time.Second
 }
 
-func readEnvDurationSecondsDefault(key string, defaultVal time.Duration) time.Duration {
+func readEnvDurationHoursDefault(key string, defaultVal time.Duration) time.Duration {
 	raw := strings.TrimSpace(os.Getenv(key))
 	if raw == "" {
 		return defaultVal
@@ -30,6 +28,6 @@ func readEnvDurationSecondsDefault(key string, defaultVal time.Duration) time.Du
 	if err != nil || n <= 0 {
 		return defaultVal
 	}
-	return time.Duration(n) * time.Second
+	return time.Duration(n) * time.Hour
 }
 
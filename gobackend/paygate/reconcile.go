@@ -0,0 +1,120 @@
+package paygate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/redislock"
+	"gobackend/store"
+	"gobackend/wechat"
+)
+
+// Reconciler catches payments whose notify never arrived: it periodically asks WeChat Pay about
+// jobs that have been awaiting_payment for a while and applies the paid ones like the notify does.
+type Reconciler struct {
+	store    store.CompareJobStore
+	payments *wechat.Payments
+	// lock (optional) lets one payment-worker replica run each round.
+	lock *redislock.Client
+
+	every time.Duration
+	// Jobs younger than minAge are left to the notify; older than maxAge are no longer checked.
+	minAge time.Duration
+	maxAge time.Duration
+}
+
+// NewReconciler reads COMPARE_PAYGATE_RECONCILE_SECONDS (interval, default 60),
+// COMPARE_PAYGATE_RECONCILE_MIN_AGE_SECONDS (default 60) and COMPARE_PAYGATE_RECONCILE_MAX_AGE_HOURS
+// (default 24).
+func NewReconciler(st store.CompareJobStore, payments *wechat.Payments, lock *redislock.Client) *Reconciler {
+	return &Reconciler{
+		store:    st,
+		payments: payments,
+		lock:     lock,
+		every:    readEnvDurationSecondsDefault("COMPARE_PAYGATE_RECONCILE_SECONDS", time.Minute),
+		minAge:   readEnvDurationSecondsDefault("COMPARE_PAYGATE_RECONCILE_MIN_AGE_SECONDS", time.Minute),
+		maxAge:   readEnvDurationHoursDefault("COMPARE_PAYGATE_RECONCILE_MAX_AGE_HOURS", 24*time.Hour),
+	}
+}
+
+// Run reconciles every interval until ctx is done.
+func (r *Reconciler) Run(ctx context.Context) {
+	t := time.NewTicker(r.every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if r.lock != nil {
+			token, err := redislock.Token()
+			if err != nil {
+				continue
+			}
+			// Not released: the lock expires just before the next round, so replicas take turns.
+			ttl := max(r.every-time.Second, r.every/2)
+			ok, err := r.lock.Acquire(ctx, r.lock.Key("paygate:reconcile"), token, ttl)
+			if err != nil || !ok {
+				continue
+			}
+		}
+		checked, paid, err := r.RunOnce(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("paygate reconcile: %v", err)
+		}
+		if paid > 0 {
+			log.Printf("paygate reconcile: checked=%d paid=%d", checked, paid)
+		}
+	}
+}
+
+// RunOnce checks the awaiting_payment jobs due for a check and reports how many were queried and
+// how many turned out paid.
+func (r *Reconciler) RunOnce(ctx context.Context) (checked, paid int, err error) {
+	now := time.Now()
+	q := store.JobQuery{Status: domain.CompareJobStatusAwaitingPayment, CreatedFrom: now.Add(-r.maxAge), Limit: 100}
+	for {
+		page, err := r.store.List(q)
+		if err != nil {
+			return checked, paid, err
+		}
+		for _, job := range page.Jobs {
+			if err := ctx.Err(); err != nil {
+				return checked, paid, err
+			}
+			if job.Paid || job.CodeURL == "" || now.Sub(awaitingSince(job)) < r.minAge {
+				continue
+			}
+			checked++
+			tx, err := r.payments.Check(job.ID, domain.ActorPaymentReconciler)
+			if err != nil {
+				if !errors.Is(err, wechat.ErrOrderNotExist) {
+					log.Printf("paygate reconcile: job=%s: %v", job.ID, err)
+				}
+				continue
+			}
+			if tx.Paid() {
+				paid++
+				log.Printf("paygate reconcile: job=%s paid (notify missed) transaction=%s", job.ID, tx.TransactionID)
+			}
+		}
+		if page.NextCursor == "" {
+			return checked, paid, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// awaitingSince is when the job last entered awaiting_payment.
+func awaitingSince(job *domain.CompareJob) time.Time {
+	for i := len(job.Events) - 1; i >= 0; i-- {
+		if job.Events[i].To == domain.CompareJobStatusAwaitingPayment {
+			return job.Events[i].At
+		}
+	}
+	return job.CreatedAt
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
 	"gobackend/domain"
+	"gobackend/pricing"
 	"gobackend/redislock"
 	"gobackend/store"
 	"gobackend/streamq"
+	"gobackend/subscription"
 	"gobackend/wechat"
 )
 
@@ -19,6 +22,11 @@ type Worker struct {
 	lock     *redislock.Client
 	lockTTL  time.Duration
 	lockKick time.Duration
+	// pollEvery is how long to wait before re-checking a job whose compare result isn't stored yet.
+	pollEvery time.Duration
+	pricer    *pricing.Engine
+	// subs releases jobs covered by the owner's plan (see SetSubscriptions).
+	subs *subscription.Service
 }
 
 func NewWorker(st store.CompareJobStore, lock *redislock.Client) *Worker {
@@ -32,9 +40,17 @@ func NewWorker(st store.CompareJobStore, lock *redislock.Client) *Worker {
 		lock:     lock,
 		lockTTL:  lockTTL,
 		lockKick: lockKick,
+
+		pollEvery: readEnvDurationSecondsDefault("COMPARE_PAYGATE_POLL_SECONDS", 5*time.Second),
+		pricer:    pricing.NewEngineFromEnv(),
 	}
 }
 
+// SetSubscriptions lets jobs whose owner has an active plan with quota left skip payment.
+func (w *Worker) SetSubscriptions(subs *subscription.Service) {
+	w.subs = subs
+}
+
 func (w *Worker) Process(ctx context.Context, jobID string) error {
 	if w == nil || w.store == nil {
 		return errors.New("paygate worker/store 未初始化")
@@ -96,17 +112,13 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 
 	ossKey := strings.TrimSpace(job.ResultOSSKey)
 	if ossKey == "" {
-		// compare stage not finished yet (or failed to persist). Keep pending, will be auto-claimed later.
-		return errors.New("result not ready (ResultOSSKey empty)")
+		// compare stage not finished yet (or failed to persist): check again later.
+		return streamq.RetryAfter(errors.New("result not ready (ResultOSSKey empty)"), w.pollEvery)
 	}
 
 	// If payment already confirmed, release.
 	if job.Paid {
-		_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
-			if j.Status == domain.CompareJobStatusCancelled {
-				return
-			}
-			j.Status = domain.CompareJobStatusReady
+		_, _, _ = store.Transition(w.store, jobID, domain.CompareJobStatusReady, domain.ActorPaymentWorker, "paid", func(j *domain.CompareJob) {
 			j.ResultOSSKey = ossKey
 			j.ResultPath = ""
 			j.AmountYuan = 0
@@ -116,18 +128,17 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 		return streamq.Terminal(nil)
 	}
 
-	feeFen := FeeFen()
+	price := w.pricer.Quote(pricing.Input{Stats: job.Stats, Mode: job.Mode, Coupon: job.Coupon})
+	feeFen := price.TotalFen
 	if feeFen <= 0 {
 		now := time.Now()
-		_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
-			if j.Status == domain.CompareJobStatusCancelled {
-				return
-			}
+		_, _, _ = store.Transition(w.store, jobID, domain.CompareJobStatusReady, domain.ActorPaymentWorker, "free", func(j *domain.CompareJob) {
+			j.Price = &price
 			if !j.Paid {
 				j.Paid = true
 				j.PaidAt = &now
+				j.PaidVia = domain.PaidViaFree
 			}
-			j.Status = domain.CompareJobStatusReady
 			j.ResultOSSKey = ossKey
 			j.ResultPath = ""
 			j.AmountYuan = 0
@@ -142,18 +153,50 @@ func (w *Worker) Process(ctx context.Context, jobID string) error {
 		return streamq.Terminal(nil)
 	}
 
+	if w.subs != nil {
+		covered, err := w.subs.Cover(job)
+		if err != nil {
+			return err
+		}
+		if covered {
+			now := time.Now()
+			_, ok, err := store.Transition(w.store, jobID, domain.CompareJobStatusReady, domain.ActorPaymentWorker, "subscription", func(j *domain.CompareJob) {
+				j.Price = &price
+				if !j.Paid {
+					j.Paid = true
+					j.PaidAt = &now
+					j.PaidVia = domain.PaidViaSubscription
+				}
+				j.ResultOSSKey = ossKey
+				j.ResultPath = ""
+				j.AmountYuan = 0
+				j.CodeURL = ""
+				j.Error = ""
+			})
+			if err != nil || !ok {
+				// Not released (cancelled / failed meanwhile, or the store failed): the plan doesn't
+				// pay for it, and a retry counts it again.
+				if uerr := w.subs.Uncover(job); uerr != nil {
+					log.Printf("paygate: release plan quota job=%s: %v", jobID, uerr)
+				}
+				if err != nil && !errors.Is(err, domain.ErrIllegalTransition) {
+					return err
+				}
+			}
+			return streamq.Terminal(nil)
+		}
+	}
+
 	codeURL, err := wechat.CreateNativeOrder(jobID, feeFen)
 	if err != nil {
 		// Business failure: mark job failed and ACK (no auto retry).
 		return streamq.Terminal(w.fail(jobID, fmt.Errorf("创建微信支付订单失败: %w", err)))
 	}
-	_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
-		if j.Status == domain.CompareJobStatusCancelled || j.Paid {
-			return
-		}
-		j.Status = domain.CompareJobStatusAwaitingPayment
+	// Rejected when the job was cancelled or paid meanwhile.
+	_, _, _ = store.Transition(w.store, jobID, domain.CompareJobStatusAwaitingPayment, domain.ActorPaymentWorker, "order created", func(j *domain.CompareJob) {
 		j.ResultOSSKey = ossKey
 		j.ResultPath = ""
+		j.Price = &price
 		j.AmountYuan = float64(feeFen) / 100.0
 		j.CodeURL = codeURL
 		j.Error = ""
@@ -169,8 +212,7 @@ func (w *Worker) fail(jobID string, err error) error {
 	if err != nil {
 		msg = err.Error()
 	}
-	_, _, _ = w.store.Update(jobID, func(j *domain.CompareJob) {
-		j.Status = domain.CompareJobStatusFailed
+	_, _, _ = store.Transition(w.store, jobID, domain.CompareJobStatusFailed, domain.ActorPaymentWorker, msg, func(j *domain.CompareJob) {
 		j.Error = msg
 	})
 	return err
@@ -0,0 +1,137 @@
+package paygate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/streamq"
+	"gobackend/subscription"
+)
+
+// cancellingStore cancels a job right before its first Update, like a user cancelling while the
+// paygate is deciding.
+type cancellingStore struct {
+	*store.InMemoryCompareJobStore
+	cancelled map[string]bool
+}
+
+func (s *cancellingStore) Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
+	if !s.cancelled[id] {
+		s.cancelled[id] = true
+		if _, _, err := store.Transition(s.InMemoryCompareJobStore, id, domain.CompareJobStatusCancelled, domain.ActorAPI, "test", nil); err != nil {
+			return nil, false, err
+		}
+	}
+	return s.InMemoryCompareJobStore.Update(id, fn)
+}
+
+// newSubscriber returns a plan service where u1 has bought "basic" (one job per month).
+func newSubscriber(t *testing.T) *subscription.Service {
+	t.Helper()
+	t.Setenv("WECHAT_MOCK", "1")
+	t.Setenv("SUBSCRIPTION_PLANS", "basic=2900:1:0")
+	t.Setenv("PRICE_BASE_FEN", "100")
+	subs := subscription.New(store.NewInMemorySubscriptionStore())
+	o, err := subs.CreateOrder("u1", "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := subs.ApplyOrder(o.ID, o.AmountFen); err != nil {
+		t.Fatal(err)
+	}
+	return subs
+}
+
+func comparedJob(t *testing.T, st store.CompareJobStore, id string) {
+	t.Helper()
+	job := &domain.CompareJob{
+		ID:           id,
+		OwnerID:      "u1",
+		CreatedAt:    time.Now(),
+		ResultOSSKey: "results/" + id + ".xlsx",
+		Stats:        &domain.CompareJobStats{RowsFile1: 10, RowsFile2: 10},
+	}
+	if err := job.Transition(domain.CompareJobStatusProcessing, domain.ActorAPI, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Create(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// process runs the paygate for id and expects it to be done with the message.
+func process(t *testing.T, w *Worker, id string) {
+	t.Helper()
+	err := w.Process(context.Background(), id)
+	var te streamq.TerminalError
+	if !errors.As(err, &te) || te.Err != nil {
+		t.Fatalf("process %s: %v", id, err)
+	}
+}
+
+func usedJobs(t *testing.T, subs *subscription.Service) int64 {
+	t.Helper()
+	s, err := subs.Status("u1")
+	if err != nil || s == nil {
+		t.Fatalf("status: %v %v", s, err)
+	}
+	return s.Usage.Jobs
+}
+
+func TestProcessCoveredBySubscription(t *testing.T) {
+	subs := newSubscriber(t)
+	st := store.NewInMemoryCompareJobStore()
+	w := NewWorker(st, nil)
+	w.SetSubscriptions(subs)
+
+	comparedJob(t, st, "job_1")
+	for i := 0; i < 2; i++ { // redelivery counts the job once
+		process(t, w, "job_1")
+	}
+	job, _, _ := st.Get("job_1")
+	if job.Status != domain.CompareJobStatusReady || !job.Paid || job.PaidVia != domain.PaidViaSubscription {
+		t.Fatalf("job: status=%s paid=%v via=%s", job.Status, job.Paid, job.PaidVia)
+	}
+	if n := usedJobs(t, subs); n != 1 {
+		t.Fatalf("used jobs = %d, want 1", n)
+	}
+
+	// Quota used up: the next job waits for payment.
+	comparedJob(t, st, "job_2")
+	process(t, w, "job_2")
+	if job, _, _ := st.Get("job_2"); job.Status != domain.CompareJobStatusAwaitingPayment || job.Paid {
+		t.Fatalf("job_2: status=%s paid=%v", job.Status, job.Paid)
+	}
+}
+
+func TestProcessReleasesQuotaWhenTransitionRejected(t *testing.T) {
+	subs := newSubscriber(t)
+	st := &cancellingStore{InMemoryCompareJobStore: store.NewInMemoryCompareJobStore(), cancelled: map[string]bool{}}
+	w := NewWorker(st, nil)
+	w.SetSubscriptions(subs)
+
+	comparedJob(t, st, "job_1")
+	process(t, w, "job_1")
+	job, _, _ := st.Get("job_1")
+	if job.Status != domain.CompareJobStatusCancelled || job.Paid {
+		t.Fatalf("job: status=%s paid=%v", job.Status, job.Paid)
+	}
+	if n := usedJobs(t, subs); n != 0 {
+		t.Fatalf("used jobs = %d after a rejected release, want 0", n)
+	}
+
+	// The quota is still there for the next job.
+	st.cancelled["job_2"] = true
+	comparedJob(t, st, "job_2")
+	process(t, w, "job_2")
+	if job, _, _ := st.Get("job_2"); job.PaidVia != domain.PaidViaSubscription {
+		t.Fatalf("job_2 not covered: status=%s via=%s", job.Status, job.PaidVia)
+	}
+	if n := usedJobs(t, subs); n != 1 {
+		t.Fatalf("used jobs = %d, want 1", n)
+	}
+}
@@ -0,0 +1,233 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+)
+
+// Config is the price list (all amounts in fen; 0 disables an item).
+type Config struct {
+	BaseFen int64
+	// FreeRows: jobs with at most this many billable rows are free (0 = no free tier).
+	FreeRows int64
+	// Per1000RowsFen is charged per started 1000 billable rows above FreeRows.
+	Per1000RowsFen int64
+	MultiSheetFen  int64
+	LargeFileBytes int64
+	LargeFileFen   int64
+	MergeFen       int64
+	ThreeWayFen    int64
+	Coupons        map[string]Coupon
+}
+
+// Coupon is a discount code: PercentOff (1-100) or OffFen, optionally expiring.
+type Coupon struct {
+	Code       string
+	PercentOff int64
+	OffFen     int64
+	Expires    time.Time // zero = never
+}
+
+var (
+	ErrInvalidCoupon = errors.New("优惠码无效")
+	ErrCouponExpired = errors.New("优惠码已过期")
+)
+
+// Engine quotes compare jobs from their measured stats and options.
+type Engine struct {
+	cfg Config
+	now func() time.Time
+}
+
+func NewEngine(cfg Config) *Engine {
+	return &Engine{cfg: cfg, now: time.Now}
+}
+
+// NewEngineFromEnv reads the price list:
+//
+//	PRICE_BASE_FEN            per paid job (default COMPARE_JOB_FEE_FEN, else 0)
+//	PRICE_FREE_ROWS           free tier: jobs up to this many rows are free
+//	PRICE_PER_1000_ROWS_FEN   per started 1000 rows above the free tier
+//	PRICE_MULTI_SHEET_FEN     surcharge when an input has more than one sheet
+//	PRICE_LARGE_FILE_MB / PRICE_LARGE_FILE_FEN   surcharge when inputs total >= MB (default 10)
+//	PRICE_MERGE_FEN / PRICE_THREE_WAY_FEN        surcharge for those modes
+//	PRICE_COUPONS             "CODE=20%,CODE2=500@2026-12-31" (percent or fen off, optional expiry)
+//
+// With none of them set every job costs COMPARE_JOB_FEE_FEN, as before.
+func NewEngineFromEnv() *Engine {
+	return NewEngine(Config{
+		BaseFen:        readEnvFenDefault("PRICE_BASE_FEN", readEnvFenDefault("COMPARE_JOB_FEE_FEN", 0)),
+		FreeRows:       readEnvFenDefault("PRICE_FREE_ROWS", 0),
+		Per1000RowsFen: readEnvFenDefault("PRICE_PER_1000_ROWS_FEN", 0),
+		MultiSheetFen:  readEnvFenDefault("PRICE_MULTI_SHEET_FEN", 0),
+		LargeFileBytes: readEnvFenDefault("PRICE_LARGE_FILE_MB", 10) << 20,
+		LargeFileFen:   readEnvFenDefault("PRICE_LARGE_FILE_FEN", 0),
+		MergeFen:       readEnvFenDefault("PRICE_MERGE_FEN", 0),
+		ThreeWayFen:    readEnvFenDefault("PRICE_THREE_WAY_FEN", 0),
+		Coupons:        ParseCoupons(os.Getenv("PRICE_COUPONS")),
+	})
+}
+
+// ParseCoupons parses PRICE_COUPONS; malformed entries are logged and skipped. Codes are
+// case-insensitive.
+func ParseCoupons(raw string) map[string]Coupon {
+	out := make(map[string]Coupon)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		c, err := parseCoupon(item)
+		if err != nil {
+			log.Printf("pricing: skip coupon %q: %v", item, err)
+			continue
+		}
+		out[c.Code] = c
+	}
+	return out
+}
+
+func parseCoupon(item string) (Coupon, error) {
+	code, val, ok := strings.Cut(item, "=")
+	c := Coupon{Code: normalizeCode(code)}
+	if !ok || c.Code == "" {
+		return c, errors.New("want CODE=VALUE")
+	}
+	val, exp, hasExp := strings.Cut(strings.TrimSpace(val), "@")
+	if hasExp {
+		t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(exp), time.Local)
+		if err != nil {
+			return c, fmt.Errorf("bad expiry: %w", err)
+		}
+		c.Expires = t.AddDate(0, 0, 1) // valid through that day
+	}
+	if pct, isPct := strings.CutSuffix(val, "%"); isPct {
+		n, err := strconv.ParseInt(strings.TrimSpace(pct), 10, 64)
+		if err != nil || n <= 0 || n > 100 {
+			return c, errors.New("percent must be 1-100")
+		}
+		c.PercentOff = n
+		return c, nil
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n <= 0 {
+		return c, errors.New("fen off must be positive")
+	}
+	c.OffFen = n
+	return c, nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CheckCoupon validates a code given at upload ("" is fine) and returns it normalized.
+func (e *Engine) CheckCoupon(code string) (string, error) {
+	code = normalizeCode(code)
+	if code == "" {
+		return "", nil
+	}
+	c, ok := e.cfg.Coupons[code]
+	if !ok {
+		return "", ErrInvalidCoupon
+	}
+	if !c.Expires.IsZero() && !e.now().Before(c.Expires) {
+		return "", ErrCouponExpired
+	}
+	return code, nil
+}
+
+// Input is what a job is priced on.
+type Input struct {
+	// Stats is nil for jobs compared before stats were recorded: they pay the base price and
+	// mode surcharges only (no free tier).
+	Stats *domain.CompareJobStats
+	Mode  domain.CompareMode
+	// Coupon was checked at upload; it is honoured even if it expired since.
+	Coupon string
+}
+
+// Quote prices a job. A zero TotalFen means the job is free.
+func (e *Engine) Quote(in Input) domain.PriceBreakdown {
+	cfg := e.cfg
+	measured := in.Stats != nil
+	var stats domain.CompareJobStats
+	if measured {
+		stats = *in.Stats
+	}
+	rows := stats.BillableRows()
+	bd := domain.PriceBreakdown{BillableRows: rows}
+	add := func(code, label string, fen int64) {
+		if fen > 0 {
+			bd.Lines = append(bd.Lines, domain.PriceLine{Code: code, Label: label, AmountFen: fen})
+			bd.SubtotalFen += fen
+		}
+	}
+
+	if measured && cfg.FreeRows > 0 && rows <= cfg.FreeRows {
+		bd.Lines = []domain.PriceLine{{Code: domain.PriceLineFreeTier, Label: fmt.Sprintf("免费额度（不超过 %d 行）", cfg.FreeRows)}}
+		return bd
+	}
+	add(domain.PriceLineBase, "基础费用", cfg.BaseFen)
+	if measured && cfg.Per1000RowsFen > 0 {
+		if extra := rows - cfg.FreeRows; extra > 0 {
+			units := (extra + 999) / 1000
+			label := fmt.Sprintf("按行计费 %d 行（每千行 %s 元）", extra, yuan(cfg.Per1000RowsFen))
+			if cfg.FreeRows > 0 {
+				label = fmt.Sprintf("按行计费 超出免费额度 %d 行（每千行 %s 元）", extra, yuan(cfg.Per1000RowsFen))
+			}
+			add(domain.PriceLineRows, label, units*cfg.Per1000RowsFen)
+		}
+	}
+	if stats.Sheets > 1 {
+		add(domain.PriceLineMultiSheet, fmt.Sprintf("多工作表（%d 个）", stats.Sheets), cfg.MultiSheetFen)
+	}
+	if cfg.LargeFileBytes > 0 && stats.InputBytes >= cfg.LargeFileBytes {
+		add(domain.PriceLineLargeFile, fmt.Sprintf("大文件（≥ %d MB）", cfg.LargeFileBytes>>20), cfg.LargeFileFen)
+	}
+	switch in.Mode {
+	case domain.CompareModeMerge:
+		add(domain.PriceLineMode, "合并导出", cfg.MergeFen)
+	case domain.CompareModeThreeWay:
+		add(domain.PriceLineMode, "三方比对", cfg.ThreeWayFen)
+	}
+
+	if c, ok := cfg.Coupons[normalizeCode(in.Coupon)]; ok && bd.SubtotalFen > 0 {
+		off := c.OffFen
+		if c.PercentOff > 0 {
+			off = bd.SubtotalFen * c.PercentOff / 100
+		}
+		off = min(off, bd.SubtotalFen)
+		if off > 0 {
+			bd.Coupon = c.Code
+			bd.DiscountFen = off
+			bd.Lines = append(bd.Lines, domain.PriceLine{Code: domain.PriceLineCoupon, Label: "优惠码 " + c.Code, AmountFen: -off})
+		}
+	}
+	bd.TotalFen = bd.SubtotalFen - bd.DiscountFen
+	return bd
+}
+
+func yuan(fen int64) string {
+	return strconv.FormatFloat(float64(fen)/100, 'f', -1, 64)
+}
+
+// readEnvFenDefault reads a non-negative integer (0 is a valid price).
+func readEnvFenDefault(key string, defaultVal int64) int64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultVal
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		return defaultVal
+	}
+	return n
+}
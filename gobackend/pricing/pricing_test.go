@@ -0,0 +1,167 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+)
+
+func rowsInput(rows int64) Input {
+	return Input{Stats: &domain.CompareJobStats{RowsFile1: rows, RowsFile2: rows / 2, Sheets: 1}}
+}
+
+func TestQuoteTierEdges(t *testing.T) {
+	e := NewEngine(Config{BaseFen: 500, FreeRows: 1000, Per1000RowsFen: 200, LargeFileBytes: 10 << 20})
+	cases := []struct {
+		rows int64
+		want int64
+	}{
+		{0, 0},
+		{1000, 0},           // at the free tier limit: still free
+		{1001, 500 + 200},   // one row over: base plus a whole started 1000
+		{2000, 500 + 200},   // exactly 1000 over
+		{2001, 500 + 400},   // 1001 over: second unit starts
+		{11000, 500 + 2000}, // 10000 over
+	}
+	for _, tc := range cases {
+		bd := e.Quote(rowsInput(tc.rows))
+		if bd.TotalFen != tc.want || bd.BillableRows != tc.rows {
+			t.Errorf("rows=%d: total=%d billable=%d, want %d", tc.rows, bd.TotalFen, bd.BillableRows, tc.want)
+		}
+		if tc.want == 0 && (len(bd.Lines) != 1 || bd.Lines[0].Code != domain.PriceLineFreeTier) {
+			t.Errorf("rows=%d: free quote lines = %+v", tc.rows, bd.Lines)
+		}
+	}
+
+	// Without a free tier every row counts.
+	e = NewEngine(Config{BaseFen: 500, Per1000RowsFen: 200})
+	if bd := e.Quote(rowsInput(1000)); bd.TotalFen != 700 {
+		t.Errorf("no free tier, 1000 rows: total=%d, want 700", bd.TotalFen)
+	}
+	// Unmeasured jobs pay the base price and mode surcharges only.
+	e = NewEngine(Config{BaseFen: 500, FreeRows: 1000, Per1000RowsFen: 200, ThreeWayFen: 300})
+	if bd := e.Quote(Input{Mode: domain.CompareModeThreeWay}); bd.TotalFen != 800 {
+		t.Errorf("unmeasured three-way: total=%d, want 800", bd.TotalFen)
+	}
+}
+
+func TestQuoteSurchargeEdges(t *testing.T) {
+	e := NewEngine(Config{BaseFen: 100, MultiSheetFen: 50, LargeFileBytes: 10 << 20, LargeFileFen: 30, MergeFen: 20})
+	cases := []struct {
+		name  string
+		stats domain.CompareJobStats
+		mode  domain.CompareMode
+		want  int64
+	}{
+		{"plain", domain.CompareJobStats{RowsFile1: 10, Sheets: 1, InputBytes: 10<<20 - 1}, "", 100},
+		{"two sheets", domain.CompareJobStats{RowsFile1: 10, Sheets: 2}, "", 150},
+		{"large file at limit", domain.CompareJobStats{RowsFile1: 10, Sheets: 1, InputBytes: 10 << 20}, "", 130},
+		{"everything", domain.CompareJobStats{RowsFile1: 10, Sheets: 3, InputBytes: 20 << 20}, domain.CompareModeMerge, 200},
+	}
+	for _, tc := range cases {
+		stats := tc.stats
+		bd := e.Quote(Input{Stats: &stats, Mode: tc.mode})
+		if bd.TotalFen != tc.want || bd.SubtotalFen != tc.want {
+			t.Errorf("%s: total=%d subtotal=%d, want %d (%+v)", tc.name, bd.TotalFen, bd.SubtotalFen, tc.want, bd.Lines)
+		}
+		var sum int64
+		for _, l := range bd.Lines {
+			sum += l.AmountFen
+		}
+		if sum != bd.TotalFen {
+			t.Errorf("%s: lines sum to %d, total %d", tc.name, sum, bd.TotalFen)
+		}
+	}
+}
+
+func TestQuoteMinimumPrice(t *testing.T) {
+	e := NewEngine(Config{
+		BaseFen: 300,
+		Coupons: ParseCoupons("BIG=1000,HALF=50%,ALL=100%"),
+	})
+	in := rowsInput(10)
+
+	// A discount larger than the price bottoms out at free, never below.
+	for _, code := range []string{"big", "ALL"} {
+		in.Coupon = code
+		bd := e.Quote(in)
+		if bd.TotalFen != 0 || bd.DiscountFen != 300 {
+			t.Errorf("%s: total=%d discount=%d, want 0 / 300", code, bd.TotalFen, bd.DiscountFen)
+		}
+	}
+	in.Coupon = "HALF"
+	if bd := e.Quote(in); bd.TotalFen != 150 || bd.Coupon != "HALF" {
+		t.Errorf("HALF: total=%d coupon=%q", bd.TotalFen, bd.Coupon)
+	}
+	// Nothing to discount on a free job: the coupon isn't applied (or shown).
+	e = NewEngine(Config{FreeRows: 100, BaseFen: 300, Coupons: ParseCoupons("BIG=1000")})
+	in.Coupon = "BIG"
+	if bd := e.Quote(in); bd.TotalFen != 0 || bd.Coupon != "" || bd.DiscountFen != 0 {
+		t.Errorf("free job with coupon: %+v", bd)
+	}
+	// An unknown code is ignored at quote time.
+	in.Coupon = "NOPE"
+	e = NewEngine(Config{BaseFen: 300})
+	if bd := e.Quote(in); bd.TotalFen != 300 {
+		t.Errorf("unknown coupon: total=%d, want 300", bd.TotalFen)
+	}
+}
+
+func TestCheckCoupon(t *testing.T) {
+	e := NewEngine(Config{Coupons: ParseCoupons("SPRING=20%@2026-03-31, vip=500")})
+	e.now = func() time.Time { return time.Date(2026, 3, 31, 23, 0, 0, 0, time.Local) }
+	if code, err := e.CheckCoupon(" spring "); err != nil || code != "SPRING" {
+		t.Fatalf("last valid day: %q %v", code, err)
+	}
+	if code, err := e.CheckCoupon("VIP"); err != nil || code != "VIP" {
+		t.Fatalf("VIP: %q %v", code, err)
+	}
+	if code, err := e.CheckCoupon(""); err != nil || code != "" {
+		t.Fatalf("empty: %q %v", code, err)
+	}
+	if _, err := e.CheckCoupon("nope"); !errors.Is(err, ErrInvalidCoupon) {
+		t.Fatalf("unknown: %v", err)
+	}
+	e.now = func() time.Time { return time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local) }
+	if _, err := e.CheckCoupon("spring"); !errors.Is(err, ErrCouponExpired) {
+		t.Fatalf("expired: %v", err)
+	}
+}
+
+func TestParseCouponsSkipsMalformed(t *testing.T) {
+	got := ParseCoupons("OK=10%, =5, BAD, ZERO=0%, TOOMUCH=101%, NEG=-5, DATE=5@2026-13-01, FEN=250")
+	if len(got) != 2 || got["OK"].PercentOff != 10 || got["FEN"].OffFen != 250 {
+		t.Fatalf("coupons = %+v, want OK and FEN only", got)
+	}
+}
+
+func TestNewEngineFromEnv(t *testing.T) {
+	t.Setenv("COMPARE_JOB_FEE_FEN", "300")
+	t.Setenv("PRICE_BASE_FEN", "")
+	t.Setenv("PRICE_FREE_ROWS", "500")
+	t.Setenv("PRICE_PER_1000_ROWS_FEN", "abc") // unparsable: default (0)
+	t.Setenv("PRICE_MULTI_SHEET_FEN", "-1")    // negative: default (0)
+	t.Setenv("PRICE_LARGE_FILE_MB", "x")       // default 10 MB
+	t.Setenv("PRICE_COUPONS", "A=10%,broken")
+	cfg := NewEngineFromEnv().cfg
+	if cfg.BaseFen != 300 || cfg.FreeRows != 500 || cfg.Per1000RowsFen != 0 || cfg.MultiSheetFen != 0 ||
+		cfg.LargeFileBytes != 10<<20 || len(cfg.Coupons) != 1 {
+		t.Fatalf("config = %+v", cfg)
+	}
+
+	// PRICE_BASE_FEN wins over the legacy flat fee; a bad value falls back to it.
+	t.Setenv("PRICE_BASE_FEN", "200")
+	if got := NewEngineFromEnv().cfg.BaseFen; got != 200 {
+		t.Fatalf("base = %d, want 200", got)
+	}
+	t.Setenv("PRICE_BASE_FEN", "2.5")
+	if got := NewEngineFromEnv().cfg.BaseFen; got != 300 {
+		t.Fatalf("base with bad PRICE_BASE_FEN = %d, want 300", got)
+	}
+	t.Setenv("COMPARE_JOB_FEE_FEN", "oops")
+	if got := NewEngineFromEnv().cfg.BaseFen; got != 0 {
+		t.Fatalf("base with nothing valid = %d, want 0", got)
+	}
+}
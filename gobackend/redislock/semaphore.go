@@ -0,0 +1,116 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Semaphore caps how many holders share one key at a time (e.g. running compare jobs per user).
+// Holders are ZSET members scored with their expiry (unix ms), so slots of a crashed worker free
+// themselves once the TTL passes without a Refresh.
+type Semaphore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func NewSemaphore(rdb *redis.Client, prefix string) *Semaphore {
+	return &Semaphore{
+		rdb:    rdb,
+		prefix: strings.TrimSpace(prefix),
+	}
+}
+
+func (s *Semaphore) Key(name string) string {
+	name = strings.TrimSpace(name)
+	if s == nil {
+		return name
+	}
+	p := strings.TrimSpace(s.prefix)
+	if p == "" {
+		p = "gy:sem:"
+	}
+	return p + name
+}
+
+var semAcquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if not redis.call("ZSCORE", KEYS[1], ARGV[2]) and redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[4]) then
+  return 0
+end
+redis.call("ZADD", KEYS[1], now + ttl, ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ttl)
+return 1
+`)
+
+// Acquire takes one of limit slots for holder (re-acquiring an own slot just extends it).
+func (s *Semaphore) Acquire(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error) {
+	if s == nil || s.rdb == nil {
+		return false, errors.New("redis semaphore 未初始化")
+	}
+	key = strings.TrimSpace(key)
+	holder = strings.TrimSpace(holder)
+	if key == "" || holder == "" {
+		return false, errors.New("semaphore key/holder 为空")
+	}
+	if limit <= 0 {
+		return true, nil
+	}
+	n, err := semAcquireScript.Run(ctx, s.rdb, []string{key}, time.Now().UnixMilli(), holder, semTTL(ttl), limit).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+var semRefreshScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+  redis.call("ZADD", KEYS[1], tonumber(ARGV[2]) + tonumber(ARGV[3]), ARGV[1])
+  redis.call("PEXPIRE", KEYS[1], ARGV[3])
+  return 1
+else
+  return 0
+end
+`)
+
+// Refresh extends holder's slot; false means it already expired (or was released).
+func (s *Semaphore) Refresh(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	if s == nil || s.rdb == nil {
+		return false, errors.New("redis semaphore 未初始化")
+	}
+	key = strings.TrimSpace(key)
+	holder = strings.TrimSpace(holder)
+	if key == "" || holder == "" {
+		return false, errors.New("semaphore key/holder 为空")
+	}
+	n, err := semRefreshScript.Run(ctx, s.rdb, []string{key}, holder, time.Now().UnixMilli(), semTTL(ttl)).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *Semaphore) Release(ctx context.Context, key, holder string) error {
+	if s == nil || s.rdb == nil {
+		return errors.New("redis semaphore 未初始化")
+	}
+	key = strings.TrimSpace(key)
+	holder = strings.TrimSpace(holder)
+	if key == "" || holder == "" {
+		return errors.New("semaphore key/holder 为空")
+	}
+	return s.rdb.ZRem(ctx, key, holder).Err()
+}
+
+func semTTL(ttl time.Duration) int64 {
+	px := ttl.Milliseconds()
+	if px <= 0 {
+		px = (2 * time.Hour).Milliseconds()
+	}
+	return px
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gobackend/auth"
+	"gobackend/compare"
+	"gobackend/domain"
+	"gobackend/objstore"
+	"gobackend/obs"
+	"gobackend/paygate"
+	"gobackend/store"
+	"gobackend/streamq"
+	"gobackend/subscription"
+	"gobackend/wallet"
+	"gobackend/webhook"
+)
+
+// setupStandalone wires the API together with the compare and paygate workers in this process:
+// jobs and queues in memory, inputs/results on local disk (LOCAL_STORE_DIR) by default. For local
+// runs and single-box installs only: everything but the files is lost on restart.
+func setupStandalone(mux *http.ServeMux, tmpRoot string, tokens *auth.Tokens) {
+	hookQ := streamq.NewMemoryQueue("webhook")
+	hookQ.SetMessageType(domain.MessageWebhookDeliver)
+	jobStore := webhook.NewNotifyingStore(store.NewInMemoryCompareJobStore(), hookQ)
+	wlt := wallet.New(store.NewInMemoryLedgerStore())
+	var subs *subscription.Service
+	if os.Getenv("SUBSCRIPTION_PLANS") != "" {
+		subs = subscription.New(store.NewInMemorySubscriptionStore())
+	}
+	registerAuth(mux, store.NewInMemoryUserStore(), tokens, wlt)
+
+	// Local disk unless OBJECT_STORE / OSS_BUCKET / S3_BUCKET says otherwise.
+	objSt, err := objstore.NewFromEnv("local", filepath.Join(tmpRoot, "objects"))
+	if err != nil {
+		log.Fatalf("init object store failed: %v", err)
+	}
+	if local, ok := objSt.(*objstore.Local); ok {
+		local.RegisterRoutes(mux)
+	}
+
+	q := streamq.NewMemoryQueue("compare")
+	q.SetMessageType(domain.MessageCompareRun)
+	payQ := streamq.NewMemoryQueue("paygate")
+	payQ.SetMessageType(domain.MessagePaygateCheck)
+
+	compareSvc := compare.NewService(jobStore, q, tmpRoot, objSt)
+	compareSvc.SetRequireLogin(readEnvDefault("AUTH_REQUIRE_LOGIN", "") == "1")
+	compareSvc.RegisterRoutes(mux)
+	payments := registerBilling(mux, wlt, subs, compareSvc, jobStore)
+
+	// One process: no distributed locks or tenant caps needed.
+	compareWorker := compare.NewWorker(jobStore, tmpRoot, objSt, payQ, nil)
+	payWorker := paygate.NewWorker(jobStore, nil)
+	if subs != nil {
+		payWorker.SetSubscriptions(subs)
+	}
+	retry := streamq.RetryPolicy{
+		MaxDeliveries: int64(readEnvIntDefault("STREAM_MAX_DELIVERIES", 5)),
+		BaseDelay:     time.Duration(readEnvIntDefault("STREAM_RETRY_BASE_SECONDS", 30)) * time.Second,
+		MaxDelay:      time.Duration(readEnvIntDefault("STREAM_RETRY_MAX_SECONDS", 600)) * time.Second,
+	}
+
+	compareCons := streamq.NewMemoryConsumer(q)
+	compareCons.SetConcurrency(readEnvIntDefault("STREAM_CONCURRENCY", 2))
+	compareCons.SetRetryPolicy(retry)
+	go consumeStandalone("compare-worker", compareCons, domain.MessageCompareRun, compareWorker.Process)
+
+	payCons := streamq.NewMemoryConsumer(payQ)
+	payCons.SetConcurrency(4)
+	payCons.SetRetryPolicy(retry)
+	go consumeStandalone("payment-worker", payCons, domain.MessagePaygateCheck, payWorker.Process)
+	go paygate.NewReconciler(jobStore, payments, nil).Run(context.Background())
+
+	if webhook.Enabled() {
+		hookCfg := webhook.ConfigFromEnv()
+		dispatcher := webhook.NewDispatcher(jobStore, hookCfg)
+		hookCons := streamq.NewMemoryConsumer(hookQ)
+		hookCons.SetConcurrency(readEnvIntDefault("WEBHOOK_CONCURRENCY", 4))
+		hookCons.SetRetryPolicy(hookCfg.RetryPolicy())
+		go consumeStandalone("webhook", hookCons, domain.MessageWebhookDeliver, dispatcher.Process)
+	}
+	log.Printf("standalone mode: in-memory jobs, queues, wallet and subscriptions")
+}
+
+// consumeStandalone runs process for every msgType message on cons until the process exits.
+func consumeStandalone(name string, cons *streamq.MemoryConsumer, msgType string, process func(ctx context.Context, jobID string) error) {
+	handle := func(ctx context.Context, env streamq.Envelope) error {
+		start := time.Now()
+		err := process(ctx, env.JobID)
+		obs.RecordWorkerJob(name, start, err)
+		return err
+	}
+	if err := cons.ConsumeLoop(context.Background(), streamq.Mux{msgType: handle}.Handle); err != nil {
+		log.Fatalf("%s consume loop exited: %v", name, err)
+	}
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// NewCompareJobStoreFromEnv picks the job store shared by the API and the workers by
+// COMPARE_JOB_STORE:
+//
+//	redis      (default) JSON in Redis, expiring after COMPARE_JOB_TTL_SECONDS
+//	sql        SQL only (DATABASE_URL); no push updates, SSE/cancel fall back to polling
+//	redis+sql  write-through: SQL is the durable record, Redis the hot cache and pub/sub
+func NewCompareJobStoreFromEnv(redisAddr, redisPassword string) (CompareJobStore, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("COMPARE_JOB_STORE")))
+	switch mode {
+	case "", "redis":
+		return NewRedisCompareJobStore(redisAddr, redisPassword)
+	case "sql", "redis+sql":
+	default:
+		return nil, fmt.Errorf("COMPARE_JOB_STORE 无效: %s", mode)
+	}
+
+	db, driver, err := OpenSQL()
+	if err != nil {
+		return nil, err
+	}
+	durable, err := NewSQLCompareJobStore(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "sql" {
+		return durable, nil
+	}
+	cache, err := NewRedisCompareJobStore(redisAddr, redisPassword)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("compare job store: write-through (sql durable, redis cache)")
+	return NewWriteThroughCompareJobStore(cache, durable), nil
+}
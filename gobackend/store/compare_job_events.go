@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"gobackend/domain"
+)
+
+// CompareJobWatcher is implemented by stores that push job changes after every Update.
+//
+// Watch returns a channel that receives the job state after each change and is closed when ctx
+// is done. Delivery is "latest wins": a slow reader may skip intermediate states but always
+// sees the newest one, which is enough because every value is the full job.
+type CompareJobWatcher interface {
+	Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error)
+}
+
+func (s *RedisCompareJobStore) eventsChannel(id string) string {
+	return s.keyPrefix + "events:" + id
+}
+
+// publish fans the new state out to every API pod (best-effort).
+func (s *RedisCompareJobStore) publish(ctx context.Context, id string, payload []byte) {
+	_ = s.rdb.Publish(ctx, s.eventsChannel(id), payload).Err()
+}
+
+// Watch shares one PSUBSCRIBE connection per store (started by the first Watch) and fans the
+// messages out in memory, so concurrent watchers don't each hold a Redis connection.
+func (s *RedisCompareJobStore) Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error) {
+	if err := s.events.start(ctx, s.rdb, s.eventsChannel("*")); err != nil {
+		return nil, err
+	}
+	return s.events.hub.subscribe(ctx, strings.TrimSpace(id)), nil
+}
+
+// redisJobEvents is the process-wide subscription to every job's events channel.
+type redisJobEvents struct {
+	mu  sync.Mutex
+	sub *redis.PubSub
+	hub jobHub
+}
+
+// start subscribes once and waits until the subscription is active, so a watcher registered
+// afterwards can Get() without missing a change. A failed start is retried by the next Watch;
+// after that go-redis reconnects and resubscribes on its own.
+func (e *redisJobEvents) start(ctx context.Context, rdb *redis.Client, pattern string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sub != nil {
+		return nil
+	}
+	sub := rdb.PSubscribe(context.Background(), pattern)
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return err
+	}
+	e.sub = sub
+	go func() {
+		for m := range sub.Channel() {
+			var rec compareJobRecord
+			if err := json.Unmarshal([]byte(m.Payload), &rec); err != nil {
+				continue
+			}
+			e.hub.publish(jobFromRecord(rec))
+		}
+	}()
+	return nil
+}
+
+// sendLatest replaces a value the reader has not picked up yet.
+func sendLatest(ch chan *domain.CompareJob, j *domain.CompareJob) {
+	for {
+		select {
+		case ch <- j:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// jobHub is the in-process fan-out used by InMemoryCompareJobStore.
+type jobHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan *domain.CompareJob]struct{}
+}
+
+func (h *jobHub) subscribe(ctx context.Context, id string) <-chan *domain.CompareJob {
+	ch := make(chan *domain.CompareJob, 1)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[string]map[chan *domain.CompareJob]struct{})
+	}
+	if h.subs[id] == nil {
+		h.subs[id] = make(map[chan *domain.CompareJob]struct{})
+	}
+	h.subs[id][ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs[id], ch)
+		if len(h.subs[id]) == 0 {
+			delete(h.subs, id)
+		}
+		close(ch)
+		h.mu.Unlock()
+	}()
+	return ch
+}
+
+func (h *jobHub) publish(j *domain.CompareJob) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[j.ID] {
+		sendLatest(ch, j.Clone())
+	}
+}
+
+func (s *InMemoryCompareJobStore) Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error) {
+	return s.hub.subscribe(ctx, id), nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"gobackend/domain"
+)
+
+func newTestRedisJobs(t *testing.T) (*RedisCompareJobStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return &RedisCompareJobStore{rdb: rdb, keyPrefix: "gy:comparejob:", ttl: time.Hour}, mr
+}
+
+func nextUpdate(t *testing.T, ch <-chan *domain.CompareJob) *domain.CompareJob {
+	t.Helper()
+	select {
+	case j, ok := <-ch:
+		if !ok {
+			t.Fatal("watch closed")
+		}
+		return j
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update")
+	}
+	return nil
+}
+
+func TestRedisWatchSharesOneSubscription(t *testing.T) {
+	s, mr := newTestRedisJobs(t)
+	for _, id := range []string{"job_1", "job_2"} {
+		if err := s.Create(&domain.CompareJob{ID: id, Status: domain.CompareJobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var watches []<-chan *domain.CompareJob
+	for i := 0; i < 5; i++ {
+		ch, err := s.Watch(ctx, "job_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		watches = append(watches, ch)
+	}
+	other, err := s.Watch(ctx, "job_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := mr.PubSubNumPat(); n != 1 {
+		t.Fatalf("%d pattern subscriptions for 6 watchers, want 1", n)
+	}
+
+	if _, _, err := s.Update("job_1", func(j *domain.CompareJob) { j.Error = "x" }); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range watches {
+		if j := nextUpdate(t, ch); j.ID != "job_1" || j.Error != "x" {
+			t.Fatalf("watcher %d got %+v", i, j)
+		}
+	}
+	select {
+	case j := <-other:
+		t.Fatalf("job_2 watcher got an update of %s", j.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Ending one watch closes only its channel.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ch, err := s.Watch(ctx2, "job_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel2()
+	if _, ok := <-ch; ok {
+		t.Fatal("cancelled watch got a value")
+	}
+	if _, _, err := s.Update("job_2", func(j *domain.CompareJob) { j.Error = "y" }); err != nil {
+		t.Fatal(err)
+	}
+	if j := nextUpdate(t, other); j.ID != "job_2" || j.Error != "y" {
+		t.Fatalf("job_2 watcher got %+v", j)
+	}
+}
+
+func TestRedisWatchIsActiveOnReturn(t *testing.T) {
+	s, _ := newTestRedisJobs(t)
+	if err := s.Create(&domain.CompareJob{ID: "job_1", Status: domain.CompareJobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := s.Watch(context.Background(), "job_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No sleep: an Update right after Watch returns must be delivered.
+	if _, _, err := s.Update("job_1", func(j *domain.CompareJob) { j.Error = "first" }); err != nil {
+		t.Fatal(err)
+	}
+	if j := nextUpdate(t, ch); j.Error != "first" {
+		t.Fatalf("got %+v", j)
+	}
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"gobackend/domain"
+)
+
+// JobQuery filters CompareJobStore.List. Zero values match everything. Results are newest first.
+type JobQuery struct {
+	// Owner matches CompareJob.OwnerID.
+	Owner  string
+	Status domain.CompareJobStatus
+	// CreatedFrom (inclusive) / CreatedTo (exclusive) bound CreatedAt.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Cursor is JobPage.NextCursor of the previous page ("" = first page).
+	Cursor string
+	// Limit defaults to 20, at most 100.
+	Limit int
+}
+
+type JobPage struct {
+	Jobs []*domain.CompareJob
+	// NextCursor is "" on the last page.
+	NextCursor string
+}
+
+var ErrInvalidCursor = errors.New("cursor 无效")
+
+func (q JobQuery) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return 20
+	case q.Limit > 100:
+		return 100
+	}
+	return q.Limit
+}
+
+// matches checks everything but the cursor.
+func (q JobQuery) matches(j *domain.CompareJob) bool {
+	if q.Owner != "" && j.OwnerID != q.Owner {
+		return false
+	}
+	if q.Status != "" && j.Status != q.Status {
+		return false
+	}
+	if !q.CreatedFrom.IsZero() && j.CreatedAt.Before(q.CreatedFrom) {
+		return false
+	}
+	if !q.CreatedTo.IsZero() && !j.CreatedAt.Before(q.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// jobCursor is the position after the last returned job: (created-at ms, id), ordered descending.
+type jobCursor struct {
+	ms int64
+	id string
+}
+
+func cursorAfter(j *domain.CompareJob) string {
+	raw := strconv.FormatInt(j.CreatedAt.UnixMilli(), 10) + ":" + j.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseCursor(s string) (*jobCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	msRaw, id, ok := strings.Cut(string(b), ":")
+	ms, err := strconv.ParseInt(msRaw, 10, 64)
+	if !ok || err != nil || ms < 0 || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &jobCursor{ms: ms, id: id}, nil
+}
+
+// before reports whether (ms, id) comes after the cursor in newest-first order.
+func (c *jobCursor) before(ms int64, id string) bool {
+	return c == nil || ms < c.ms || (ms == c.ms && id < c.id)
+}
+
+func (s *InMemoryCompareJobStore) List(q JobQuery) (JobPage, error) {
+	cur, err := parseCursor(q.Cursor)
+	if err != nil {
+		return JobPage{}, err
+	}
+	s.mu.Lock()
+	var jobs []*domain.CompareJob
+	for _, j := range s.jobs {
+		if j != nil && q.matches(j) && cur.before(j.CreatedAt.UnixMilli(), j.ID) {
+			jobs = append(jobs, j.Clone())
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool {
+		ma, mb := jobs[a].CreatedAt.UnixMilli(), jobs[b].CreatedAt.UnixMilli()
+		if ma != mb {
+			return ma > mb
+		}
+		return jobs[a].ID > jobs[b].ID
+	})
+	var page JobPage
+	if n := q.limit(); len(jobs) > n {
+		jobs = jobs[:n]
+		page.NextCursor = cursorAfter(jobs[n-1])
+	}
+	page.Jobs = jobs
+	return page, nil
+}
+
+// Redis secondary indexes: sorted sets scored by created-at (unix ms), members are job IDs.
+//
+//	<prefix>idx:all, <prefix>idx:owner:<owner>, <prefix>idx:status:<status>,
+//	<prefix>idx:owner:<owner>:status:<status>
+//
+// List reads the narrowest index for the query. Index entries outlive expired jobs; they are
+// trimmed by age on every write and dropped lazily when List finds the job gone.
+
+func (s *RedisCompareJobStore) indexKey(owner string, status domain.CompareJobStatus) string {
+	switch {
+	case owner != "" && status != "":
+		return s.keyPrefix + "idx:owner:" + owner + ":status:" + string(status)
+	case owner != "":
+		return s.keyPrefix + "idx:owner:" + owner
+	case status != "":
+		return s.keyPrefix + "idx:status:" + string(status)
+	}
+	return s.keyPrefix + "idx:all"
+}
+
+// indexKeys are all indexes a job with owner/status belongs to.
+func (s *RedisCompareJobStore) indexKeys(owner string, status domain.CompareJobStatus) []string {
+	keys := []string{s.indexKey("", "")}
+	if status != "" {
+		keys = append(keys, s.indexKey("", status))
+	}
+	if owner != "" {
+		keys = append(keys, s.indexKey(owner, ""))
+		if status != "" {
+			keys = append(keys, s.indexKey(owner, status))
+		}
+	}
+	return keys
+}
+
+// addToIndexes queues the index writes for a new job (or one whose owner/status changed from
+// prev; prev is nil for a new job).
+func (s *RedisCompareJobStore) addToIndexes(ctx context.Context, pipe redis.Pipeliner, prev, j *domain.CompareJob) {
+	if prev != nil {
+		if prev.OwnerID == j.OwnerID && prev.Status == j.Status {
+			return
+		}
+		old := s.indexKeys(prev.OwnerID, prev.Status)
+		keep := make(map[string]bool)
+		for _, k := range s.indexKeys(j.OwnerID, j.Status) {
+			keep[k] = true
+		}
+		for _, k := range old {
+			if !keep[k] {
+				pipe.ZRem(ctx, k, j.ID)
+			}
+		}
+	}
+	cutoff := strconv.FormatInt(time.Now().Add(-s.ttl).UnixMilli(), 10)
+	for _, k := range s.indexKeys(j.OwnerID, j.Status) {
+		pipe.ZAdd(ctx, k, redis.Z{Score: float64(j.CreatedAt.UnixMilli()), Member: j.ID})
+		pipe.ZRemRangeByScore(ctx, k, "-inf", "("+cutoff)
+		// Per-owner indexes of inactive owners go away with their jobs.
+		pipe.Expire(ctx, k, s.ttl)
+	}
+}
+
+func (s *RedisCompareJobStore) List(q JobQuery) (JobPage, error) {
+	cur, err := parseCursor(q.Cursor)
+	if err != nil {
+		return JobPage{}, err
+	}
+	limit := q.limit()
+	key := s.indexKey(q.Owner, q.Status)
+
+	min, max := "-inf", "+inf"
+	if !q.CreatedFrom.IsZero() {
+		min = strconv.FormatInt(q.CreatedFrom.UnixMilli(), 10)
+	}
+	if !q.CreatedTo.IsZero() {
+		max = "(" + strconv.FormatInt(q.CreatedTo.UnixMilli(), 10)
+	}
+	if cur != nil && (q.CreatedTo.IsZero() || cur.ms < q.CreatedTo.UnixMilli()) {
+		// Inclusive: jobs created in the same millisecond as the cursor are filtered by ID below.
+		max = strconv.FormatInt(cur.ms, 10)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	var (
+		jobs  []*domain.CompareJob
+		stale []interface{}
+	)
+	batch := int64(limit + 1)
+	for offset := int64(0); len(jobs) <= limit; offset += batch {
+		zs, err := s.rdb.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min: min, Max: max, Offset: offset, Count: batch,
+		}).Result()
+		if err != nil {
+			return JobPage{}, err
+		}
+		var ids []string
+		for _, z := range zs {
+			id, _ := z.Member.(string)
+			if cur.before(int64(z.Score), id) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			keys := make([]string, len(ids))
+			for i, id := range ids {
+				keys[i] = s.key(id)
+			}
+			vals, err := s.rdb.MGet(ctx, keys...).Result()
+			if err != nil {
+				return JobPage{}, err
+			}
+			for i, v := range vals {
+				raw, ok := v.(string)
+				if !ok {
+					stale = append(stale, ids[i])
+					continue
+				}
+				var rec compareJobRecord
+				if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+					continue
+				}
+				// Re-check: the index can lag a concurrent status change by a moment.
+				if j := jobFromRecord(rec); q.matches(j) {
+					jobs = append(jobs, j)
+				}
+			}
+		}
+		if int64(len(zs)) < batch {
+			break
+		}
+	}
+	if len(stale) > 0 {
+		_ = s.rdb.ZRem(ctx, key, stale...).Err()
+	}
+
+	var page JobPage
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+		page.NextCursor = cursorAfter(jobs[limit-1])
+	}
+	page.Jobs = jobs
+	return page, nil
+}
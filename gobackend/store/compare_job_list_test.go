@@ -0,0 +1,210 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+)
+
+// listStores are the CompareJobStore backends List is tested against.
+func listStores(t *testing.T) map[string]CompareJobStore {
+	t.Helper()
+	rs, _ := newTestRedisJobs(t)
+	return map[string]CompareJobStore{
+		"memory": NewInMemoryCompareJobStore(),
+		"redis":  rs,
+	}
+}
+
+type listJob struct {
+	id     string
+	owner  string
+	status domain.CompareJobStatus
+	// ms is the offset from the test's base time; equal offsets share a millisecond.
+	ms int64
+}
+
+// listAll pages through q and returns the IDs in order.
+func listAll(t *testing.T, st CompareJobStore, q JobQuery) string {
+	t.Helper()
+	var ids []string
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			t.Fatal("cursor does not advance")
+		}
+		page, err := st.List(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Jobs) > q.limit() {
+			t.Fatalf("page of %d jobs, limit %d", len(page.Jobs), q.limit())
+		}
+		for _, j := range page.Jobs {
+			ids = append(ids, j.ID)
+		}
+		if page.NextCursor == "" {
+			return strings.Join(ids, ",")
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	const (
+		processing = domain.CompareJobStatusProcessing
+		ready      = domain.CompareJobStatusReady
+	)
+	cases := []struct {
+		name string
+		jobs []listJob
+		q    JobQuery
+		want string
+	}{
+		{
+			name: "owner and status",
+			jobs: []listJob{
+				{"j1", "u1", ready, 1}, {"j2", "u1", processing, 2}, {"j3", "u2", ready, 3},
+				{"j4", "u1", ready, 4}, {"j5", "u1", ready, 5}, {"j6", "", ready, 6},
+			},
+			q:    JobQuery{Owner: "u1", Status: ready, Limit: 2},
+			want: "j5,j4,j1",
+		},
+		{
+			name: "owner only",
+			jobs: []listJob{{"j1", "u1", ready, 1}, {"j2", "u1", processing, 2}, {"j3", "u2", ready, 3}},
+			q:    JobQuery{Owner: "u1"},
+			want: "j2,j1",
+		},
+		{
+			name: "same millisecond ties broken by ID",
+			jobs: []listJob{
+				{"j1", "u1", ready, 0}, {"j4", "u1", ready, 0}, {"j2", "u1", ready, 0},
+				{"j3", "u1", ready, 0}, {"j5", "u1", ready, -1},
+			},
+			q:    JobQuery{Owner: "u1", Limit: 1},
+			want: "j4,j3,j2,j1,j5",
+		},
+		{
+			name: "ties split across pages",
+			jobs: []listJob{
+				{"a", "u1", ready, 2}, {"b", "u1", ready, 1}, {"c", "u1", ready, 1},
+				{"d", "u1", ready, 1}, {"e", "u1", ready, 0},
+			},
+			q:    JobQuery{Limit: 2},
+			want: "a,d,c,b,e",
+		},
+	}
+	for _, tc := range cases {
+		for name, st := range listStores(t) {
+			t.Run(tc.name+"/"+name, func(t *testing.T) {
+				base := time.Now().Truncate(time.Second)
+				for _, lj := range tc.jobs {
+					job := &domain.CompareJob{ID: lj.id, OwnerID: lj.owner, Status: lj.status, CreatedAt: base.Add(time.Duration(lj.ms) * time.Millisecond)}
+					if err := st.Create(job); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if got := listAll(t, st, tc.q); got != tc.want {
+					t.Fatalf("List = %s, want %s", got, tc.want)
+				}
+			})
+		}
+	}
+}
+
+func TestListJobsInvalidCursor(t *testing.T) {
+	forged := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	cursors := map[string]string{
+		"not base64":    "not a cursor!",
+		"no separator":  forged("1700000000000"),
+		"no id":         forged("1700000000000:"),
+		"no time":       forged(":job_1"),
+		"bad time":      forged("yesterday:job_1"),
+		"negative time": forged("-1:job_1"),
+		"padded base64": base64.URLEncoding.EncodeToString([]byte("1700000000000:job_1x")),
+	}
+	for name, st := range listStores(t) {
+		for cname, c := range cursors {
+			if _, err := st.List(JobQuery{Owner: "u1", Cursor: c}); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("%s, %s cursor: err = %v, want ErrInvalidCursor", name, cname, err)
+			}
+		}
+	}
+}
+
+// A job whose status changed is listed under its new status only, on both stores.
+func TestListJobsAfterStatusChange(t *testing.T) {
+	for name, st := range listStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			for i, id := range []string{"j1", "j2"} {
+				job := &domain.CompareJob{ID: id, OwnerID: "u1", Status: domain.CompareJobStatusProcessing, CreatedAt: now.Add(time.Duration(i) * time.Millisecond)}
+				if err := st.Create(job); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, _, err := Transition(st, "j1", domain.CompareJobStatusFailed, domain.ActorCompareWorker, "boom", nil); err != nil {
+				t.Fatal(err)
+			}
+			for q, want := range map[JobQuery]string{
+				{Owner: "u1", Status: domain.CompareJobStatusProcessing}: "j2",
+				{Status: domain.CompareJobStatusProcessing}:              "j2",
+				{Owner: "u1", Status: domain.CompareJobStatusFailed}:     "j1",
+				{Owner: "u1"}: "j2,j1",
+			} {
+				if got := listAll(t, st, q); got != want {
+					t.Errorf("List(%+v) = %s, want %s", q, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisListDropsStaleIndexEntries(t *testing.T) {
+	s, mr := newTestRedisJobs(t)
+	now := time.Now()
+	for i, id := range []string{"j1", "j2", "j3"} {
+		job := &domain.CompareJob{ID: id, OwnerID: "u1", Status: domain.CompareJobStatusProcessing, CreatedAt: now.Add(time.Duration(i) * time.Millisecond)}
+		if err := s.Create(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	procIdx := s.indexKey("u1", domain.CompareJobStatusProcessing)
+
+	// The status change moves j1 out of the processing indexes.
+	if _, _, err := Transition(s, "j1", domain.CompareJobStatusFailed, domain.ActorCompareWorker, "boom", nil); err != nil {
+		t.Fatal(err)
+	}
+	members := func(key string) string {
+		t.Helper()
+		m, err := mr.ZMembers(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(m, ",")
+	}
+	if got := members(procIdx); got != "j2,j3" {
+		t.Fatalf("%s = %s, want j1 gone after leaving processing", procIdx, got)
+	}
+	if got := members(s.indexKey("u1", domain.CompareJobStatusFailed)); got != "j1" {
+		t.Fatalf("failed index = %s, want j1", got)
+	}
+
+	// An index that lags the change (a write racing List) is re-checked against the job.
+	if _, err := mr.ZAdd(procIdx, float64(now.UnixMilli()), "j1"); err != nil {
+		t.Fatal(err)
+	}
+	// j2 expired: its index entries outlive it until List finds it gone.
+	mr.Del(s.key("j2"))
+
+	if got, want := listAll(t, s, JobQuery{Owner: "u1", Status: domain.CompareJobStatusProcessing}), "j3"; got != want {
+		t.Fatalf("List = %s, want %s", got, want)
+	}
+	if got := members(procIdx); got != "j1,j3" {
+		t.Fatalf("%s = %s, want the expired j2 dropped", procIdx, got)
+	}
+}
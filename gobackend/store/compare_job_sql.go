@@ -0,0 +1,235 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+)
+
+// SQLCompareJobStore keeps jobs in the compare_jobs table (PostgreSQL in production, SQLite in
+// tests). Jobs don't expire, so payment records stay available for reconciliation.
+//
+// The full job is stored as JSON in data; owner/status/paid/amount/timestamps are copied into
+// columns for listing and finance queries. Update uses optimistic concurrency on version instead
+// of row locks.
+type SQLCompareJobStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLCompareJobStore migrates the schema and returns the store. driver is the database/sql
+// driver name the db was opened with ("pgx"/"postgres" or "sqlite3"/"sqlite").
+func NewSQLCompareJobStore(db *sql.DB, driver string) (*SQLCompareJobStore, error) {
+	d, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := migrateSQL(ctx, db, d); err != nil {
+		return nil, err
+	}
+	log.Printf("compare job store: sql enabled driver=%s", driver)
+	return &SQLCompareJobStore{db: db, dialect: d}, nil
+}
+
+// sqlJobColumns are the queryable copies of job fields, in the order used by insert/update.
+func sqlJobColumns(j *domain.CompareJob) (owner string, status string, paid bool, amountFen int64, paidAtMs sql.NullInt64) {
+	if j.PaidAt != nil {
+		paidAtMs = sql.NullInt64{Int64: j.PaidAt.UnixMilli(), Valid: true}
+	}
+	return j.OwnerID, string(j.Status), j.Paid, int64(math.Round(j.AmountYuan * 100)), paidAtMs
+}
+
+func (s *SQLCompareJobStore) Create(job *domain.CompareJob) error {
+	if job == nil || strings.TrimSpace(job.ID) == "" {
+		return errors.New("job/id 为空")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := s.insert(ctx, job)
+	return err
+}
+
+// insert adds job at version 1; an existing ID is left untouched (created=false), like SETNX.
+func (s *SQLCompareJobStore) insert(ctx context.Context, job *domain.CompareJob) (created bool, err error) {
+	b, err := json.Marshal(recordFromJob(job))
+	if err != nil {
+		return false, err
+	}
+	owner, status, paid, amountFen, paidAtMs := sqlJobColumns(job)
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO compare_jobs
+		(id, version, owner_id, status, paid, amount_fen, created_at_ms, updated_at_ms, paid_at_ms, data)
+		VALUES (?, 1, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`),
+		job.ID, owner, status, paid, amountFen, job.CreatedAt.UnixMilli(), time.Now().UnixMilli(), paidAtMs, string(b))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLCompareJobStore) Get(id string) (*domain.CompareJob, bool, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	j, _, ok, err := s.get(ctx, id)
+	return j, ok, err
+}
+
+func (s *SQLCompareJobStore) get(ctx context.Context, id string) (*domain.CompareJob, int64, bool, error) {
+	var (
+		version int64
+		data    string
+	)
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT version, data FROM compare_jobs WHERE id = ?`), id).Scan(&version, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	var rec compareJobRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, 0, false, err
+	}
+	return jobFromRecord(rec), version, true, nil
+}
+
+func (s *SQLCompareJobStore) Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
+	j, _, ok, err := s.update(id, fn)
+	return j, ok, err
+}
+
+// update applies fn and returns the new job and version. A concurrent writer bumps version first,
+// so the UPDATE matches no row and fn is re-run on the fresh state. If fn changes nothing the row
+// is not written and the version stays the same.
+func (s *SQLCompareJobStore) update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, int64, bool, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, 0, false, nil
+	}
+	if fn == nil {
+		return nil, 0, false, errors.New("update fn 为空")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	for i := 0; i < 8; i++ {
+		j, ver, found, err := s.get(ctx, id)
+		if err != nil || !found {
+			return nil, 0, false, err
+		}
+		before, err := json.Marshal(recordFromJob(j))
+		if err != nil {
+			return nil, 0, false, err
+		}
+		fn(j)
+		b, err := json.Marshal(recordFromJob(j))
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if bytes.Equal(before, b) {
+			// Nothing changed (e.g. fn rejected a transition): keep the row and its version.
+			return j, ver, true, nil
+		}
+		owner, status, paid, amountFen, paidAtMs := sqlJobColumns(j)
+		res, err := s.db.ExecContext(ctx, s.dialect.rebind(`UPDATE compare_jobs
+			SET version = version + 1, owner_id = ?, status = ?, paid = ?, amount_fen = ?,
+				updated_at_ms = ?, paid_at_ms = ?, data = ?
+			WHERE id = ? AND version = ?`),
+			owner, status, paid, amountFen, time.Now().UnixMilli(), paidAtMs, string(b), id, ver)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if n == 1 {
+			return j, ver + 1, true, nil
+		}
+	}
+	return nil, 0, false, errors.New("sql update retry exceeded")
+}
+
+func (s *SQLCompareJobStore) List(q JobQuery) (JobPage, error) {
+	cur, err := parseCursor(q.Cursor)
+	if err != nil {
+		return JobPage{}, err
+	}
+	var (
+		where []string
+		args  []interface{}
+	)
+	if q.Owner != "" {
+		where = append(where, "owner_id = ?")
+		args = append(args, q.Owner)
+	}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(q.Status))
+	}
+	if !q.CreatedFrom.IsZero() {
+		where = append(where, "created_at_ms >= ?")
+		args = append(args, q.CreatedFrom.UnixMilli())
+	}
+	if !q.CreatedTo.IsZero() {
+		where = append(where, "created_at_ms < ?")
+		args = append(args, q.CreatedTo.UnixMilli())
+	}
+	if cur != nil {
+		where = append(where, "(created_at_ms < ? OR (created_at_ms = ? AND id < ?))")
+		args = append(args, cur.ms, cur.ms, cur.id)
+	}
+	query := "SELECT data FROM compare_jobs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := q.limit()
+	query += " ORDER BY created_at_ms DESC, id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return JobPage{}, err
+	}
+	defer rows.Close()
+	var jobs []*domain.CompareJob
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return JobPage{}, err
+		}
+		var rec compareJobRecord
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return JobPage{}, err
+		}
+		jobs = append(jobs, jobFromRecord(rec))
+	}
+	if err := rows.Err(); err != nil {
+		return JobPage{}, err
+	}
+
+	var page JobPage
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+		page.NextCursor = cursorAfter(jobs[limit-1])
+	}
+	page.Jobs = jobs
+	return page, nil
+}
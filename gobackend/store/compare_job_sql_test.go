@@ -0,0 +1,320 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	_ "github.com/mattn/go-sqlite3" // registers the "sqlite3" driver (tests only)
+
+	"gobackend/domain"
+)
+
+// openTestSQL opens an empty SQLite database in a temp dir. The sqlite3 driver needs cgo.
+func openTestSQL(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Skipf("sqlite3 unavailable (cgo disabled?): %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestSQLJobs(t *testing.T) (*SQLCompareJobStore, *sql.DB) {
+	t.Helper()
+	db := openTestSQL(t)
+	s, err := NewSQLCompareJobStore(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+func sqlJobVersion(t *testing.T, db *sql.DB, id string) int64 {
+	t.Helper()
+	var v int64
+	if err := db.QueryRow(`SELECT version FROM compare_jobs WHERE id = ?`, id).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMigrateSQL(t *testing.T) {
+	db := openTestSQL(t)
+	for i := 0; i < 2; i++ { // the second run must be a no-op
+		if err := migrateSQL(t.Context(), db, sqlDialect{}); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+	var n, top int
+	if err := db.QueryRow(`SELECT COUNT(*), MAX(version) FROM schema_migrations`).Scan(&n, &top); err != nil {
+		t.Fatal(err)
+	}
+	last := sqlMigrations[len(sqlMigrations)-1].version
+	if n != len(sqlMigrations) || top != last {
+		t.Fatalf("schema_migrations: %d rows, max %d; want %d, %d", n, top, len(sqlMigrations), last)
+	}
+	for _, table := range []string{"compare_jobs", "ledger_accounts", "ledger_journals", "ledger_postings", "wallet_topups",
+		"subscriptions", "subscription_orders", "subscription_usage", "subscription_usage_jobs"} {
+		if _, err := db.Exec(`SELECT COUNT(*) FROM ` + table); err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+}
+
+func TestSQLCompareJobStoreCreateGet(t *testing.T) {
+	s, _ := newTestSQLJobs(t)
+	job := &domain.CompareJob{ID: "j1", Status: domain.CompareJobStatusProcessing, OwnerID: "u1", CreatedAt: time.Now()}
+	if err := s.Create(job); err != nil {
+		t.Fatal(err)
+	}
+	// A second Create with the same ID keeps the first job (SETNX semantics).
+	if err := s.Create(&domain.CompareJob{ID: "j1", Status: domain.CompareJobStatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := s.Get("j1")
+	if err != nil || !ok {
+		t.Fatalf("get: ok=%v err=%v", ok, err)
+	}
+	if got.Status != domain.CompareJobStatusProcessing || got.OwnerID != "u1" {
+		t.Fatalf("got %+v", got)
+	}
+	if _, ok, err := s.Get("missing"); ok || err != nil {
+		t.Fatalf("missing job: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSQLCompareJobStoreUpdateVersionConflict(t *testing.T) {
+	s, db := newTestSQLJobs(t)
+	if err := s.Create(&domain.CompareJob{ID: "j1", Status: domain.CompareJobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run of the outer fn lets another Update commit in between its read and its write,
+	// so the outer UPDATE finds a newer version and has to re-run fn on the fresh row.
+	calls := 0
+	got, ok, err := s.Update("j1", func(j *domain.CompareJob) {
+		calls++
+		if calls == 1 {
+			if _, _, err := s.Update("j1", func(j *domain.CompareJob) { j.CodeURL = "weixin://inner" }); err != nil {
+				t.Errorf("inner update: %v", err)
+			}
+		}
+		j.Error = "outer"
+	})
+	if err != nil || !ok {
+		t.Fatalf("update: ok=%v err=%v", ok, err)
+	}
+	if calls != 2 {
+		t.Fatalf("fn ran %d times, want 2 (conflict + retry)", calls)
+	}
+	if got.CodeURL != "weixin://inner" || got.Error != "outer" {
+		t.Fatalf("lost update: %+v", got)
+	}
+	if v := sqlJobVersion(t, db, "j1"); v != 3 {
+		t.Fatalf("version = %d, want 3", v)
+	}
+}
+
+func TestSQLCompareJobStoreConcurrentUpdates(t *testing.T) {
+	s, db := newTestSQLJobs(t)
+	if err := s.Create(&domain.CompareJob{ID: "j1", Status: domain.CompareJobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 6
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := s.Update("j1", func(j *domain.CompareJob) {
+				j.Error = strings.TrimPrefix(j.Error+fmt.Sprintf(",%d", i), ",")
+			}); err != nil {
+				t.Errorf("update %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	got, _, _ := s.Get("j1")
+	if parts := strings.Split(got.Error, ","); len(parts) != n {
+		t.Fatalf("updates lost: %q", got.Error)
+	}
+	if v := sqlJobVersion(t, db, "j1"); v != n+1 {
+		t.Fatalf("version = %d, want %d", v, n+1)
+	}
+}
+
+func TestSQLCompareJobStoreRejectedTransitionKeepsRow(t *testing.T) {
+	s, db := newTestSQLJobs(t)
+	job := &domain.CompareJob{ID: "j1", CreatedAt: time.Now()}
+	if err := job.Transition(domain.CompareJobStatusProcessing, domain.ActorAPI, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(job); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Transition(s, "j1", domain.CompareJobStatusCancelled, domain.ActorAPI, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	v := sqlJobVersion(t, db, "j1")
+
+	_, _, err := Transition(s, "j1", domain.CompareJobStatusReady, domain.ActorCompareWorker, "", func(j *domain.CompareJob) {
+		j.ResultOSSKey = "results/j1.xlsx"
+	})
+	if !errors.Is(err, domain.ErrIllegalTransition) {
+		t.Fatalf("cancelled -> ready: err=%v, want ErrIllegalTransition", err)
+	}
+	if got := sqlJobVersion(t, db, "j1"); got != v {
+		t.Fatalf("rejected transition bumped version %d -> %d", v, got)
+	}
+	got, _, _ := s.Get("j1")
+	if got.Status != domain.CompareJobStatusCancelled || got.ResultOSSKey != "" || len(got.Events) != 2 {
+		t.Fatalf("rejected transition changed the job: %+v", got)
+	}
+}
+
+func TestSQLCompareJobStoreListPages(t *testing.T) {
+	s, _ := newTestSQLJobs(t)
+	base := time.UnixMilli(1_700_000_000_000)
+	// Two jobs share each timestamp so paging has to break ties on ID.
+	for i := 0; i < 7; i++ {
+		owner := "u1"
+		if i == 3 {
+			owner = "u2"
+		}
+		job := &domain.CompareJob{
+			ID:        fmt.Sprintf("j%d", i),
+			Status:    domain.CompareJobStatusReady,
+			OwnerID:   owner,
+			CreatedAt: base.Add(time.Duration(i/2) * time.Second),
+		}
+		if err := s.Create(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	q := JobQuery{Owner: "u1", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("cursor does not advance")
+		}
+		page, err := s.List(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, j := range page.Jobs {
+			ids = append(ids, j.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if got, want := strings.Join(ids, ","), "j6,j5,j4,j2,j1,j0"; got != want {
+		t.Fatalf("pages = %s, want %s", got, want)
+	}
+
+	if _, err := s.List(JobQuery{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("bad cursor: err=%v", err)
+	}
+}
+
+func newTestWriteThrough(t *testing.T) (*WriteThroughCompareJobStore, *sql.DB, *miniredis.Miniredis) {
+	t.Helper()
+	durable, db := newTestSQLJobs(t)
+	mr := miniredis.RunT(t)
+	cache, err := NewRedisCompareJobStore(mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWriteThroughCompareJobStore(cache, durable), db, mr
+}
+
+func cachedJob(t *testing.T, mr *miniredis.Miniredis, id string) (versionedJobRecord, bool) {
+	t.Helper()
+	raw, err := mr.Get("gy:comparejob:" + id)
+	if err != nil {
+		return versionedJobRecord{}, false
+	}
+	var rec versionedJobRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec, true
+}
+
+func TestWriteThroughUpdatesRedisAfterSQL(t *testing.T) {
+	s, db, mr := newTestWriteThrough(t)
+	if err := s.Create(&domain.CompareJob{ID: "j1", Status: domain.CompareJobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, ok := cachedJob(t, mr, "j1"); !ok || rec.Version != 1 {
+		t.Fatalf("after create: cached=%v version=%d", ok, rec.Version)
+	}
+
+	_, _, err := s.Update("j1", func(j *domain.CompareJob) {
+		if rec, _ := cachedJob(t, mr, "j1"); rec.Error != "" {
+			t.Errorf("redis changed before the sql write")
+		}
+		j.Error = "x"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := cachedJob(t, mr, "j1"); rec.Error != "x" || rec.Version != 2 {
+		t.Fatalf("after update: error=%q version=%d", rec.Error, rec.Version)
+	}
+
+	// A failing SQL write must leave the cached copy alone.
+	if _, err := db.Exec(`CREATE TRIGGER fail_update BEFORE UPDATE ON compare_jobs BEGIN SELECT RAISE(ABORT, 'boom'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Update("j1", func(j *domain.CompareJob) { j.Error = "y" }); err == nil {
+		t.Fatal("update succeeded with a failing sql write")
+	}
+	if rec, _ := cachedJob(t, mr, "j1"); rec.Error != "x" || rec.Version != 2 {
+		t.Fatalf("redis changed although sql failed: error=%q version=%d", rec.Error, rec.Version)
+	}
+	if got, _, _ := s.Get("j1"); got.Error != "x" {
+		t.Fatalf("get: error=%q", got.Error)
+	}
+}
+
+func TestWriteThroughRefillsFromSQL(t *testing.T) {
+	s, _, mr := newTestWriteThrough(t)
+	if err := s.Create(&domain.CompareJob{ID: "j1", Status: domain.CompareJobStatusProcessing, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Update("j1", func(j *domain.CompareJob) { j.Error = "x" }); err != nil {
+		t.Fatal(err)
+	}
+	mr.Del("gy:comparejob:j1") // the Redis copy expired
+
+	got, ok, err := s.Get("j1")
+	if err != nil || !ok || got.Error != "x" {
+		t.Fatalf("get: job=%+v ok=%v err=%v", got, ok, err)
+	}
+	if rec, ok := cachedJob(t, mr, "j1"); !ok || rec.Version != 2 {
+		t.Fatalf("cache not refilled at the sql version: cached=%v version=%d", ok, rec.Version)
+	}
+
+	// An older version never replaces a newer cached copy.
+	if _, written, err := s.cache.putVersioned(t.Context(), &domain.CompareJob{ID: "j1", Error: "stale"}, 1); err != nil || written {
+		t.Fatalf("stale put: written=%v err=%v", written, err)
+	}
+	if rec, _ := cachedJob(t, mr, "j1"); rec.Error != "x" {
+		t.Fatalf("stale copy cached: %q", rec.Error)
+	}
+}
@@ -25,11 +25,30 @@ type CompareJobStore interface {
 	Create(job *domain.CompareJob) error
 	Get(id string) (*domain.CompareJob, bool, error)
 	Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error)
+	// List returns one page of jobs matching q, newest first (ErrInvalidCursor for a bad cursor).
+	List(q JobQuery) (JobPage, error)
+}
+
+// Transition moves job id to status to (see domain.CompareJob.Transition) and, if allowed, applies
+// fn for the fields that go with the move, all in one Update. A rejected move changes nothing and
+// returns a *domain.TransitionError.
+func Transition(st CompareJobStore, id string, to domain.CompareJobStatus, actor, reason string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
+	var terr error
+	j, ok, err := st.Update(id, func(j *domain.CompareJob) {
+		if terr = j.Transition(to, actor, reason); terr == nil && fn != nil {
+			fn(j)
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return j, ok, terr
 }
 
 type InMemoryCompareJobStore struct {
 	mu   sync.Mutex
 	jobs map[string]*domain.CompareJob
+	hub  jobHub
 }
 
 func NewInMemoryCompareJobStore() *InMemoryCompareJobStore {
@@ -39,7 +58,7 @@ func NewInMemoryCompareJobStore() *InMemoryCompareJobStore {
 func (s *InMemoryCompareJobStore) Create(job *domain.CompareJob) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.jobs[job.ID] = job
+	s.jobs[job.ID] = job.Clone()
 	return nil
 }
 
@@ -51,8 +70,7 @@ func (s *InMemoryCompareJobStore) Get(id string) (*domain.CompareJob, bool, erro
 		return nil, false, nil
 	}
 	// Return a copy to avoid accidental mutation/data races outside the lock.
-	cp := *j
-	return &cp, true, nil
+	return j.Clone(), true, nil
 }
 
 func (s *InMemoryCompareJobStore) Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
@@ -63,31 +81,54 @@ func (s *InMemoryCompareJobStore) Update(id string, fn func(j *domain.CompareJob
 		return nil, false, nil
 	}
 	fn(j)
+	s.hub.publish(j)
 	// Return a copy to avoid callers mutating shared state outside the lock.
-	cp := *j
-	return &cp, true, nil
+	return j.Clone(), true, nil
 }
 
 type compareJobRecord struct {
 	ID        string                  `json:"id"`
 	Status    domain.CompareJobStatus `json:"status"`
 	CreatedAt time.Time               `json:"createdAt"`
+	Mode      domain.CompareMode      `json:"mode,omitempty"`
+
+	File1Path   string `json:"file1Path"`
+	File2Path   string `json:"file2Path"`
+	File1OSSKey string `json:"file1OssKey"`
+	File2OSSKey string `json:"file2OssKey"`
+	File1Name   string `json:"file1Name"`
+	File2Name   string `json:"file2Name"`
+	BaseOSSKey  string `json:"baseOssKey,omitempty"`
+	BaseName    string `json:"baseName,omitempty"`
+	MergePrec   string `json:"mergePrecedence,omitempty"`
+
+	Lane   domain.CompareLane `json:"lane,omitempty"`
+	Tenant string             `json:"tenant,omitempty"`
+
+	OwnerID string                   `json:"ownerId,omitempty"`
+	Events  []domain.CompareJobEvent `json:"events,omitempty"`
+
+	Progress *domain.CompareJobProgress `json:"progress,omitempty"`
 
-	File1Path    string `json:"file1Path"`
-	File2Path    string `json:"file2Path"`
-	File1OSSKey  string `json:"file1OssKey"`
-	File2OSSKey  string `json:"file2OssKey"`
-	File1Name    string `json:"file1Name"`
-	File2Name    string `json:"file2Name"`
 	ResultPath   string `json:"resultPath"`
 	ResultOSSKey string `json:"resultOssKey"`
 
+	Coupon string                  `json:"coupon,omitempty"`
+	Stats  *domain.CompareJobStats `json:"stats,omitempty"`
+	Price  *domain.PriceBreakdown  `json:"price,omitempty"`
+
 	AmountYuan  float64    `json:"amountYuan"`
 	CodeURL     string     `json:"codeUrl"`
 	Paid        bool       `json:"paid"`
 	PaidAt      *time.Time `json:"paidAt,omitempty"`
+	PaidVia     string     `json:"paidVia,omitempty"`
 	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
 
+	Refunds []domain.CompareJobRefund `json:"refunds,omitempty"`
+
+	CallbackURL     string                  `json:"callbackUrl,omitempty"`
+	WebhookAttempts []domain.WebhookAttempt `json:"webhookAttempts,omitempty"`
+
 	Error string `json:"error,omitempty"`
 }
 
@@ -99,42 +140,75 @@ func recordFromJob(j *domain.CompareJob) compareJobRecord {
 		ID:           j.ID,
 		Status:       j.Status,
 		CreatedAt:    j.CreatedAt,
+		Mode:         j.Mode,
 		File1Path:    j.File1Path,
 		File2Path:    j.File2Path,
 		File1OSSKey:  j.File1OSSKey,
 		File2OSSKey:  j.File2OSSKey,
 		File1Name:    j.File1Name,
 		File2Name:    j.File2Name,
+		BaseOSSKey:   j.BaseOSSKey,
+		BaseName:     j.BaseName,
+		MergePrec:    j.MergePrecedence,
+		Lane:         j.Lane,
+		Tenant:       j.Tenant,
+		OwnerID:      j.OwnerID,
+		Events:       j.Events,
+		Progress:     j.Progress,
 		ResultPath:   j.ResultPath,
 		ResultOSSKey: j.ResultOSSKey,
+		Coupon:       j.Coupon,
+		Stats:        j.Stats,
+		Price:        j.Price,
 		AmountYuan:   j.AmountYuan,
 		CodeURL:      j.CodeURL,
 		Paid:         j.Paid,
 		PaidAt:       j.PaidAt,
+		PaidVia:      j.PaidVia,
 		CancelledAt:  j.CancelledAt,
+		Refunds:      j.Refunds,
 		Error:        j.Error,
+
+		CallbackURL:     j.CallbackURL,
+		WebhookAttempts: j.WebhookAttempts,
 	}
 }
 
 func jobFromRecord(r compareJobRecord) *domain.CompareJob {
 	return &domain.CompareJob{
-		ID:           r.ID,
-		Status:       r.Status,
-		CreatedAt:    r.CreatedAt,
-		File1Path:    r.File1Path,
-		File2Path:    r.File2Path,
-		File1OSSKey:  r.File1OSSKey,
-		File2OSSKey:  r.File2OSSKey,
-		File1Name:    r.File1Name,
-		File2Name:    r.File2Name,
-		ResultPath:   r.ResultPath,
-		ResultOSSKey: r.ResultOSSKey,
-		AmountYuan:   r.AmountYuan,
-		CodeURL:      r.CodeURL,
-		Paid:         r.Paid,
-		PaidAt:       r.PaidAt,
-		CancelledAt:  r.CancelledAt,
-		Error:        r.Error,
+		ID:              r.ID,
+		Status:          r.Status,
+		CreatedAt:       r.CreatedAt,
+		Mode:            r.Mode,
+		File1Path:       r.File1Path,
+		File2Path:       r.File2Path,
+		File1OSSKey:     r.File1OSSKey,
+		File2OSSKey:     r.File2OSSKey,
+		File1Name:       r.File1Name,
+		File2Name:       r.File2Name,
+		BaseOSSKey:      r.BaseOSSKey,
+		BaseName:        r.BaseName,
+		MergePrecedence: r.MergePrec,
+		Lane:            r.Lane,
+		Tenant:          r.Tenant,
+		OwnerID:         r.OwnerID,
+		Events:          r.Events,
+		Progress:        r.Progress,
+		ResultPath:      r.ResultPath,
+		ResultOSSKey:    r.ResultOSSKey,
+		Coupon:          r.Coupon,
+		Stats:           r.Stats,
+		Price:           r.Price,
+		AmountYuan:      r.AmountYuan,
+		CodeURL:         r.CodeURL,
+		Paid:            r.Paid,
+		PaidAt:          r.PaidAt,
+		PaidVia:         r.PaidVia,
+		CancelledAt:     r.CancelledAt,
+		Refunds:         r.Refunds,
+		CallbackURL:     r.CallbackURL,
+		WebhookAttempts: r.WebhookAttempts,
+		Error:           r.Error,
 	}
 }
 
@@ -142,6 +216,7 @@ type RedisCompareJobStore struct {
 	rdb       *redis.Client
 	keyPrefix string
 	ttl       time.Duration
+	events    redisJobEvents
 }
 
 func readRedisDB() int {
@@ -209,7 +284,15 @@ func (s *RedisCompareJobStore) Create(job *domain.CompareJob) error {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	return s.rdb.SetNX(ctx, s.key(job.ID), b, s.ttl).Err()
+	created, err := s.rdb.SetNX(ctx, s.key(job.ID), b, s.ttl).Result()
+	if err != nil || !created {
+		return err
+	}
+	_, err = s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.addToIndexes(ctx, pipe, nil, job)
+		return nil
+	})
+	return err
 }
 
 func (s *RedisCompareJobStore) Get(id string) (*domain.CompareJob, bool, error) {
@@ -246,6 +329,7 @@ func (s *RedisCompareJobStore) Update(id string, fn func(j *domain.CompareJob))
 
 	var out *domain.CompareJob
 	var ok bool
+	var payload []byte
 
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
@@ -265,6 +349,7 @@ func (s *RedisCompareJobStore) Update(id string, fn func(j *domain.CompareJob))
 			if err := json.Unmarshal([]byte(val), &rec); err != nil {
 				return err
 			}
+			prev := jobFromRecord(rec)
 			j := jobFromRecord(rec)
 			fn(j)
 			out = j
@@ -274,14 +359,19 @@ func (s *RedisCompareJobStore) Update(id string, fn func(j *domain.CompareJob))
 			if err != nil {
 				return err
 			}
+			payload = nb
 			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 				pipe.Set(ctx, key, nb, s.ttl)
+				s.addToIndexes(ctx, pipe, prev, j)
 				return nil
 			})
 			return err
 		}, key)
 
 		if err == nil {
+			if ok {
+				s.publish(ctx, id, payload)
+			}
 			return out, ok, nil
 		}
 		if errors.Is(err, redis.TxFailedErr) {
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+)
+
+// historyJob is a processing job whose Events has spare capacity, so an append on a shallow copy
+// would write into the stored job's array.
+func historyJob(id string) *domain.CompareJob {
+	job := &domain.CompareJob{ID: id, OwnerID: "u1", CreatedAt: time.Now(), Events: make([]domain.CompareJobEvent, 0, 64)}
+	_ = job.Transition(domain.CompareJobStatusProcessing, domain.ActorAPI, "")
+	return job
+}
+
+func appendEvent(j *domain.CompareJob, reason string) {
+	j.Events = append(j.Events, domain.CompareJobEvent{From: j.Status, To: j.Status, Actor: "test", Reason: reason, At: time.Now()})
+}
+
+func TestInMemoryCompareJobStoreReturnsCopies(t *testing.T) {
+	s := NewInMemoryCompareJobStore()
+	job := historyJob("job_1")
+	job.Progress = &domain.CompareJobProgress{Percent: 10}
+	if err := s.Create(job); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w1, _ := s.Watch(ctx, "job_1")
+	w2, _ := s.Watch(ctx, "job_1")
+
+	// The caller's job and everything read back are the caller's own.
+	appendEvent(job, "after create")
+	got, _, _ := s.Get("job_1")
+	appendEvent(got, "on get")
+	got.Progress.Percent = 50
+	page, _ := s.List(JobQuery{})
+	appendEvent(page.Jobs[0], "on list")
+	updated, _, _ := s.Update("job_1", func(j *domain.CompareJob) { appendEvent(j, "update") })
+	appendEvent(updated, "on update result")
+	updated.Events[0].Reason = "rewritten"
+	seen1, seen2 := nextUpdate(t, w1), nextUpdate(t, w2)
+	appendEvent(seen1, "on watch")
+	seen1.Events[1].Reason = "rewritten"
+
+	got, _, _ = s.Get("job_1")
+	if len(got.Events) != 2 || got.Events[0].Reason != "" || got.Events[1].Reason != "update" || got.Progress.Percent != 10 {
+		t.Fatalf("stored job changed through a copy: events %+v, progress %+v", got.Events, got.Progress)
+	}
+	if len(seen2.Events) != 2 || seen2.Events[1].Reason != "update" {
+		t.Fatalf("one watcher's change reached another: %+v", seen2.Events)
+	}
+}
+
+// Run with -race: copies handed out by Get/List/Update/Watch are read and appended to while
+// Updates append to the stored history.
+func TestInMemoryCompareJobStoreConcurrentHistory(t *testing.T) {
+	s := NewInMemoryCompareJobStore()
+	if err := s.Create(historyJob("job_1")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, _ := s.Watch(ctx, "job_1")
+
+	const writers, rounds = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				j, _, err := s.Update("job_1", func(j *domain.CompareJob) { appendEvent(j, fmt.Sprintf("w%d-%d", w, i)) })
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				appendEvent(j, "caller")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				j, _, _ := s.Get("job_1")
+				appendEvent(j, "reader")
+				page, _ := s.List(JobQuery{Owner: "u1"})
+				for _, j := range page.Jobs {
+					appendEvent(j, "lister")
+					_ = j.Events[len(j.Events)-1]
+				}
+			}
+		}()
+	}
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		for {
+			select {
+			case j := <-updates:
+				appendEvent(j, "watcher")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	cancel()
+	<-watched
+
+	j, _, _ := s.Get("job_1")
+	if n := len(j.Events); n != 1+writers*rounds {
+		t.Fatalf("%d events, want %d", n, 1+writers*rounds)
+	}
+	for _, ev := range j.Events[1:] {
+		if ev.Actor != "test" || ev.Reason == "caller" || ev.Reason == "reader" || ev.Reason == "lister" || ev.Reason == "watcher" {
+			t.Fatalf("history holds %+v written to a copy", ev)
+		}
+	}
+}
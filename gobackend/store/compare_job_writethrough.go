@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"gobackend/domain"
+)
+
+// WriteThroughCompareJobStore makes SQL the durable record and keeps Redis as the hot cache:
+// writes go to SQL first and are then copied to Redis; reads hit Redis and fall back to SQL (and
+// refill the cache) after the Redis copy expired. List always reads SQL, which also covers jobs
+// older than the Redis TTL. Watch stays on Redis pub/sub.
+type WriteThroughCompareJobStore struct {
+	cache   *RedisCompareJobStore
+	durable *SQLCompareJobStore
+}
+
+func NewWriteThroughCompareJobStore(cache *RedisCompareJobStore, durable *SQLCompareJobStore) *WriteThroughCompareJobStore {
+	return &WriteThroughCompareJobStore{cache: cache, durable: durable}
+}
+
+func (s *WriteThroughCompareJobStore) Create(job *domain.CompareJob) error {
+	if job == nil || strings.TrimSpace(job.ID) == "" {
+		return s.durable.Create(job)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	created, err := s.durable.insert(ctx, job)
+	if err != nil || !created {
+		return err
+	}
+	s.fill(ctx, job, 1)
+	return nil
+}
+
+func (s *WriteThroughCompareJobStore) Get(id string) (*domain.CompareJob, bool, error) {
+	j, ok, err := s.cache.Get(id)
+	if err == nil && ok {
+		return j, true, nil
+	}
+	if err != nil {
+		log.Printf("compare job cache: get failed job=%s, reading sql: %v", id, err)
+	}
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	j, version, ok, err := s.durable.get(ctx, id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	s.fill(ctx, j, version)
+	return j, true, nil
+}
+
+func (s *WriteThroughCompareJobStore) Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
+	j, version, ok, err := s.durable.update(id, fn)
+	if err != nil || !ok {
+		return j, ok, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if payload, written := s.fill(ctx, j, version); written {
+		s.cache.publish(ctx, j.ID, payload)
+	}
+	return j, true, nil
+}
+
+func (s *WriteThroughCompareJobStore) List(q JobQuery) (JobPage, error) {
+	return s.durable.List(q)
+}
+
+func (s *WriteThroughCompareJobStore) Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error) {
+	return s.cache.Watch(ctx, id)
+}
+
+// fill copies version of j into Redis. A failed write drops the cached copy so readers fall back
+// to SQL instead of seeing a stale job.
+func (s *WriteThroughCompareJobStore) fill(ctx context.Context, j *domain.CompareJob, version int64) ([]byte, bool) {
+	payload, written, err := s.cache.putVersioned(ctx, j, version)
+	if err != nil {
+		log.Printf("compare job cache: write failed job=%s: %v", j.ID, err)
+		_ = s.cache.rdb.Del(ctx, s.cache.key(j.ID)).Err()
+		return nil, false
+	}
+	return payload, written
+}
+
+// versionedJobRecord is the cached form used by write-through: the SQL row version rides along so
+// a slow writer can't replace a newer copy with an older one.
+type versionedJobRecord struct {
+	compareJobRecord
+	Version int64 `json:"version"`
+}
+
+// putVersionedScript sets KEYS[1] unless the cached copy already has version >= ARGV[2].
+var putVersionedScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+	local ok, rec = pcall(cjson.decode, cur)
+	if ok and type(rec) == 'table' and tonumber(rec['version'] or 0) >= tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+return 1
+`)
+
+// putVersioned caches j at version (no list indexes: write-through lists from SQL) and reports
+// whether it was written.
+func (s *RedisCompareJobStore) putVersioned(ctx context.Context, j *domain.CompareJob, version int64) ([]byte, bool, error) {
+	b, err := json.Marshal(versionedJobRecord{compareJobRecord: recordFromJob(j), Version: version})
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := putVersionedScript.Run(ctx, s.rdb, []string{s.key(j.ID)},
+		b, strconv.FormatInt(version, 10), strconv.FormatInt(s.ttl.Milliseconds(), 10)).Int()
+	if err != nil {
+		return nil, false, err
+	}
+	return b, n == 1, nil
+}
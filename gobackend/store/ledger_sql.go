@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+)
+
+// SQLLedgerStore keeps the wallet ledger in ledger_accounts / ledger_journals / ledger_postings.
+// Each Post is one transaction: the journal row doubles as the idempotency record, and guarded
+// accounts are debited with a conditional UPDATE so concurrent holds can't overdraw.
+type SQLLedgerStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLLedgerStore migrates the schema and returns the store (driver as in NewSQLCompareJobStore).
+func NewSQLLedgerStore(db *sql.DB, driver string) (*SQLLedgerStore, error) {
+	d, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := migrateSQL(ctx, db, d); err != nil {
+		return nil, err
+	}
+	log.Printf("ledger store: sql enabled driver=%s", driver)
+	return &SQLLedgerStore{db: db, dialect: d}, nil
+}
+
+func (s *SQLLedgerStore) Post(j *domain.LedgerJournal) (*domain.LedgerJournal, bool, error) {
+	if err := j.Validate(); err != nil {
+		return nil, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	createdAt := j.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	nowMs := time.Now().UnixMilli()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO ledger_journals (id, kind, user_id, memo, created_at_ms)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`),
+		j.ID, j.Kind, j.UserID, j.Memo, createdAt.UnixMilli())
+	if err != nil {
+		return nil, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, false, err
+	} else if n == 0 {
+		_ = tx.Rollback()
+		prev, ok, err := s.getJournal(ctx, j.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, errors.New("ledger journal vanished")
+		}
+		return prev, false, nil
+	}
+
+	for _, p := range sortedPostingTotals(j) {
+		if p.AmountFen == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO ledger_accounts (id, balance_fen, updated_at_ms)
+			VALUES (?, 0, ?) ON CONFLICT (id) DO NOTHING`), p.Account, nowMs); err != nil {
+			return nil, false, err
+		}
+		q := `UPDATE ledger_accounts SET balance_fen = balance_fen + ?, updated_at_ms = ? WHERE id = ?`
+		args := []any{p.AmountFen, nowMs, p.Account}
+		if domain.LedgerAccountGuarded(p.Account) && p.AmountFen < 0 {
+			q += ` AND balance_fen + ? >= 0`
+			args = append(args, p.AmountFen)
+		}
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(q), args...)
+		if err != nil {
+			return nil, false, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, false, err
+		} else if n == 0 {
+			return nil, false, domain.ErrInsufficientFunds
+		}
+	}
+	for i, p := range j.Postings {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO ledger_postings (journal_id, seq, account_id, amount_fen)
+			VALUES (?, ?, ?, ?)`), j.ID, i, p.Account, p.AmountFen); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	stored := copyJournal(j)
+	stored.CreatedAt = time.UnixMilli(createdAt.UnixMilli())
+	return stored, true, nil
+}
+
+func (s *SQLLedgerStore) GetJournal(id string) (*domain.LedgerJournal, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.getJournal(ctx, id)
+}
+
+func (s *SQLLedgerStore) getJournal(ctx context.Context, id string) (*domain.LedgerJournal, bool, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT j.id, j.kind, j.user_id, j.memo, j.created_at_ms, p.account_id, p.amount_fen
+		FROM ledger_journals j JOIN ledger_postings p ON p.journal_id = j.id
+		WHERE j.id = ? ORDER BY p.seq`), id)
+	if err != nil {
+		return nil, false, err
+	}
+	out, err := scanJournals(rows)
+	if err != nil || len(out) == 0 {
+		return nil, false, err
+	}
+	return out[0], true, nil
+}
+
+func (s *SQLLedgerStore) Balance(account string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var n int64
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT balance_fen FROM ledger_accounts WHERE id = ?`), account).Scan(&n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *SQLLedgerStore) UserJournals(userID string, limit int) ([]*domain.LedgerJournal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT j.id, j.kind, j.user_id, j.memo, j.created_at_ms, p.account_id, p.amount_fen
+		FROM (SELECT id, kind, user_id, memo, created_at_ms FROM ledger_journals
+			WHERE user_id = ? ORDER BY created_at_ms DESC, id DESC LIMIT ?) j
+		JOIN ledger_postings p ON p.journal_id = j.id
+		ORDER BY j.created_at_ms DESC, j.id DESC, p.seq`), userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanJournals(rows)
+}
+
+// scanJournals folds journal x posting rows (grouped by journal) back into journals.
+func scanJournals(rows *sql.Rows) ([]*domain.LedgerJournal, error) {
+	defer rows.Close()
+	var out []*domain.LedgerJournal
+	for rows.Next() {
+		var (
+			j         domain.LedgerJournal
+			createdMs int64
+			p         domain.LedgerPosting
+		)
+		if err := rows.Scan(&j.ID, &j.Kind, &j.UserID, &j.Memo, &createdMs, &p.Account, &p.AmountFen); err != nil {
+			return nil, err
+		}
+		if n := len(out); n == 0 || out[n-1].ID != j.ID {
+			j.CreatedAt = time.UnixMilli(createdMs)
+			out = append(out, &j)
+		}
+		last := out[len(out)-1]
+		last.Postings = append(last.Postings, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLLedgerStore) CreateTopUp(t *domain.WalletTopUp) error {
+	if t == nil || strings.TrimSpace(t.ID) == "" {
+		return errors.New("topup/id 为空")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO wallet_topups (id, user_id, amount_fen, code_url, created_at_ms)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`),
+		t.ID, t.UserID, t.AmountFen, t.CodeURL, t.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *SQLLedgerStore) GetTopUp(id string) (*domain.WalletTopUp, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var (
+		t         domain.WalletTopUp
+		createdMs int64
+	)
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT id, user_id, amount_fen, code_url, created_at_ms FROM wallet_topups WHERE id = ?`), id).
+		Scan(&t.ID, &t.UserID, &t.AmountFen, &t.CodeURL, &createdMs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	t.CreatedAt = time.UnixMilli(createdMs)
+	return &t, true, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gobackend/domain"
+)
+
+// LedgerStore keeps the wallet ledger (see domain.LedgerJournal) and pending top-up orders.
+type LedgerStore interface {
+	// Post applies a balanced journal atomically. It is idempotent on j.ID: if the ID exists
+	// nothing changes and the stored journal is returned with applied=false. A guarded (user)
+	// account that would go negative fails the whole journal with domain.ErrInsufficientFunds.
+	Post(j *domain.LedgerJournal) (stored *domain.LedgerJournal, applied bool, err error)
+	GetJournal(id string) (*domain.LedgerJournal, bool, error)
+	Balance(account string) (int64, error)
+	// UserJournals returns the user's journals, newest first (at most limit).
+	UserJournals(userID string, limit int) ([]*domain.LedgerJournal, error)
+
+	CreateTopUp(t *domain.WalletTopUp) error
+	GetTopUp(id string) (*domain.WalletTopUp, bool, error)
+}
+
+// sortedPostingTotals sums postings per account, in account order (a fixed lock order for SQL).
+func sortedPostingTotals(j *domain.LedgerJournal) []domain.LedgerPosting {
+	totals := make(map[string]int64)
+	for _, p := range j.Postings {
+		totals[p.Account] += p.AmountFen
+	}
+	out := make([]domain.LedgerPosting, 0, len(totals))
+	for a, n := range totals {
+		out = append(out, domain.LedgerPosting{Account: a, AmountFen: n})
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].Account < out[k].Account })
+	return out
+}
+
+func copyJournal(j *domain.LedgerJournal) *domain.LedgerJournal {
+	cp := *j
+	cp.Postings = append([]domain.LedgerPosting(nil), j.Postings...)
+	return &cp
+}
+
+type InMemoryLedgerStore struct {
+	mu       sync.Mutex
+	journals map[string]*domain.LedgerJournal
+	order    []*domain.LedgerJournal
+	balances map[string]int64
+	topups   map[string]*domain.WalletTopUp
+}
+
+func NewInMemoryLedgerStore() *InMemoryLedgerStore {
+	return &InMemoryLedgerStore{
+		journals: make(map[string]*domain.LedgerJournal),
+		balances: make(map[string]int64),
+		topups:   make(map[string]*domain.WalletTopUp),
+	}
+}
+
+func (s *InMemoryLedgerStore) Post(j *domain.LedgerJournal) (*domain.LedgerJournal, bool, error) {
+	if err := j.Validate(); err != nil {
+		return nil, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.journals[j.ID]; ok {
+		return copyJournal(prev), false, nil
+	}
+	totals := sortedPostingTotals(j)
+	for _, p := range totals {
+		if domain.LedgerAccountGuarded(p.Account) && s.balances[p.Account]+p.AmountFen < 0 {
+			return nil, false, domain.ErrInsufficientFunds
+		}
+	}
+	for _, p := range totals {
+		s.balances[p.Account] += p.AmountFen
+	}
+	stored := copyJournal(j)
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now()
+	}
+	s.journals[j.ID] = stored
+	s.order = append(s.order, stored)
+	return copyJournal(stored), true, nil
+}
+
+func (s *InMemoryLedgerStore) GetJournal(id string) (*domain.LedgerJournal, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.journals[id]
+	if !ok {
+		return nil, false, nil
+	}
+	return copyJournal(j), true, nil
+}
+
+func (s *InMemoryLedgerStore) Balance(account string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.balances[account], nil
+}
+
+func (s *InMemoryLedgerStore) UserJournals(userID string, limit int) ([]*domain.LedgerJournal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*domain.LedgerJournal
+	for i := len(s.order) - 1; i >= 0 && len(out) < limit; i-- {
+		if s.order[i].UserID == userID {
+			out = append(out, copyJournal(s.order[i]))
+		}
+	}
+	return out, nil
+}
+
+func (s *InMemoryLedgerStore) CreateTopUp(t *domain.WalletTopUp) error {
+	if t == nil || strings.TrimSpace(t.ID) == "" {
+		return errors.New("topup/id 为空")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topups[t.ID]; !ok {
+		cp := *t
+		s.topups[t.ID] = &cp
+	}
+	return nil
+}
+
+func (s *InMemoryLedgerStore) GetTopUp(id string) (*domain.WalletTopUp, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topups[id]
+	if !ok {
+		return nil, false, nil
+	}
+	cp := *t
+	return &cp, true, nil
+}
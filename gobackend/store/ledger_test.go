@@ -0,0 +1,166 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"gobackend/domain"
+)
+
+// testLedgers returns each LedgerStore implementation, the SQL one on SQLite.
+func testLedgers(t *testing.T) map[string]LedgerStore {
+	t.Helper()
+	sq, err := NewSQLLedgerStore(openTestSQL(t), "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]LedgerStore{"memory": NewInMemoryLedgerStore(), "sql": sq}
+}
+
+func transfer(id, from, to string, fen int64) *domain.LedgerJournal {
+	return &domain.LedgerJournal{
+		ID:     id,
+		Kind:   domain.LedgerKindCharge,
+		UserID: "u1",
+		Postings: []domain.LedgerPosting{
+			{Account: from, AmountFen: -fen},
+			{Account: to, AmountFen: fen},
+		},
+	}
+}
+
+func TestLedgerPostIdempotent(t *testing.T) {
+	for name, l := range testLedgers(t) {
+		t.Run(name, func(t *testing.T) {
+			user := domain.WalletAccount("u1")
+			for i := 0; i < 3; i++ {
+				_, applied, err := l.Post(transfer("topup:1", domain.LedgerAccountWeChat, user, 500))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if applied != (i == 0) {
+					t.Fatalf("post %d: applied=%v", i+1, applied)
+				}
+			}
+			// Same ID, different amount: the stored journal wins.
+			stored, applied, err := l.Post(transfer("topup:1", domain.LedgerAccountWeChat, user, 900))
+			if err != nil || applied || stored.AmountFor(user) != 500 {
+				t.Fatalf("conflicting repost: applied=%v amount=%d err=%v", applied, stored.AmountFor(user), err)
+			}
+			if bal, _ := l.Balance(user); bal != 500 {
+				t.Fatalf("balance = %d, want 500", bal)
+			}
+		})
+	}
+}
+
+func TestLedgerInsufficientFundsPostsNothing(t *testing.T) {
+	for name, l := range testLedgers(t) {
+		t.Run(name, func(t *testing.T) {
+			user := domain.WalletAccount("u1")
+			if _, _, err := l.Post(transfer("topup:1", domain.LedgerAccountWeChat, user, 100)); err != nil {
+				t.Fatal(err)
+			}
+			// The revenue credit sorts before the user debit, so a partial write would be visible.
+			j := &domain.LedgerJournal{
+				ID:     "charge:job:1",
+				Kind:   domain.LedgerKindCharge,
+				UserID: "u1",
+				Postings: []domain.LedgerPosting{
+					{Account: domain.LedgerAccountRevenue, AmountFen: 150},
+					{Account: user, AmountFen: -150},
+				},
+			}
+			if _, _, err := l.Post(j); !errors.Is(err, domain.ErrInsufficientFunds) {
+				t.Fatalf("err = %v, want ErrInsufficientFunds", err)
+			}
+			if _, ok, _ := l.GetJournal(j.ID); ok {
+				t.Fatal("rejected journal was stored")
+			}
+			if bal, _ := l.Balance(user); bal != 100 {
+				t.Fatalf("user balance = %d, want 100", bal)
+			}
+			if rev, _ := l.Balance(domain.LedgerAccountRevenue); rev != 0 {
+				t.Fatalf("revenue = %d, want 0", rev)
+			}
+			if js, _ := l.UserJournals("u1", 10); len(js) != 1 {
+				t.Fatalf("journals = %d, want 1", len(js))
+			}
+			// The ID is still free once the money is there.
+			if _, _, err := l.Post(transfer("topup:2", domain.LedgerAccountWeChat, user, 50)); err != nil {
+				t.Fatal(err)
+			}
+			if _, applied, err := l.Post(j); err != nil || !applied {
+				t.Fatalf("retry: applied=%v err=%v", applied, err)
+			}
+		})
+	}
+}
+
+func TestLedgerRejectsUnbalancedJournal(t *testing.T) {
+	for name, l := range testLedgers(t) {
+		t.Run(name, func(t *testing.T) {
+			j := transfer("bad", domain.LedgerAccountWeChat, domain.WalletAccount("u1"), 100)
+			j.Postings[1].AmountFen = 99
+			if _, _, err := l.Post(j); !errors.Is(err, domain.ErrUnbalancedJournal) {
+				t.Fatalf("err = %v, want ErrUnbalancedJournal", err)
+			}
+			if _, ok, _ := l.GetJournal("bad"); ok {
+				t.Fatal("unbalanced journal was stored")
+			}
+		})
+	}
+}
+
+func TestSQLLedgerConcurrentDebits(t *testing.T) {
+	db := openTestSQL(t)
+	l, err := NewSQLLedgerStore(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := domain.WalletAccount("u1")
+	if _, _, err := l.Post(transfer("topup:1", domain.LedgerAccountWeChat, user, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		posted int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, err := l.Post(transfer(fmt.Sprintf("charge:%d", i), user, domain.LedgerAccountRevenue, 100))
+			if err != nil && !errors.Is(err, domain.ErrInsufficientFunds) {
+				t.Error(err)
+			}
+			if err == nil {
+				mu.Lock()
+				posted++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	bal, _ := l.Balance(user)
+	if posted != 10 || bal != 0 {
+		t.Fatalf("balance = %d after %d charges", bal, posted)
+	}
+
+	// Every stored journal balances, and so does the whole ledger.
+	var unbalanced int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM (SELECT journal_id FROM ledger_postings
+		GROUP BY journal_id HAVING SUM(amount_fen) <> 0) AS x`).Scan(&unbalanced); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	if err := db.QueryRow(`SELECT COALESCE(SUM(balance_fen), 0) FROM ledger_accounts`).Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if unbalanced != 0 || total != 0 {
+		t.Fatalf("unbalanced journals=%d, sum of balances=%d", unbalanced, total)
+	}
+}
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+)
+
+// sqlDialect papers over the differences between PostgreSQL (production) and SQLite (tests, local
+// tooling). Queries are written with "?" placeholders and rebound for Postgres.
+type sqlDialect struct {
+	postgres bool
+}
+
+func dialectFor(driver string) (sqlDialect, error) {
+	switch strings.ToLower(strings.TrimSpace(driver)) {
+	case "pgx", "postgres", "postgresql":
+		return sqlDialect{postgres: true}, nil
+	case "sqlite", "sqlite3":
+		return sqlDialect{}, nil
+	}
+	return sqlDialect{}, fmt.Errorf("不支持的数据库驱动: %s", driver)
+}
+
+func (d sqlDialect) rebind(q string) string {
+	if !d.postgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(q); i++ {
+		if q[i] == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(q[i])
+	}
+	return b.String()
+}
+
+// OpenSQL opens and pings a database: DATABASE_DRIVER (default "pgx" = PostgreSQL) and
+// DATABASE_URL; DATABASE_MAX_CONNS caps the pool (default 10). The driver must be registered in
+// the binary: only pgx is built in (the sqlite3 driver is linked into tests only).
+func OpenSQL() (*sql.DB, string, error) {
+	driver := strings.TrimSpace(os.Getenv("DATABASE_DRIVER"))
+	if driver == "" {
+		driver = "pgx"
+	}
+	dsn := strings.TrimSpace(os.Getenv("DATABASE_URL"))
+	if dsn == "" {
+		return nil, "", errors.New("DATABASE_URL 为空")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	maxConns := 10
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("DATABASE_MAX_CONNS"))); err == nil && n > 0 {
+		maxConns = n
+	}
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, "", fmt.Errorf("database ping failed: %w", err)
+	}
+	return db, driver, nil
+}
+
+// sqlMigration is one schema step. Versions only grow; never edit a released migration, add a new
+// one instead.
+type sqlMigration struct {
+	version int
+	name    string
+	// stmts run in order; postgres/sqlite variants are picked by stmtsFor.
+	postgres []string
+	sqlite   []string
+}
+
+func (m sqlMigration) stmtsFor(d sqlDialect) []string {
+	if d.postgres {
+		return m.postgres
+	}
+	return m.sqlite
+}
+
+var sqlMigrations = []sqlMigration{
+	{
+		version: 1,
+		name:    "compare_jobs",
+		postgres: []string{
+			`CREATE TABLE compare_jobs (
+				id            TEXT PRIMARY KEY,
+				version       BIGINT NOT NULL,
+				owner_id      TEXT NOT NULL DEFAULT '',
+				status        TEXT NOT NULL,
+				paid          BOOLEAN NOT NULL DEFAULT FALSE,
+				amount_fen    BIGINT NOT NULL DEFAULT 0,
+				created_at_ms BIGINT NOT NULL,
+				updated_at_ms BIGINT NOT NULL,
+				paid_at_ms    BIGINT,
+				data          JSONB NOT NULL
+			)`,
+			`CREATE INDEX compare_jobs_created_idx ON compare_jobs (created_at_ms DESC, id DESC)`,
+			`CREATE INDEX compare_jobs_owner_idx ON compare_jobs (owner_id, created_at_ms DESC, id DESC)`,
+			`CREATE INDEX compare_jobs_status_idx ON compare_jobs (status, created_at_ms DESC, id DESC)`,
+			`CREATE INDEX compare_jobs_paid_at_idx ON compare_jobs (paid_at_ms) WHERE paid_at_ms IS NOT NULL`,
+		},
+		sqlite: []string{
+			`CREATE TABLE compare_jobs (
+				id            TEXT PRIMARY KEY,
+				version       INTEGER NOT NULL,
+				owner_id      TEXT NOT NULL DEFAULT '',
+				status        TEXT NOT NULL,
+				paid          BOOLEAN NOT NULL DEFAULT FALSE,
+				amount_fen    INTEGER NOT NULL DEFAULT 0,
+				created_at_ms INTEGER NOT NULL,
+				updated_at_ms INTEGER NOT NULL,
+				paid_at_ms    INTEGER,
+				data          TEXT NOT NULL
+			)`,
+			`CREATE INDEX compare_jobs_created_idx ON compare_jobs (created_at_ms DESC, id DESC)`,
+			`CREATE INDEX compare_jobs_owner_idx ON compare_jobs (owner_id, created_at_ms DESC, id DESC)`,
+			`CREATE INDEX compare_jobs_status_idx ON compare_jobs (status, created_at_ms DESC, id DESC)`,
+			`CREATE INDEX compare_jobs_paid_at_idx ON compare_jobs (paid_at_ms) WHERE paid_at_ms IS NOT NULL`,
+		},
+	},
+	{version: 2, name: "wallet_ledger", postgres: ledgerSchema, sqlite: ledgerSchema},
+	{version: 3, name: "subscriptions", postgres: subscriptionSchema, sqlite: subscriptionSchema},
+}
+
+// ledgerSchema is shared by both dialects (BIGINT/TEXT mean the same to SQLite).
+var ledgerSchema = []string{
+	`CREATE TABLE ledger_accounts (
+		id            TEXT PRIMARY KEY,
+		balance_fen   BIGINT NOT NULL DEFAULT 0,
+		updated_at_ms BIGINT NOT NULL
+	)`,
+	`CREATE TABLE ledger_journals (
+		id            TEXT PRIMARY KEY,
+		kind          TEXT NOT NULL,
+		user_id       TEXT NOT NULL DEFAULT '',
+		memo          TEXT NOT NULL DEFAULT '',
+		created_at_ms BIGINT NOT NULL
+	)`,
+	`CREATE INDEX ledger_journals_user_idx ON ledger_journals (user_id, created_at_ms DESC, id DESC)`,
+	`CREATE TABLE ledger_postings (
+		journal_id TEXT NOT NULL REFERENCES ledger_journals (id),
+		seq        INTEGER NOT NULL,
+		account_id TEXT NOT NULL,
+		amount_fen BIGINT NOT NULL,
+		PRIMARY KEY (journal_id, seq)
+	)`,
+	`CREATE INDEX ledger_postings_account_idx ON ledger_postings (account_id)`,
+	`CREATE TABLE wallet_topups (
+		id            TEXT PRIMARY KEY,
+		user_id       TEXT NOT NULL,
+		amount_fen    BIGINT NOT NULL,
+		code_url      TEXT NOT NULL DEFAULT '',
+		created_at_ms BIGINT NOT NULL
+	)`,
+}
+
+// subscriptionSchema is shared by both dialects. subscription_usage holds the per-period counters;
+// subscription_usage_jobs records which jobs were counted (one row per job, for idempotency).
+var subscriptionSchema = []string{
+	`CREATE TABLE subscriptions (
+		user_id       TEXT PRIMARY KEY,
+		plan_id       TEXT NOT NULL,
+		started_at_ms BIGINT NOT NULL,
+		expires_at_ms BIGINT NOT NULL,
+		updated_at_ms BIGINT NOT NULL
+	)`,
+	`CREATE TABLE subscription_orders (
+		id            TEXT PRIMARY KEY,
+		user_id       TEXT NOT NULL,
+		plan_id       TEXT NOT NULL,
+		amount_fen    BIGINT NOT NULL,
+		code_url      TEXT NOT NULL DEFAULT '',
+		created_at_ms BIGINT NOT NULL,
+		applied_at_ms BIGINT
+	)`,
+	`CREATE INDEX subscription_orders_user_idx ON subscription_orders (user_id, created_at_ms DESC)`,
+	`CREATE TABLE subscription_usage (
+		user_id         TEXT NOT NULL,
+		period_start_ms BIGINT NOT NULL,
+		job_count       BIGINT NOT NULL DEFAULT 0,
+		row_count       BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, period_start_ms)
+	)`,
+	`CREATE TABLE subscription_usage_jobs (
+		job_id          TEXT PRIMARY KEY,
+		user_id         TEXT NOT NULL,
+		period_start_ms BIGINT NOT NULL,
+		row_count       BIGINT NOT NULL,
+		created_at_ms   BIGINT NOT NULL
+	)`,
+}
+
+// migrateSQL applies pending sqlMigrations in one transaction. On Postgres an advisory lock keeps
+// replicas starting together from racing; every step is recorded in schema_migrations.
+func migrateSQL(ctx context.Context, db *sql.DB, d sqlDialect) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version       INTEGER PRIMARY KEY,
+		name          TEXT NOT NULL,
+		applied_at_ms BIGINT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if d.postgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(7428113)`); err != nil {
+			return err
+		}
+	}
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for _, m := range sqlMigrations {
+		if m.version <= current {
+			continue
+		}
+		for _, stmt := range m.stmtsFor(d) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations (version, name, applied_at_ms) VALUES (?, ?, ?)`),
+			m.version, m.name, time.Now().UnixMilli()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
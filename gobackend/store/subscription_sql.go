@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+)
+
+// SQLSubscriptionStore keeps plans in subscriptions / subscription_orders and quota usage in
+// subscription_usage (+ subscription_usage_jobs). Applying an order and consuming quota are single
+// transactions; the usage counters are bumped with a conditional UPDATE so concurrent jobs can't
+// overrun a quota.
+type SQLSubscriptionStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLSubscriptionStore migrates the schema and returns the store (driver as in NewSQLCompareJobStore).
+func NewSQLSubscriptionStore(db *sql.DB, driver string) (*SQLSubscriptionStore, error) {
+	d, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := migrateSQL(ctx, db, d); err != nil {
+		return nil, err
+	}
+	log.Printf("subscription store: sql enabled driver=%s", driver)
+	return &SQLSubscriptionStore{db: db, dialect: d}, nil
+}
+
+func (s *SQLSubscriptionStore) GetSubscription(userID string) (*domain.Subscription, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.getSubscription(ctx, s.db, userID)
+}
+
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLSubscriptionStore) getSubscription(ctx context.Context, q sqlQueryer, userID string) (*domain.Subscription, bool, error) {
+	var (
+		sub                  domain.Subscription
+		startedMs, expiresMs int64
+	)
+	err := q.QueryRowContext(ctx, s.dialect.rebind(`SELECT user_id, plan_id, started_at_ms, expires_at_ms FROM subscriptions WHERE user_id = ?`), userID).
+		Scan(&sub.UserID, &sub.PlanID, &startedMs, &expiresMs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if sub.PlanID == "" {
+		// Placeholder row written by ApplyOrder to lock the user.
+		return nil, false, nil
+	}
+	sub.StartedAt = time.UnixMilli(startedMs)
+	sub.ExpiresAt = time.UnixMilli(expiresMs)
+	return &sub, true, nil
+}
+
+func (s *SQLSubscriptionStore) CreateOrder(o *domain.SubscriptionOrder) error {
+	if o == nil || strings.TrimSpace(o.ID) == "" {
+		return errors.New("order/id 为空")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO subscription_orders (id, user_id, plan_id, amount_fen, code_url, created_at_ms)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`),
+		o.ID, o.UserID, o.PlanID, o.AmountFen, o.CodeURL, o.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *SQLSubscriptionStore) GetOrder(id string) (*domain.SubscriptionOrder, bool, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.getOrder(ctx, s.db, id)
+}
+
+func (s *SQLSubscriptionStore) getOrder(ctx context.Context, q sqlQueryer, id string) (*domain.SubscriptionOrder, bool, bool, error) {
+	var (
+		o         domain.SubscriptionOrder
+		createdMs int64
+		appliedMs sql.NullInt64
+	)
+	err := q.QueryRowContext(ctx, s.dialect.rebind(`SELECT id, user_id, plan_id, amount_fen, code_url, created_at_ms, applied_at_ms
+		FROM subscription_orders WHERE id = ?`), id).
+		Scan(&o.ID, &o.UserID, &o.PlanID, &o.AmountFen, &o.CodeURL, &createdMs, &appliedMs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, false, nil
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+	o.CreatedAt = time.UnixMilli(createdMs)
+	return &o, appliedMs.Valid, true, nil
+}
+
+func (s *SQLSubscriptionStore) ApplyOrder(id string, now time.Time) (*domain.Subscription, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, _, ok, err := s.getOrder(ctx, tx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, ErrOrderNotFound
+	}
+	nowMs := now.UnixMilli()
+	// Lock the user's row (a placeholder if there is none yet) so concurrent orders of the same
+	// user renew one after the other.
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO subscriptions (user_id, plan_id, started_at_ms, expires_at_ms, updated_at_ms)
+		VALUES (?, '', 0, 0, ?) ON CONFLICT (user_id) DO NOTHING`), o.UserID, nowMs); err != nil {
+		return nil, false, err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE subscriptions SET updated_at_ms = ? WHERE user_id = ?`), nowMs, o.UserID); err != nil {
+		return nil, false, err
+	}
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE subscription_orders SET applied_at_ms = ? WHERE id = ? AND applied_at_ms IS NULL`), nowMs, id)
+	if err != nil {
+		return nil, false, err
+	}
+	cur, _, err := s.getSubscription(ctx, tx, o.UserID)
+	if err != nil {
+		return nil, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, false, err
+	} else if n == 0 {
+		return cur, false, nil
+	}
+	next := domain.RenewSubscription(cur, o.UserID, o.PlanID, time.UnixMilli(nowMs))
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE subscriptions SET plan_id = ?, started_at_ms = ?, expires_at_ms = ?, updated_at_ms = ?
+		WHERE user_id = ?`), next.PlanID, next.StartedAt.UnixMilli(), next.ExpiresAt.UnixMilli(), nowMs, o.UserID); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return next, true, nil
+}
+
+func (s *SQLSubscriptionStore) Usage(userID string, periodStart time.Time) (domain.SubscriptionUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var u domain.SubscriptionUsage
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT job_count, row_count FROM subscription_usage WHERE user_id = ? AND period_start_ms = ?`),
+		userID, periodStart.UnixMilli()).Scan(&u.Jobs, &u.Rows)
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, nil
+	}
+	return u, err
+}
+
+func (s *SQLSubscriptionStore) ConsumeQuota(userID string, periodStart time.Time, jobID string, rows, jobQuota, rowQuota int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	startMs := periodStart.UnixMilli()
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO subscription_usage_jobs (job_id, user_id, period_start_ms, row_count, created_at_ms)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (job_id) DO NOTHING`), jobID, userID, startMs, rows, time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		return true, nil
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO subscription_usage (user_id, period_start_ms, job_count, row_count)
+		VALUES (?, ?, 0, 0) ON CONFLICT (user_id, period_start_ms) DO NOTHING`), userID, startMs); err != nil {
+		return false, err
+	}
+	res, err = tx.ExecContext(ctx, s.dialect.rebind(`UPDATE subscription_usage SET job_count = job_count + 1, row_count = row_count + ?
+		WHERE user_id = ? AND period_start_ms = ? AND (? = 0 OR job_count + 1 <= ?) AND (? = 0 OR row_count + ? <= ?)`),
+		rows, userID, startMs, jobQuota, jobQuota, rowQuota, rows, rowQuota)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+func (s *SQLSubscriptionStore) ReleaseQuota(jobID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		userID  string
+		startMs int64
+		rows    int64
+	)
+	// Deleting first claims the row, so two releases of one job can't both give it back.
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(`DELETE FROM subscription_usage_jobs WHERE job_id = ?
+		RETURNING user_id, period_start_ms, row_count`), jobID).Scan(&userID, &startMs, &rows)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE subscription_usage SET job_count = job_count - 1, row_count = row_count - ?
+		WHERE user_id = ? AND period_start_ms = ?`), rows, userID, startMs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"gobackend/domain"
+)
+
+// SubscriptionStore keeps users' plans, plan purchase orders and per-period quota usage.
+type SubscriptionStore interface {
+	GetSubscription(userID string) (*domain.Subscription, bool, error)
+
+	CreateOrder(o *domain.SubscriptionOrder) error
+	GetOrder(id string) (o *domain.SubscriptionOrder, applied, ok bool, err error)
+	// ApplyOrder renews the order's user with its plan (domain.RenewSubscription) at most once:
+	// applying it again changes nothing and returns the current subscription with applied=false.
+	ApplyOrder(id string, now time.Time) (sub *domain.Subscription, applied bool, err error)
+
+	Usage(userID string, periodStart time.Time) (domain.SubscriptionUsage, error)
+	// ConsumeQuota counts jobID (one job, rows rows) against the user's period if that stays
+	// within jobQuota / rowQuota (0 = unlimited) and reports whether it did. A job that was
+	// already counted returns true without counting it again.
+	ConsumeQuota(userID string, periodStart time.Time, jobID string, rows, jobQuota, rowQuota int64) (bool, error)
+	// ReleaseQuota gives back what ConsumeQuota counted for jobID (no-op if it wasn't counted), so
+	// a job that could not be released after all doesn't use up the plan.
+	ReleaseQuota(jobID string) error
+}
+
+var ErrOrderNotFound = errors.New("订单不存在")
+
+// withinQuota reports whether one more job of rows rows fits in the quotas (0 = unlimited).
+func withinQuota(u domain.SubscriptionUsage, rows, jobQuota, rowQuota int64) bool {
+	return (jobQuota == 0 || u.Jobs+1 <= jobQuota) && (rowQuota == 0 || u.Rows+rows <= rowQuota)
+}
+
+type usageKey struct {
+	userID string
+	start  int64
+}
+
+// countedJob is where a job was counted, for ReleaseQuota.
+type countedJob struct {
+	key  usageKey
+	rows int64
+}
+
+type InMemorySubscriptionStore struct {
+	mu      sync.Mutex
+	subs    map[string]*domain.Subscription
+	orders  map[string]*domain.SubscriptionOrder
+	applied map[string]bool
+	usage   map[usageKey]domain.SubscriptionUsage
+	counted map[string]countedJob
+}
+
+func NewInMemorySubscriptionStore() *InMemorySubscriptionStore {
+	return &InMemorySubscriptionStore{
+		subs:    make(map[string]*domain.Subscription),
+		orders:  make(map[string]*domain.SubscriptionOrder),
+		applied: make(map[string]bool),
+		usage:   make(map[usageKey]domain.SubscriptionUsage),
+		counted: make(map[string]countedJob),
+	}
+}
+
+func (s *InMemorySubscriptionStore) GetSubscription(userID string) (*domain.Subscription, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[userID]
+	if !ok {
+		return nil, false, nil
+	}
+	cp := *sub
+	return &cp, true, nil
+}
+
+func (s *InMemorySubscriptionStore) CreateOrder(o *domain.SubscriptionOrder) error {
+	if o == nil || strings.TrimSpace(o.ID) == "" {
+		return errors.New("order/id 为空")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[o.ID]; !ok {
+		cp := *o
+		s.orders[o.ID] = &cp
+	}
+	return nil
+}
+
+func (s *InMemorySubscriptionStore) GetOrder(id string) (*domain.SubscriptionOrder, bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, false, false, nil
+	}
+	cp := *o
+	return &cp, s.applied[id], true, nil
+}
+
+func (s *InMemorySubscriptionStore) ApplyOrder(id string, now time.Time) (*domain.Subscription, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, false, ErrOrderNotFound
+	}
+	if s.applied[id] {
+		cp := *s.subs[o.UserID]
+		return &cp, false, nil
+	}
+	next := domain.RenewSubscription(s.subs[o.UserID], o.UserID, o.PlanID, now)
+	s.subs[o.UserID] = next
+	s.applied[id] = true
+	cp := *next
+	return &cp, true, nil
+}
+
+func (s *InMemorySubscriptionStore) Usage(userID string, periodStart time.Time) (domain.SubscriptionUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[usageKey{userID, periodStart.UnixMilli()}], nil
+}
+
+func (s *InMemorySubscriptionStore) ConsumeQuota(userID string, periodStart time.Time, jobID string, rows, jobQuota, rowQuota int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counted[jobID]; ok {
+		return true, nil
+	}
+	k := usageKey{userID, periodStart.UnixMilli()}
+	u := s.usage[k]
+	if !withinQuota(u, rows, jobQuota, rowQuota) {
+		return false, nil
+	}
+	u.Jobs++
+	u.Rows += rows
+	s.usage[k] = u
+	s.counted[jobID] = countedJob{key: k, rows: rows}
+	return true, nil
+}
+
+func (s *InMemorySubscriptionStore) ReleaseQuota(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counted[jobID]
+	if !ok {
+		return nil
+	}
+	u := s.usage[c.key]
+	u.Jobs--
+	u.Rows -= c.rows
+	s.usage[c.key] = u
+	delete(s.counted, jobID)
+	return nil
+}
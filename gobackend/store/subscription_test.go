@@ -0,0 +1,68 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func testSubscriptionStores(t *testing.T) map[string]SubscriptionStore {
+	t.Helper()
+	sq, err := NewSQLSubscriptionStore(openTestSQL(t), "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]SubscriptionStore{"memory": NewInMemorySubscriptionStore(), "sql": sq}
+}
+
+func TestSubscriptionConsumeAndReleaseQuota(t *testing.T) {
+	start := time.UnixMilli(1_700_000_000_000)
+	for name, s := range testSubscriptionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			consume := func(jobID string, rows int64) bool {
+				t.Helper()
+				ok, err := s.ConsumeQuota("u1", start, jobID, rows, 2, 100)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ok
+			}
+			wantUsage := func(jobs, rows int64) {
+				t.Helper()
+				u, err := s.Usage("u1", start)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if u.Jobs != jobs || u.Rows != rows {
+					t.Fatalf("usage = %d jobs / %d rows, want %d / %d", u.Jobs, u.Rows, jobs, rows)
+				}
+			}
+
+			if !consume("j1", 60) || !consume("j1", 60) {
+				t.Fatal("j1 not covered")
+			}
+			wantUsage(1, 60)
+			if consume("j2", 50) {
+				t.Fatal("j2 covered beyond the row quota")
+			}
+			wantUsage(1, 60)
+
+			for i := 0; i < 2; i++ { // releasing twice gives it back once
+				if err := s.ReleaseQuota("j1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.ReleaseQuota("never-counted"); err != nil {
+				t.Fatal(err)
+			}
+			wantUsage(0, 0)
+
+			if !consume("j2", 50) || !consume("j1", 40) {
+				t.Fatal("quota not given back")
+			}
+			wantUsage(2, 90)
+			if consume("j3", 1) {
+				t.Fatal("j3 covered beyond the job quota")
+			}
+		})
+	}
+}
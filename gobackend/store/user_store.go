@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"gobackend/domain"
+)
+
+// UserStore keeps accounts.
+type UserStore interface {
+	Get(id string) (*domain.User, bool, error)
+	// UpsertWeChat returns the user for a WeChat openid, creating it on first login. A non-empty
+	// nickname/avatar refreshes the stored profile.
+	UpsertWeChat(openID, unionID, nickname, avatarURL string) (*domain.User, error)
+}
+
+func newUserID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "u_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return "u_" + hex.EncodeToString(b)
+}
+
+// applyProfile updates u with a non-empty profile and reports whether anything changed.
+func applyProfile(u *domain.User, unionID, nickname, avatarURL string) bool {
+	changed := false
+	for _, f := range []struct {
+		dst *string
+		v   string
+	}{{&u.WeChatUnionID, unionID}, {&u.Nickname, nickname}, {&u.AvatarURL, avatarURL}} {
+		if v := strings.TrimSpace(f.v); v != "" && *f.dst != v {
+			*f.dst = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+type InMemoryUserStore struct {
+	mu       sync.Mutex
+	users    map[string]*domain.User
+	byOpenID map[string]string
+}
+
+func NewInMemoryUserStore() *InMemoryUserStore {
+	return &InMemoryUserStore{users: make(map[string]*domain.User), byOpenID: make(map[string]string)}
+}
+
+func (s *InMemoryUserStore) Get(id string) (*domain.User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return nil, false, nil
+	}
+	cp := *u
+	return &cp, true, nil
+}
+
+func (s *InMemoryUserStore) UpsertWeChat(openID, unionID, nickname, avatarURL string) (*domain.User, error) {
+	openID = strings.TrimSpace(openID)
+	if openID == "" {
+		return nil, errors.New("openid 为空")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[s.byOpenID[openID]]
+	if !ok {
+		u = &domain.User{ID: newUserID(), WeChatOpenID: openID, CreatedAt: time.Now()}
+		s.users[u.ID] = u
+		s.byOpenID[openID] = u.ID
+	}
+	applyProfile(u, unionID, nickname, avatarURL)
+	cp := *u
+	return &cp, nil
+}
+
+// RedisUserStore keeps users as JSON under <prefix><id> with an openid -> id index. Users don't
+// expire.
+type RedisUserStore struct {
+	rdb       *redis.Client
+	keyPrefix string
+}
+
+func NewRedisUserStore(rdb *redis.Client) *RedisUserStore {
+	return &RedisUserStore{rdb: rdb, keyPrefix: "gy:user:"}
+}
+
+type userRecord struct {
+	ID            string    `json:"id"`
+	WeChatOpenID  string    `json:"wechatOpenId,omitempty"`
+	WeChatUnionID string    `json:"wechatUnionId,omitempty"`
+	Nickname      string    `json:"nickname,omitempty"`
+	AvatarURL     string    `json:"avatarUrl,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (s *RedisUserStore) key(id string) string { return s.keyPrefix + id }
+
+func (s *RedisUserStore) openIDKey(openID string) string { return s.keyPrefix + "wechat:" + openID }
+
+func (s *RedisUserStore) Get(id string) (*domain.User, bool, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.get(ctx, id)
+}
+
+func (s *RedisUserStore) get(ctx context.Context, id string) (*domain.User, bool, error) {
+	val, err := s.rdb.Get(ctx, s.key(id)).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var rec userRecord
+	if err := json.Unmarshal([]byte(val), &rec); err != nil {
+		return nil, false, err
+	}
+	u := domain.User(rec)
+	return &u, true, nil
+}
+
+func (s *RedisUserStore) put(ctx context.Context, u *domain.User) error {
+	b, err := json.Marshal(userRecord(*u))
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, s.key(u.ID), b, 0).Err()
+}
+
+func (s *RedisUserStore) UpsertWeChat(openID, unionID, nickname, avatarURL string) (*domain.User, error) {
+	openID = strings.TrimSpace(openID)
+	if openID == "" {
+		return nil, errors.New("openid 为空")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// SETNX on the openid index decides the user ID when two first logins race.
+	id := newUserID()
+	created, err := s.rdb.SetNX(ctx, s.openIDKey(openID), id, 0).Result()
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		u := &domain.User{ID: id, WeChatOpenID: openID, CreatedAt: time.Now()}
+		applyProfile(u, unionID, nickname, avatarURL)
+		if err := s.put(ctx, u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+
+	if id, err = s.rdb.Get(ctx, s.openIDKey(openID)).Result(); err != nil {
+		return nil, err
+	}
+	u, ok, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// The winner of the race hasn't written the user yet (or it failed to): write it now.
+		u = &domain.User{ID: id, WeChatOpenID: openID, CreatedAt: time.Now()}
+		applyProfile(u, unionID, nickname, avatarURL)
+		return u, s.put(ctx, u)
+	}
+	if applyProfile(u, unionID, nickname, avatarURL) {
+		if err := s.put(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,186 @@
+package streamq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Delayed messages wait in a sorted set next to their stream (DelayedKey, score = due time in unix
+// ms, member = the message fields as JSON). Every Consumer runs a mover that appends due members to
+// the stream, so a scheduled message is picked up within about a second of its due time.
+
+// RetryAfterError asks the consumer to ACK the message and deliver it again after Delay (e.g.
+// polling for a result that isn't there yet). Like Requeue it is not counted as a delivery, so a
+// RetryPolicy never dead-letters a message that keeps polling; the handler decides when to stop.
+type RetryAfterError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e RetryAfterError) Error() string {
+	if e.Err == nil {
+		return "retry after " + e.Delay.String()
+	}
+	return e.Err.Error()
+}
+
+func (e RetryAfterError) Unwrap() error { return e.Err }
+
+func RetryAfter(err error, delay time.Duration) error { return RetryAfterError{Err: err, Delay: delay} }
+
+// DelayedQueue is a CompareQueue that can also schedule a job for later.
+type DelayedQueue interface {
+	CompareQueue
+	EnqueueAfter(ctx context.Context, jobID string, delay time.Duration) error
+}
+
+// DelayedKey is the sorted set holding a stream's scheduled messages.
+func DelayedKey(stream string) string {
+	return strings.TrimSpace(stream) + ":delayed"
+}
+
+// attemptField carries the number of earlier deliveries across a delayed retry (the stream entry
+// itself is new, so XPENDING starts counting from 1 again).
+const attemptField = "attempt"
+
+// EnqueueAfter adds an envelope of the queue's message type for jobID once delay has passed
+// (delay<=0 enqueues now).
+func (q *RedisStreamQueue) EnqueueAfter(ctx context.Context, jobID string, delay time.Duration) error {
+	jobID = strings.TrimSpace(jobID)
+	if jobID == "" {
+		return errors.New("jobID 为空")
+	}
+	return q.PublishAfter(ctx, Envelope{Type: q.messageType(), JobID: jobID}, delay)
+}
+
+// PublishAfter adds env to the stream once delay has passed (delay<=0 publishes now).
+func (q *RedisStreamQueue) PublishAfter(ctx context.Context, env Envelope, delay time.Duration) error {
+	if delay <= 0 {
+		return q.Publish(ctx, env)
+	}
+	if q == nil || q.rdb == nil {
+		return errors.New("redis stream queue 未初始化")
+	}
+	if q.stream == "" {
+		return errors.New("stream key 为空")
+	}
+	member, err := delayedMember(env.Stamp(ctx).Values())
+	if err != nil {
+		return err
+	}
+	return q.rdb.ZAdd(ctx, DelayedKey(q.stream), redis.Z{Score: dueScore(delay), Member: member}).Err()
+}
+
+// schedule moves a delivered message into the delayed set (values replace its fields) and ACKs it
+// in one MULTI. On failure the message stays pending.
+func (c *Consumer) schedule(ctx context.Context, msg redis.XMessage, values map[string]interface{}, delay time.Duration) error {
+	member, err := delayedMember(values)
+	if err != nil {
+		return err
+	}
+	_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, DelayedKey(c.stream), redis.Z{Score: dueScore(delay), Member: member})
+		pipe.XAck(ctx, c.stream, c.group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("schedule failed msg=%s: %v (keep pending)", msg.ID, err)
+	}
+	return err
+}
+
+// retryLater schedules the next delivery of a failed message after delay, recording the attempt.
+func (c *Consumer) retryLater(ctx context.Context, msg redis.XMessage, deliveries int64, delay time.Duration, cause error) {
+	values := make(map[string]interface{}, len(msg.Values)+1)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[attemptField] = deliveries
+	if c.schedule(ctx, msg, values, delay) == nil {
+		log.Printf("retry scheduled msg=%s jobId=%v delivery=%d in %s: %v", msg.ID, msg.Values["jobId"], deliveries, delay, cause)
+	}
+}
+
+// priorAttempts reads attemptField (0 for a first-time message).
+func priorAttempts(msg redis.XMessage) int64 {
+	v, ok := msg.Values[attemptField]
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(fmt.Sprintf("%v", v), 10, 64)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+var moveDueScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, tonumber(ARGV[2]))
+for _, m in ipairs(due) do
+  local args = {KEYS[1], "MAXLEN", "~", ARGV[3], "*"}
+  for k, v in pairs(cjson.decode(m)) do
+    table.insert(args, k)
+    table.insert(args, tostring(v))
+  end
+  redis.call("XADD", unpack(args))
+  redis.call("ZREM", KEYS[2], m)
+end
+return #due
+`)
+
+// moveDue appends due delayed messages to the stream. The script is atomic, so any number of
+// consumers can run it concurrently without duplicating a message.
+func (c *Consumer) moveDue(ctx context.Context) (int64, error) {
+	return moveDueScript.Run(ctx, c.rdb, []string{c.stream, DelayedKey(c.stream)},
+		time.Now().UnixMilli(), 100, streamMaxLen).Int64()
+}
+
+// runMover moves due delayed messages every moveEvery until ctx is done.
+func (c *Consumer) runMover(ctx context.Context) {
+	t := time.NewTicker(c.moveEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for {
+				n, err := c.moveDue(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("delayed move error stream=%s: %v", c.stream, err)
+					}
+					break
+				}
+				if n < 100 {
+					break
+				}
+			}
+		}
+	}
+}
+
+func delayedMember(values map[string]interface{}) (string, error) {
+	fields := make(map[string]string, len(values))
+	for k, v := range values {
+		fields[k] = fmt.Sprintf("%v", v)
+	}
+	// Map keys are marshalled sorted, so the member is stable for equal fields.
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func dueScore(delay time.Duration) float64 {
+	return float64(time.Now().Add(delay).UnixMilli())
+}
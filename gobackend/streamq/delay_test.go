@@ -0,0 +1,261 @@
+package streamq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestEnqueueAfterMovesOnlyDueMessages(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	q := NewRedisStreamQueue(rdb, "s", "g", 0)
+	q.SetMessageType("compare.run")
+
+	if err := q.EnqueueAfter(ctx, "job_soon", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueAfter(ctx, "job_later", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := rdb.XLen(ctx, "s").Result(); n != 0 {
+		t.Fatalf("stream has %d messages before they are due", n)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	moved, err := c.moveDue(ctx)
+	if err != nil || moved != 1 {
+		t.Fatalf("moveDue = %d, %v; want 1", moved, err)
+	}
+	msgs, err := rdb.XRange(ctx, "s", "-", "+").Result()
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("stream = %v, %v", msgs, err)
+	}
+	if env := envelopeFromMessage(msgs[0]); env.JobID != "job_soon" || env.Type != "compare.run" {
+		t.Fatalf("released %+v", env)
+	}
+	if n, _ := rdb.ZCard(ctx, DelayedKey("s")).Result(); n != 1 {
+		t.Fatalf("%d scheduled messages left, want 1", n)
+	}
+	if moved, _ := c.moveDue(ctx); moved != 0 {
+		t.Fatalf("second move released %d", moved)
+	}
+}
+
+func TestEnqueueAfterWithoutDelayEnqueuesNow(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	q := NewRedisStreamQueue(rdb, "s", "g", 0)
+	if err := q.EnqueueAfter(ctx, "job_1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := rdb.XLen(ctx, "s").Result(); n != 1 {
+		t.Fatalf("stream has %d messages, want 1", n)
+	}
+	if n, _ := rdb.ZCard(ctx, DelayedKey("s")).Result(); n != 0 {
+		t.Fatalf("%d scheduled messages, want 0", n)
+	}
+}
+
+func TestConcurrentMoversReleaseOnce(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	const n = 250 // more than one batch
+	past := float64(time.Now().Add(-time.Second).UnixMilli())
+	for i := 0; i < n; i++ {
+		member, err := delayedMember(map[string]interface{}{"type": "t", "jobId": "job_" + strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rdb.ZAdd(ctx, DelayedKey("s"), redis.Z{Score: past, Member: member}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var moved atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		c := newTestConsumer(t, rdb, "s")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				m, err := c.moveDue(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				moved.Add(m)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := moved.Load(); got != n {
+		t.Fatalf("movers released %d, want %d", got, n)
+	}
+	msgs, err := rdb.XRange(ctx, "s", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, m := range msgs {
+		id := envelopeFromMessage(m).JobID
+		if seen[id] {
+			t.Fatalf("%s released twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != n {
+		t.Fatalf("stream has %d jobs, want %d", len(seen), n)
+	}
+}
+
+func TestRetryAfterIsNotCountedAsDelivery(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 2, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond})
+
+	const polls = 5
+	var (
+		mu       sync.Mutex
+		attempts []int64
+	)
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts = append(attempts, env.Attempt)
+			if len(attempts) <= polls {
+				return RetryAfter(errors.New("not ready"), 5*time.Millisecond)
+			}
+			return nil
+		})
+	})
+	if err := NewRedisStreamQueue(rdb, "s", "g", 0).Enqueue(ctx, "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "polls", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) == polls+1
+	})
+	mu.Lock()
+	for i, a := range attempts {
+		if a != 1 {
+			t.Fatalf("poll %d saw attempt %d, want 1 (attempts %v)", i, a, attempts)
+		}
+	}
+	mu.Unlock()
+	if n, _ := rdb.XLen(ctx, DeadLetterKey("s")).Result(); n != 0 {
+		t.Fatal("polling message was dead-lettered")
+	}
+}
+
+func TestMemoryRetryAfterIsNotCountedAsDelivery(t *testing.T) {
+	q := NewMemoryQueue("q")
+	c := NewMemoryConsumer(q)
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 2, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond})
+
+	const polls = 5
+	var (
+		mu       sync.Mutex
+		attempts []int64
+	)
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts = append(attempts, env.Attempt)
+			if len(attempts) <= polls {
+				return RetryAfter(errors.New("not ready"), 5*time.Millisecond)
+			}
+			return nil
+		})
+	})
+	if err := q.Enqueue(context.Background(), "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "polls", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) == polls+1
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for i, a := range attempts {
+		if a != 1 {
+			t.Fatalf("poll %d saw attempt %d, want 1 (attempts %v)", i, a, attempts)
+		}
+	}
+}
+
+func TestRequeueDelay(t *testing.T) {
+	for requeues, want := range map[int64]time.Duration{
+		0:   time.Second,
+		1:   2 * time.Second,
+		4:   16 * time.Second,
+		5:   maxRequeuePause,
+		100: maxRequeuePause,
+	} {
+		if got := requeueDelay(requeues); got != want {
+			t.Errorf("requeueDelay(%d) = %s, want %s", requeues, got, want)
+		}
+	}
+}
+
+// Each Requeue of the same message schedules it further out and carries the count.
+func TestRequeueBacksOff(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	values := Envelope{Type: "compare.run", JobID: "job_1"}.Stamp(ctx).Values()
+	for n := int64(0); n < 3; n++ {
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: "s", Values: values}).Err(); err != nil {
+			t.Fatal(err)
+		}
+		res, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "c1", Streams: []string{"s", ">"}, Count: 1}).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := res[0].Messages[0]
+		if got := envelopeFromMessage(msg).Requeues; got != n {
+			t.Fatalf("delivery %d: Requeues = %d", n, got)
+		}
+
+		before := time.Now()
+		c.requeue(ctx, msg, errors.New("tenant at cap"))
+		after := time.Now()
+
+		due, err := rdb.ZRangeWithScores(ctx, DelayedKey("s"), 0, -1).Result()
+		if err != nil || len(due) != 1 {
+			t.Fatalf("delayed set = %v, err = %v", due, err)
+		}
+		d := requeueDelay(n)
+		if score := int64(due[0].Score); score < before.Add(d).UnixMilli() || score > after.Add(d).UnixMilli() {
+			t.Fatalf("requeue %d due in %s, want %s", n, time.UnixMilli(score).Sub(before), d)
+		}
+		if pe, _ := rdb.XPending(ctx, "s", "g").Result(); pe.Count != 0 {
+			t.Fatalf("pending = %d after requeue", pe.Count)
+		}
+		// What the mover would re-add next.
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(due[0].Member.(string)), &fields); err != nil {
+			t.Fatal(err)
+		}
+		values = map[string]interface{}{}
+		for k, v := range fields {
+			values[k] = v
+		}
+		rdb.Del(ctx, DelayedKey("s"))
+	}
+}
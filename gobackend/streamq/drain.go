@@ -0,0 +1,89 @@
+package streamq
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// drainState tracks a consume loop and its running handlers for graceful shutdown and probes.
+// A MultiConsumer shares one drainState across its lanes.
+type drainState struct {
+	timeout  time.Duration
+	running  atomic.Bool
+	draining atomic.Bool
+	inflight sync.WaitGroup
+}
+
+// begin marks the loop as running and returns the context handlers run with. It is not cancelled
+// together with ctx: once ctx is done the loop stops reading, and handlers get up to timeout to
+// finish before their context is cancelled too. finish waits for running handlers.
+func (s *drainState) begin(ctx context.Context) (context.Context, func()) {
+	s.running.Store(true)
+	s.draining.Store(false)
+	hctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		s.draining.Store(true)
+		if s.timeout <= 0 {
+			cancel()
+			return
+		}
+		log.Printf("stream consumer draining: waiting up to %s for running handlers", s.timeout)
+		time.AfterFunc(s.timeout, cancel)
+	})
+	return hctx, func() {
+		stop()
+		s.inflight.Wait()
+		cancel()
+		s.running.Store(false)
+	}
+}
+
+func (s *drainState) ready() bool {
+	return s.running.Load() && !s.draining.Load()
+}
+
+// deliver dispatches a freshly read msg on hctx, unless the loop (ctx) is already shutting down:
+// a blocking read can return after SIGTERM, and such a message is released instead of started
+// (this delivery never ran, so it is not counted).
+func (c *Consumer) deliver(ctx, hctx context.Context, handler Handler, msg redis.XMessage, deliveries int64) {
+	if ctx.Err() != nil {
+		_ = c.readd(context.WithoutCancel(ctx), msg, max(deliveries-1, 0)+priorAttempts(msg))
+		return
+	}
+	c.dispatch(hctx, handler, msg, deliveries)
+}
+
+// SetDrainTimeout enables graceful shutdown: when the ConsumeLoop context is cancelled (SIGTERM)
+// the loop stops reading new messages and waits up to d for running handlers. Handlers still
+// running after d are cancelled, and their messages are released (re-added to the stream and
+// ACKed) so another worker picks them up at once instead of after the claim idle time.
+// d<=0 (default) cancels handlers together with the loop.
+func (c *Consumer) SetDrainTimeout(d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.state.timeout = d
+}
+
+// Ready reports whether ConsumeLoop is running and not draining (readiness probe).
+func (c *Consumer) Ready() bool {
+	return c != nil && c.state.ready()
+}
+
+// SetDrainTimeout: see Consumer.SetDrainTimeout.
+func (m *MultiConsumer) SetDrainTimeout(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.state.timeout = d
+}
+
+// Ready reports whether ConsumeLoop is running and not draining (readiness probe).
+func (m *MultiConsumer) Ready() bool {
+	return m != nil && m.state.ready()
+}
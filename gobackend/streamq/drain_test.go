@@ -0,0 +1,139 @@
+package streamq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// drainOnce starts a consumer with drain timeout d, cancels it while a handler that needs
+// handlerTime is running, and waits for ConsumeLoop to return.
+func drainOnce(t *testing.T, d, handlerTime time.Duration) (streamLen, pending int64, finished bool) {
+	t.Helper()
+	_, rdb := newTestRedis(t)
+	bg := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	c.SetConcurrency(2)
+	c.SetDrainTimeout(d)
+	if err := NewRedisStreamQueue(rdb, "s", "g", 0).Enqueue(bg, "job_1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(bg)
+	started := make(chan struct{})
+	result := make(chan bool, 1)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		_ = c.ConsumeLoop(ctx, func(hctx context.Context, env Envelope) error {
+			close(started)
+			select {
+			case <-time.After(handlerTime):
+				result <- true
+				return nil
+			case <-hctx.Done():
+				result <- false
+				return hctx.Err()
+			}
+		})
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not started")
+	}
+	if !c.Ready() {
+		t.Fatal("not ready while consuming")
+	}
+	cancel()
+	waitFor(t, "drain", func() bool { return !c.Ready() })
+	select {
+	case <-loopDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeLoop did not return")
+	}
+	// ConsumeLoop waits for running handlers.
+	select {
+	case finished = <-result:
+	default:
+		t.Fatal("ConsumeLoop returned before the handler")
+	}
+
+	streamLen, _ = rdb.XLen(bg, "s").Result()
+	p, _ := rdb.XPending(bg, "s", "g").Result()
+	return streamLen, p.Count, finished
+}
+
+func TestDrainWaitsForRunningHandler(t *testing.T) {
+	n, pending, finished := drainOnce(t, 5*time.Second, 100*time.Millisecond)
+	if !finished || n != 1 || pending != 0 {
+		t.Fatalf("finished=%v len=%d pending=%d, want the handler to finish and its message ACKed", finished, n, pending)
+	}
+}
+
+func TestDrainTimeoutReleasesMessage(t *testing.T) {
+	n, pending, finished := drainOnce(t, 50*time.Millisecond, 10*time.Second)
+	// Cut off: a copy goes back on the stream for another worker, nothing stays pending.
+	if finished || n != 2 || pending != 0 {
+		t.Fatalf("finished=%v len=%d pending=%d, want the handler cancelled and its message re-added", finished, n, pending)
+	}
+}
+
+// cutOff runs a consumer on "s" until its handler starts, then shuts it down with a short drain
+// so the delivery is released. It returns the attempt the handler saw.
+func cutOff(t *testing.T, rdb *redis.Client, p RetryPolicy) int64 {
+	t.Helper()
+	c := newTestConsumer(t, rdb, "s")
+	c.SetConcurrency(1)
+	c.SetDrainTimeout(10 * time.Millisecond)
+	c.SetRetryPolicy(p)
+	ctx, cancel := context.WithCancel(context.Background())
+	attempt := make(chan int64, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = c.ConsumeLoop(ctx, func(hctx context.Context, env Envelope) error {
+			attempt <- env.Attempt
+			<-hctx.Done()
+			return hctx.Err()
+		})
+	}()
+	var got int64
+	select {
+	case got = <-attempt:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not started")
+	}
+	cancel()
+	<-done
+	return got
+}
+
+// Deliveries cut off by repeated deploys count towards MaxDeliveries.
+func TestDrainReleaseKeepsAttemptCount(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	bg := context.Background()
+	p := RetryPolicy{MaxDeliveries: 3, BaseDelay: time.Hour}
+	if err := NewRedisStreamQueue(rdb, "s", "g", 0).Enqueue(bg, "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if got := cutOff(t, rdb, p); got != want {
+			t.Fatalf("deploy %d: attempt = %d", want, got)
+		}
+	}
+	// The third release was the last allowed delivery: dead-lettered instead of re-added.
+	dead, _, err := NewDeadLetterQueue(rdb, "s").List(bg, "", 10)
+	if err != nil || len(dead) != 1 || dead[0].JobID != "job_1" || dead[0].Deliveries != 3 {
+		t.Fatalf("dead letters = %+v, err = %v", dead, err)
+	}
+	if pe, _ := rdb.XPending(bg, "s", "g").Result(); pe.Count != 0 {
+		t.Fatalf("pending = %d, want 0", pe.Count)
+	}
+	msgs, _ := rdb.XRange(bg, "s", "-", "+").Result()
+	if n := len(msgs); n != 3 || priorAttempts(msgs[1]) != 1 || priorAttempts(msgs[2]) != 2 {
+		t.Fatalf("stream = %+v, want the original and two copies carrying attempts 1 and 2", msgs)
+	}
+}
@@ -0,0 +1,124 @@
+package streamq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Envelope is one queued message. On the stream it is stored as flat fields:
+//
+//	type, jobId, payload (JSON), attempt (earlier deliveries), requeues, enqueuedAt (unix ms), trace (JSON)
+//
+// Messages written before envelopes existed only have jobId; they decode with an empty Type.
+type Envelope struct {
+	// ID is the stream entry ID (set on delivery).
+	ID string `json:"id,omitempty"`
+	// Type routes the message (see Mux), e.g. domain.MessageCompareRun.
+	Type string `json:"type"`
+	// JobID is the job the message is about ("" for messages that aren't about one job).
+	JobID string `json:"jobId,omitempty"`
+	// Payload is optional type-specific data.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Attempt is the delivery number starting at 1 (0 = unknown: auto-claimed without a RetryPolicy).
+	Attempt int64 `json:"attempt"`
+	// Requeues counts earlier Requeue results; each one doubles the wait before the next try.
+	Requeues   int64     `json:"requeues,omitempty"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	// Trace is the producer's W3C trace context (traceparent / tracestate), so traces continue
+	// across API -> compare-worker -> payment-worker.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// Stamp fills EnqueuedAt and Trace (from ctx) when they are unset.
+func (e Envelope) Stamp(ctx context.Context) Envelope {
+	if e.EnqueuedAt.IsZero() {
+		e.EnqueuedAt = time.Now()
+	}
+	if e.Trace == nil && ctx != nil {
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		if len(carrier) > 0 {
+			e.Trace = carrier
+		}
+	}
+	return e
+}
+
+// Context returns ctx carrying the producer's trace context (if any) as the remote parent.
+func (e Envelope) Context(ctx context.Context) context.Context {
+	if len(e.Trace) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(e.Trace))
+}
+
+// Values are the stream fields of the envelope (ID and Attempt are not stored).
+func (e Envelope) Values() map[string]interface{} {
+	v := map[string]interface{}{
+		"type":       e.Type,
+		"enqueuedAt": e.EnqueuedAt.UnixMilli(),
+	}
+	if e.JobID != "" {
+		v["jobId"] = e.JobID
+	}
+	if len(e.Payload) > 0 {
+		v["payload"] = string(e.Payload)
+	}
+	if len(e.Trace) > 0 {
+		if b, err := json.Marshal(e.Trace); err == nil {
+			v["trace"] = string(b)
+		}
+	}
+	return v
+}
+
+func envelopeFromMessage(msg redis.XMessage) Envelope {
+	str := func(k string) string {
+		if v, ok := msg.Values[k]; ok {
+			return strings.TrimSpace(fmt.Sprintf("%v", v))
+		}
+		return ""
+	}
+	env := Envelope{
+		ID:    msg.ID,
+		Type:  str("type"),
+		JobID: str("jobId"),
+	}
+	if p := str("payload"); p != "" && json.Valid([]byte(p)) {
+		env.Payload = json.RawMessage(p)
+	}
+	if n, err := strconv.ParseInt(str(requeueField), 10, 64); err == nil && n > 0 {
+		env.Requeues = n
+	}
+	if ms, err := strconv.ParseInt(str("enqueuedAt"), 10, 64); err == nil && ms > 0 {
+		env.EnqueuedAt = time.UnixMilli(ms)
+	}
+	if t := str("trace"); t != "" {
+		_ = json.Unmarshal([]byte(t), &env.Trace)
+	}
+	return env
+}
+
+// Mux routes envelopes to handlers by Type. Types without a handler go to the "" handler if one is
+// registered (this also catches untyped messages from before envelopes); otherwise the message is
+// ACKed as a terminal error.
+type Mux map[string]Handler
+
+func (m Mux) Handle(ctx context.Context, env Envelope) error {
+	h, ok := m[env.Type]
+	if !ok {
+		h, ok = m[""]
+	}
+	if !ok || h == nil {
+		return Terminal(fmt.Errorf("未知消息类型: %q", env.Type))
+	}
+	return h(ctx, env)
+}
@@ -0,0 +1,154 @@
+package streamq
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// withTraceContext installs the W3C propagator and returns ctx carrying a sampled remote span.
+func withTraceContext(t *testing.T) (context.Context, trace.SpanContext) {
+	t.Helper()
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(prev) })
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	return trace.ContextWithSpanContext(context.Background(), sc), sc
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	ctx, sc := withTraceContext(t)
+	enqueued := time.UnixMilli(1_700_000_000_123)
+	env := Envelope{
+		Type:       "webhook.deliver",
+		JobID:      "job_1",
+		Payload:    json.RawMessage(`{"event":"job.ready"}`),
+		EnqueuedAt: enqueued,
+	}.Stamp(ctx)
+	if env.EnqueuedAt != enqueued {
+		t.Fatalf("Stamp replaced EnqueuedAt: %s", env.EnqueuedAt)
+	}
+	if env.Trace["traceparent"] == "" {
+		t.Fatalf("Stamp did not record the trace context: %v", env.Trace)
+	}
+
+	// Stream fields come back as strings.
+	values := map[string]interface{}{}
+	for k, v := range env.Values() {
+		b, _ := json.Marshal(v)
+		if s, ok := v.(string); ok {
+			values[k] = s
+		} else {
+			values[k] = string(b)
+		}
+	}
+	got := envelopeFromMessage(redis.XMessage{ID: "1-0", Values: values})
+	if got.ID != "1-0" || got.Type != env.Type || got.JobID != env.JobID || string(got.Payload) != string(env.Payload) || !got.EnqueuedAt.Equal(enqueued) {
+		t.Fatalf("round trip = %+v, want %+v", got, env)
+	}
+	if got.Trace["traceparent"] != env.Trace["traceparent"] {
+		t.Fatalf("trace = %v, want %v", got.Trace, env.Trace)
+	}
+	if parent := trace.SpanContextFromContext(got.Context(context.Background())); parent.TraceID() != sc.TraceID() || parent.SpanID() != sc.SpanID() || !parent.IsRemote() {
+		t.Fatalf("restored span context = %+v, want %+v", parent, sc)
+	}
+
+	// Optional fields are left out, and a bad payload is dropped instead of passed on.
+	bare := Envelope{Type: "t"}.Values()
+	for _, k := range []string{"jobId", "payload", "trace"} {
+		if _, ok := bare[k]; ok {
+			t.Fatalf("empty %s stored: %v", k, bare)
+		}
+	}
+	if e := envelopeFromMessage(redis.XMessage{Values: map[string]interface{}{"type": "t", "payload": "{nope"}}); e.Payload != nil {
+		t.Fatalf("invalid payload kept: %s", e.Payload)
+	}
+	if c := (Envelope{}).Context(ctx); c != ctx {
+		t.Fatal("Context without a trace should return ctx as is")
+	}
+}
+
+func TestConsumerCarriesEnvelope(t *testing.T) {
+	ctx, sc := withTraceContext(t)
+	_, rdb := newTestRedis(t)
+	c := newTestConsumer(t, rdb, "s")
+	q := NewRedisStreamQueue(rdb, "s", "g", 0)
+	q.SetMessageType("compare.run")
+
+	if err := q.Enqueue(ctx, "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	// Written before envelopes existed: jobId only.
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: "s", Values: map[string]interface{}{"jobId": "legacy"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Publish(ctx, Envelope{Type: "unknown.type", JobID: "job_2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	type seen struct {
+		env     Envelope
+		traceID trace.TraceID
+	}
+	runs := make(chan seen, 3)
+	fallback := make(chan Envelope, 3)
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, Mux{
+			"compare.run": func(ctx context.Context, env Envelope) error {
+				runs <- seen{env, trace.SpanContextFromContext(ctx).TraceID()}
+				return nil
+			},
+			"": func(ctx context.Context, env Envelope) error {
+				fallback <- env
+				return nil
+			},
+		}.Handle)
+	})
+
+	var got seen
+	select {
+	case got = <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("compare.run not delivered")
+	}
+	if got.env.JobID != "job_1" || got.env.Attempt != 1 || got.env.EnqueuedAt.IsZero() {
+		t.Fatalf("compare.run envelope = %+v", got.env)
+	}
+	if got.traceID != sc.TraceID() {
+		t.Fatalf("handler trace = %s, want the producer's %s", got.traceID, sc.TraceID())
+	}
+	for _, want := range []Envelope{{JobID: "legacy"}, {Type: "unknown.type", JobID: "job_2"}} {
+		select {
+		case env := <-fallback:
+			if env.Type != want.Type || env.JobID != want.JobID {
+				t.Fatalf("fallback got %+v, want %+v", env, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%+v did not reach the \"\" handler", want)
+		}
+	}
+}
+
+func TestMuxUnknownTypeIsTerminal(t *testing.T) {
+	called := false
+	m := Mux{"a": func(context.Context, Envelope) error { called = true; return nil }}
+	err := m.Handle(context.Background(), Envelope{Type: "b"})
+	if !IsTerminal(err) || err.Error() == "" || called {
+		t.Fatalf("err = %v, called = %v", err, called)
+	}
+	if err := m.Handle(context.Background(), Envelope{}); !IsTerminal(err) {
+		t.Fatalf("untyped without fallback: %v", err)
+	}
+}
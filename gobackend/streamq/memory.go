@@ -0,0 +1,244 @@
+package streamq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is an in-process DelayedQueue for standalone mode (one binary, no Redis). Messages
+// live only in memory: they are lost when the process exits, and only a MemoryConsumer in the same
+// process can read them.
+type MemoryQueue struct {
+	name    string
+	msgType string
+
+	mu     sync.Mutex
+	items  []Envelope
+	seq    int64
+	notify chan struct{}
+}
+
+func NewMemoryQueue(name string) *MemoryQueue {
+	return &MemoryQueue{name: strings.TrimSpace(name), notify: make(chan struct{}, 1)}
+}
+
+// SetMessageType sets the envelope Type used by Enqueue / EnqueueAfter.
+func (q *MemoryQueue) SetMessageType(t string) {
+	if q == nil {
+		return
+	}
+	q.msgType = strings.TrimSpace(t)
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, jobID string) error {
+	return q.EnqueueAfter(ctx, jobID, 0)
+}
+
+func (q *MemoryQueue) EnqueueAfter(ctx context.Context, jobID string, delay time.Duration) error {
+	jobID = strings.TrimSpace(jobID)
+	if jobID == "" {
+		return errors.New("jobID 为空")
+	}
+	return q.PublishAfter(ctx, Envelope{Type: q.msgType, JobID: jobID}, delay)
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, env Envelope) error {
+	return q.PublishAfter(ctx, env, 0)
+}
+
+// PublishAfter adds env once delay has passed (delay<=0 publishes now).
+func (q *MemoryQueue) PublishAfter(ctx context.Context, env Envelope, delay time.Duration) error {
+	if q == nil {
+		return errors.New("memory queue 未初始化")
+	}
+	if env.Type == "" && env.JobID == "" {
+		return errors.New("消息缺少 type/jobId")
+	}
+	env = env.Stamp(ctx)
+	env.Attempt = 0
+	q.later(env, delay)
+	return nil
+}
+
+// later appends env after delay. env.Attempt carries the number of earlier deliveries.
+func (q *MemoryQueue) later(env Envelope, delay time.Duration) {
+	if delay <= 0 {
+		q.push(env)
+		return
+	}
+	time.AfterFunc(delay, func() { q.push(env) })
+}
+
+func (q *MemoryQueue) push(env Envelope) {
+	q.mu.Lock()
+	q.seq++
+	env.ID = strconv.FormatInt(time.Now().UnixMilli(), 10) + "-" + strconv.FormatInt(q.seq, 10)
+	q.items = append(q.items, env)
+	q.mu.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *MemoryQueue) pop() (Envelope, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return Envelope{}, false
+	}
+	env := q.items[0]
+	q.items[0] = Envelope{}
+	q.items = q.items[1:]
+	if len(q.items) > 0 {
+		// Wake another reader for the rest.
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+	return env, true
+}
+
+// Len is the number of messages waiting (not counting scheduled ones).
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// MemoryConsumer reads a MemoryQueue with the same handler contract as Consumer (Terminal,
+// Requeue, RetryAfter, RetryPolicy). There are no pending entries: a message that exhausts its
+// RetryPolicy is logged and dropped (there is no dead-letter stream), and without a policy failures
+// are retried every memoryRetryDelay.
+type MemoryConsumer struct {
+	q      *MemoryQueue
+	concur chan struct{}
+	retry  *RetryPolicy
+	state  *drainState
+}
+
+func NewMemoryConsumer(q *MemoryQueue) *MemoryConsumer {
+	return &MemoryConsumer{q: q, state: &drainState{}}
+}
+
+func (c *MemoryConsumer) SetConcurrency(n int) {
+	if c == nil {
+		return
+	}
+	if n <= 1 {
+		c.concur = nil
+		return
+	}
+	c.concur = make(chan struct{}, n)
+}
+
+// SetRetryPolicy bounds retries (DeadLetter is ignored). Without one, failures are retried every
+// memoryRetryDelay without limit.
+func (c *MemoryConsumer) SetRetryPolicy(p RetryPolicy) {
+	if c == nil {
+		return
+	}
+	c.retry = &p
+}
+
+// SetDrainTimeout: see Consumer.SetDrainTimeout. Messages cut off by shutdown are lost with the
+// process.
+func (c *MemoryConsumer) SetDrainTimeout(d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.state.timeout = d
+}
+
+func (c *MemoryConsumer) Ready() bool {
+	return c != nil && c.state.ready()
+}
+
+// memoryRetryDelay is the backoff for failures when no RetryPolicy is set (the Redis consumer
+// would leave them pending for XAUTOCLAIM).
+const memoryRetryDelay = 30 * time.Second
+
+func (c *MemoryConsumer) ConsumeLoop(ctx context.Context, handler Handler) error {
+	if c == nil || c.q == nil {
+		return errors.New("memory consumer 未初始化")
+	}
+	if handler == nil {
+		return errors.New("handler 为空")
+	}
+	hctx, finish := c.state.begin(ctx)
+	defer finish()
+	for {
+		if c.concur != nil {
+			select {
+			case c.concur <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		env, ok := c.q.pop()
+		if !ok {
+			if c.concur != nil {
+				<-c.concur
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.q.notify:
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			// Popped as shutdown began: leave it queued instead of starting it (like Consumer.deliver).
+			c.q.push(env)
+			if c.concur != nil {
+				<-c.concur
+			}
+			return ctx.Err()
+		}
+		c.state.inflight.Add(1)
+		if c.concur == nil {
+			c.handleOne(hctx, handler, env)
+			c.state.inflight.Done()
+			continue
+		}
+		go func(env Envelope) {
+			defer c.state.inflight.Done()
+			defer func() { <-c.concur }()
+			c.handleOne(hctx, handler, env)
+		}(env)
+	}
+}
+
+func (c *MemoryConsumer) handleOne(ctx context.Context, handler Handler, env Envelope) {
+	prior := env.Attempt
+	env.Attempt = prior + 1
+	err := runHandler(ctx, handler, env, "memory", c.q.name)
+
+	var later RetryAfterError
+	retry := env
+	switch {
+	case err == nil || IsTerminal(err):
+	case IsRequeue(err):
+		retry.Attempt = prior
+		retry.Requeues++
+		c.q.later(retry, requeueDelay(env.Requeues))
+	case ctx.Err() != nil:
+		log.Printf("dropped msg=%s jobId=%s on shutdown (memory queue %s)", env.ID, env.JobID, c.q.name)
+	case errors.As(err, &later):
+		retry.Attempt = prior
+		c.q.later(retry, later.Delay)
+	case c.retry != nil && c.retry.MaxDeliveries > 0 && env.Attempt >= c.retry.MaxDeliveries:
+		log.Printf("giving up msg=%s jobId=%s after %d deliveries: %v", env.ID, env.JobID, env.Attempt, err)
+	case c.retry != nil:
+		c.q.later(retry, c.retry.Delay(env.Attempt))
+	default:
+		log.Printf("handler non-terminal error msg=%s jobId=%s delivery=%d: %v (retry in %s)", env.ID, env.JobID, env.Attempt, err, memoryRetryDelay)
+		c.q.later(retry, memoryRetryDelay)
+	}
+}
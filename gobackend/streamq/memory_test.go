@@ -0,0 +1,236 @@
+package streamq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryRecorder collects the envelopes a handler saw.
+type memoryRecorder struct {
+	mu   sync.Mutex
+	seen []Envelope
+}
+
+func (r *memoryRecorder) add(env Envelope) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, env)
+	return len(r.seen)
+}
+
+func (r *memoryRecorder) snapshot() []Envelope {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Envelope(nil), r.seen...)
+}
+
+func (r *memoryRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.seen)
+}
+
+func TestMemoryConsumerAcks(t *testing.T) {
+	q := NewMemoryQueue("q")
+	q.SetMessageType("compare.run")
+	c := NewMemoryConsumer(q)
+	var rec memoryRecorder
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			rec.add(env)
+			if env.JobID == "job_2" {
+				return Terminal(errors.New("bad input")) // handled too: never retried
+			}
+			return nil
+		})
+	})
+	waitFor(t, "ready", c.Ready)
+	for _, id := range []string{"job_1", "job_2", "job_3"} {
+		if err := q.Enqueue(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "deliveries", func() bool { return rec.count() == 3 })
+	time.Sleep(20 * time.Millisecond)
+
+	seen := rec.snapshot()
+	if len(seen) != 3 || q.Len() != 0 {
+		t.Fatalf("%d deliveries, %d left, want 3 / 0", len(seen), q.Len())
+	}
+	for i, env := range seen {
+		if want := []string{"job_1", "job_2", "job_3"}[i]; env.JobID != want || env.Type != "compare.run" || env.Attempt != 1 || env.ID == "" || env.EnqueuedAt.IsZero() {
+			t.Fatalf("delivery %d = %+v, want %s in order", i, env, want)
+		}
+	}
+	if err := q.Enqueue(context.Background(), " "); err == nil {
+		t.Fatal("empty jobID accepted")
+	}
+}
+
+func TestMemoryConsumerRetriesThenGivesUp(t *testing.T) {
+	q := NewMemoryQueue("q")
+	c := NewMemoryConsumer(q)
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond})
+	var rec memoryRecorder
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			rec.add(env)
+			return errors.New("boom")
+		})
+	})
+	if err := q.Enqueue(context.Background(), "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "retries", func() bool { return rec.count() == 3 })
+	time.Sleep(100 * time.Millisecond) // well past Delay(3)
+
+	seen := rec.snapshot()
+	if len(seen) != 3 {
+		t.Fatalf("%d deliveries, want 3 (MaxDeliveries)", len(seen))
+	}
+	for i, env := range seen {
+		if env.Attempt != int64(i+1) {
+			t.Fatalf("delivery %d has attempt %d", i, env.Attempt)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("%d messages left after giving up", q.Len())
+	}
+}
+
+func TestMemoryConsumerRequeue(t *testing.T) {
+	q := NewMemoryQueue("q")
+	c := NewMemoryConsumer(q)
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 1})
+	var rec memoryRecorder
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			if rec.add(env) == 1 {
+				return Requeue(errors.New("tenant at cap"))
+			}
+			return nil
+		})
+	})
+	start := time.Now()
+	if err := q.Enqueue(context.Background(), "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "requeued delivery", func() bool { return rec.count() == 2 })
+
+	// Requeues don't use up MaxDeliveries, and wait requeueDelay(0) before coming back.
+	seen := rec.snapshot()
+	if seen[1].Attempt != 1 || seen[1].Requeues != 1 {
+		t.Fatalf("requeued delivery = %+v, want attempt 1 and one requeue", seen[1])
+	}
+	if waited := time.Since(start); waited < requeueDelay(0) {
+		t.Fatalf("requeued after %s, want at least %s", waited, requeueDelay(0))
+	}
+}
+
+func TestMemoryQueueDelayed(t *testing.T) {
+	q := NewMemoryQueue("q")
+	q.SetMessageType("paygate.check")
+	ctx := context.Background()
+	if err := q.EnqueueAfter(ctx, "job_1", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.PublishAfter(ctx, Envelope{Type: "other", JobID: "job_2"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.Len(); n != 1 {
+		t.Fatalf("Len = %d, want only the undelayed message", n)
+	}
+
+	c := NewMemoryConsumer(q)
+	var rec memoryRecorder
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			rec.add(env)
+			return nil
+		})
+	})
+	waitFor(t, "delayed message", func() bool { return rec.count() == 2 })
+	seen := rec.snapshot()
+	if seen[0].JobID != "job_2" || seen[1].JobID != "job_1" || seen[1].Type != "paygate.check" {
+		t.Fatalf("deliveries = %+v, want job_2 then the delayed job_1", seen)
+	}
+	if err := q.Publish(ctx, Envelope{}); err == nil {
+		t.Fatal("envelope without type/jobId accepted")
+	}
+}
+
+// memoryDrain runs one handler needing handlerTime, shuts the consumer down with drain timeout d
+// and reports whether the handler finished.
+func memoryDrain(t *testing.T, d, handlerTime time.Duration) (finished bool, deliveries int) {
+	t.Helper()
+	q := NewMemoryQueue("q")
+	c := NewMemoryConsumer(q)
+	c.SetConcurrency(2)
+	c.SetDrainTimeout(d)
+	var rec memoryRecorder
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 1)
+	result := make(chan bool, 1)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		_ = c.ConsumeLoop(ctx, func(hctx context.Context, env Envelope) error {
+			rec.add(env)
+			started <- struct{}{}
+			select {
+			case <-time.After(handlerTime):
+				result <- true
+				return nil
+			case <-hctx.Done():
+				result <- false
+				return hctx.Err()
+			}
+		})
+	}()
+	if err := q.Enqueue(context.Background(), "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not started")
+	}
+	cancel()
+	waitFor(t, "drain", func() bool { return !c.Ready() })
+	// Enqueued while draining: not started.
+	if err := q.Enqueue(context.Background(), "job_2"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-loopDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeLoop did not return")
+	}
+	select {
+	case finished = <-result:
+	default:
+		t.Fatal("ConsumeLoop returned before the handler")
+	}
+	if n := q.Len(); n != 1 {
+		t.Fatalf("%d messages queued after shutdown, want job_2 left", n)
+	}
+	return finished, rec.count()
+}
+
+func TestMemoryDrainWaitsForRunningHandler(t *testing.T) {
+	finished, n := memoryDrain(t, 5*time.Second, 100*time.Millisecond)
+	if !finished || n != 1 {
+		t.Fatalf("finished=%v deliveries=%d, want the running handler to finish and nothing new started", finished, n)
+	}
+}
+
+func TestMemoryDrainTimeoutCancelsHandler(t *testing.T) {
+	finished, n := memoryDrain(t, 50*time.Millisecond, 10*time.Second)
+	// Cut off: the message is lost with the process, not retried.
+	if finished || n != 1 {
+		t.Fatalf("finished=%v deliveries=%d, want the handler cancelled", finished, n)
+	}
+}
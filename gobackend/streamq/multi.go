@@ -0,0 +1,195 @@
+package streamq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lane is one stream read by a MultiConsumer.
+type Lane struct {
+	Stream string
+	// Weight is the lane's share of reads while several lanes have work (<=0 means 1).
+	Weight int
+}
+
+// MultiConsumer reads several streams (priority lanes) with one consumer group and a shared
+// handler concurrency limit.
+//
+// While lanes have backlog, messages are taken one at a time in a weighted round-robin order
+// (weights 3:1 -> three reads from the first lane per read from the second), so a full lane cannot
+// starve the others; each message is dispatched before the next read, so lanes are only drained as
+// fast as handler slots free up. When every lane is empty it blocks on all of them at once.
+type MultiConsumer struct {
+	rdb    *redis.Client
+	group  string
+	lanes  []*Consumer
+	order  []int
+	block  time.Duration
+	concur chan struct{}
+	state  *drainState
+}
+
+func NewMultiConsumer(rdb *redis.Client, group, consumer string, lanes []Lane) *MultiConsumer {
+	m := &MultiConsumer{rdb: rdb, group: strings.TrimSpace(group), block: 10 * time.Second, state: &drainState{}}
+	weights := make([]int, 0, len(lanes))
+	for _, l := range lanes {
+		c := NewConsumer(rdb, l.Stream, group, consumer)
+		c.state = m.state
+		m.lanes = append(m.lanes, c)
+		w := l.Weight
+		if w <= 0 {
+			w = 1
+		}
+		weights = append(weights, w)
+	}
+	if len(m.lanes) > 0 {
+		// Every lane shares one consumer name so XPENDING/XCLAIM ownership stays readable.
+		for _, c := range m.lanes[1:] {
+			c.consumer = m.lanes[0].consumer
+		}
+	}
+	m.order = weightedOrder(weights)
+	return m
+}
+
+// weightedOrder interleaves lane indexes by weight (smooth weighted round-robin):
+// weights [3,1] -> [0 0 1 0].
+func weightedOrder(weights []int) []int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	cur := make([]int, len(weights))
+	order := make([]int, 0, total)
+	for n := 0; n < total; n++ {
+		best := -1
+		for i, w := range weights {
+			cur[i] += w
+			if best < 0 || cur[i] > cur[best] {
+				best = i
+			}
+		}
+		cur[best] -= total
+		order = append(order, best)
+	}
+	return order
+}
+
+// SetConcurrency sets the max concurrent handler goroutines across all lanes.
+// n<=1 means run sequentially.
+func (m *MultiConsumer) SetConcurrency(n int) {
+	if m == nil {
+		return
+	}
+	m.concur = nil
+	if n > 1 {
+		m.concur = make(chan struct{}, n)
+	}
+	for _, c := range m.lanes {
+		c.concur = m.concur
+	}
+}
+
+// SetRetryPolicy applies p to every lane; each lane dead-letters to its own DeadLetterKey.
+func (m *MultiConsumer) SetRetryPolicy(p RetryPolicy) {
+	if m == nil {
+		return
+	}
+	for _, c := range m.lanes {
+		lp := p
+		lp.DeadLetter = ""
+		c.SetRetryPolicy(lp)
+	}
+}
+
+func (m *MultiConsumer) ConsumeLoop(ctx context.Context, handler Handler) error {
+	if m == nil || m.rdb == nil || len(m.lanes) == 0 {
+		return errors.New("consumer 未初始化")
+	}
+	if m.group == "" {
+		return errors.New("stream/group 为空")
+	}
+	for _, c := range m.lanes {
+		if c.stream == "" {
+			return errors.New("stream/group 为空")
+		}
+	}
+	if handler == nil {
+		return errors.New("handler 为空")
+	}
+	// Handlers run on hctx, which outlives ctx by the drain timeout.
+	hctx, finish := m.state.begin(ctx)
+	defer finish()
+	for _, c := range m.lanes {
+		go c.runMover(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		for _, c := range m.lanes {
+			c.maybeAutoClaim(hctx, handler)
+		}
+
+		got := false
+		for _, i := range m.order {
+			if ctx.Err() != nil {
+				break
+			}
+			// Block < 0: no BLOCK argument, returns immediately when the lane is empty.
+			if m.read(ctx, hctx, handler, []*Consumer{m.lanes[i]}, -1) {
+				got = true
+			}
+		}
+		if !got && ctx.Err() == nil {
+			m.read(ctx, hctx, handler, m.lanes, m.block)
+		}
+	}
+}
+
+// read takes at most one new message per given lane and dispatches it on hctx; it reports whether any arrived.
+func (m *MultiConsumer) read(ctx, hctx context.Context, handler Handler, lanes []*Consumer, block time.Duration) bool {
+	streams := make([]string, 0, len(lanes)*2)
+	byStream := make(map[string]*Consumer, len(lanes))
+	for _, c := range lanes {
+		streams = append(streams, c.stream)
+		byStream[c.stream] = c
+	}
+	for range lanes {
+		streams = append(streams, ">")
+	}
+	res, err := m.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    m.group,
+		Consumer: m.lanes[0].consumer,
+		Streams:  streams,
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+			log.Printf("stream consume error: %v", err)
+			time.Sleep(500 * time.Millisecond)
+		}
+		return false
+	}
+	got := false
+	for _, s := range res {
+		c := byStream[s.Stream]
+		if c == nil {
+			continue
+		}
+		for _, msg := range s.Messages {
+			got = true
+			c.deliver(ctx, hctx, handler, msg, 1)
+		}
+	}
+	return got
+}
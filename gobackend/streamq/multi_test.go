@@ -0,0 +1,109 @@
+package streamq
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeightedOrder(t *testing.T) {
+	cases := []struct {
+		weights []int
+		want    []int
+	}{
+		{[]int{1}, []int{0}},
+		{[]int{1, 1}, []int{0, 1}},
+		{[]int{3, 1}, []int{0, 0, 1, 0}},
+		{[]int{1, 3}, []int{1, 0, 1, 1}},
+		{[]int{2, 2, 1}, []int{0, 1, 2, 0, 1}},
+		{[]int{5, 1, 1}, []int{0, 0, 1, 0, 2, 0, 0}},
+	}
+	for _, tc := range cases {
+		got := weightedOrder(tc.weights)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("weightedOrder(%v) = %v, want %v", tc.weights, got, tc.want)
+		}
+		count := make([]int, len(tc.weights))
+		for _, i := range got {
+			count[i]++
+		}
+		if !reflect.DeepEqual(count, tc.weights) {
+			t.Errorf("weightedOrder(%v) picks lanes %v times", tc.weights, count)
+		}
+	}
+}
+
+func TestMultiConsumerWeightedLanes(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	small := NewRedisStreamQueue(rdb, "s", "g", 0)
+	large := NewRedisStreamQueue(rdb, "s:large", "g", 0)
+	for _, q := range []*RedisStreamQueue{small, large} {
+		if err := q.EnsureGroup(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both lanes have a backlog before the consumer starts.
+	for i := 0; i < 9; i++ {
+		if err := small.Enqueue(ctx, "small"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		if err := large.Enqueue(ctx, "large"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMultiConsumer(rdb, "g", "c1", []Lane{{Stream: "s", Weight: 3}, {Stream: "s:large", Weight: 1}})
+	m.block = 20 * time.Millisecond
+	var (
+		mu  sync.Mutex
+		seq []string
+	)
+	runConsumer(t, func(ctx context.Context) error {
+		return m.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			mu.Lock()
+			defer mu.Unlock()
+			seq = append(seq, env.JobID)
+			return nil
+		})
+	})
+	waitFor(t, "all messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seq) == 15
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	// 3:1 while both lanes have work, then the rest of the large lane.
+	want := "small small large small " +
+		"small small large small " +
+		"small small large small " +
+		"large large large"
+	if got := strings.Join(seq, " "); got != want {
+		t.Fatalf("order:\n got %s\nwant %s", got, want)
+	}
+	for _, s := range []string{"s", "s:large"} {
+		if p, _ := rdb.XPending(ctx, s, "g").Result(); p.Count != 0 {
+			t.Fatalf("%s: %d pending", s, p.Count)
+		}
+	}
+}
+
+func TestMultiConsumerRetryPolicyPerLane(t *testing.T) {
+	m := NewMultiConsumer(nil, "g", "c1", []Lane{{Stream: "s"}, {Stream: "s:large"}})
+	m.SetRetryPolicy(RetryPolicy{MaxDeliveries: 3, DeadLetter: "shared:dead"})
+	for _, c := range m.lanes {
+		if c.retry == nil || c.retry.MaxDeliveries != 3 || c.retry.DeadLetter != DeadLetterKey(c.stream) {
+			t.Fatalf("lane %s: retry = %+v", c.stream, c.retry)
+		}
+		if c.consumer != m.lanes[0].consumer {
+			t.Fatalf("lane %s reads as %q, want %q", c.stream, c.consumer, m.lanes[0].consumer)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package streamq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RetryPolicy bounds how often a failing (non-terminal) message is redelivered.
+//
+// Without a policy a pending message is auto-claimed again every claimMinIdle, forever. With one,
+// a failed delivery is ACKed and scheduled again after Delay(deliveries) through the delayed set
+// (see delay.go), and after MaxDeliveries failed deliveries the message is moved to the
+// dead-letter stream together with its last error. Deliveries that never reported back (worker
+// crashed) are found in XPENDING and re-claimed (or dead-lettered after MaxDeliveries) once idle
+// for the consumer's claim idle time; a handler that is still running keeps its message fresh
+// (heartbeat). The backoff only applies once a delivery has failed: an entry that was already
+// re-claimed after a crash waits at least Delay(deliveries) before the next claim.
+type RetryPolicy struct {
+	// MaxDeliveries is the delivery count (XPENDING) after which a failing message is dead-lettered.
+	// 0 means retry forever.
+	MaxDeliveries int64
+	// BaseDelay is the wait before the 2nd delivery; it doubles per delivery up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// DeadLetter is the dead-letter stream ("" = DeadLetterKey(stream)).
+	DeadLetter string
+}
+
+// Delay is the minimum idle time before a message delivered `deliveries` times is retried.
+func (p RetryPolicy) Delay(deliveries int64) time.Duration {
+	d := p.BaseDelay
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := int64(1); i < deliveries && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// DeadLetterKey is the default dead-letter stream of a stream.
+func DeadLetterKey(stream string) string {
+	return strings.TrimSpace(stream) + ":dead"
+}
+
+// SetRetryPolicy enables bounded retries and dead-lettering (see RetryPolicy).
+func (c *Consumer) SetRetryPolicy(p RetryPolicy) {
+	if c == nil {
+		return
+	}
+	if strings.TrimSpace(p.DeadLetter) == "" {
+		p.DeadLetter = DeadLetterKey(c.stream)
+	}
+	c.retry = &p
+	c.pendingStart = "-"
+}
+
+// claimDue re-claims pending messages whose backoff has elapsed (RetryPolicy mode).
+func (c *Consumer) claimDue(ctx context.Context, handler Handler) {
+	p := c.retry
+	pending, err := c.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.stream,
+		Group:  c.group,
+		Idle:   c.claimMinIdle,
+		Start:  c.pendingStart,
+		End:    "+",
+		Count:  c.claimCount,
+	}).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("xpending error: %v", err)
+		}
+		return
+	}
+	// Walk the PEL in pages so old not-yet-due messages don't hide newer due ones.
+	if int64(len(pending)) < c.claimCount {
+		c.pendingStart = "-"
+	} else {
+		c.pendingStart = "(" + pending[len(pending)-1].ID
+	}
+
+	for _, pe := range pending {
+		// Running handlers heartbeat their message, so only a delivery that stopped reporting back
+		// (worker crashed or was killed mid-run) gets this idle.
+		idle := c.claimIdle(pe.RetryCount)
+		if pe.Idle < idle {
+			continue
+		}
+		if p.MaxDeliveries > 0 && pe.RetryCount >= p.MaxDeliveries {
+			c.deadLetterByID(ctx, pe.ID, pe.RetryCount, errors.New("max deliveries reached without handler result"))
+			continue
+		}
+		// MinIdle makes the claim a no-op if another consumer claimed it meanwhile.
+		msgs, err := c.rdb.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   c.stream,
+			Group:    c.group,
+			Consumer: c.consumer,
+			MinIdle:  idle,
+			Messages: []string{pe.ID},
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				log.Printf("xclaim error msg=%s: %v", pe.ID, err)
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			c.dispatch(ctx, handler, msg, pe.RetryCount+1)
+		}
+	}
+}
+
+// claimIdle is how long a pending entry delivered `deliveries` times must be idle before it is
+// claimed: claimMinIdle for a first delivery (liveness only), and at least the retry backoff once
+// an earlier delivery of the entry was lost.
+func (c *Consumer) claimIdle(deliveries int64) time.Duration {
+	if deliveries <= 1 {
+		return c.claimMinIdle
+	}
+	return max(c.claimMinIdle, c.retry.Delay(deliveries))
+}
+
+// deadLetter moves a message to the dead-letter stream and ACKs it in one MULTI.
+func (c *Consumer) deadLetter(ctx context.Context, msg redis.XMessage, deliveries int64, cause error) {
+	values := make(map[string]interface{}, len(msg.Values)+len(deadLetterFields))
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	for k, v := range map[string]interface{}{
+		"stream":     c.stream,
+		"group":      c.group,
+		"msgId":      msg.ID,
+		"deliveries": deliveries,
+		"error":      truncateError(cause),
+		"deadAt":     time.Now().UnixMilli(),
+	} {
+		values[k] = v
+	}
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: c.retry.DeadLetter, MaxLen: deadLetterMaxLen, Approx: true, Values: values})
+		pipe.XAck(ctx, c.stream, c.group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("dead-letter failed msg=%s: %v (keep pending)", msg.ID, err)
+		return
+	}
+	log.Printf("dead-lettered msg=%s jobId=%v deliveries=%d stream=%s: %v", msg.ID, msg.Values["jobId"], deliveries, c.retry.DeadLetter, cause)
+}
+
+func (c *Consumer) deadLetterByID(ctx context.Context, id string, deliveries int64, cause error) {
+	msgs, err := c.rdb.XRangeN(ctx, c.stream, id, id, 1).Result()
+	if err != nil {
+		return
+	}
+	if len(msgs) == 0 {
+		// Trimmed from the stream: nothing left to keep.
+		_ = c.ack(ctx, id)
+		return
+	}
+	c.deadLetter(ctx, msgs[0], deliveries, cause)
+}
+
+const deadLetterMaxLen = 100000
+
+// deadLetterFields are added by deadLetter; Replay strips them (and the attempt and requeue
+// counts) to restore the original envelope.
+var deadLetterFields = []string{"stream", "group", "msgId", "deliveries", "error", "deadAt", attemptField, requeueField}
+
+func truncateError(err error) string {
+	if err == nil {
+		return ""
+	}
+	s := err.Error()
+	if len(s) > 1000 {
+		s = s[:1000]
+	}
+	return s
+}
+
+// DeadLetter is one dead-lettered message.
+type DeadLetter struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type,omitempty"`
+	JobID      string    `json:"jobId"`
+	Stream     string    `json:"stream"`
+	Group      string    `json:"group"`
+	MessageID  string    `json:"msgId"`
+	Deliveries int64     `json:"deliveries"`
+	Error      string    `json:"error"`
+	DeadAt     time.Time `json:"deadAt"`
+}
+
+// DeadLetterQueue reads and replays the dead-letter stream of one work stream.
+type DeadLetterQueue struct {
+	rdb    *redis.Client
+	key    string
+	stream string
+}
+
+func NewDeadLetterQueue(rdb *redis.Client, stream string) *DeadLetterQueue {
+	return &DeadLetterQueue{rdb: rdb, key: DeadLetterKey(stream), stream: strings.TrimSpace(stream)}
+}
+
+// List returns up to count entries, newest first, strictly older than cursor ("" = newest).
+// next is "" when there are no more entries.
+func (q *DeadLetterQueue) List(ctx context.Context, cursor string, count int64) ([]DeadLetter, string, error) {
+	if q == nil || q.rdb == nil {
+		return nil, "", errors.New("dead-letter queue 未初始化")
+	}
+	if count <= 0 || count > 500 {
+		count = 50
+	}
+	end := "+"
+	if c := strings.TrimSpace(cursor); c != "" {
+		end = "(" + c
+	}
+	msgs, err := q.rdb.XRevRangeN(ctx, q.key, end, "-", count).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	out := make([]DeadLetter, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, deadLetterFromMessage(m))
+	}
+	next := ""
+	if int64(len(msgs)) == count {
+		next = msgs[len(msgs)-1].ID
+	}
+	return out, next, nil
+}
+
+// Replay re-enqueues a dead-lettered message (its original envelope, attempts reset) on its
+// original stream and removes the entry.
+func (q *DeadLetterQueue) Replay(ctx context.Context, id string) (string, error) {
+	if q == nil || q.rdb == nil {
+		return "", errors.New("dead-letter queue 未初始化")
+	}
+	id = strings.TrimSpace(id)
+	msgs, err := q.rdb.XRangeN(ctx, q.key, id, id, 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "", ErrDeadLetterNotFound
+	}
+	dl := deadLetterFromMessage(msgs[0])
+	if dl.JobID == "" && dl.Type == "" {
+		return "", fmt.Errorf("dead-letter %s 缺少 type/jobId", id)
+	}
+	values := make(map[string]interface{}, len(msgs[0].Values))
+	for k, v := range msgs[0].Values {
+		values[k] = v
+	}
+	for _, k := range deadLetterFields {
+		delete(values, k)
+	}
+	_, err = q.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: q.stream, MaxLen: streamMaxLen, Approx: true, Values: values})
+		pipe.XDel(ctx, q.key, id)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return dl.JobID, nil
+}
+
+var ErrDeadLetterNotFound = errors.New("dead-letter 不存在")
+
+func deadLetterFromMessage(m redis.XMessage) DeadLetter {
+	str := func(k string) string {
+		if v, ok := m.Values[k]; ok {
+			return fmt.Sprintf("%v", v)
+		}
+		return ""
+	}
+	deliveries, _ := strconv.ParseInt(str("deliveries"), 10, 64)
+	deadAt, _ := strconv.ParseInt(str("deadAt"), 10, 64)
+	return DeadLetter{
+		ID:         m.ID,
+		Type:       str("type"),
+		JobID:      str("jobId"),
+		Stream:     str("stream"),
+		Group:      str("group"),
+		MessageID:  str("msgId"),
+		Deliveries: deliveries,
+		Error:      str("error"),
+		DeadAt:     time.UnixMilli(deadAt),
+	}
+}
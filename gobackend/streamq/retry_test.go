@@ -0,0 +1,326 @@
+package streamq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb
+}
+
+// newTestConsumer returns a consumer on stream (group "g") with timings short enough for tests.
+func newTestConsumer(t *testing.T, rdb *redis.Client, stream string) *Consumer {
+	t.Helper()
+	q := NewRedisStreamQueue(rdb, stream, "g", 0)
+	if err := q.EnsureGroup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := NewConsumer(rdb, stream, "g", "c1")
+	c.block = 20 * time.Millisecond
+	c.claimEvery = 10 * time.Millisecond
+	c.moveEvery = 10 * time.Millisecond
+	return c
+}
+
+// runConsumer runs loop until the test ends.
+func runConsumer(t *testing.T, loop func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = loop(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	cases := []struct {
+		p          RetryPolicy
+		deliveries int64
+		want       time.Duration
+	}{
+		{RetryPolicy{}, 1, time.Second},
+		{RetryPolicy{}, 4, 8 * time.Second},
+		{RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}, 0, 30 * time.Second},
+		{RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}, 1, 30 * time.Second},
+		{RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}, 2, time.Minute},
+		{RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}, 5, 8 * time.Minute},
+		{RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}, 6, 10 * time.Minute},
+		{RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}, 1000, 10 * time.Minute},
+		// MaxDelay below BaseDelay caps the first wait too.
+		{RetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Second}, 1, 10 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := tc.p.Delay(tc.deliveries); got != tc.want {
+			t.Errorf("%+v Delay(%d) = %s, want %s", tc.p, tc.deliveries, got, tc.want)
+		}
+	}
+}
+
+func TestDeadLetterAfterMaxDeliveries(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond})
+
+	var (
+		mu       sync.Mutex
+		attempts []int64
+		fail     = true
+	)
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts = append(attempts, env.Attempt)
+			if fail {
+				return errors.New("boom")
+			}
+			return nil
+		})
+	})
+	q := NewRedisStreamQueue(rdb, "s", "g", 0)
+	q.SetMessageType("compare.run")
+	if err := q.Enqueue(ctx, "job_1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dlq := NewDeadLetterQueue(rdb, "s")
+	var dead []DeadLetter
+	waitFor(t, "dead letter", func() bool {
+		dead, _, _ = dlq.List(ctx, "", 10)
+		return len(dead) == 1
+	})
+	mu.Lock()
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 2 || attempts[2] != 3 {
+		t.Fatalf("attempts = %v, want [1 2 3]", attempts)
+	}
+	fail = false
+	mu.Unlock()
+
+	dl := dead[0]
+	if dl.JobID != "job_1" || dl.Type != "compare.run" || dl.Deliveries != 3 || dl.Error != "boom" || dl.Stream != "s" {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+	if n, _ := rdb.ZCard(ctx, DelayedKey("s")).Result(); n != 0 {
+		t.Fatalf("%d messages still scheduled", n)
+	}
+	if p, _ := rdb.XPending(ctx, "s", "g").Result(); p.Count != 0 {
+		t.Fatalf("%d messages still pending", p.Count)
+	}
+
+	// Replay puts the original envelope back with a fresh delivery count.
+	jobID, err := dlq.Replay(ctx, dl.ID)
+	if err != nil || jobID != "job_1" {
+		t.Fatalf("replay: job=%q err=%v", jobID, err)
+	}
+	waitFor(t, "replayed delivery", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) == 4
+	})
+	mu.Lock()
+	if attempts[3] != 1 {
+		t.Fatalf("replayed attempt = %d, want 1", attempts[3])
+	}
+	mu.Unlock()
+	if dead, _, _ := dlq.List(ctx, "", 10); len(dead) != 0 {
+		t.Fatalf("replayed entry still listed: %+v", dead)
+	}
+	if _, err := dlq.Replay(ctx, dl.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("second replay: %v", err)
+	}
+}
+
+func TestDeadLetterQueueListPages(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	for _, job := range []string{"j1", "j2", "j3"} {
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: DeadLetterKey("s"), Values: map[string]interface{}{
+			"type": "compare.run", "jobId": job, "deliveries": 5, "error": "boom", "deadAt": time.Now().UnixMilli(),
+		}}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dlq := NewDeadLetterQueue(rdb, "s")
+	var got []string
+	cursor := ""
+	for i := 0; ; i++ {
+		if i > 3 {
+			t.Fatal("cursor does not advance")
+		}
+		page, next, err := dlq.List(ctx, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dl := range page {
+			got = append(got, dl.JobID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(got) != 3 || got[0] != "j3" || got[2] != "j1" {
+		t.Fatalf("listed %v, want newest first", got)
+	}
+}
+
+func TestClaimDueWaitsForBackoffBeforeDeadLetter(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	c.claimMinIdle = time.Second
+	p := RetryPolicy{MaxDeliveries: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	c.SetRetryPolicy(p)
+
+	t0 := time.Now()
+	mr.SetTime(t0)
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: "s", Values: map[string]interface{}{"type": "t", "jobId": "job_1"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	// A worker that took the 3rd delivery and stopped reporting back.
+	res, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "gone", Streams: []string{"s", ">"}, Count: 1}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res[0].Messages[0].ID
+	if err := rdb.Do(ctx, "XCLAIM", "s", "g", "gone", 0, id, "RETRYCOUNT", 3).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	handled := 0
+	handler := func(ctx context.Context, env Envelope) error { handled++; return nil }
+
+	// Idle past claimMinIdle but not past Delay(3): its earlier deliveries were lost, so it backs off.
+	mr.SetTime(t0.Add(p.Delay(3) - time.Millisecond))
+	c.claimDue(ctx, handler)
+	if n, _ := rdb.XLen(ctx, DeadLetterKey("s")).Result(); n != 0 {
+		t.Fatal("dead-lettered before its backoff elapsed")
+	}
+	if pe, _ := rdb.XPending(ctx, "s", "g").Result(); pe.Count != 1 {
+		t.Fatalf("pending = %d, want 1", pe.Count)
+	}
+
+	mr.SetTime(t0.Add(p.Delay(3)))
+	c.claimDue(ctx, handler)
+	if n, _ := rdb.XLen(ctx, DeadLetterKey("s")).Result(); n != 1 {
+		t.Fatal("not dead-lettered after MaxDeliveries and backoff")
+	}
+	if pe, _ := rdb.XPending(ctx, "s", "g").Result(); pe.Count != 0 || handled != 0 {
+		t.Fatalf("pending = %d handled = %d, want 0 / 0", pe.Count, handled)
+	}
+}
+
+func TestHeartbeatKeepsSlowDeliveryAlive(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	c.SetConcurrency(2) // the loop keeps claiming while the handler runs
+	// claimMinIdle = 60ms: without a heartbeat the running delivery would be dead-lettered.
+	c.claimMinIdle = 60 * time.Millisecond
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 1, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	var (
+		mu    sync.Mutex
+		calls int
+		done  bool
+	)
+	runConsumer(t, func(ctx context.Context) error {
+		return c.ConsumeLoop(ctx, func(ctx context.Context, env Envelope) error {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			time.Sleep(400 * time.Millisecond)
+			mu.Lock()
+			done = true
+			mu.Unlock()
+			return nil
+		})
+	})
+	if err := NewRedisStreamQueue(rdb, "s", "g", 0).Enqueue(ctx, "job_1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "handler", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return done
+	})
+	waitFor(t, "ack", func() bool {
+		pe, _ := rdb.XPending(ctx, "s", "g").Result()
+		return pe != nil && pe.Count == 0
+	})
+	if n, _ := rdb.XLen(ctx, DeadLetterKey("s")).Result(); n != 0 {
+		t.Fatal("slow delivery was dead-lettered while running")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+}
+
+// Crash detection uses claimMinIdle, however long the retry backoff is.
+func TestClaimDueReclaimsLostDeliveryAfterClaimIdle(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	ctx := context.Background()
+	c := newTestConsumer(t, rdb, "s")
+	c.claimMinIdle = time.Second
+	c.SetRetryPolicy(RetryPolicy{MaxDeliveries: 5, BaseDelay: 10 * time.Minute, MaxDelay: time.Hour})
+
+	t0 := time.Now()
+	mr.SetTime(t0)
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: "s", Values: map[string]interface{}{"type": "t", "jobId": "job_1"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	// First delivery taken by a worker that died.
+	if err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "gone", Streams: []string{"s", ">"}, Count: 1}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts []int64
+	handler := func(ctx context.Context, env Envelope) error {
+		attempts = append(attempts, env.Attempt)
+		return nil
+	}
+	mr.SetTime(t0.Add(c.claimMinIdle - time.Millisecond))
+	c.claimDue(ctx, handler)
+	if len(attempts) != 0 {
+		t.Fatal("claimed a delivery that may still be running")
+	}
+
+	mr.SetTime(t0.Add(c.claimMinIdle))
+	c.claimDue(ctx, handler)
+	if len(attempts) != 1 || attempts[0] != 2 {
+		t.Fatalf("attempts = %v, want the lost delivery re-run as attempt 2", attempts)
+	}
+	if pe, _ := rdb.XPending(ctx, "s", "g").Result(); pe.Count != 0 {
+		t.Fatalf("pending = %d, want 0", pe.Count)
+	}
+}
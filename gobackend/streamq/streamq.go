@@ -10,8 +10,14 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("gobackend/streamq")
+
 // Terminal marks an error as "terminal": the message should be ACKed even if err != nil.
 // This matches the current product behavior: failed jobs are recorded in job store and we don't want automatic retries.
 type TerminalError struct{ Err error }
@@ -32,6 +38,31 @@ func IsTerminal(err error) bool {
 	return errors.As(err, &te)
 }
 
+// Requeue marks an error as "try again later without counting a failed delivery": the consumer
+// ACKs the current delivery and re-adds the message after requeueDelay (via the delayed set), e.g.
+// for throttling by a per-user concurrency cap. The wait doubles with every requeue of the same
+// message, so a message that stays throttled doesn't cycle through the stream every second.
+type RequeueError struct{ Err error }
+
+func (e RequeueError) Error() string {
+	if e.Err == nil {
+		return "requeue"
+	}
+	return e.Err.Error()
+}
+
+func (e RequeueError) Unwrap() error { return e.Err }
+
+func Requeue(err error) error { return RequeueError{Err: err} }
+
+func IsRequeue(err error) bool {
+	var re RequeueError
+	return errors.As(err, &re)
+}
+
+// streamMaxLen caps streams on re-adds made by the consumer itself (approximate MAXLEN).
+const streamMaxLen = 100000
+
 type CompareQueue interface {
 	Enqueue(ctx context.Context, jobID string) error
 }
@@ -41,6 +72,8 @@ type RedisStreamQueue struct {
 	stream string
 	group  string
 	maxLen int64
+	// msgType is the Envelope.Type written by Enqueue (SetMessageType).
+	msgType string
 }
 
 func NewRedisStreamQueue(rdb *redis.Client, stream, group string, maxLen int64) *RedisStreamQueue {
@@ -55,29 +88,51 @@ func NewRedisStreamQueue(rdb *redis.Client, stream, group string, maxLen int64)
 	}
 }
 
-func (q *RedisStreamQueue) Enqueue(ctx context.Context, jobID string) error {
-	if q == nil || q.rdb == nil {
-		return errors.New("redis stream queue 未初始化")
+// SetMessageType sets the Envelope.Type of messages added with Enqueue / EnqueueAfter.
+func (q *RedisStreamQueue) SetMessageType(t string) {
+	if q == nil {
+		return
 	}
+	q.msgType = strings.TrimSpace(t)
+}
+
+// Enqueue publishes an envelope of the queue's message type for jobID.
+func (q *RedisStreamQueue) Enqueue(ctx context.Context, jobID string) error {
 	jobID = strings.TrimSpace(jobID)
 	if jobID == "" {
 		return errors.New("jobID 为空")
 	}
+	return q.Publish(ctx, Envelope{Type: q.messageType(), JobID: jobID})
+}
+
+// Publish adds env to the stream; EnqueuedAt and the trace context are taken from now / ctx when unset.
+func (q *RedisStreamQueue) Publish(ctx context.Context, env Envelope) error {
+	if q == nil || q.rdb == nil {
+		return errors.New("redis stream queue 未初始化")
+	}
 	stream := strings.TrimSpace(q.stream)
 	if stream == "" {
 		return errors.New("stream key 为空")
 	}
+	if strings.TrimSpace(env.Type) == "" && strings.TrimSpace(env.JobID) == "" {
+		return errors.New("消息 type/jobId 均为空")
+	}
 	args := &redis.XAddArgs{
 		Stream: stream,
 		MaxLen: q.maxLen,
 		Approx: true,
-		Values: map[string]interface{}{
-			"jobId": jobID,
-		},
+		Values: env.Stamp(ctx).Values(),
 	}
 	return q.rdb.XAdd(ctx, args).Err()
 }
 
+func (q *RedisStreamQueue) messageType() string {
+	if q == nil {
+		return ""
+	}
+	return q.msgType
+}
+
 func (q *RedisStreamQueue) EnsureGroup(ctx context.Context) error {
 	if q == nil || q.rdb == nil {
 		return errors.New("redis stream queue 未初始化")
@@ -99,7 +154,8 @@ func (q *RedisStreamQueue) EnsureGroup(ctx context.Context) error {
 	return err
 }
 
-type Handler func(ctx context.Context, jobID string) error
+// Handler processes one envelope. ctx carries the producer's trace context and a consumer span.
+type Handler func(ctx context.Context, env Envelope) error
 
 type Consumer struct {
 	rdb      *redis.Client
@@ -116,6 +172,16 @@ type Consumer struct {
 	claimStart      string
 	claimEvery      time.Duration
 	lastClaimedTime time.Time
+
+	// Optional bounded retries (SetRetryPolicy); nil keeps plain XAUTOCLAIM.
+	retry        *RetryPolicy
+	pendingStart string
+
+	// Graceful shutdown / readiness (SetDrainTimeout, Ready).
+	state *drainState
+
+	// How often due delayed messages are moved into the stream (see delay.go).
+	moveEvery time.Duration
 }
 
 func NewConsumer(rdb *redis.Client, stream, group, consumer string) *Consumer {
@@ -135,6 +201,9 @@ func NewConsumer(rdb *redis.Client, stream, group, consumer string) *Consumer {
 		claimCount:   50,
 		claimStart:   "0-0",
 		claimEvery:   3 * time.Second,
+
+		state:     &drainState{},
+		moveEvery: time.Second,
 	}
 }
 
@@ -161,6 +230,10 @@ func (c *Consumer) ConsumeLoop(ctx context.Context, handler Handler) error {
 	if handler == nil {
 		return errors.New("handler 为空")
 	}
+	// Handlers run on hctx, which outlives ctx by the drain timeout.
+	hctx, finish := c.state.begin(ctx)
+	defer finish()
+	go c.runMover(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -169,7 +242,7 @@ func (c *Consumer) ConsumeLoop(ctx context.Context, handler Handler) error {
 		}
 
 		// Best-effort: auto-claim pending messages (worker crash/restart).
-		c.maybeAutoClaim(ctx, handler)
+		c.maybeAutoClaim(hctx, handler)
 
 		res, err := c.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    c.group,
@@ -190,20 +263,29 @@ func (c *Consumer) ConsumeLoop(ctx context.Context, handler Handler) error {
 		}
 		for _, s := range res {
 			for _, msg := range s.Messages {
-				if c.concur == nil {
-					c.handleOne(ctx, handler, msg)
-					continue
-				}
-				c.concur <- struct{}{}
-				go func(m redis.XMessage) {
-					defer func() { <-c.concur }()
-					c.handleOne(ctx, handler, m)
-				}(msg)
+				c.deliver(ctx, hctx, handler, msg, 1)
 			}
 		}
 	}
 }
 
+// dispatch runs handleOne inline or on a goroutine bounded by the concurrency limit.
+// deliveries is the message's delivery count (0 = unknown).
+func (c *Consumer) dispatch(ctx context.Context, handler Handler, msg redis.XMessage, deliveries int64) {
+	c.state.inflight.Add(1)
+	if c.concur == nil {
+		defer c.state.inflight.Done()
+		c.handleOne(ctx, handler, msg, deliveries)
+		return
+	}
+	c.concur <- struct{}{}
+	go func(m redis.XMessage) {
+		defer c.state.inflight.Done()
+		defer func() { <-c.concur }()
+		c.handleOne(ctx, handler, m, deliveries)
+	}(msg)
+}
+
 func (c *Consumer) ack(ctx context.Context, id string) error {
 	if strings.TrimSpace(id) == "" {
 		return nil
@@ -211,38 +293,177 @@ func (c *Consumer) ack(ctx context.Context, id string) error {
 	return c.rdb.XAck(ctx, c.stream, c.group, id).Err()
 }
 
-func (c *Consumer) handleOne(ctx context.Context, handler Handler, msg redis.XMessage) {
-	jobID, ok := msg.Values["jobId"]
-	if !ok {
+func (c *Consumer) handleOne(ctx context.Context, handler Handler, msg redis.XMessage, deliveries int64) {
+	env := envelopeFromMessage(msg)
+	if env.Type == "" && env.JobID == "" {
+		log.Printf("drop malformed msg=%s stream=%s: no type/jobId", msg.ID, c.stream)
 		_ = c.ack(ctx, msg.ID)
 		return
 	}
-	jid := strings.TrimSpace(fmt.Sprintf("%v", jobID))
-	if jid == "" {
-		_ = c.ack(ctx, msg.ID)
-		return
+	jid := env.JobID
+	if deliveries > 0 {
+		// Deliveries before a delayed retry are carried on the message.
+		deliveries += priorAttempts(msg)
 	}
+	env.Attempt = deliveries
 
-	var err error
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("handler panic msg=%s jobId=%s: %v", msg.ID, jid, r)
-				// treat panic as terminal to avoid hot-looping on poison message; job status should be persisted by handler.
-				err = Terminal(fmt.Errorf("panic: %v", r))
-			}
-		}()
-		err = handler(ctx, jid)
-	}()
+	stopHeartbeat := c.heartbeat(ctx, msg.ID)
+	err := runHandler(ctx, handler, env, "redis", c.stream)
+	stopHeartbeat()
+
+	// ctx may be cancelled by now (shutdown); the result must still be recorded.
+	opCtx := context.WithoutCancel(ctx)
+	var later RetryAfterError
 
 	// ACK rules:
 	// - nil or Terminal(err): always ACK
+	// - Requeue(err): re-add after requeueDelay (delayed set), ACK; not counted as a delivery
+	// - cut off by shutdown: release (append a copy carrying the attempt count, ACK) for another
+	//   worker, unless that was the last allowed delivery under a RetryPolicy
+	// - RetryAfter(err, d): re-add after d, ACK; not counted as a delivery
+	// - last allowed delivery under a RetryPolicy: move to the dead-letter stream
+	// - other errors under a RetryPolicy: re-add after RetryPolicy.Delay, ACK
 	// - otherwise: keep pending (will be auto-claimed later)
-	if err == nil || IsTerminal(err) {
-		_ = c.ack(ctx, msg.ID)
-	} else {
-		log.Printf("handler non-terminal error msg=%s jobId=%s: %v (keep pending)", msg.ID, jid, err)
+	switch {
+	case err == nil || IsTerminal(err):
+		_ = c.ack(opCtx, msg.ID)
+	case IsRequeue(err):
+		c.requeue(opCtx, msg, err)
+	case ctx.Err() != nil && !c.lastDelivery(deliveries):
+		if c.readd(opCtx, msg, deliveries) == nil {
+			log.Printf("released msg=%s jobId=%s on shutdown", msg.ID, jid)
+		}
+	case errors.As(err, &later):
+		c.retryLater(opCtx, msg, max(deliveries-1, 0), later.Delay, err)
+	case c.lastDelivery(deliveries):
+		c.deadLetter(opCtx, msg, deliveries, err)
+	case c.retry != nil:
+		c.retryLater(opCtx, msg, deliveries, c.retry.Delay(deliveries), err)
+	default:
+		log.Printf("handler non-terminal error msg=%s jobId=%s delivery=%d: %v (keep pending)", msg.ID, jid, deliveries, err)
+	}
+}
+
+// heartbeat keeps a running delivery from going idle: every third of claimMinIdle it re-claims id
+// for this consumer with XCLAIM JUSTID, which resets the idle time without counting a delivery. A
+// slow handler is thus neither claimed by another worker nor dead-lettered while it runs. The
+// returned func stops the heartbeat.
+func (c *Consumer) heartbeat(ctx context.Context, id string) func() {
+	every := c.claimMinIdle / 3
+	if every <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				err := c.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+					Stream:   c.stream,
+					Group:    c.group,
+					Consumer: c.consumer,
+					Messages: []string{id},
+				}).Err()
+				if err != nil && !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+					log.Printf("heartbeat failed msg=%s: %v", id, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// runHandler runs handler for env inside a consumer span (parented to the producer's trace). A
+// panic becomes a Terminal error so a poison message can't hot-loop.
+func runHandler(ctx context.Context, handler Handler, env Envelope, system, dest string) (err error) {
+	spanCtx, span := tracer.Start(env.Context(ctx), "consume "+env.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", dest),
+			attribute.String("messaging.message.id", env.ID),
+			attribute.String("gy.job_id", env.JobID),
+			attribute.Int64("gy.attempt", env.Attempt),
+		))
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("handler panic msg=%s jobId=%s: %v", env.ID, env.JobID, r)
+			// treat panic as terminal to avoid hot-looping on poison message; job status should be persisted by handler.
+			err = Terminal(fmt.Errorf("panic: %v", r))
+		}
+		// Terminal(nil) is a successful "nothing to do".
+		if err != nil && (!IsTerminal(err) || errors.Unwrap(err) != nil) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	return handler(spanCtx, env)
+}
+
+// requeuePause is the first requeue wait; it doubles per requeue up to maxRequeuePause.
+const (
+	requeuePause    = time.Second
+	maxRequeuePause = 30 * time.Second
+)
+
+// requeueField counts the requeues of a message (Envelope.Requeues).
+const requeueField = "requeues"
+
+// requeueDelay is the wait before retrying a message requeued `requeues` times before.
+func requeueDelay(requeues int64) time.Duration {
+	d := requeuePause
+	for i := int64(0); i < requeues && d < maxRequeuePause; i++ {
+		d *= 2
 	}
+	return min(d, maxRequeuePause)
+}
+
+func (c *Consumer) requeue(ctx context.Context, msg redis.XMessage, cause error) {
+	requeues := envelopeFromMessage(msg).Requeues
+	values := make(map[string]interface{}, len(msg.Values)+1)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[requeueField] = requeues + 1
+	delay := requeueDelay(requeues)
+	if c.schedule(ctx, msg, values, delay) != nil {
+		return
+	}
+	log.Printf("requeued msg=%s jobId=%v in %s: %v", msg.ID, msg.Values["jobId"], delay, cause)
+}
+
+// lastDelivery reports whether the RetryPolicy allows no delivery after this one.
+func (c *Consumer) lastDelivery(deliveries int64) bool {
+	return c.retry != nil && c.retry.MaxDeliveries > 0 && deliveries >= c.retry.MaxDeliveries
+}
+
+// readd appends a copy of msg to the stream tail and ACKs the original in one MULTI. The copy
+// carries deliveries in attemptField, so deliveries cut off by repeated shutdowns still count
+// towards MaxDeliveries.
+func (c *Consumer) readd(ctx context.Context, msg redis.XMessage, deliveries int64) error {
+	values := make(map[string]interface{}, len(msg.Values)+1)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	if deliveries > 0 {
+		values[attemptField] = deliveries
+	}
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: c.stream, MaxLen: streamMaxLen, Approx: true, Values: values})
+		pipe.XAck(ctx, c.stream, c.group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("re-add failed msg=%s: %v (keep pending)", msg.ID, err)
+	}
+	return err
 }
 
 func (c *Consumer) maybeAutoClaim(ctx context.Context, handler Handler) {
@@ -258,6 +479,11 @@ func (c *Consumer) maybeAutoClaim(ctx context.Context, handler Handler) {
 	}
 	c.lastClaimedTime = now
 
+	if c.retry != nil {
+		c.claimDue(ctx, handler)
+		return
+	}
+
 	// If redis doesn't support XAUTOCLAIM, it will error; we just skip (keeps backward compatibility).
 	msgs, nextStart, err := c.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
 		Stream:   c.stream,
@@ -278,14 +504,6 @@ func (c *Consumer) maybeAutoClaim(ctx context.Context, handler Handler) {
 		c.claimStart = nextStart
 	}
 	for _, msg := range msgs {
-		if c.concur == nil {
-			c.handleOne(ctx, handler, msg)
-			continue
-		}
-		c.concur <- struct{}{}
-		go func(m redis.XMessage) {
-			defer func() { <-c.concur }()
-			c.handleOne(ctx, handler, m)
-		}(msg)
+		c.dispatch(ctx, handler, msg, 0)
 	}
 }
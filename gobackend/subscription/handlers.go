@@ -0,0 +1,140 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gobackend/auth"
+)
+
+// RegisterRoutes serves the plan API; all but the plan list need a logged-in user.
+//
+//	GET  /subscription/plans         -> plans for sale
+//	GET  /subscription               -> current plan, usage this period and renewal date
+//	POST /subscription/orders        -> {planId} opens a WeChat Native order (code_url) for one month
+//	GET  /subscription/orders/{id}   -> plan order and whether it has been paid
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/subscription/plans", s.handlePlans)
+	mux.HandleFunc("/subscription", s.handleStatus)
+	mux.HandleFunc("/subscription/orders", s.handleCreateOrder)
+	mux.HandleFunc("/subscription/orders/", s.handleGetOrder)
+}
+
+// requireUser returns the logged-in user, or writes the error response and returns "".
+func requireUser(w http.ResponseWriter, r *http.Request, method string) string {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return ""
+	}
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return ""
+	}
+	uid := auth.UserID(r.Context())
+	if uid == "" {
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+	}
+	return uid
+}
+
+func (s *Service) handlePlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"plans": s.Plans()})
+}
+
+func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+	uid := requireUser(w, r, http.MethodGet)
+	if uid == "" {
+		return
+	}
+	st, err := s.Status(uid)
+	if err != nil {
+		log.Printf("subscription: status user=%s: %v", uid, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if st == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active":      true,
+		"plan":        st.Plan,
+		"startedAt":   st.Subscription.StartedAt,
+		"periodStart": st.PeriodStart,
+		"renewsAt":    st.PeriodEnd,
+		"expiresAt":   st.Subscription.ExpiresAt,
+		"usage": map[string]int64{
+			"jobs":     st.Usage.Jobs,
+			"rows":     st.Usage.Rows,
+			"jobQuota": st.Plan.JobQuota,
+			"rowQuota": st.Plan.RowQuota,
+		},
+	})
+}
+
+func (s *Service) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	uid := requireUser(w, r, http.MethodPost)
+	if uid == "" {
+		return
+	}
+	var req struct {
+		PlanID string `json:"planId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	o, err := s.CreateOrder(uid, req.PlanID)
+	if err != nil {
+		if errors.Is(err, ErrUnknownPlan) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("subscription: create order user=%s: %v", uid, err)
+		http.Error(w, "创建微信支付订单失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Service) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	uid := requireUser(w, r, http.MethodGet)
+	if uid == "" {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/subscription/orders/"), "/")
+	o, applied, ok, err := s.Order(id)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || o.UserID != uid {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"orderId":   o.ID,
+		"planId":    o.PlanID,
+		"amountFen": o.AmountFen,
+		"code_url":  o.CodeURL,
+		"paid":      applied,
+		"createdAt": o.CreatedAt,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
@@ -0,0 +1,106 @@
+package subscription
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gobackend/auth"
+	"gobackend/store"
+)
+
+// serveAs runs the request as user (anonymous if "").
+func serveAs(mux *http.ServeMux, user, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if user != "" {
+		r = r.WithContext(auth.WithUserID(r.Context(), user))
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestHandlersNeedLogin(t *testing.T) {
+	s, _ := newTestService(t, store.NewInMemorySubscriptionStore(), "basic=2900:1:0")
+	o, err := s.CreateOrder("u1", "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	if rec := serveAs(mux, "", http.MethodGet, "/subscription/plans", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"basic"`) {
+		t.Fatalf("anonymous plans: %d %s", rec.Code, rec.Body)
+	}
+	for _, req := range []struct{ method, target, body string }{
+		{http.MethodGet, "/subscription", ""},
+		{http.MethodPost, "/subscription/orders", `{"planId":"basic"}`},
+		{http.MethodGet, "/subscription/orders/" + o.ID, ""},
+	} {
+		if rec := serveAs(mux, "", req.method, req.target, req.body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: %d, want 401", req.method, req.target, rec.Code)
+		}
+		if rec := serveAs(mux, "", http.MethodOptions, req.target, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("preflight %s: %d, want 204", req.target, rec.Code)
+		}
+		if rec := serveAs(mux, "u1", http.MethodDelete, req.target, ""); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("DELETE %s: %d, want 405", req.target, rec.Code)
+		}
+	}
+}
+
+func TestOrderHandlers(t *testing.T) {
+	s, _ := newTestService(t, store.NewInMemorySubscriptionStore(), "basic=2900:1:0")
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	rec := serveAs(mux, "u1", http.MethodPost, "/subscription/orders", `{"planId":"basic"}`)
+	var o struct {
+		ID        string `json:"orderId"`
+		AmountFen int64  `json:"amountFen"`
+		CodeURL   string `json:"code_url"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &o) != nil || o.ID == "" || o.AmountFen != 2900 || o.CodeURL == "" {
+		t.Fatalf("create order: %d %s", rec.Code, rec.Body)
+	}
+	for body, want := range map[string]int{`{"planId":"gold"}`: http.StatusBadRequest, `not json`: http.StatusBadRequest} {
+		if rec := serveAs(mux, "u1", http.MethodPost, "/subscription/orders", body); rec.Code != want {
+			t.Errorf("create order %s: %d, want %d", body, rec.Code, want)
+		}
+	}
+
+	// Only the buyer sees the order; others can't tell it exists.
+	for _, id := range []string{o.ID, "plan_unknown"} {
+		if rec := serveAs(mux, "u2", http.MethodGet, "/subscription/orders/"+id, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("u2 GET order %s: %d, want 404", id, rec.Code)
+		}
+	}
+	if rec := serveAs(mux, "u1", http.MethodGet, "/subscription/orders/"+o.ID, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"paid":false`) {
+		t.Fatalf("u1 GET order: %d %s", rec.Code, rec.Body)
+	}
+	if err := s.ApplyOrder(o.ID, o.AmountFen); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serveAs(mux, "u1", http.MethodGet, "/subscription/orders/"+o.ID, ""); !strings.Contains(rec.Body.String(), `"paid":true`) {
+		t.Fatalf("u1 GET paid order: %d %s", rec.Code, rec.Body)
+	}
+
+	// The plan is u1's alone.
+	if rec := serveAs(mux, "u2", http.MethodGet, "/subscription", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"active":false`) {
+		t.Fatalf("u2 status: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := s.Cover(jobOf("u1", "j1", 10)); err != nil {
+		t.Fatal(err)
+	}
+	rec = serveAs(mux, "u1", http.MethodGet, "/subscription", "")
+	var status struct {
+		Active bool             `json:"active"`
+		Usage  map[string]int64 `json:"usage"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &status) != nil || !status.Active ||
+		status.Usage["jobs"] != 1 || status.Usage["rows"] != 10 || status.Usage["jobQuota"] != 1 {
+		t.Fatalf("u1 status: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,220 @@
+package subscription
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/wechat"
+)
+
+// Service sells monthly plans (WeChat Native Pay) and lets the paygate release a subscriber's
+// jobs against the plan's quotas instead of asking for payment.
+type Service struct {
+	store store.SubscriptionStore
+	plans map[string]domain.SubscriptionPlan
+	now   func() time.Time
+}
+
+var ErrUnknownPlan = errors.New("套餐不存在")
+
+// New reads the plans from SUBSCRIPTION_PLANS: "ID=PRICE_FEN:JOBS:ROWS[:名称]" separated by
+// commas, quotas per month with 0 = unlimited (e.g. "basic=2900:100:200000:基础版,pro=9900:0:0").
+func New(st store.SubscriptionStore) *Service {
+	return &Service{store: st, plans: ParsePlans(os.Getenv("SUBSCRIPTION_PLANS")), now: time.Now}
+}
+
+// ParsePlans parses SUBSCRIPTION_PLANS; malformed entries are logged and skipped.
+func ParsePlans(raw string) map[string]domain.SubscriptionPlan {
+	out := make(map[string]domain.SubscriptionPlan)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		p, err := parsePlan(item)
+		if err != nil {
+			log.Printf("subscription: skip plan %q: %v", item, err)
+			continue
+		}
+		out[p.ID] = p
+	}
+	return out
+}
+
+func parsePlan(item string) (domain.SubscriptionPlan, error) {
+	id, val, ok := strings.Cut(item, "=")
+	p := domain.SubscriptionPlan{ID: strings.TrimSpace(id)}
+	if !ok || p.ID == "" {
+		return p, errors.New("want ID=PRICE_FEN:JOBS:ROWS[:NAME]")
+	}
+	fields := strings.SplitN(val, ":", 4)
+	if len(fields) < 3 {
+		return p, errors.New("want ID=PRICE_FEN:JOBS:ROWS[:NAME]")
+	}
+	var nums [3]int64
+	for i := range nums {
+		n, err := strconv.ParseInt(strings.TrimSpace(fields[i]), 10, 64)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("bad number %q", fields[i])
+		}
+		nums[i] = n
+	}
+	if nums[0] == 0 {
+		return p, errors.New("price must be positive")
+	}
+	p.PriceFen, p.JobQuota, p.RowQuota = nums[0], nums[1], nums[2]
+	p.Name = p.ID
+	if len(fields) == 4 && strings.TrimSpace(fields[3]) != "" {
+		p.Name = strings.TrimSpace(fields[3])
+	}
+	return p, nil
+}
+
+// Plans returns the plans for sale, cheapest first.
+func (s *Service) Plans() []domain.SubscriptionPlan {
+	out := make([]domain.SubscriptionPlan, 0, len(s.plans))
+	for _, p := range s.plans {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, k int) bool {
+		if out[i].PriceFen != out[k].PriceFen {
+			return out[i].PriceFen < out[k].PriceFen
+		}
+		return out[i].ID < out[k].ID
+	})
+	return out
+}
+
+// Status is a user's active plan and the current billing period.
+type Status struct {
+	Plan         domain.SubscriptionPlan
+	Subscription domain.Subscription
+	PeriodStart  time.Time
+	// PeriodEnd is when the usage counters reset (the renewal date), at most ExpiresAt.
+	PeriodEnd time.Time
+	Usage     domain.SubscriptionUsage
+}
+
+// Status returns the user's active plan, or nil if there is none.
+func (s *Service) Status(userID string) (*Status, error) {
+	sub, plan, ok, err := s.active(userID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	start, end := sub.Period(s.now())
+	usage, err := s.store.Usage(userID, start)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{Plan: plan, Subscription: *sub, PeriodStart: start, PeriodEnd: end, Usage: usage}, nil
+}
+
+// active returns the user's subscription if it covers now and its plan is still configured.
+func (s *Service) active(userID string) (*domain.Subscription, domain.SubscriptionPlan, bool, error) {
+	sub, ok, err := s.store.GetSubscription(userID)
+	if err != nil || !ok || !sub.Active(s.now()) {
+		return nil, domain.SubscriptionPlan{}, false, err
+	}
+	plan, ok := s.plans[sub.PlanID]
+	if !ok {
+		log.Printf("subscription: user=%s has plan %q which is no longer configured", userID, sub.PlanID)
+		return nil, domain.SubscriptionPlan{}, false, nil
+	}
+	return sub, plan, true, nil
+}
+
+// Cover counts a paid job against its owner's plan and reports whether the plan pays for it.
+// Anonymous jobs, users without an active plan and jobs beyond the quotas are not covered.
+// Repeated calls for the same job don't count it twice.
+func (s *Service) Cover(job *domain.CompareJob) (bool, error) {
+	if job == nil || job.OwnerID == "" {
+		return false, nil
+	}
+	sub, plan, ok, err := s.active(job.OwnerID)
+	if err != nil || !ok {
+		return false, err
+	}
+	var rows int64
+	if job.Stats != nil {
+		rows = job.Stats.BillableRows()
+	}
+	start, _ := sub.Period(s.now())
+	return s.store.ConsumeQuota(job.OwnerID, start, job.ID, rows, plan.JobQuota, plan.RowQuota)
+}
+
+// Uncover gives back the quota Cover counted for job, for a job that was not released after all
+// (e.g. cancelled meanwhile).
+func (s *Service) Uncover(job *domain.CompareJob) error {
+	if job == nil {
+		return nil
+	}
+	return s.store.ReleaseQuota(job.ID)
+}
+
+// CreateOrder opens a WeChat Native order for one month of planID; the plan is applied when it
+// is paid (ApplyOrder).
+func (s *Service) CreateOrder(userID, planID string) (*domain.SubscriptionOrder, error) {
+	plan, ok := s.plans[strings.TrimSpace(planID)]
+	if !ok {
+		return nil, ErrUnknownPlan
+	}
+	o := &domain.SubscriptionOrder{
+		ID:        newOrderID(),
+		UserID:    userID,
+		PlanID:    plan.ID,
+		AmountFen: plan.PriceFen,
+		CreatedAt: s.now(),
+	}
+	codeURL, err := wechat.CreateNativeOrder(o.ID, o.AmountFen)
+	if err != nil {
+		return nil, err
+	}
+	o.CodeURL = codeURL
+	if err := s.store.CreateOrder(o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Order returns the plan order and whether it has been applied.
+func (s *Service) Order(id string) (o *domain.SubscriptionOrder, applied, ok bool, err error) {
+	return s.store.GetOrder(id)
+}
+
+// ApplyOrder applies a paid plan order; repeated notifies are no-ops. It is the
+// wechat.OrderSettler for domain.SubscriptionTradePrefix.
+func (s *Service) ApplyOrder(outTradeNo string, paidFen int64) error {
+	o, _, ok, err := s.store.GetOrder(outTradeNo)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("套餐订单不存在: %s", outTradeNo)
+	}
+	if paidFen != o.AmountFen {
+		return fmt.Errorf("%w: expected=%d total=%d", wechat.ErrAmountMismatch, o.AmountFen, paidFen)
+	}
+	sub, applied, err := s.store.ApplyOrder(o.ID, s.now())
+	if err == nil && applied {
+		log.Printf("subscription: user=%s plan=%s until %s (order %s)", o.UserID, sub.PlanID, sub.ExpiresAt.Format(time.RFC3339), o.ID)
+	}
+	return err
+}
+
+func newOrderID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err == nil {
+		return domain.SubscriptionTradePrefix + hex.EncodeToString(buf)
+	}
+	return fmt.Sprintf("%s%d", domain.SubscriptionTradePrefix, time.Now().UnixNano())
+}
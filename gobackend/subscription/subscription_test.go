@@ -0,0 +1,202 @@
+package subscription
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// clock is a settable Service.now.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+// newTestService returns a plan service with plans (SUBSCRIPTION_PLANS syntax) on a fake clock.
+func newTestService(t *testing.T, st store.SubscriptionStore, plans string) (*Service, *clock) {
+	t.Helper()
+	t.Setenv("WECHAT_MOCK", "1")
+	t.Setenv("SUBSCRIPTION_PLANS", plans)
+	c := &clock{t: time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)}
+	s := New(st)
+	s.now = c.now
+	return s, c
+}
+
+// subscribe buys (and pays for) months of planID for userID.
+func subscribe(t *testing.T, s *Service, userID, planID string, months int) {
+	t.Helper()
+	for i := 0; i < months; i++ {
+		o, err := s.CreateOrder(userID, planID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ApplyOrder(o.ID, o.AmountFen); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func jobOf(owner, id string, rows int64) *domain.CompareJob {
+	return &domain.CompareJob{ID: id, OwnerID: owner, Stats: &domain.CompareJobStats{RowsFile1: rows, RowsFile2: rows / 2}}
+}
+
+func cover(t *testing.T, s *Service, job *domain.CompareJob) bool {
+	t.Helper()
+	ok, err := s.Cover(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func wantUsage(t *testing.T, s *Service, userID string, jobs, rows int64) {
+	t.Helper()
+	st, err := s.Status(userID)
+	if err != nil || st == nil {
+		t.Fatalf("Status = %+v, %v", st, err)
+	}
+	if st.Usage.Jobs != jobs || st.Usage.Rows != rows {
+		t.Fatalf("usage = %d jobs / %d rows, want %d / %d", st.Usage.Jobs, st.Usage.Rows, jobs, rows)
+	}
+}
+
+func TestCoverQuotas(t *testing.T) {
+	s, _ := newTestService(t, store.NewInMemorySubscriptionStore(), "basic=2900:2:100,rows=4900:0:100,jobs=9900:2:0")
+	subscribe(t, s, "u1", "basic", 1)
+
+	cases := []struct {
+		job  *domain.CompareJob
+		want bool
+	}{
+		{jobOf("u1", "j1", 60), true},
+		{jobOf("u1", "j2", 50), false},                       // 110 rows > 100
+		{jobOf("u1", "j3", 40), true},                        // exactly the row quota
+		{&domain.CompareJob{ID: "j4", OwnerID: "u1"}, false}, // no rows, but the job quota is used up
+		{jobOf("", "anon", 1), false},
+		{jobOf("u2", "no-plan", 1), false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		if got := cover(t, s, tc.job); got != tc.want {
+			t.Errorf("Cover(%+v) = %v, want %v", tc.job, got, tc.want)
+		}
+	}
+	wantUsage(t, s, "u1", 2, 100)
+
+	// 0 = unlimited, for either quota.
+	subscribe(t, s, "u3", "rows", 1)
+	for i, want := range []bool{true, true, false} {
+		if got := cover(t, s, jobOf("u3", fmt.Sprintf("u3-%d", i), 50)); got != want {
+			t.Errorf("row-only plan, job %d: covered=%v, want %v", i, got, want)
+		}
+	}
+	subscribe(t, s, "u4", "jobs", 1)
+	for i, want := range []bool{true, true, false} {
+		if got := cover(t, s, jobOf("u4", fmt.Sprintf("u4-%d", i), 1_000_000)); got != want {
+			t.Errorf("job-only plan, job %d: covered=%v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestCoverInactivePlan(t *testing.T) {
+	st := store.NewInMemorySubscriptionStore()
+	s, c := newTestService(t, st, "basic=2900:0:0")
+	subscribe(t, s, "u1", "basic", 1)
+	if !cover(t, s, jobOf("u1", "j1", 1)) {
+		t.Fatal("active plan did not cover")
+	}
+
+	// A plan that is no longer configured covers nothing.
+	s2, _ := newTestService(t, st, "pro=9900:0:0")
+	s2.now = c.now
+	if cover(t, s2, jobOf("u1", "j2", 1)) {
+		t.Fatal("covered by a plan that is no longer sold")
+	}
+
+	// Nor does a lapsed one.
+	c.t = c.t.AddDate(0, 1, 0)
+	if cover(t, s, jobOf("u1", "j3", 1)) {
+		t.Fatal("covered after the plan expired")
+	}
+	if st, err := s.Status("u1"); err != nil || st != nil {
+		t.Fatalf("Status after expiry = %+v, %v, want none", st, err)
+	}
+}
+
+func TestCoverSameJobOnce(t *testing.T) {
+	s, _ := newTestService(t, store.NewInMemorySubscriptionStore(), "basic=2900:1:0")
+	subscribe(t, s, "u1", "basic", 1)
+
+	j1 := jobOf("u1", "j1", 10)
+	for i := 0; i < 3; i++ { // redeliveries of the same job
+		if !cover(t, s, j1) {
+			t.Fatalf("cover %d of j1 refused", i+1)
+		}
+	}
+	wantUsage(t, s, "u1", 1, 10)
+	if cover(t, s, jobOf("u1", "j2", 10)) {
+		t.Fatal("j2 covered beyond the job quota")
+	}
+
+	for i := 0; i < 2; i++ { // giving back twice returns the quota once
+		if err := s.Uncover(j1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Uncover(jobOf("u1", "never-covered", 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Uncover(nil); err != nil {
+		t.Fatal(err)
+	}
+	wantUsage(t, s, "u1", 0, 0)
+	if !cover(t, s, jobOf("u1", "j2", 10)) {
+		t.Fatal("quota not given back")
+	}
+	wantUsage(t, s, "u1", 1, 10)
+}
+
+func TestCoverPeriodRollover(t *testing.T) {
+	st := store.NewInMemorySubscriptionStore()
+	s, c := newTestService(t, st, "basic=2900:1:0")
+	started := c.t
+	subscribe(t, s, "u1", "basic", 2)
+
+	if !cover(t, s, jobOf("u1", "j1", 10)) || cover(t, s, jobOf("u1", "j2", 10)) {
+		t.Fatal("first month: want j1 covered and j2 over the quota")
+	}
+
+	// Started on Jan 31: the next period starts a calendar month later (Mar 3 in 2026), not Feb 28.
+	c.t = started.AddDate(0, 1, 0).Add(-time.Second)
+	if cover(t, s, jobOf("u1", "j2", 10)) {
+		t.Fatal("covered before the period rolled over")
+	}
+	c.t = started.AddDate(0, 1, 0)
+	status, err := s.Status("u1")
+	if err != nil || status == nil {
+		t.Fatal(status, err)
+	}
+	if !status.PeriodStart.Equal(c.t) || !status.PeriodEnd.Equal(status.Subscription.ExpiresAt) || status.Usage != (domain.SubscriptionUsage{}) {
+		t.Fatalf("second period = %s..%s usage %+v, want %s.. with fresh counters", status.PeriodStart, status.PeriodEnd, status.Usage, c.t)
+	}
+	if !cover(t, s, jobOf("u1", "j2", 10)) {
+		t.Fatal("new period did not cover j2")
+	}
+	// j1 was counted in the first period: re-covering it doesn't count it in the second.
+	if !cover(t, s, jobOf("u1", "j1", 10)) {
+		t.Fatal("j1 no longer covered")
+	}
+	wantUsage(t, s, "u1", 1, 10)
+
+	// Giving back j1 returns it to the period it was counted in.
+	if err := s.Uncover(jobOf("u1", "j1", 10)); err != nil {
+		t.Fatal(err)
+	}
+	wantUsage(t, s, "u1", 1, 10)
+	if u, err := st.Usage("u1", started); err != nil || u != (domain.SubscriptionUsage{}) {
+		t.Fatalf("first period usage = %+v, %v, want j1 given back", u, err)
+	}
+}
@@ -0,0 +1,326 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobackend/auth"
+	"gobackend/domain"
+)
+
+// RegisterRoutes serves the wallet and the hold/capture billing API; all routes need a logged-in user.
+//
+//	GET  /wallet                 -> balance
+//	GET  /wallet/transactions    -> ledger entries, newest first (?limit=, default 20, max 100)
+//	POST /wallet/topups          -> {amountFen} opens a WeChat Native order (code_url)
+//	GET  /wallet/topups/{id}     -> top-up order and whether it has been credited
+//	POST /billing/pending        -> {amount, idempotencyKey?} holds amount (元)
+//	POST /billing/deduct         -> {idempotencyKey, amount} captures the hold (holding first if needed)
+//	POST /billing/release        -> {idempotencyKey} releases the hold
+func (w *Wallet) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/wallet", w.handleBalance)
+	mux.HandleFunc("/wallet/transactions", w.handleTransactions)
+	mux.HandleFunc("/wallet/topups", w.handleCreateTopUp)
+	mux.HandleFunc("/wallet/topups/", w.handleGetTopUp)
+	mux.HandleFunc("/billing/pending", w.handlePending)
+	mux.HandleFunc("/billing/deduct", w.handleDeduct)
+	mux.HandleFunc("/billing/release", w.handleRelease)
+}
+
+// requireUser returns the logged-in user, or writes the error response and returns "".
+func requireUser(w http.ResponseWriter, r *http.Request, method string) string {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return ""
+	}
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return ""
+	}
+	uid := auth.UserID(r.Context())
+	if uid == "" {
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+	}
+	return uid
+}
+
+func (w *Wallet) handleBalance(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodGet)
+	if uid == "" {
+		return
+	}
+	available, held, err := w.Balance(uid)
+	if err != nil {
+		log.Printf("wallet: balance user=%s: %v", uid, err)
+		http.Error(rw, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"availableFen": available,
+		"heldFen":      held,
+		"balance":      FormatYuan(available),
+	})
+}
+
+// transactionView is a journal as the user sees it: AmountFen is the change to their spendable
+// balance (0 for captures of held funds).
+type transactionView struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Memo      string    `json:"memo,omitempty"`
+	AmountFen int64     `json:"amountFen"`
+	HeldFen   int64     `json:"heldFen"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (w *Wallet) handleTransactions(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodGet)
+	if uid == "" {
+		return
+	}
+	limit := 20
+	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(rw, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 100)
+	}
+	journals, err := w.Transactions(uid, limit)
+	if err != nil {
+		log.Printf("wallet: transactions user=%s: %v", uid, err)
+		http.Error(rw, "server error", http.StatusInternalServerError)
+		return
+	}
+	items := make([]transactionView, 0, len(journals))
+	for _, j := range journals {
+		items = append(items, transactionView{
+			ID:        j.ID,
+			Kind:      j.Kind,
+			Memo:      j.Memo,
+			AmountFen: j.AmountFor(domain.WalletAccount(uid)),
+			HeldFen:   j.AmountFor(domain.WalletHeldAccount(uid)),
+			CreatedAt: j.CreatedAt,
+		})
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+func (w *Wallet) handleCreateTopUp(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodPost)
+	if uid == "" {
+		return
+	}
+	var req struct {
+		AmountFen int64 `json:"amountFen"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "invalid json", http.StatusBadRequest)
+		return
+	}
+	t, err := w.CreateTopUp(uid, req.AmountFen)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAmount) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("wallet: create topup user=%s: %v", uid, err)
+		http.Error(rw, "创建微信支付订单失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(rw, http.StatusOK, t)
+}
+
+func (w *Wallet) handleGetTopUp(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodGet)
+	if uid == "" {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/wallet/topups/"), "/")
+	t, credited, ok, err := w.TopUp(id)
+	if err != nil {
+		http.Error(rw, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || t.UserID != uid {
+		http.NotFound(rw, r)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"topUpId":   t.ID,
+		"amountFen": t.AmountFen,
+		"code_url":  t.CodeURL,
+		"paid":      credited,
+		"createdAt": t.CreatedAt,
+	})
+}
+
+type billingRequest struct {
+	IdempotencyKey string                 `json:"idempotencyKey"`
+	Amount         float64                `json:"amount"` // 元
+	ApiCall        string                 `json:"apiCall,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+}
+
+func decodeBillingRequest(rw http.ResponseWriter, r *http.Request) (billingRequest, bool) {
+	var req billingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "invalid json", http.StatusBadRequest)
+		return req, false
+	}
+	req.IdempotencyKey = strings.TrimSpace(req.IdempotencyKey)
+	if req.IdempotencyKey != "" && !validKey(req.IdempotencyKey) {
+		http.Error(rw, "idempotencyKey 非法", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func (w *Wallet) handlePending(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodPost)
+	if uid == "" {
+		return
+	}
+	req, ok := decodeBillingRequest(rw, r)
+	if !ok {
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(rw, "amount 必须为正数", http.StatusBadRequest)
+		return
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = newKey()
+	}
+	if _, err := w.Hold(uid, req.IdempotencyKey, yuanToFen(req.Amount), req.ApiCall); err != nil {
+		writeBillingError(rw, uid, err)
+		return
+	}
+	status := "pending"
+	if settled, err := w.Settlement(uid, req.IdempotencyKey); err == nil && settled != nil {
+		status = settlementStatus(settled)
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"idempotencyKey": req.IdempotencyKey,
+		"status":         status,
+	})
+}
+
+func (w *Wallet) handleDeduct(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodPost)
+	if uid == "" {
+		return
+	}
+	req, ok := decodeBillingRequest(rw, r)
+	if !ok {
+		return
+	}
+	if req.IdempotencyKey == "" {
+		http.Error(rw, "idempotencyKey 不能为空", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(rw, "amount 必须为正数", http.StatusBadRequest)
+		return
+	}
+	amountFen := yuanToFen(req.Amount)
+	j, err := w.Capture(uid, req.IdempotencyKey, amountFen, req.ApiCall)
+	if errors.Is(err, ErrHoldMissing) {
+		// No /billing/pending first: hold and capture in one go.
+		if _, err = w.Hold(uid, req.IdempotencyKey, amountFen, req.ApiCall); err == nil {
+			j, err = w.Capture(uid, req.IdempotencyKey, amountFen, req.ApiCall)
+		}
+	}
+	if err != nil {
+		writeBillingError(rw, uid, err)
+		return
+	}
+	captured := j.AmountFor(domain.LedgerAccountRevenue)
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"idempotencyKey": req.IdempotencyKey,
+		"deducted":       true,
+		"amount":         float64(captured) / 100,
+	})
+}
+
+func (w *Wallet) handleRelease(rw http.ResponseWriter, r *http.Request) {
+	uid := requireUser(rw, r, http.MethodPost)
+	if uid == "" {
+		return
+	}
+	req, ok := decodeBillingRequest(rw, r)
+	if !ok {
+		return
+	}
+	if req.IdempotencyKey == "" {
+		http.Error(rw, "idempotencyKey 不能为空", http.StatusBadRequest)
+		return
+	}
+	if _, err := w.Release(uid, req.IdempotencyKey, req.ApiCall); err != nil {
+		writeBillingError(rw, uid, err)
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"idempotencyKey": req.IdempotencyKey,
+		"status":         "released",
+	})
+}
+
+func settlementStatus(j *domain.LedgerJournal) string {
+	if j.Kind == domain.LedgerKindRelease {
+		return "released"
+	}
+	return "deducted"
+}
+
+func writeBillingError(rw http.ResponseWriter, uid string, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInsufficientFunds):
+		http.Error(rw, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, ErrHoldMissing):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrKeyConflict), errors.Is(err, ErrHoldSettled):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidAmount):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("wallet: billing user=%s: %v", uid, err)
+		http.Error(rw, "server error", http.StatusInternalServerError)
+	}
+}
+
+// FormatYuan renders fen as yuan with two decimals ("12.30").
+func FormatYuan(fen int64) string {
+	return fmt.Sprintf("%.2f", float64(fen)/100)
+}
+
+func yuanToFen(yuan float64) int64 {
+	return int64(math.Round(yuan * 100))
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func readEnvInt64Default(key string, defaultVal int64) int64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultVal
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return defaultVal
+	}
+	return n
+}
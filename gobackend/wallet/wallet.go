@@ -0,0 +1,295 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/wechat"
+)
+
+// Wallet is the prepaid balance on top of the ledger. Journal IDs double as idempotency keys:
+//
+//	topup:<topUpId>           sys:wechat -> user            (credited by the WeChat Pay notify)
+//	hold:<uid>:<key>          user -> user:held              (POST /billing/pending)
+//	settle:<uid>:<key>        user:held -> sys:revenue (+ rest back to user), or all back on release
+//	charge:<ref>              user -> sys:revenue            (one-shot payment, e.g. a compare job)
+//	refund:<ref>              reverses charge:<ref>
+//
+// A hold is settled exactly once, so capture and release of the same key exclude each other.
+type Wallet struct {
+	ledger store.LedgerStore
+
+	minTopUpFen int64
+	maxTopUpFen int64
+}
+
+var (
+	// ErrKeyConflict: the idempotency key was already used with a different amount.
+	ErrKeyConflict = errors.New("幂等键已用于不同金额")
+	// ErrHoldSettled: the hold was already captured/released the other way.
+	ErrHoldSettled = errors.New("该预扣已结算")
+	ErrHoldMissing = errors.New("预扣不存在")
+	// ErrChargeRefunded: the charge was refunded; its ref can't be charged again.
+	ErrChargeRefunded = errors.New("该扣款已退回")
+	ErrInvalidAmount  = errors.New("金额非法")
+)
+
+// New returns the wallet. Top-up amounts are bounded by WALLET_TOPUP_MIN_FEN (default 100) and
+// WALLET_TOPUP_MAX_FEN (default 100000).
+func New(ledger store.LedgerStore) *Wallet {
+	return &Wallet{
+		ledger:      ledger,
+		minTopUpFen: readEnvInt64Default("WALLET_TOPUP_MIN_FEN", 100),
+		maxTopUpFen: readEnvInt64Default("WALLET_TOPUP_MAX_FEN", 100000),
+	}
+}
+
+// Balance returns the user's spendable and held amounts (fen).
+func (w *Wallet) Balance(userID string) (available, held int64, err error) {
+	if available, err = w.ledger.Balance(domain.WalletAccount(userID)); err != nil {
+		return 0, 0, err
+	}
+	held, err = w.ledger.Balance(domain.WalletHeldAccount(userID))
+	return available, held, err
+}
+
+// Transactions returns the user's journals, newest first.
+func (w *Wallet) Transactions(userID string, limit int) ([]*domain.LedgerJournal, error) {
+	return w.ledger.UserJournals(userID, limit)
+}
+
+// Hold reserves amountFen under key. Repeating the same key returns the existing hold.
+func (w *Wallet) Hold(userID, key string, amountFen int64, memo string) (*domain.LedgerJournal, error) {
+	if amountFen <= 0 {
+		return nil, fmt.Errorf("%w: 必须为正数", ErrInvalidAmount)
+	}
+	j, applied, err := w.ledger.Post(&domain.LedgerJournal{
+		ID:     holdID(userID, key),
+		Kind:   domain.LedgerKindHold,
+		UserID: userID,
+		Memo:   memo,
+		Postings: []domain.LedgerPosting{
+			{Account: domain.WalletAccount(userID), AmountFen: -amountFen},
+			{Account: domain.WalletHeldAccount(userID), AmountFen: amountFen},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !applied && j.AmountFor(domain.WalletHeldAccount(userID)) != amountFen {
+		return nil, ErrKeyConflict
+	}
+	return j, nil
+}
+
+// Capture settles the hold under key: amountFen (<= held; 0 = all of it) goes to revenue and the
+// rest back to the user. Repeating it returns the first capture.
+func (w *Wallet) Capture(userID, key string, amountFen int64, memo string) (*domain.LedgerJournal, error) {
+	held, err := w.heldAmount(userID, key)
+	if err != nil {
+		return nil, err
+	}
+	if amountFen == 0 {
+		amountFen = held
+	}
+	if amountFen < 0 || amountFen > held {
+		return nil, fmt.Errorf("%w: 超出预扣金额 %d 分", ErrInvalidAmount, held)
+	}
+	postings := []domain.LedgerPosting{
+		{Account: domain.WalletHeldAccount(userID), AmountFen: -held},
+		{Account: domain.LedgerAccountRevenue, AmountFen: amountFen},
+	}
+	if rest := held - amountFen; rest > 0 {
+		postings = append(postings, domain.LedgerPosting{Account: domain.WalletAccount(userID), AmountFen: rest})
+	}
+	return w.settle(userID, key, domain.LedgerKindCapture, memo, postings)
+}
+
+// Release returns the whole hold under key to the user.
+func (w *Wallet) Release(userID, key, memo string) (*domain.LedgerJournal, error) {
+	held, err := w.heldAmount(userID, key)
+	if err != nil {
+		return nil, err
+	}
+	return w.settle(userID, key, domain.LedgerKindRelease, memo, []domain.LedgerPosting{
+		{Account: domain.WalletHeldAccount(userID), AmountFen: -held},
+		{Account: domain.WalletAccount(userID), AmountFen: held},
+	})
+}
+
+// Settlement returns how the hold under key was settled (nil if it is still open).
+func (w *Wallet) Settlement(userID, key string) (*domain.LedgerJournal, error) {
+	j, ok, err := w.ledger.GetJournal(settleID(userID, key))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (w *Wallet) heldAmount(userID, key string) (int64, error) {
+	hold, ok, err := w.ledger.GetJournal(holdID(userID, key))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrHoldMissing
+	}
+	return hold.AmountFor(domain.WalletHeldAccount(userID)), nil
+}
+
+func (w *Wallet) settle(userID, key, kind, memo string, postings []domain.LedgerPosting) (*domain.LedgerJournal, error) {
+	j, applied, err := w.ledger.Post(&domain.LedgerJournal{
+		ID:       settleID(userID, key),
+		Kind:     kind,
+		UserID:   userID,
+		Memo:     memo,
+		Postings: postings,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !applied && j.Kind != kind {
+		return nil, ErrHoldSettled
+	}
+	return j, nil
+}
+
+// Charge pays amountFen from the balance in one step. ref names what is paid for ("job:<id>") and
+// makes the charge idempotent.
+func (w *Wallet) Charge(userID, ref string, amountFen int64, memo string) (*domain.LedgerJournal, error) {
+	if amountFen <= 0 {
+		return nil, fmt.Errorf("%w: 必须为正数", ErrInvalidAmount)
+	}
+	j, applied, err := w.ledger.Post(&domain.LedgerJournal{
+		ID:     "charge:" + ref,
+		Kind:   domain.LedgerKindCharge,
+		UserID: userID,
+		Memo:   memo,
+		Postings: []domain.LedgerPosting{
+			{Account: domain.WalletAccount(userID), AmountFen: -amountFen},
+			{Account: domain.LedgerAccountRevenue, AmountFen: amountFen},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if applied {
+		return j, nil
+	}
+	if j.UserID != userID || j.AmountFor(domain.LedgerAccountRevenue) != amountFen {
+		return nil, ErrKeyConflict
+	}
+	if _, refunded, err := w.ledger.GetJournal("refund:" + ref); err != nil {
+		return nil, err
+	} else if refunded {
+		return nil, ErrChargeRefunded
+	}
+	return j, nil
+}
+
+// Refund reverses charge:<ref> (no-op if there is none or it was already refunded).
+func (w *Wallet) Refund(ref, memo string) error {
+	charge, ok, err := w.ledger.GetJournal("charge:" + ref)
+	if err != nil || !ok {
+		return err
+	}
+	postings := make([]domain.LedgerPosting, 0, len(charge.Postings))
+	for _, p := range charge.Postings {
+		postings = append(postings, domain.LedgerPosting{Account: p.Account, AmountFen: -p.AmountFen})
+	}
+	_, _, err = w.ledger.Post(&domain.LedgerJournal{
+		ID:       "refund:" + ref,
+		Kind:     domain.LedgerKindRefund,
+		UserID:   charge.UserID,
+		Memo:     memo,
+		Postings: postings,
+	})
+	return err
+}
+
+// CreateTopUp opens a WeChat Native order; the wallet is credited when it is paid (CreditTopUp).
+func (w *Wallet) CreateTopUp(userID string, amountFen int64) (*domain.WalletTopUp, error) {
+	if amountFen < w.minTopUpFen || amountFen > w.maxTopUpFen {
+		return nil, fmt.Errorf("%w: 充值金额需在 %s ~ %s 元之间", ErrInvalidAmount, FormatYuan(w.minTopUpFen), FormatYuan(w.maxTopUpFen))
+	}
+	t := &domain.WalletTopUp{
+		ID:        newTopUpID(),
+		UserID:    userID,
+		AmountFen: amountFen,
+		CreatedAt: time.Now(),
+	}
+	codeURL, err := wechat.CreateNativeOrder(t.ID, amountFen)
+	if err != nil {
+		return nil, err
+	}
+	t.CodeURL = codeURL
+	if err := w.ledger.CreateTopUp(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TopUp returns the top-up order and whether it has been credited.
+func (w *Wallet) TopUp(id string) (t *domain.WalletTopUp, credited, ok bool, err error) {
+	t, ok, err = w.ledger.GetTopUp(id)
+	if err != nil || !ok {
+		return nil, false, false, err
+	}
+	_, credited, err = w.ledger.GetJournal("topup:" + id)
+	return t, credited, true, err
+}
+
+// CreditTopUp credits a paid top-up order; repeated notifies are no-ops. It is the
+// wechat.OrderSettler for domain.TopUpTradePrefix.
+func (w *Wallet) CreditTopUp(outTradeNo string, paidFen int64) error {
+	t, ok, err := w.ledger.GetTopUp(outTradeNo)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("充值单不存在: %s", outTradeNo)
+	}
+	if paidFen != t.AmountFen {
+		return fmt.Errorf("%w: expected=%d total=%d", wechat.ErrAmountMismatch, t.AmountFen, paidFen)
+	}
+	_, _, err = w.ledger.Post(&domain.LedgerJournal{
+		ID:     "topup:" + t.ID,
+		Kind:   domain.LedgerKindTopUp,
+		UserID: t.UserID,
+		Memo:   "微信充值",
+		Postings: []domain.LedgerPosting{
+			{Account: domain.LedgerAccountWeChat, AmountFen: -t.AmountFen},
+			{Account: domain.WalletAccount(t.UserID), AmountFen: t.AmountFen},
+		},
+	})
+	return err
+}
+
+func holdID(userID, key string) string   { return "hold:" + userID + ":" + key }
+func settleID(userID, key string) string { return "settle:" + userID + ":" + key }
+
+func newTopUpID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err == nil {
+		return domain.TopUpTradePrefix + hex.EncodeToString(buf)
+	}
+	return fmt.Sprintf("%s%d", domain.TopUpTradePrefix, time.Now().UnixNano())
+}
+
+func newKey() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err == nil {
+		return "pending_" + hex.EncodeToString(buf)
+	}
+	return fmt.Sprintf("pending_%d", time.Now().UnixNano())
+}
+
+func validKey(key string) bool {
+	return key != "" && len(key) <= 128 && !strings.ContainsAny(key, " \t\r\n")
+}
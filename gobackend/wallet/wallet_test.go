@@ -0,0 +1,214 @@
+package wallet
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // registers the "sqlite3" driver (tests only)
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// testWallets returns a wallet on each LedgerStore implementation, plus the ledger itself.
+func testWallets(t *testing.T) map[string]*Wallet {
+	t.Helper()
+	out := map[string]*Wallet{"memory": New(store.NewInMemoryLedgerStore())}
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "ledger.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := db.Ping(); err != nil {
+		t.Logf("sqlite3 unavailable, memory ledger only: %v", err)
+		return out
+	}
+	sq, err := store.NewSQLLedgerStore(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out["sql"] = New(sq)
+	return out
+}
+
+// fund credits userID with fen through a paid top-up.
+func fund(t *testing.T, w *Wallet, userID, topUpID string, fen int64) {
+	t.Helper()
+	if err := w.ledger.CreateTopUp(&domain.WalletTopUp{ID: topUpID, UserID: userID, AmountFen: fen}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreditTopUp(topUpID, fen); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantBalance(t *testing.T, w *Wallet, userID string, available, held int64) {
+	t.Helper()
+	a, h, err := w.Balance(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != available || h != held {
+		t.Fatalf("balance = %d available / %d held, want %d / %d", a, h, available, held)
+	}
+}
+
+// wantBalanced checks every journal of userID nets to zero and the system accounts mirror the
+// user's money.
+func wantBalanced(t *testing.T, w *Wallet, userID string) {
+	t.Helper()
+	js, err := w.Transactions(userID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range js {
+		var debits, credits int64
+		for _, p := range j.Postings {
+			if p.AmountFen < 0 {
+				debits -= p.AmountFen
+			} else {
+				credits += p.AmountFen
+			}
+		}
+		if debits != credits || debits == 0 {
+			t.Errorf("journal %s: debits %d, credits %d", j.ID, debits, credits)
+		}
+	}
+	var sum int64
+	for _, acct := range []string{domain.WalletAccount(userID), domain.WalletHeldAccount(userID), domain.LedgerAccountWeChat, domain.LedgerAccountRevenue} {
+		n, err := w.ledger.Balance(acct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += n
+	}
+	if sum != 0 {
+		t.Errorf("accounts sum to %d", sum)
+	}
+}
+
+func TestHoldCaptureReleaseIdempotent(t *testing.T) {
+	for name, w := range testWallets(t) {
+		t.Run(name, func(t *testing.T) {
+			fund(t, w, "u1", "tp_1", 1000)
+
+			for i := 0; i < 3; i++ {
+				if _, err := w.Hold("u1", "k1", 300, ""); err != nil {
+					t.Fatalf("hold %d: %v", i+1, err)
+				}
+			}
+			wantBalance(t, w, "u1", 700, 300)
+			if _, err := w.Hold("u1", "k1", 200, ""); !errors.Is(err, ErrKeyConflict) {
+				t.Fatalf("hold with another amount: %v", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				j, err := w.Capture("u1", "k1", 120, "")
+				if err != nil {
+					t.Fatalf("capture %d: %v", i+1, err)
+				}
+				if j.AmountFor(domain.LedgerAccountRevenue) != 120 {
+					t.Fatalf("captured %d", j.AmountFor(domain.LedgerAccountRevenue))
+				}
+			}
+			wantBalance(t, w, "u1", 880, 0)
+			if _, err := w.Release("u1", "k1", ""); !errors.Is(err, ErrHoldSettled) {
+				t.Fatalf("release after capture: %v", err)
+			}
+
+			if _, err := w.Hold("u1", "k2", 500, ""); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if _, err := w.Release("u1", "k2", ""); err != nil {
+					t.Fatalf("release %d: %v", i+1, err)
+				}
+			}
+			if _, err := w.Capture("u1", "k2", 0, ""); !errors.Is(err, ErrHoldSettled) {
+				t.Fatalf("capture after release: %v", err)
+			}
+			if _, err := w.Capture("u1", "missing", 0, ""); !errors.Is(err, ErrHoldMissing) {
+				t.Fatalf("capture without hold: %v", err)
+			}
+
+			wantBalance(t, w, "u1", 880, 0)
+			if rev, _ := w.ledger.Balance(domain.LedgerAccountRevenue); rev != 120 {
+				t.Fatalf("revenue = %d, want 120", rev)
+			}
+			// topup, hold k1, capture k1, hold k2, release k2
+			if js, _ := w.Transactions("u1", 100); len(js) != 5 {
+				t.Fatalf("%d journals, want 5", len(js))
+			}
+			wantBalanced(t, w, "u1")
+		})
+	}
+}
+
+func TestChargeInsufficientFunds(t *testing.T) {
+	for name, w := range testWallets(t) {
+		t.Run(name, func(t *testing.T) {
+			fund(t, w, "u1", "tp_1", 100)
+			if _, err := w.Hold("u1", "k1", 300, ""); !errors.Is(err, domain.ErrInsufficientFunds) {
+				t.Fatalf("hold: %v", err)
+			}
+			if _, err := w.Charge("u1", "job:j1", 150, ""); !errors.Is(err, domain.ErrInsufficientFunds) {
+				t.Fatalf("charge: %v", err)
+			}
+			if _, ok, _ := w.ledger.GetJournal("charge:job:j1"); ok {
+				t.Fatal("rejected charge left a journal")
+			}
+			if _, ok, _ := w.ledger.GetJournal(holdID("u1", "k1")); ok {
+				t.Fatal("rejected hold left a journal")
+			}
+			wantBalance(t, w, "u1", 100, 0)
+			if rev, _ := w.ledger.Balance(domain.LedgerAccountRevenue); rev != 0 {
+				t.Fatalf("revenue = %d, want 0", rev)
+			}
+			if js, _ := w.Transactions("u1", 100); len(js) != 1 {
+				t.Fatalf("%d journals, want 1", len(js))
+			}
+			wantBalanced(t, w, "u1")
+		})
+	}
+}
+
+func TestRefundCapturedCharge(t *testing.T) {
+	for name, w := range testWallets(t) {
+		t.Run(name, func(t *testing.T) {
+			fund(t, w, "u1", "tp_1", 500)
+			for i := 0; i < 2; i++ {
+				if _, err := w.Charge("u1", "job:j1", 200, ""); err != nil {
+					t.Fatalf("charge %d: %v", i+1, err)
+				}
+			}
+			if _, err := w.Charge("u1", "job:j1", 300, ""); !errors.Is(err, ErrKeyConflict) {
+				t.Fatalf("charge with another amount: %v", err)
+			}
+			wantBalance(t, w, "u1", 300, 0)
+
+			for i := 0; i < 2; i++ {
+				if err := w.Refund("job:j1", "任务失败"); err != nil {
+					t.Fatalf("refund %d: %v", i+1, err)
+				}
+			}
+			wantBalance(t, w, "u1", 500, 0)
+			if rev, _ := w.ledger.Balance(domain.LedgerAccountRevenue); rev != 0 {
+				t.Fatalf("revenue = %d, want 0", rev)
+			}
+			refund, ok, _ := w.ledger.GetJournal("refund:job:j1")
+			if !ok || refund.Kind != domain.LedgerKindRefund || refund.AmountFor(domain.WalletAccount("u1")) != 200 {
+				t.Fatalf("refund journal: %+v", refund)
+			}
+			if _, err := w.Charge("u1", "job:j1", 200, ""); !errors.Is(err, ErrChargeRefunded) {
+				t.Fatalf("charge after refund: %v", err)
+			}
+			if err := w.Refund("job:never-charged", ""); err != nil {
+				t.Fatalf("refund without charge: %v", err)
+			}
+			wantBalance(t, w, "u1", 500, 0)
+			wantBalanced(t, w, "u1")
+		})
+	}
+}
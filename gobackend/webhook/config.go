@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/streamq"
+)
+
+// Config controls signing and retries of outbound callbacks.
+type Config struct {
+	// Secret is the HMAC-SHA256 key for the X-GY-Signature header (WEBHOOK_SECRET).
+	Secret string
+	// MaxAttempts per event before the message is dead-lettered (WEBHOOK_MAX_ATTEMPTS, default 8).
+	MaxAttempts int
+	// Retry n waits BaseDelay*2^(n-1), capped at MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout of one POST (WEBHOOK_TIMEOUT_SECONDS, default 10s).
+	Timeout time.Duration
+	// AllowPrivate allows callbacks to loopback / private addresses (WEBHOOK_ALLOW_PRIVATE=1, dev only).
+	AllowPrivate bool
+}
+
+func ConfigFromEnv() Config {
+	return Config{
+		Secret:       strings.TrimSpace(os.Getenv("WEBHOOK_SECRET")),
+		MaxAttempts:  readEnvIntDefault("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseDelay:    time.Duration(readEnvIntDefault("WEBHOOK_RETRY_BASE_SECONDS", 10)) * time.Second,
+		MaxDelay:     time.Duration(readEnvIntDefault("WEBHOOK_RETRY_MAX_SECONDS", 3600)) * time.Second,
+		Timeout:      time.Duration(readEnvIntDefault("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+		AllowPrivate: strings.TrimSpace(os.Getenv("WEBHOOK_ALLOW_PRIVATE")) == "1",
+	}
+}
+
+// RetryPolicy is the policy for the webhook stream consumer.
+func (c Config) RetryPolicy() streamq.RetryPolicy {
+	return streamq.RetryPolicy{
+		MaxDeliveries: int64(c.MaxAttempts),
+		BaseDelay:     c.BaseDelay,
+		MaxDelay:      c.MaxDelay,
+	}
+}
+
+// Enabled reports whether callbacks can be signed; without a secret job creation rejects callback_url.
+func Enabled() bool {
+	return strings.TrimSpace(os.Getenv("WEBHOOK_SECRET")) != ""
+}
+
+// Notifiable reports whether reaching this status triggers a callback.
+func Notifiable(s domain.CompareJobStatus) bool {
+	switch s {
+	case domain.CompareJobStatusReady, domain.CompareJobStatusFailed, domain.CompareJobStatusAwaitingPayment:
+		return true
+	}
+	return false
+}
+
+// EventName is the "event" field / X-GY-Event header for a status, e.g. "job.ready".
+func EventName(s domain.CompareJobStatus) string {
+	return "job." + string(s)
+}
+
+// ValidateCallbackURL accepts absolute http(s) URLs only.
+func ValidateCallbackURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) > 2048 {
+		return "", errors.New("callback_url 过长")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", errors.New("callback_url 必须是 http(s) 绝对地址")
+	}
+	if u.User != nil {
+		return "", errors.New("callback_url 不支持携带用户名/密码")
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+func readEnvIntDefault(key string, defaultVal int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return defaultVal
+	}
+	return n
+}
@@ -0,0 +1,241 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/streamq"
+)
+
+// Payload is the JSON body POSTed to the callback URL.
+//
+// It describes the job as it is when the delivery is sent: if a job moves on before a pending
+// event was delivered (e.g. awaiting_payment -> ready), only the newer event is sent.
+type Payload struct {
+	ID        string                 `json:"id"` // jobId:event, stable across retries (use it to de-duplicate)
+	Event     string                 `json:"event"`
+	JobID     string                 `json:"jobId"`
+	Status    string                 `json:"status"`
+	Mode      string                 `json:"mode,omitempty"`
+	Paid      bool                   `json:"paid"`
+	Amount    float64                `json:"amount,omitempty"`
+	CodeURL   string                 `json:"code_url,omitempty"`
+	Price     *domain.PriceBreakdown `json:"price,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+	SentAt    time.Time              `json:"sentAt"`
+}
+
+// Dispatcher delivers callbacks for jobs read from the webhook stream.
+//
+// Retries reuse the stream: a failed POST returns a non-terminal error, and the consumer's
+// streamq.RetryPolicy (Config.RetryPolicy) ACKs the message and schedules the next try in the
+// delayed set with exponential backoff, dead-lettering it after MaxDeliveries (= MaxAttempts).
+// Every attempt is recorded on the job.
+type Dispatcher struct {
+	store  store.CompareJobStore
+	cfg    Config
+	client *http.Client
+}
+
+func NewDispatcher(st store.CompareJobStore, cfg Config) *Dispatcher {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !cfg.AllowPrivate {
+		dialer.Control = denyPrivateAddr
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &Dispatcher{
+		store: st,
+		cfg:   cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+			// Redirects are reported as failures instead of being followed.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+func (d *Dispatcher) Process(ctx context.Context, jobID string) error {
+	if d == nil || d.store == nil {
+		return errors.New("webhook dispatcher/store 未初始化")
+	}
+	jobID = strings.TrimSpace(jobID)
+	job, ok, err := d.store.Get(jobID)
+	if err != nil {
+		return err
+	}
+	if !ok || job == nil || job.CallbackURL == "" || !Notifiable(job.Status) {
+		return streamq.Terminal(nil)
+	}
+	event := EventName(job.Status)
+	sent, delivered := attemptsFor(job.WebhookAttempts, event)
+	if delivered {
+		return streamq.Terminal(nil)
+	}
+
+	attempt := domain.WebhookAttempt{Event: event, Attempt: sent + 1, At: time.Now()}
+	code, sendErr := d.send(ctx, job, event)
+	attempt.StatusCode = code
+	if sendErr != nil {
+		attempt.Error = truncate(sendErr.Error(), 200)
+	}
+	_, _, _ = d.store.Update(jobID, func(j *domain.CompareJob) {
+		j.WebhookAttempts = append(j.WebhookAttempts, attempt)
+	})
+	return sendErr
+}
+
+func (d *Dispatcher) send(ctx context.Context, job *domain.CompareJob, event string) (int, error) {
+	now := time.Now()
+	p := Payload{
+		ID:        job.ID + ":" + event,
+		Event:     event,
+		JobID:     job.ID,
+		Status:    string(job.Status),
+		Mode:      string(job.Mode),
+		Paid:      job.Paid,
+		CreatedAt: job.CreatedAt,
+		SentAt:    now,
+	}
+	switch job.Status {
+	case domain.CompareJobStatusAwaitingPayment:
+		p.Amount = job.AmountYuan
+		p.CodeURL = job.CodeURL
+		p.Price = job.Price
+	case domain.CompareJobStatusFailed:
+		p.Error = job.Error
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gy-webhook/1")
+	req.Header.Set("X-GY-Event", event)
+	req.Header.Set("X-GY-Delivery", p.ID)
+	req.Header.Set("X-GY-Timestamp", ts)
+	req.Header.Set("X-GY-Signature", "sha256="+Sign(d.cfg.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("callback 返回 HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns hex(HMAC-SHA256(secret, timestamp + "." + body)).
+// Receivers recompute it from the X-GY-Timestamp header and the raw body.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func attemptsFor(attempts []domain.WebhookAttempt, event string) (sent int, delivered bool) {
+	for _, a := range attempts {
+		if a.Event != event {
+			continue
+		}
+		sent++
+		if a.Error == "" && a.StatusCode >= 200 && a.StatusCode <= 299 {
+			delivered = true
+		}
+	}
+	return sent, delivered
+}
+
+// deniedPrefixes are never dialed for callbacks, on top of the net.IP private/loopback checks:
+// shared (CGNAT), "this network", benchmarking and reserved ranges, and IPv6 forms that embed or
+// translate to IPv4 (checked again on the embedded address in deniedAddr).
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+}
+
+// Prefixes whose IPv6 addresses carry an IPv4 address that is checked like any other.
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// denyPrivateAddr stops callbacks from reaching internal services (checked after DNS resolution).
+func denyPrivateAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || deniedAddr(ip) {
+		return fmt.Errorf("callback 地址不允许: %s", host)
+	}
+	return nil
+}
+
+func deniedAddr(ip netip.Addr) bool {
+	ip = ip.WithZone("").Unmap() // ::ffff:10.0.0.1 is 10.0.0.1
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, p := range deniedPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	if ip.Is6() {
+		b := ip.As16()
+		switch {
+		case nat64Prefix.Contains(ip):
+			return deniedAddr(netip.AddrFrom4([4]byte(b[12:16])))
+		case sixToFour.Contains(ip):
+			return deniedAddr(netip.AddrFrom4([4]byte(b[2:6])))
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,207 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/streamq"
+)
+
+type receiver struct {
+	mu     sync.Mutex
+	status int
+	reqs   []*http.Request
+	bodies [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reqs = append(r.reqs, req)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(r.status)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.reqs)
+}
+
+func readyJob(t *testing.T, st store.CompareJobStore, url string) {
+	t.Helper()
+	job := &domain.CompareJob{ID: "job_1", OwnerID: "u1", CreatedAt: time.Now(), CallbackURL: url}
+	for _, s := range []domain.CompareJobStatus{domain.CompareJobStatusProcessing, domain.CompareJobStatusReady} {
+		if err := job.Transition(s, domain.ActorAPI, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Create(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcherSignsAndRecordsAttempts(t *testing.T) {
+	rcv := &receiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	st := store.NewInMemoryCompareJobStore()
+	readyJob(t, st, srv.URL+"/hook")
+	d := NewDispatcher(st, Config{Secret: "s3cret", AllowPrivate: true})
+	ctx := context.Background()
+
+	// Non-2xx: recorded as a failed attempt, and the stream retries it.
+	err := d.Process(ctx, "job_1")
+	if err == nil || streamq.IsTerminal(err) {
+		t.Fatalf("non-2xx: err = %v, want a retryable error", err)
+	}
+	job, _, _ := st.Get("job_1")
+	if len(job.WebhookAttempts) != 1 || job.WebhookAttempts[0].StatusCode != 500 || job.WebhookAttempts[0].Error == "" {
+		t.Fatalf("attempts after 500: %+v", job.WebhookAttempts)
+	}
+
+	rcv.mu.Lock()
+	rcv.status = http.StatusNoContent
+	rcv.mu.Unlock()
+	if err := d.Process(ctx, "job_1"); !streamq.IsTerminal(err) && err != nil {
+		t.Fatalf("2xx: %v", err)
+	}
+	job, _, _ = st.Get("job_1")
+	if len(job.WebhookAttempts) != 2 || job.WebhookAttempts[1].Attempt != 2 || job.WebhookAttempts[1].Error != "" {
+		t.Fatalf("attempts after 204: %+v", job.WebhookAttempts)
+	}
+
+	// Delivered: a redelivered message is not sent again.
+	if err := d.Process(ctx, "job_1"); !streamq.IsTerminal(err) {
+		t.Fatalf("after delivery: err = %v, want terminal", err)
+	}
+	if n := rcv.count(); n != 2 {
+		t.Fatalf("receiver got %d requests, want 2", n)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	req, body := rcv.reqs[1], rcv.bodies[1]
+	if req.Method != http.MethodPost || req.Header.Get("X-GY-Event") != "job.ready" || req.Header.Get("X-GY-Delivery") != "job_1:job.ready" {
+		t.Fatalf("request: %s event=%q delivery=%q", req.Method, req.Header.Get("X-GY-Event"), req.Header.Get("X-GY-Delivery"))
+	}
+	want := "sha256=" + Sign("s3cret", req.Header.Get("X-GY-Timestamp"), body)
+	if got := req.Header.Get("X-GY-Signature"); got != want {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil || p.JobID != "job_1" || p.Status != "ready" || p.ID != "job_1:job.ready" {
+		t.Fatalf("payload = %+v, %v", p, err)
+	}
+}
+
+func TestDispatcherRefusesPrivateAddresses(t *testing.T) {
+	rcv := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(rcv) // listens on 127.0.0.1
+	defer srv.Close()
+
+	st := store.NewInMemoryCompareJobStore()
+	readyJob(t, st, srv.URL)
+	d := NewDispatcher(st, Config{Secret: "s3cret"})
+	if err := d.Process(context.Background(), "job_1"); err == nil || streamq.IsTerminal(err) {
+		t.Fatalf("err = %v, want a retryable error", err)
+	}
+	if n := rcv.count(); n != 0 {
+		t.Fatalf("loopback receiver got %d requests", n)
+	}
+	job, _, _ := st.Get("job_1")
+	if len(job.WebhookAttempts) != 1 || !strings.Contains(job.WebhookAttempts[0].Error, "callback 地址不允许") {
+		t.Fatalf("attempts: %+v", job.WebhookAttempts)
+	}
+}
+
+func TestDenyPrivateAddr(t *testing.T) {
+	for addr, denied := range map[string]bool{
+		"127.0.0.1:80":                true,
+		"[::1]:443":                   true,
+		"10.1.2.3:80":                 true,
+		"172.16.0.1:80":               true,
+		"192.168.1.1:80":              true,
+		"169.254.169.254:80":          true, // cloud metadata
+		"[fe80::1]:80":                true,
+		"0.0.0.0:80":                  true,
+		"[fd00::1]:80":                true,
+		"0.1.2.3:80":                  true, // 0.0.0.0/8
+		"100.64.0.1:80":               true, // CGNAT
+		"100.127.255.254:80":          true,
+		"198.18.0.1:80":               true,
+		"255.255.255.255:80":          true,
+		"[::ffff:127.0.0.1]:80":       true, // IPv4-mapped
+		"[::ffff:10.0.0.1]:80":        true,
+		"[::ffff:169.254.169.254]:80": true,
+		"[::ffff:100.64.0.1]:80":      true,
+		"[64:ff9b::10.0.0.1]:80":      true, // NAT64 to a private address
+		"[64:ff9b::7f00:1]:80":        true,
+		"[64:ff9b:1::1]:80":           true, // local-use NAT64
+		"[2002:a00:1::1]:80":          true, // 6to4 of 10.0.0.1
+		"[fe80::1%eth0]:80":           true,
+		"8.8.8.8:443":                 false,
+		"100.128.0.1:80":              false, // just past CGNAT
+		"[::ffff:8.8.8.8]:443":        false,
+		"[64:ff9b::8.8.8.8]:443":      false,
+		"[2001:4860::1]:443":          false,
+	} {
+		err := denyPrivateAddr("tcp", addr, nil)
+		if (err != nil) != denied {
+			t.Errorf("%s: err = %v, want denied=%v", addr, err, denied)
+		}
+	}
+	if err := denyPrivateAddr("tcp", "no-port", nil); err == nil {
+		t.Error("malformed address accepted")
+	}
+}
+
+// The check runs in the dialer, after resolution, so every address form is refused before connecting.
+func TestDenyPrivateAddrDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	d := &net.Dialer{Timeout: time.Second, Control: denyPrivateAddr}
+	for _, host := range []string{"127.0.0.1", "localhost", "::ffff:127.0.0.1"} {
+		conn, err := d.Dial("tcp", net.JoinHostPort(host, port))
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: connected", host)
+			continue
+		}
+		if !strings.Contains(err.Error(), "callback 地址不允许") {
+			t.Errorf("%s: err = %v, want the deny-list error", host, err)
+		}
+	}
+	// Without the check the listener is reachable, so the refusals above came from denyPrivateAddr.
+	conn, err := (&net.Dialer{Timeout: time.Second}).Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+	"gobackend/streamq"
+)
+
+// NotifyingStore wraps a job store and enqueues a webhook delivery whenever an Update moves a
+// job with a CallbackURL into a notifiable status. Every binary that changes job status
+// (API notify/cancel, compare-worker, payment-worker) wraps its store with it.
+type NotifyingStore struct {
+	store.CompareJobStore
+	queue streamq.CompareQueue
+}
+
+func NewNotifyingStore(inner store.CompareJobStore, q streamq.CompareQueue) *NotifyingStore {
+	return &NotifyingStore{CompareJobStore: inner, queue: q}
+}
+
+func (s *NotifyingStore) Update(id string, fn func(j *domain.CompareJob)) (*domain.CompareJob, bool, error) {
+	var before domain.CompareJobStatus
+	j, ok, err := s.CompareJobStore.Update(id, func(j *domain.CompareJob) {
+		// Redis stores may run fn more than once (optimistic retry); the last run wins.
+		before = j.Status
+		fn(j)
+	})
+	if err != nil || !ok || j == nil {
+		return j, ok, err
+	}
+	if s.queue != nil && j.CallbackURL != "" && j.Status != before && Notifiable(j.Status) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if qerr := s.queue.Enqueue(ctx, j.ID); qerr != nil {
+			log.Printf("webhook enqueue failed job=%s status=%s: %v", j.ID, j.Status, qerr)
+		}
+	}
+	return j, ok, err
+}
+
+// Watch keeps store.CompareJobWatcher working through the wrapper.
+func (s *NotifyingStore) Watch(ctx context.Context, id string) (<-chan *domain.CompareJob, error) {
+	w, ok := s.CompareJobStore.(store.CompareJobWatcher)
+	if !ok {
+		return nil, errors.New("store 不支持 watch")
+	}
+	return w.Watch(ctx, id)
+}
@@ -0,0 +1,284 @@
+package wechat
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// platformCertCache holds the platform certificates downloaded from /v3/certificates, by serial
+// number. WeChat Pay lists the next certificate before it starts signing with it, so a periodic
+// refresh picks it up in time; a signature with a serial we don't know also triggers a refresh.
+type platformCertCache struct {
+	// refreshMu serializes downloads; mu guards the fields below.
+	refreshMu sync.Mutex
+	mu        sync.Mutex
+	certs     map[string]*x509.Certificate
+	triedAt   time.Time
+}
+
+var platformCerts = &platformCertCache{}
+
+// platformCertMinRetry limits on-demand downloads (empty cache, unknown serial).
+const platformCertMinRetry = time.Minute
+
+// platformCertDownloadEnabled: downloading needs the merchant key and the APIv3 key, and is off
+// with WECHAT_MOCK=1 or WECHAT_PLATFORM_CERT_DOWNLOAD=0.
+func platformCertDownloadEnabled() bool {
+	if strings.TrimSpace(os.Getenv("WECHAT_MOCK")) == "1" || strings.TrimSpace(os.Getenv("WECHAT_PLATFORM_CERT_DOWNLOAD")) == "0" {
+		return false
+	}
+	if !isValidWechatMchID(readWechatMchID()) {
+		return false
+	}
+	if _, err := readWechatAPIV3Key(); err != nil {
+		return false
+	}
+	_, _, _, err := resolveWechatpayCertPaths()
+	return err == nil
+}
+
+// RunPlatformCertRefresher downloads the platform certificates now and then every
+// WECHAT_PLATFORM_CERT_REFRESH_HOURS (default 12; after a failure within 10 minutes) until ctx is
+// done. It returns at once if downloading is disabled.
+func RunPlatformCertRefresher(ctx context.Context) {
+	if !platformCertDownloadEnabled() {
+		return
+	}
+	every := 12 * time.Hour
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("WECHAT_PLATFORM_CERT_REFRESH_HOURS"))); err == nil && n > 0 {
+		every = time.Duration(n) * time.Hour
+	}
+	for {
+		wait := every
+		if err := platformCerts.refresh(0); err != nil {
+			log.Printf("wechatpay: download platform certificates failed: %v", err)
+			wait = min(every, 10*time.Minute)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// snapshot returns the cached certificates, downloading them first if there are none yet.
+func (c *platformCertCache) snapshot() map[string]*x509.Certificate {
+	c.mu.Lock()
+	n := len(c.certs)
+	c.mu.Unlock()
+	if n == 0 {
+		if err := c.refresh(platformCertMinRetry); err != nil {
+			log.Printf("wechatpay: download platform certificates failed: %v", err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.certs
+}
+
+// lookup returns the certificate with serial, refreshing the list if it isn't cached.
+func (c *platformCertCache) lookup(serial string) *x509.Certificate {
+	c.mu.Lock()
+	cert := c.certs[serial]
+	c.mu.Unlock()
+	if cert != nil {
+		return cert
+	}
+	if err := c.refresh(platformCertMinRetry); err != nil {
+		log.Printf("wechatpay: download platform certificates for serial %s failed: %v", serial, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.certs[serial]
+}
+
+// refresh downloads the certificate list unless the last attempt is younger than minAge. The new
+// list replaces the cached one (expired certificates drop out of it).
+func (c *platformCertCache) refresh(minAge time.Duration) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	c.mu.Lock()
+	if minAge > 0 && time.Since(c.triedAt) < minAge {
+		c.mu.Unlock()
+		return nil
+	}
+	c.triedAt = time.Now()
+	c.mu.Unlock()
+
+	certs, err := downloadPlatformCertificates()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	added := make([]string, 0, len(certs))
+	for serial := range certs {
+		if c.certs[serial] == nil {
+			added = append(added, serial)
+		}
+	}
+	c.certs = certs
+	c.mu.Unlock()
+	if len(added) > 0 {
+		log.Printf("wechatpay: platform certificates updated, new serials=%s", strings.Join(added, ","))
+	}
+	return nil
+}
+
+type wechatpayCertificatesResponse struct {
+	Data []struct {
+		SerialNo           string `json:"serial_no"`
+		EffectiveTime      string `json:"effective_time"`
+		ExpireTime         string `json:"expire_time"`
+		EncryptCertificate struct {
+			Algorithm      string `json:"algorithm"`
+			Nonce          string `json:"nonce"`
+			AssociatedData string `json:"associated_data"`
+			Ciphertext     string `json:"ciphertext"`
+		} `json:"encrypt_certificate"`
+	} `json:"data"`
+}
+
+// downloadPlatformCertificates fetches and decrypts the current platform certificates.
+func downloadPlatformCertificates() (map[string]*x509.Certificate, error) {
+	mchID := readWechatMchID()
+	if !isValidWechatMchID(mchID) {
+		return nil, fmt.Errorf("WECHAT_MCHID 非法：%q（必须是纯数字直连商户号）", mchID)
+	}
+	apiV3Key, err := readWechatAPIV3Key()
+	if err != nil {
+		return nil, err
+	}
+	merchantKeyPath, merchantCertPath, _, err := resolveWechatpayCertPaths()
+	if err != nil {
+		return nil, err
+	}
+	merchantPrivateKey, err := loadRSAPrivateKeyFromPath(merchantKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载商户私钥失败: %w", err)
+	}
+	merchantCert, err := loadX509CertFromPath(merchantCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载商户证书失败: %w", err)
+	}
+	merchantSerial := strings.ToUpper(merchantCert.SerialNumber.Text(16))
+
+	u := "https://api.mch.weixin.qq.com/v3/certificates"
+	ts := fmt.Sprintf("%d", time.Now().Unix())
+	nonce := mustNonce()
+	canonicalURL, _ := url.Parse(u)
+	sig, err := wechatpaySignRequest(merchantPrivateKey, http.MethodGet, canonicalURL.RequestURI(), ts, nonce, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth := fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",timestamp="%s",serial_no="%s",signature="%s"`,
+		mchID, nonce, ts, merchantSerial, sig)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", auth)
+
+	client := &http.Client{Timeout: 20 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载平台证书请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("下载平台证书失败: %s", msg)
+	}
+	var out wechatpayCertificatesResponse
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+
+	// The certificates are authenticated by the APIv3 key (AES-GCM); the response must also be
+	// signed by one of them.
+	certs := make(map[string]*x509.Certificate, len(out.Data))
+	for _, item := range out.Data {
+		ec := item.EncryptCertificate
+		plain, err := decryptWechatpayResource(apiV3Key, ec.AssociatedData, ec.Nonce, ec.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("解密平台证书 %s 失败: %w", item.SerialNo, err)
+		}
+		block, _ := pem.Decode(plain)
+		if block == nil {
+			return nil, fmt.Errorf("平台证书 %s 不是 PEM", item.SerialNo)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析平台证书 %s 失败: %w", item.SerialNo, err)
+		}
+		serial := normalizeWechatpaySerial(cert.SerialNumber.Text(16))
+		if serial != normalizeWechatpaySerial(item.SerialNo) {
+			return nil, fmt.Errorf("平台证书序列号不符: %s != %s", item.SerialNo, serial)
+		}
+		certs[serial] = cert
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("下载平台证书失败: 应答中没有证书")
+	}
+	serial := normalizeWechatpaySerial(resp.Header.Get("Wechatpay-Serial"))
+	signer := certs[serial]
+	if signer == nil {
+		return nil, fmt.Errorf("平台证书应答的签名序列号 %q 不在证书列表中", serial)
+	}
+	pub, ok := signer.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("平台证书公钥不是 RSA")
+	}
+	if err := verifyWechatpaySignature(pub, resp.Header, b); err != nil {
+		return nil, fmt.Errorf("平台证书应答验签失败: %w", err)
+	}
+	return certs, nil
+}
+
+// normalizeWechatpaySerial makes certificate serials comparable: upper case hex without leading
+// zeros (x509 serials print without them, WeChat Pay sometimes pads).
+func normalizeWechatpaySerial(serial string) string {
+	serial = strings.ToUpper(strings.TrimSpace(serial))
+	if strings.HasPrefix(serial, "PUB_KEY_ID_") {
+		return serial
+	}
+	if s := strings.TrimLeft(serial, "0"); s != "" {
+		return s
+	}
+	return serial
+}
+
+// verifyWechatpaySignature checks the Wechatpay-Signature header with pub.
+func verifyWechatpaySignature(pub *rsa.PublicKey, h http.Header, body []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(h.Get("Wechatpay-Signature"))
+	if err != nil {
+		return err
+	}
+	msg := h.Get("Wechatpay-Timestamp") + "\n" + h.Get("Wechatpay-Nonce") + "\n" + string(body) + "\n"
+	digest := sha256.Sum256([]byte(msg))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+}
@@ -0,0 +1,127 @@
+package wechat
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNormalizeWechatpaySerial(t *testing.T) {
+	for in, want := range map[string]string{
+		"5157F09EFDC096DE15EBE81A47057A7232F1B8E1":   "5157F09EFDC096DE15EBE81A47057A7232F1B8E1",
+		"005157f09efdc096de15ebe81a47057a7232f1b8e1": "5157F09EFDC096DE15EBE81A47057A7232F1B8E1",
+		" 0abcdef ":       "ABCDEF",
+		"0":               "0",
+		"0000":            "0000",
+		"PUB_KEY_ID_0011": "PUB_KEY_ID_0011",
+		"pub_key_id_0011": "PUB_KEY_ID_0011",
+	} {
+		if got := normalizeWechatpaySerial(in); got != want {
+			t.Errorf("normalizeWechatpaySerial(%q) = %q, want %q", in, got, want)
+		}
+	}
+	// x509 prints serials without the padding WeChat Pay may send.
+	pc := newTestPlatformCert(t, 0x0abcdef)
+	if normalizeWechatpaySerial(pc.cert.SerialNumber.Text(16)) != normalizeWechatpaySerial("000"+pc.serial) {
+		t.Fatalf("serial %s does not match its padded form", pc.serial)
+	}
+}
+
+func TestPlatformCertLookupRefreshesRateLimited(t *testing.T) {
+	f := newFakeWechatPay(t)
+	current := newTestPlatformCert(t, 0x1001)
+	next := newTestPlatformCert(t, 0x1002)
+	f.enableCertDownload(current)
+	c := &platformCertCache{}
+
+	// Empty cache: the first lookup downloads, and the padded serial_no is stored normalized.
+	if cert := c.lookup(current.serial); cert == nil || cert.SerialNumber.Cmp(current.cert.SerialNumber) != 0 {
+		t.Fatalf("lookup(%s) = %v", current.serial, cert)
+	}
+	if f.certCalls != 1 {
+		t.Fatalf("downloads = %d, want 1", f.certCalls)
+	}
+	// Known serials don't download again.
+	c.lookup(current.serial)
+	if f.certCalls != 1 {
+		t.Fatalf("downloads after a cached lookup = %d, want 1", f.certCalls)
+	}
+
+	// WeChat Pay starts listing the next certificate. An unknown serial refreshes, at most once
+	// per platformCertMinRetry however often it is asked for.
+	f.mu.Lock()
+	f.certs = []testPlatformCert{current, next}
+	f.mu.Unlock()
+	for i := 0; i < 5; i++ {
+		if cert := c.lookup(next.serial); cert != nil {
+			t.Fatalf("lookup %d found %s before the retry interval passed", i, next.serial)
+		}
+	}
+	if f.certCalls != 1 {
+		t.Fatalf("downloads for unknown serials within the retry interval = %d, want 1", f.certCalls)
+	}
+
+	c.mu.Lock()
+	c.triedAt = time.Now().Add(-platformCertMinRetry)
+	c.mu.Unlock()
+	if cert := c.lookup(next.serial); cert == nil {
+		t.Fatalf("rotated certificate %s not found after refresh", next.serial)
+	}
+	if f.certCalls != 2 {
+		t.Fatalf("downloads = %d, want 2", f.certCalls)
+	}
+	if c.lookup("DEADBEEF") != nil || f.certCalls != 2 {
+		t.Fatalf("unknown serial: downloads = %d, want 2 (rate limited)", f.certCalls)
+	}
+
+	// The scheduled refresh ignores the limit; certificates no longer listed drop out.
+	f.mu.Lock()
+	f.certs = []testPlatformCert{next}
+	f.mu.Unlock()
+	if err := c.refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if f.certCalls != 3 || len(c.snapshot()) != 1 {
+		t.Fatalf("downloads = %d, cached = %d; want 3 / 1", f.certCalls, len(c.snapshot()))
+	}
+}
+
+func TestVerifyWithRotatedPlatformCert(t *testing.T) {
+	f := newFakeWechatPay(t)
+	current := newTestPlatformCert(t, 0x2001)
+	next := newTestPlatformCert(t, 0x2002)
+	f.enableCertDownload(current)
+
+	v, err := loadWechatpayVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"id":"EV-1"}`)
+	h := http.Header{}
+	signResponse(t, h, current.key, "00"+current.serial, body)
+	if err := v.Verify(h, body); err != nil {
+		t.Fatalf("current certificate: %v", err)
+	}
+
+	// Signed with a certificate issued after our last download.
+	f.mu.Lock()
+	f.certs = []testPlatformCert{current, next}
+	f.mu.Unlock()
+	platformCerts.mu.Lock()
+	platformCerts.triedAt = time.Now().Add(-platformCertMinRetry)
+	platformCerts.mu.Unlock()
+	h = http.Header{}
+	signResponse(t, h, next.key, next.serial, body)
+	if err := v.Verify(h, body); err != nil {
+		t.Fatalf("rotated certificate: %v", err)
+	}
+	if f.certCalls != 2 {
+		t.Fatalf("downloads = %d, want 2", f.certCalls)
+	}
+
+	// A valid serial does not make a bad signature pass.
+	h.Set("Wechatpay-Signature", "AAAA")
+	if err := v.Verify(h, body); err == nil {
+		t.Fatal("bad signature accepted")
+	}
+}
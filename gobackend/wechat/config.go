@@ -91,6 +91,18 @@ func readWechatNotifyURL() string {
 	return strings.TrimSpace(os.Getenv("WECHAT_NOTIFY_URL"))
 }
 
+// readWechatRefundNotifyURL defaults to the refund notify next to WECHAT_NOTIFY_URL
+// (".../wechatpay/notify" -> ".../wechatpay/refund-notify").
+func readWechatRefundNotifyURL() string {
+	if v := strings.TrimSpace(os.Getenv("WECHAT_REFUND_NOTIFY_URL")); v != "" {
+		return v
+	}
+	if base, ok := strings.CutSuffix(strings.TrimRight(readWechatNotifyURL(), "/"), "/wechatpay/notify"); ok {
+		return base + "/wechatpay/refund-notify"
+	}
+	return ""
+}
+
 // Platform verification config (either platform certificate or platform public key).
 // If you configure platform public key mode, provide:
 // - WECHAT_PLATFORM_PUBLIC_KEY_ID (value like PUB_KEY_ID_...)
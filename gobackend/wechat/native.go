@@ -144,6 +144,71 @@ func CloseNativeOrder(outTradeNo string) error {
 	return wechatpayPostCloseOrder(mchID, merchantSerial, merchantPrivateKey, verifier, outTradeNo)
 }
 
+// Transaction is a Native order as WeChat Pay reports it (order query / payment notify).
+type Transaction struct {
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionID string `json:"transaction_id"`
+	// TradeState: SUCCESS / NOTPAY / CLOSED / REFUND / USERPAYING / PAYERROR / REVOKED
+	TradeState  string `json:"trade_state"`
+	SuccessTime string `json:"success_time"`
+	Amount      struct {
+		Total int64 `json:"total"`
+	} `json:"amount"`
+}
+
+// Paid reports whether the order has been paid.
+func (t *Transaction) Paid() bool {
+	return strings.ToUpper(t.TradeState) == "SUCCESS"
+}
+
+// ErrOrderNotExist: WeChat Pay has no order with this out_trade_no (never created, or free job).
+var ErrOrderNotExist = errors.New("微信订单不存在")
+
+// QueryNativeOrder asks WeChat Pay for the state of an order by out_trade_no. The response is
+// verified with the platform key, since callers act on it like on a notify.
+// With WECHAT_MOCK=1 every order is reported unpaid.
+func QueryNativeOrder(outTradeNo string) (*Transaction, error) {
+	if strings.TrimSpace(outTradeNo) == "" {
+		return nil, errors.New("out_trade_no 为空")
+	}
+
+	if strings.TrimSpace(os.Getenv("WECHAT_MOCK")) == "1" {
+		return &Transaction{OutTradeNo: outTradeNo, TradeState: "NOTPAY"}, nil
+	}
+
+	mchID := readWechatMchID()
+	if mchID == "" {
+		return nil, errors.New("缺少 WECHAT_MCHID")
+	}
+	if !isValidWechatMchID(mchID) {
+		return nil, fmt.Errorf("WECHAT_MCHID 非法：%q（必须是纯数字直连商户号）", mchID)
+	}
+
+	merchantKeyPath, merchantCertPath, _, err := resolveWechatpayCertPaths()
+	if err != nil {
+		return nil, err
+	}
+	merchantPrivateKey, err := loadRSAPrivateKeyFromPath(merchantKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载商户私钥失败: %w", err)
+	}
+	merchantCert, err := loadX509CertFromPath(merchantCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载商户证书失败: %w", err)
+	}
+	verifier, err := loadWechatpayVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	merchantSerial := strings.ToUpper(merchantCert.SerialNumber.Text(16))
+	if merchantSerial == "" {
+		return nil, errors.New("无法获取商户证书序列号")
+	}
+
+	return wechatpayGetOrder(mchID, merchantSerial, merchantPrivateKey, verifier, outTradeNo)
+}
+
 func wechatpayPostNativePrepay(mchID, merchantSerial string, merchantPriv *rsa.PrivateKey, verifier *wechatpayVerifier, body []byte) (string, error) {
 	u := "https://api.mch.weixin.qq.com/v3/pay/transactions/native"
 	ts := fmt.Sprintf("%d", time.Now().Unix())
@@ -236,6 +301,57 @@ func wechatpayPostCloseOrder(mchID, merchantSerial string, merchantPriv *rsa.Pri
 	return nil
 }
 
+func wechatpayGetOrder(mchID, merchantSerial string, merchantPriv *rsa.PrivateKey, verifier *wechatpayVerifier, outTradeNo string) (*Transaction, error) {
+	u := "https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/" + url.PathEscape(outTradeNo) + "?mchid=" + url.QueryEscape(mchID)
+	ts := fmt.Sprintf("%d", time.Now().Unix())
+	nonce := mustNonce()
+
+	canonicalURL, _ := url.Parse(u)
+	sig, err := wechatpaySignRequest(merchantPriv, http.MethodGet, canonicalURL.RequestURI(), ts, nonce, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth := fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",timestamp="%s",serial_no="%s",signature="%s"`,
+		mchID, nonce, ts, merchantSerial, sig)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", auth)
+
+	client := &http.Client{Timeout: 20 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("微信查单请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<10))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrOrderNotExist
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("微信查单失败: %s", msg)
+	}
+	if err := verifier.Verify(resp.Header, b); err != nil {
+		return nil, fmt.Errorf("微信查单应答验签失败: %w", err)
+	}
+	var tx Transaction
+	if err := json.Unmarshal(b, &tx); err != nil {
+		return nil, err
+	}
+	if tx.OutTradeNo != outTradeNo {
+		return nil, fmt.Errorf("微信查单应答 out_trade_no 不符: %s", tx.OutTradeNo)
+	}
+	return &tx, nil
+}
+
 func wechatpaySignRequest(priv *rsa.PrivateKey, method, canonicalURL, timestamp, nonce string, body []byte) (string, error) {
 	// message = method + "\n" + canonical_url + "\n" + timestamp + "\n" + nonce + "\n" + body + "\n"
 	msg := method + "\n" + canonicalURL + "\n" + timestamp + "\n" + nonce + "\n" + string(body) + "\n"
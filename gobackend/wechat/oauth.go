@@ -0,0 +1,133 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// WeChat OAuth login (website QR login via open.weixin.qq.com, or snsapi_userinfo inside the
+// WeChat browser). Uses WECHAT_OAUTH_APPID / WECHAT_OAUTH_SECRET, which belong to the open-platform
+// website app and are usually not the payment appid.
+
+// OAuthUser is the WeChat identity returned by a login.
+type OAuthUser struct {
+	OpenID    string
+	UnionID   string
+	Nickname  string
+	AvatarURL string
+}
+
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+func readWechatOAuthAppID() string  { return strings.TrimSpace(os.Getenv("WECHAT_OAUTH_APPID")) }
+func readWechatOAuthSecret() string { return strings.TrimSpace(os.Getenv("WECHAT_OAUTH_SECRET")) }
+
+func oauthMock() bool { return strings.TrimSpace(os.Getenv("WECHAT_MOCK")) == "1" }
+
+// OAuthEnabled reports whether WeChat login is configured (always true with WECHAT_MOCK=1).
+func OAuthEnabled() bool {
+	return oauthMock() || (readWechatOAuthAppID() != "" && readWechatOAuthSecret() != "")
+}
+
+// OAuthAuthorizeURL is where the browser is sent to log in. redirectURI must be on the domain
+// registered for the app. inWeChat picks the in-app authorize page instead of the QR page.
+// With WECHAT_MOCK=1 it points straight back to redirectURI with a fake code.
+func OAuthAuthorizeURL(redirectURI, state string, inWeChat bool) string {
+	if oauthMock() {
+		sep := "?"
+		if strings.Contains(redirectURI, "?") {
+			sep = "&"
+		}
+		return redirectURI + sep + url.Values{"code": {"mock"}, "state": {state}}.Encode()
+	}
+	q := url.Values{}
+	q.Set("appid", readWechatOAuthAppID())
+	q.Set("redirect_uri", redirectURI)
+	q.Set("response_type", "code")
+	q.Set("state", state)
+	if inWeChat {
+		q.Set("scope", "snsapi_userinfo")
+		return "https://open.weixin.qq.com/connect/oauth2/authorize?" + q.Encode() + "#wechat_redirect"
+	}
+	q.Set("scope", "snsapi_login")
+	return "https://open.weixin.qq.com/connect/qrconnect?" + q.Encode() + "#wechat_redirect"
+}
+
+type oauthError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// OAuthExchange trades the callback code for the user's identity and profile.
+func OAuthExchange(ctx context.Context, code string) (*OAuthUser, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, errors.New("code 为空")
+	}
+	if oauthMock() {
+		return &OAuthUser{OpenID: "mock-openid-" + code, Nickname: "测试用户"}, nil
+	}
+	if !OAuthEnabled() {
+		return nil, errors.New("缺少 WECHAT_OAUTH_APPID/WECHAT_OAUTH_SECRET")
+	}
+
+	var tok struct {
+		oauthError
+		AccessToken string `json:"access_token"`
+		OpenID      string `json:"openid"`
+		UnionID     string `json:"unionid"`
+		Scope       string `json:"scope"`
+	}
+	q := url.Values{}
+	q.Set("appid", readWechatOAuthAppID())
+	q.Set("secret", readWechatOAuthSecret())
+	q.Set("code", code)
+	q.Set("grant_type", "authorization_code")
+	if err := oauthGet(ctx, "https://api.weixin.qq.com/sns/oauth2/access_token?"+q.Encode(), &tok); err != nil {
+		return nil, err
+	}
+	if tok.ErrCode != 0 || tok.OpenID == "" {
+		return nil, fmt.Errorf("微信登录换取 access_token 失败: %d %s", tok.ErrCode, tok.ErrMsg)
+	}
+	u := &OAuthUser{OpenID: tok.OpenID, UnionID: tok.UnionID}
+
+	// Profile is best-effort: the login itself only needs the openid.
+	var info struct {
+		oauthError
+		Nickname   string `json:"nickname"`
+		HeadImgURL string `json:"headimgurl"`
+		UnionID    string `json:"unionid"`
+	}
+	q = url.Values{"access_token": {tok.AccessToken}, "openid": {tok.OpenID}, "lang": {"zh_CN"}}
+	if err := oauthGet(ctx, "https://api.weixin.qq.com/sns/userinfo?"+q.Encode(), &info); err == nil && info.ErrCode == 0 {
+		u.Nickname = info.Nickname
+		u.AvatarURL = info.HeadImgURL
+		if u.UnionID == "" {
+			u.UnionID = info.UnionID
+		}
+	}
+	return u, nil
+}
+
+func oauthGet(ctx context.Context, u string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("微信接口返回 %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
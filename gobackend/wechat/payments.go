@@ -0,0 +1,129 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// OrderSettler settles a paid non-job order (wallet top-up, plan purchase). It must be
+// idempotent and wrap ErrAmountMismatch when paidFen is not the order amount.
+type OrderSettler func(outTradeNo string, paidFen int64) error
+
+var (
+	ErrAmountMismatch = errors.New("amount mismatch")
+	// ErrOrderTypeDisabled: a top-up / plan order was paid but that feature is not enabled here.
+	ErrOrderTypeDisabled = errors.New("order type disabled")
+)
+
+// Payments applies successful WeChat Pay payments, however we learn of them: the notify, the
+// paygate reconciler or a user asking to check again (both via QueryNativeOrder). Applying the
+// same payment again is a no-op. It also refunds job payments (refunds.go).
+type Payments struct {
+	store  store.CompareJobStore
+	orders map[string]OrderSettler
+}
+
+// NewPayments routes out_trade_no values starting with a key of orders (e.g.
+// domain.TopUpTradePrefix) to that settler; everything else is a compare job in st.
+func NewPayments(st store.CompareJobStore, orders map[string]OrderSettler) *Payments {
+	return &Payments{store: st, orders: orders}
+}
+
+// ApplyPaid applies a successful payment of totalFen for outTradeNo. actor is recorded on the
+// job's history.
+func (p *Payments) ApplyPaid(outTradeNo string, totalFen int64, actor string) error {
+	for prefix, settle := range p.orders {
+		if strings.HasPrefix(outTradeNo, prefix) {
+			return settle(outTradeNo, totalFen)
+		}
+	}
+	if strings.HasPrefix(outTradeNo, domain.TopUpTradePrefix) || strings.HasPrefix(outTradeNo, domain.SubscriptionTradePrefix) {
+		return fmt.Errorf("%w: %s", ErrOrderTypeDisabled, outTradeNo)
+	}
+	return p.applyJobPaid(outTradeNo, totalFen, actor)
+}
+
+// applyJobPaid marks the job paid and releases it, or sends it back to processing until the
+// compare result is stored (the paygate releases it then).
+func (p *Payments) applyJobPaid(jobID string, totalFen int64, actor string) error {
+	job, ok, err := p.store.Get(jobID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Printf("wechatpay: paid job not found out_trade_no=%s", jobID)
+		return nil
+	}
+	if job.Paid {
+		if job.PaidVia != domain.PaidViaWeChat {
+			log.Printf("wechatpay: job paid again by WeChat out_trade_no=%s paidVia=%s total=%d", jobID, job.PaidVia, totalFen)
+		}
+		return nil
+	}
+
+	// 金额校验：以 job 上记录的 amount 为准（单位：分）。若未记录则回退为 1 分（兼容旧逻辑/竞态）。
+	expectedFen := int64(math.Round(job.AmountYuan * 100))
+	if expectedFen <= 0 {
+		expectedFen = 1
+	}
+	if totalFen != expectedFen {
+		return fmt.Errorf("%w: out_trade_no=%s expected=%d total=%d", ErrAmountMismatch, jobID, expectedFen, totalFen)
+	}
+
+	now := time.Now()
+	_, ok, err = p.store.Update(jobID, func(j *domain.CompareJob) {
+		// 幂等：已支付就不重复写
+		if j.Paid {
+			return
+		}
+		j.Paid = true
+		j.PaidAt = &now
+		j.PaidVia = domain.PaidViaWeChat
+		// 如果结果已生成，则放行；否则先退出“等待支付”，继续轮询直到 ready。
+		// 已取消/失败的任务由状态机拒绝，只记录已支付。
+		to := domain.CompareJobStatusProcessing
+		if hasResult(j) {
+			to = domain.CompareJobStatusReady
+		}
+		if err := j.Transition(to, actor, "paid"); err != nil {
+			log.Printf("wechatpay: paid but %v out_trade_no=%s", err, jobID)
+			return
+		}
+		if to == domain.CompareJobStatusReady {
+			j.AmountYuan = 0
+			j.CodeURL = ""
+		}
+	})
+	if err == nil && !ok {
+		log.Printf("wechatpay: job not found out_trade_no=%s", jobID)
+	}
+	return err
+}
+
+// Check asks WeChat Pay about outTradeNo and applies the payment if it went through.
+func (p *Payments) Check(outTradeNo, actor string) (*Transaction, error) {
+	tx, err := QueryNativeOrder(outTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Paid() {
+		if err := p.ApplyPaid(outTradeNo, tx.Amount.Total, actor); err != nil {
+			return tx, err
+		}
+	}
+	return tx, nil
+}
+
+func hasResult(job *domain.CompareJob) bool {
+	if job == nil {
+		return false
+	}
+	return strings.TrimSpace(job.ResultOSSKey) != "" || strings.TrimSpace(job.ResultPath) != ""
+}
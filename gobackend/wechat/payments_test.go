@@ -0,0 +1,105 @@
+package wechat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// awaitingJob stores a job waiting for a 1 yuan WeChat payment.
+func awaitingJob(t *testing.T, st store.CompareJobStore, id string, withResult bool) {
+	t.Helper()
+	job := &domain.CompareJob{ID: id, OwnerID: "u1", CreatedAt: time.Now(), AmountYuan: 1, CodeURL: "weixin://wxpay/bizpayurl?pr=x"}
+	if withResult {
+		job.ResultOSSKey = "results/" + id + ".xlsx"
+	}
+	for _, s := range []domain.CompareJobStatus{domain.CompareJobStatusProcessing, domain.CompareJobStatusAwaitingPayment} {
+		if err := job.Transition(s, domain.ActorAPI, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Create(job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func paidEvents(job *domain.CompareJob) int {
+	n := 0
+	for _, ev := range job.Events {
+		if ev.Reason == "paid" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCheckMarksJobPaidOnce(t *testing.T) {
+	f := newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	awaitingJob(t, st, "job_1", true)
+
+	tx, err := p.Check("job_1", domain.ActorPaymentReconciler)
+	if err != nil || tx.Paid() {
+		t.Fatalf("unpaid order: tx=%+v err=%v", tx, err)
+	}
+	if job, _, _ := st.Get("job_1"); job.Paid || job.Status != domain.CompareJobStatusAwaitingPayment {
+		t.Fatalf("NOTPAY changed the job: status=%s paid=%v", job.Status, job.Paid)
+	}
+
+	f.setPaid("job_1", 100)
+	for i := 0; i < 3; i++ { // reconciler rounds, user re-checks, a late notify
+		tx, err := p.Check("job_1", domain.ActorPaymentReconciler)
+		if err != nil || !tx.Paid() || tx.TransactionID != "42job_1" {
+			t.Fatalf("check %d: tx=%+v err=%v", i, tx, err)
+		}
+	}
+	if err := p.ApplyPaid("job_1", 100, domain.ActorWeChatNotify); err != nil {
+		t.Fatal(err)
+	}
+
+	job, _, _ := st.Get("job_1")
+	if !job.Paid || job.PaidVia != domain.PaidViaWeChat || job.Status != domain.CompareJobStatusReady || job.CodeURL != "" {
+		t.Fatalf("job: status=%s paid=%v via=%s code=%q", job.Status, job.Paid, job.PaidVia, job.CodeURL)
+	}
+	if n := paidEvents(job); n != 1 {
+		t.Fatalf("%d paid events, want 1: %+v", n, job.Events)
+	}
+	if last := job.Events[len(job.Events)-1]; last.Actor != domain.ActorPaymentReconciler {
+		t.Fatalf("paid by %q, want the reconciler", last.Actor)
+	}
+}
+
+func TestCheckPaidBeforeResult(t *testing.T) {
+	f := newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	awaitingJob(t, st, "job_1", false)
+	f.setPaid("job_1", 100)
+
+	if _, err := p.Check("job_1", domain.ActorPaymentReconciler); err != nil {
+		t.Fatal(err)
+	}
+	// Back to processing until the paygate stores the result and releases it.
+	if job, _, _ := st.Get("job_1"); !job.Paid || job.Status != domain.CompareJobStatusProcessing {
+		t.Fatalf("job: status=%s paid=%v", job.Status, job.Paid)
+	}
+}
+
+func TestCheckAmountMismatch(t *testing.T) {
+	f := newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	awaitingJob(t, st, "job_1", true)
+	f.setPaid("job_1", 5)
+
+	if _, err := p.Check("job_1", domain.ActorPaymentReconciler); !errors.Is(err, ErrAmountMismatch) {
+		t.Fatalf("err = %v, want ErrAmountMismatch", err)
+	}
+	if job, _, _ := st.Get("job_1"); job.Paid {
+		t.Fatal("job paid with the wrong amount")
+	}
+}
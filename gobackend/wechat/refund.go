@@ -0,0 +1,171 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// RefundRequest refunds part or all of a paid Native order.
+type RefundRequest struct {
+	OutTradeNo  string
+	OutRefundNo string
+	Reason      string
+	RefundFen   int64
+	// TotalFen is the order amount (WeChat Pay checks it).
+	TotalFen int64
+}
+
+// Refund is a refund as WeChat Pay reports it (refund response / refund notify).
+type Refund struct {
+	OutTradeNo  string `json:"out_trade_no"`
+	OutRefundNo string `json:"out_refund_no"`
+	RefundID    string `json:"refund_id"`
+	// SUCCESS / CLOSED / PROCESSING / ABNORMAL: "status" in the response, "refund_status" in the notify.
+	Status       string `json:"status"`
+	RefundStatus string `json:"refund_status"`
+	SuccessTime  string `json:"success_time"`
+	Amount       struct {
+		Total  int64 `json:"total"`
+		Refund int64 `json:"refund"`
+	} `json:"amount"`
+}
+
+// State returns the refund status in upper case, whichever field carried it.
+func (r *Refund) State() string {
+	if r.Status != "" {
+		return strings.ToUpper(r.Status)
+	}
+	return strings.ToUpper(r.RefundStatus)
+}
+
+// ErrRefundRejected: WeChat Pay refused the refund request, so nothing was refunded. Other errors
+// from CreateRefund leave the outcome unknown; resending the same out_refund_no is safe.
+var ErrRefundRejected = errors.New("微信拒绝退款")
+
+// CreateRefund requests a refund (POST /v3/refund/domestic/refunds). The result arrives on the
+// refund notify unless the response already reports it.
+// With WECHAT_MOCK=1 every refund succeeds at once.
+func CreateRefund(req RefundRequest) (*Refund, error) {
+	if strings.TrimSpace(req.OutTradeNo) == "" || strings.TrimSpace(req.OutRefundNo) == "" {
+		return nil, errors.New("out_trade_no/out_refund_no 为空")
+	}
+	if req.RefundFen <= 0 || req.RefundFen > req.TotalFen {
+		return nil, errors.New("退款金额必须为正数且不超过订单金额(分)")
+	}
+
+	if strings.TrimSpace(os.Getenv("WECHAT_MOCK")) == "1" {
+		out := &Refund{OutTradeNo: req.OutTradeNo, OutRefundNo: req.OutRefundNo, RefundID: "mock_" + req.OutRefundNo, Status: "SUCCESS"}
+		out.SuccessTime = time.Now().Format(time.RFC3339)
+		out.Amount.Total, out.Amount.Refund = req.TotalFen, req.RefundFen
+		return out, nil
+	}
+
+	mchID := readWechatMchID()
+	if mchID == "" {
+		return nil, errors.New("缺少 WECHAT_MCHID")
+	}
+	if !isValidWechatMchID(mchID) {
+		return nil, fmt.Errorf("WECHAT_MCHID 非法：%q（必须是纯数字直连商户号）", mchID)
+	}
+
+	merchantKeyPath, merchantCertPath, _, err := resolveWechatpayCertPaths()
+	if err != nil {
+		return nil, err
+	}
+	merchantPrivateKey, err := loadRSAPrivateKeyFromPath(merchantKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载商户私钥失败: %w", err)
+	}
+	merchantCert, err := loadX509CertFromPath(merchantCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载商户证书失败: %w", err)
+	}
+	verifier, err := loadWechatpayVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	merchantSerial := strings.ToUpper(merchantCert.SerialNumber.Text(16))
+	if merchantSerial == "" {
+		return nil, errors.New("无法获取商户证书序列号")
+	}
+
+	reqBody := map[string]interface{}{
+		"out_trade_no":  req.OutTradeNo,
+		"out_refund_no": req.OutRefundNo,
+		"amount": map[string]interface{}{
+			"refund":   req.RefundFen,
+			"total":    req.TotalFen,
+			"currency": "CNY",
+		},
+	}
+	if req.Reason != "" {
+		reqBody["reason"] = req.Reason
+	}
+	// Without notify_url WeChat Pay uses the one configured in the merchant platform.
+	if u := readWechatRefundNotifyURL(); u != "" {
+		reqBody["notify_url"] = u
+	}
+	bodyBytes, _ := json.Marshal(reqBody)
+
+	return wechatpayPostRefund(mchID, merchantSerial, merchantPrivateKey, verifier, bodyBytes)
+}
+
+func wechatpayPostRefund(mchID, merchantSerial string, merchantPriv *rsa.PrivateKey, verifier *wechatpayVerifier, body []byte) (*Refund, error) {
+	u := "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds"
+	ts := fmt.Sprintf("%d", time.Now().Unix())
+	nonce := mustNonce()
+	canonicalURL, _ := url.Parse(u)
+	sig, err := wechatpaySignRequest(merchantPriv, http.MethodPost, canonicalURL.RequestURI(), ts, nonce, body)
+	if err != nil {
+		return nil, err
+	}
+	auth := fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",timestamp="%s",serial_no="%s",signature="%s"`,
+		mchID, nonce, ts, merchantSerial, sig)
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", auth)
+
+	client := &http.Client{Timeout: 20 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("微信退款请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		// 4xx: the request was refused (bad amount, insufficient balance, frequency limit ...);
+		// 5xx: the refund may or may not have been accepted.
+		if resp.StatusCode < 500 {
+			return nil, fmt.Errorf("%w: %s", ErrRefundRejected, msg)
+		}
+		return nil, fmt.Errorf("微信退款失败: %s", msg)
+	}
+	if err := verifier.Verify(resp.Header, b); err != nil {
+		return nil, fmt.Errorf("微信退款应答验签失败: %w", err)
+	}
+	var out Refund
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,242 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gobackend/domain"
+)
+
+var (
+	ErrJobNotFound = errors.New("任务不存在")
+	// ErrNotRefundable: the job's WeChat order was never paid (or doesn't exist).
+	ErrNotRefundable = errors.New("该任务没有可退款的微信支付")
+	ErrRefundAmount  = errors.New("退款金额无效或超过可退金额")
+	ErrRefundReason  = errors.New("退款原因最多 80 个字")
+	// ErrRefundNotPending: only a refund still processing can be resent.
+	ErrRefundNotPending = errors.New("退款不存在或已有结果")
+)
+
+// Refund refunds amountFen (0 = all that is still refundable) of the job's WeChat Pay payment.
+// The refund is recorded on the job as processing before WeChat Pay is asked, so concurrent
+// refunds can't exceed the payment; a rejected request marks it failed. If the outcome is unknown
+// the refund stays processing (with the error) until the refund notify or ResendRefund settles it.
+func (p *Payments) Refund(jobID string, amountFen int64, reason string) (*domain.CompareJobRefund, error) {
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > 80 {
+		return nil, ErrRefundReason
+	}
+	if amountFen < 0 {
+		return nil, ErrRefundAmount
+	}
+	if _, ok, err := p.store.Get(jobID); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrJobNotFound
+	}
+	tx, err := QueryNativeOrder(jobID)
+	if errors.Is(err, ErrOrderNotExist) {
+		return nil, ErrNotRefundable
+	}
+	if err != nil {
+		return nil, err
+	}
+	// REFUND: paid and (partly) refunded already.
+	if st := strings.ToUpper(tx.TradeState); st != "SUCCESS" && st != "REFUND" {
+		return nil, ErrNotRefundable
+	}
+	total := tx.Amount.Total
+
+	var rf domain.CompareJobRefund
+	var reserveErr error
+	_, ok, err := p.store.Update(jobID, func(j *domain.CompareJob) {
+		left := j.RefundableFen(total)
+		amt := amountFen
+		if amt == 0 {
+			amt = left
+		}
+		if amt <= 0 || amt > left {
+			reserveErr = fmt.Errorf("%w: 可退 %d 分", ErrRefundAmount, left)
+			return
+		}
+		rf = domain.CompareJobRefund{
+			ID:        fmt.Sprintf("%s%s_%d", domain.RefundTradePrefix, j.ID, len(j.Refunds)+1),
+			AmountFen: amt,
+			TotalFen:  total,
+			Reason:    reason,
+			Status:    domain.RefundStatusProcessing,
+			CreatedAt: time.Now(),
+		}
+		j.Refunds = append(append([]domain.CompareJobRefund(nil), j.Refunds...), rf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if reserveErr != nil {
+		return nil, reserveErr
+	}
+	log.Printf("wechatpay: refund job=%s refund=%s amount=%d/%d reason=%q", jobID, rf.ID, rf.AmountFen, total, reason)
+	return p.sendRefund(jobID, rf)
+}
+
+// ResendRefund asks WeChat Pay again for a refund whose outcome is unknown (same out_refund_no,
+// so it is refunded at most once).
+func (p *Payments) ResendRefund(jobID, refundID string) (*domain.CompareJobRefund, error) {
+	job, ok, err := p.store.Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	rf, ok := job.Refund(refundID)
+	if !ok || rf.Status != domain.RefundStatusProcessing {
+		return nil, ErrRefundNotPending
+	}
+	return p.sendRefund(jobID, *rf)
+}
+
+func (p *Payments) sendRefund(jobID string, rf domain.CompareJobRefund) (*domain.CompareJobRefund, error) {
+	res, err := CreateRefund(RefundRequest{
+		OutTradeNo:  jobID,
+		OutRefundNo: rf.ID,
+		Reason:      rf.Reason,
+		RefundFen:   rf.AmountFen,
+		TotalFen:    rf.TotalFen,
+	})
+	if err != nil {
+		status := domain.RefundStatusProcessing
+		if errors.Is(err, ErrRefundRejected) {
+			status = domain.RefundStatusFailed
+		}
+		log.Printf("wechatpay: refund job=%s refund=%s: %v", jobID, rf.ID, err)
+		updated, uerr := p.updateRefund(jobID, rf.ID, func(r *domain.CompareJobRefund) {
+			r.Error = err.Error()
+			if r.Status == domain.RefundStatusProcessing {
+				r.Status = status
+			}
+		})
+		if uerr != nil {
+			log.Printf("wechatpay: record refund error job=%s refund=%s: %v", jobID, rf.ID, uerr)
+			return &rf, err
+		}
+		return updated, err
+	}
+	if err := p.ApplyRefund(res); err != nil {
+		return &rf, err
+	}
+	job, _, err := p.store.Get(jobID)
+	if err != nil || job == nil {
+		return &rf, err
+	}
+	out, _ := job.Refund(rf.ID)
+	return out, nil
+}
+
+// ApplyRefund records a refund result from WeChat Pay (refund response or notify) on its job.
+// Refunds made in the merchant platform are added; a settled refund is not changed again.
+func (p *Payments) ApplyRefund(res *Refund) error {
+	jobID := strings.TrimSpace(res.OutTradeNo)
+	if strings.HasPrefix(jobID, domain.TopUpTradePrefix) || strings.HasPrefix(jobID, domain.SubscriptionTradePrefix) {
+		// Top-ups and plans are not tracked for refunds.
+		log.Printf("wechatpay: refund of out_trade_no=%s refund=%s status=%s amount=%d (not tracked)", jobID, res.OutRefundNo, res.State(), res.Amount.Refund)
+		return nil
+	}
+	status, ok := refundStatus(res.State())
+	if !ok {
+		return fmt.Errorf("unknown refund status %q", res.State())
+	}
+	now := time.Now()
+	_, found, err := p.store.Update(jobID, func(j *domain.CompareJob) {
+		if _, ok := j.Refund(res.OutRefundNo); !ok {
+			j.Refunds = append(append([]domain.CompareJobRefund(nil), j.Refunds...), domain.CompareJobRefund{
+				ID:        res.OutRefundNo,
+				AmountFen: res.Amount.Refund,
+				TotalFen:  res.Amount.Total,
+				Reason:    "商户平台退款",
+				Status:    domain.RefundStatusProcessing,
+				CreatedAt: now,
+			})
+		} else {
+			j.Refunds = append([]domain.CompareJobRefund(nil), j.Refunds...)
+		}
+		r, _ := j.Refund(res.OutRefundNo)
+		if r.Status == domain.RefundStatusSucceeded || r.Status == domain.RefundStatusFailed {
+			return
+		}
+		r.Status = status
+		if res.RefundID != "" {
+			r.WeChatID = res.RefundID
+		}
+		if status == domain.RefundStatusSucceeded {
+			at := now
+			if t, err := time.Parse(time.RFC3339, res.SuccessTime); err == nil {
+				at = t
+			}
+			r.SucceededAt = &at
+			r.Error = ""
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("wechatpay: refund for unknown job out_trade_no=%s refund=%s", jobID, res.OutRefundNo)
+		return nil
+	}
+	log.Printf("wechatpay: refund job=%s refund=%s status=%s", jobID, res.OutRefundNo, status)
+	return nil
+}
+
+// updateRefund changes one of the job's refunds and returns it.
+func (p *Payments) updateRefund(jobID, refundID string, fn func(r *domain.CompareJobRefund)) (*domain.CompareJobRefund, error) {
+	var out *domain.CompareJobRefund
+	_, ok, err := p.store.Update(jobID, func(j *domain.CompareJob) {
+		j.Refunds = append([]domain.CompareJobRefund(nil), j.Refunds...)
+		if r, ok := j.Refund(refundID); ok {
+			fn(r)
+			cp := *r
+			out = &cp
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok || out == nil {
+		return nil, ErrRefundNotPending
+	}
+	return out, nil
+}
+
+func refundStatus(state string) (domain.RefundStatus, bool) {
+	switch state {
+	case "SUCCESS":
+		return domain.RefundStatusSucceeded, true
+	case "PROCESSING":
+		return domain.RefundStatusProcessing, true
+	case "ABNORMAL":
+		return domain.RefundStatusAbnormal, true
+	case "CLOSED":
+		return domain.RefundStatusFailed, true
+	}
+	return "", false
+}
+
+// RefundedJob returns the job whose refunds are asked for.
+func (p *Payments) RefundedJob(jobID string) (*domain.CompareJob, error) {
+	job, ok, err := p.store.Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
@@ -0,0 +1,150 @@
+package wechat
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"gobackend/domain"
+	"gobackend/store"
+)
+
+// paidJob stores a released job paid 1 yuan through WeChat Pay.
+func paidJob(t *testing.T, f *fakeWechatPay, st store.CompareJobStore, p *Payments, id string) {
+	t.Helper()
+	awaitingJob(t, st, id, true)
+	f.setPaid(id, 100)
+	if err := p.ApplyPaid(id, 100, domain.ActorWeChatNotify); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefundIDStableAcrossRetries(t *testing.T) {
+	f := newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	paidJob(t, f, st, p, "job_1")
+
+	// WeChat Pay times out: the outcome is unknown, so the refund stays processing.
+	f.refundCode = http.StatusInternalServerError
+	rf, err := p.Refund("job_1", 40, "导出有误")
+	if err == nil || errors.Is(err, ErrRefundRejected) {
+		t.Fatalf("err = %v, want an unknown outcome", err)
+	}
+	if rf.ID != "rf_job_1_1" || rf.Status != domain.RefundStatusProcessing || rf.Error == "" {
+		t.Fatalf("refund = %+v", rf)
+	}
+	// The reserved amount can't be refunded twice meanwhile.
+	if _, err := p.Refund("job_1", 70, ""); !errors.Is(err, ErrRefundAmount) {
+		t.Fatalf("over-refund: %v", err)
+	}
+
+	// Resending reuses out_refund_no, so WeChat Pay refunds it at most once.
+	rf, err = p.ResendRefund("job_1", "rf_job_1_1")
+	if err == nil || rf.Status != domain.RefundStatusProcessing {
+		t.Fatalf("second try: %+v %v", rf, err)
+	}
+	f.refundCode, f.refundStatus = 0, "SUCCESS"
+	rf, err = p.ResendRefund("job_1", "rf_job_1_1")
+	if err != nil || rf.Status != domain.RefundStatusSucceeded || rf.WeChatID != "50rf_job_1_1" || rf.Error != "" || rf.SucceededAt == nil {
+		t.Fatalf("third try: %+v %v", rf, err)
+	}
+	if want := []string{"rf_job_1_1", "rf_job_1_1", "rf_job_1_1"}; len(f.refundNos) != 3 || f.refundNos[0] != want[0] || f.refundNos[1] != want[1] || f.refundNos[2] != want[2] {
+		t.Fatalf("out_refund_no sent: %v, want %v", f.refundNos, want)
+	}
+	if _, err := p.ResendRefund("job_1", "rf_job_1_1"); !errors.Is(err, ErrRefundNotPending) {
+		t.Fatalf("resend of a settled refund: %v", err)
+	}
+
+	// The next refund gets the next number and only what is left.
+	rf, err = p.Refund("job_1", 0, "")
+	if err != nil || rf.ID != "rf_job_1_2" || rf.AmountFen != 60 || rf.TotalFen != 100 {
+		t.Fatalf("second refund: %+v %v", rf, err)
+	}
+	if _, err := p.Refund("job_1", 0, ""); !errors.Is(err, ErrRefundAmount) {
+		t.Fatalf("fully refunded job: %v", err)
+	}
+}
+
+func TestRefundRejected(t *testing.T) {
+	f := newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	paidJob(t, f, st, p, "job_1")
+
+	f.refundCode = http.StatusForbidden
+	rf, err := p.Refund("job_1", 0, "")
+	if !errors.Is(err, ErrRefundRejected) || rf.Status != domain.RefundStatusFailed {
+		t.Fatalf("refund = %+v, err = %v", rf, err)
+	}
+	// Nothing was refunded, so the full amount is refundable again.
+	f.refundCode, f.refundStatus = 0, "PROCESSING"
+	rf, err = p.Refund("job_1", 0, "")
+	if err != nil || rf.ID != "rf_job_1_2" || rf.AmountFen != 100 || rf.Status != domain.RefundStatusProcessing {
+		t.Fatalf("retry: %+v %v", rf, err)
+	}
+}
+
+func TestRefundNotRefundable(t *testing.T) {
+	newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	awaitingJob(t, st, "job_1", true)
+
+	if _, err := p.Refund("job_1", 0, ""); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("unpaid job: %v", err)
+	}
+	if _, err := p.Refund("nope", 0, ""); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("unknown job: %v", err)
+	}
+}
+
+func TestApplyRefundNotifyOnce(t *testing.T) {
+	f := newFakeWechatPay(t)
+	st := store.NewInMemoryCompareJobStore()
+	p := NewPayments(st, nil)
+	paidJob(t, f, st, p, "job_1")
+	f.refundStatus = "PROCESSING"
+	if _, err := p.Refund("job_1", 100, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	notify := &Refund{OutTradeNo: "job_1", OutRefundNo: "rf_job_1_1", RefundID: "50rf_job_1_1", RefundStatus: "SUCCESS", SuccessTime: "2026-10-01T10:00:00+08:00"}
+	notify.Amount.Total, notify.Amount.Refund = 100, 100
+	for i := 0; i < 3; i++ { // WeChat Pay repeats the notify until it gets a 200
+		if err := p.ApplyRefund(notify); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A late, contradicting notify doesn't change a settled refund.
+	late := *notify
+	late.RefundStatus = "CLOSED"
+	if err := p.ApplyRefund(&late); err != nil {
+		t.Fatal(err)
+	}
+
+	job, _, _ := st.Get("job_1")
+	if len(job.Refunds) != 1 {
+		t.Fatalf("refunds = %+v, want one", job.Refunds)
+	}
+	rf := job.Refunds[0]
+	if rf.Status != domain.RefundStatusSucceeded || rf.SucceededAt == nil || rf.SucceededAt.Format("2006-01-02T15:04:05Z07:00") != "2026-10-01T10:00:00+08:00" {
+		t.Fatalf("refund = %+v", rf)
+	}
+
+	// A refund made in the merchant platform is added once.
+	other := &Refund{OutTradeNo: "job_1", OutRefundNo: "manual_1", RefundStatus: "PROCESSING"}
+	other.Amount.Total, other.Amount.Refund = 100, 10
+	for i := 0; i < 2; i++ {
+		if err := p.ApplyRefund(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job, _, _ := st.Get("job_1"); len(job.Refunds) != 2 || job.Refunds[1].ID != "manual_1" || job.Refunds[1].AmountFen != 10 {
+		t.Fatalf("refunds = %+v", job.Refunds)
+	}
+
+	if err := p.ApplyRefund(&Refund{OutTradeNo: "job_1", OutRefundNo: "x", RefundStatus: "WEIRD"}); err == nil {
+		t.Fatal("unknown refund status accepted")
+	}
+}
@@ -22,6 +22,11 @@ type wechatpayVerifier struct {
 
 	platformPublicKey   *rsa.PublicKey
 	platformPublicKeyID string // PUB_KEY_ID_...
+
+	// Downloaded platform certificates by serial (platformCerts); download marks that unknown
+	// serials may be looked up there.
+	certs    map[string]*x509.Certificate
+	download bool
 }
 
 func loadWechatpayVerifier() (*wechatpayVerifier, error) {
@@ -46,7 +51,13 @@ func loadWechatpayVerifier() (*wechatpayVerifier, error) {
 		}
 	}
 
-	if v.platformCert == nil && v.platformPublicKey == nil {
+	// 3) Platform certificates downloaded from /v3/certificates (rotate automatically)
+	if platformCertDownloadEnabled() {
+		v.download = true
+		v.certs = platformCerts.snapshot()
+	}
+
+	if v.platformCert == nil && v.platformPublicKey == nil && len(v.certs) == 0 {
 		// Common confusion: users sometimes put merchant cert as cert.txt.
 		if mch := strings.TrimSpace(readWechatMchID()); mch != "" {
 			certTxt := filepath.Join("wechatpay", "cert", "cert.txt")
@@ -61,7 +72,7 @@ func loadWechatpayVerifier() (*wechatpayVerifier, error) {
 				}
 			}
 		}
-		return nil, errors.New("缺少平台验签材料：请提供 wechatpay/cert/platform_cert.pem，或提供平台公钥 WECHAT_PLATFORM_PUBLIC_KEY（也支持放置 wechatpay/cert/platform_public_key.pem / tmp/wechatpay_cache/cert/platform_public_key.pem）")
+		return nil, errors.New("缺少平台验签材料：平台证书下载未成功（需 WECHAT_MCHID、商户私钥与 WECHAT_API_V3_KEY），请提供 wechatpay/cert/platform_cert.pem，或提供平台公钥 WECHAT_PLATFORM_PUBLIC_KEY（也支持放置 wechatpay/cert/platform_public_key.pem / tmp/wechatpay_cache/cert/platform_public_key.pem）")
 	}
 	return v, nil
 }
@@ -75,6 +86,14 @@ func (v *wechatpayVerifier) Verify(h http.Header, body []byte) error {
 		return errors.New("缺少微信验签头")
 	}
 
+	// Use the key named by Wechatpay-Serial when we have it.
+	if pub := v.keyFor(normalizeWechatpaySerial(serial)); pub != nil {
+		if err := verifyWechatpaySignature(pub, h, body); err != nil {
+			return fmt.Errorf("验签失败（%s）: %w", serial, err)
+		}
+		return nil
+	}
+
 	sig, err := base64.StdEncoding.DecodeString(sigB64)
 	if err != nil {
 		return err
@@ -114,6 +133,30 @@ func (v *wechatpayVerifier) Verify(h http.Header, body []byte) error {
 	return errors.New("验签失败：平台证书/公钥均未通过验证")
 }
 
+// keyFor returns the platform key with serial (a certificate serial or PUB_KEY_ID_...), or nil if
+// it is unknown; Verify then falls back to trying every configured key.
+func (v *wechatpayVerifier) keyFor(serial string) *rsa.PublicKey {
+	if strings.HasPrefix(serial, "PUB_KEY_ID_") {
+		if v.platformPublicKey != nil && strings.EqualFold(strings.TrimSpace(v.platformPublicKeyID), serial) {
+			return v.platformPublicKey
+		}
+		return nil
+	}
+	cert := v.certs[serial]
+	if cert == nil && v.platformCert != nil && normalizeWechatpaySerial(v.platformCertSerial) == serial {
+		cert = v.platformCert
+	}
+	if cert == nil && v.download {
+		// Possibly a newly rotated certificate.
+		cert = platformCerts.lookup(serial)
+	}
+	if cert == nil {
+		return nil
+	}
+	pub, _ := cert.PublicKey.(*rsa.PublicKey)
+	return pub
+}
+
 func parseRSAPublicKeyFromPEM(pemText string) (*rsa.PublicKey, error) {
 	orig := pemText
 	pemText = strings.TrimSpace(pemText)
@@ -0,0 +1,275 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMchID = "1900000001"
+
+// fakeWechatPay stands in for api.mch.weixin.qq.com (installed as http.DefaultTransport). It
+// checks the merchant signature of every request and signs its responses like WeChat Pay.
+type fakeWechatPay struct {
+	t        *testing.T
+	merchant *rsa.PublicKey
+	// platform signs API responses (platform public key mode, serial PUB_KEY_ID_TEST).
+	platform *rsa.PrivateKey
+
+	mu sync.Mutex
+	// paid orders: out_trade_no -> amount; other orders are NOTPAY.
+	paid map[string]int64
+	// refundCode (default 200) and refundStatus answer POST /v3/refund/domestic/refunds.
+	refundCode   int
+	refundStatus string
+	refundNos    []string
+	// certs are listed by /v3/certificates (encrypted with apiV3Key, response signed by certs[0]).
+	certs     []testPlatformCert
+	apiV3Key  string
+	certCalls int
+}
+
+type testPlatformCert struct {
+	key    *rsa.PrivateKey
+	cert   *x509.Certificate
+	serial string // upper case hex, as x509 prints it
+}
+
+// newFakeWechatPay configures merchant credentials in a temp working directory and routes WeChat
+// Pay requests to the fake. Platform certificate download stays off (see enableCertDownload).
+func newFakeWechatPay(t *testing.T) *fakeWechatPay {
+	t.Helper()
+	dir := t.TempDir()
+	certDir := filepath.Join(dir, "wechatpay", "cert")
+	if err := os.MkdirAll(certDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	merchantKey := newTestKey(t)
+	merchantCert := newTestCert(t, merchantKey, 0x5a5a, testMchID)
+	kb, err := x509.MarshalPKCS8PrivateKey(merchantKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(certDir, "merchant_key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: kb}))
+	writeFile(t, filepath.Join(certDir, "merchant_cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: merchantCert.Raw}))
+
+	platform := newTestKey(t)
+	pub, err := x509.MarshalPKIXPublicKey(&platform.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	t.Setenv("WECHAT_MOCK", "")
+	t.Setenv("WECHAT_MCHID", testMchID)
+	t.Setenv("WECHAT_PLATFORM_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})))
+	t.Setenv("WECHAT_PLATFORM_PUBLIC_KEY_ID", "PUB_KEY_ID_TEST")
+	t.Setenv("WECHAT_API_V3_KEY", "")
+	t.Setenv("WECHAT_PLATFORM_CERT_DOWNLOAD", "0")
+	t.Setenv("WECHAT_REFUND_NOTIFY_URL", "")
+
+	prevCerts := platformCerts
+	platformCerts = &platformCertCache{}
+	f := &fakeWechatPay{t: t, merchant: &merchantKey.PublicKey, platform: platform, paid: map[string]int64{}}
+	prevTransport := http.DefaultTransport
+	http.DefaultTransport = f
+	t.Cleanup(func() {
+		http.DefaultTransport = prevTransport
+		platformCerts = prevCerts
+	})
+	return f
+}
+
+// enableCertDownload turns on /v3/certificates with the given platform certificates.
+func (f *fakeWechatPay) enableCertDownload(certs ...testPlatformCert) {
+	f.t.Helper()
+	f.mu.Lock()
+	f.apiV3Key = "0123456789abcdef0123456789abcdef"
+	f.certs = certs
+	f.mu.Unlock()
+	f.t.Setenv("WECHAT_API_V3_KEY", f.apiV3Key)
+	f.t.Setenv("WECHAT_PLATFORM_CERT_DOWNLOAD", "")
+}
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func newTestCert(t *testing.T, key *rsa.PrivateKey, serial int64, cn string) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestPlatformCert(t *testing.T, serial int64) testPlatformCert {
+	t.Helper()
+	key := newTestKey(t)
+	cert := newTestCert(t, key, serial, "Tenpay.com Root CA")
+	return testPlatformCert{key: key, cert: cert, serial: strings.ToUpper(cert.SerialNumber.Text(16))}
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signResponse sets the Wechatpay-* headers for body, signed by key under serial.
+func signResponse(t *testing.T, h http.Header, key *rsa.PrivateKey, serial string, body []byte) {
+	t.Helper()
+	ts := fmt.Sprint(time.Now().Unix())
+	nonce := "fake-nonce"
+	digest := sha256.Sum256([]byte(ts + "\n" + nonce + "\n" + string(body) + "\n"))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Set("Wechatpay-Timestamp", ts)
+	h.Set("Wechatpay-Nonce", nonce)
+	h.Set("Wechatpay-Signature", base64.StdEncoding.EncodeToString(sig))
+	h.Set("Wechatpay-Serial", serial)
+}
+
+func (f *fakeWechatPay) RoundTrip(r *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if r.Body != nil {
+		reqBody, _ = io.ReadAll(r.Body)
+	}
+	f.checkMerchantSignature(r, reqBody)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, body := http.StatusOK, ""
+	switch {
+	case r.URL.Path == "/v3/certificates":
+		f.certCalls++
+		return f.certificates(r), nil
+	case r.URL.Path == "/v3/refund/domestic/refunds":
+		var in struct {
+			OutTradeNo  string `json:"out_trade_no"`
+			OutRefundNo string `json:"out_refund_no"`
+			Amount      struct{ Refund, Total int64 }
+		}
+		if err := json.Unmarshal(reqBody, &in); err != nil {
+			f.t.Errorf("refund body: %v", err)
+		}
+		f.refundNos = append(f.refundNos, in.OutRefundNo)
+		if f.refundCode != 0 {
+			status = f.refundCode
+		}
+		if status == http.StatusOK {
+			body = fmt.Sprintf(`{"refund_id":"50%s","out_refund_no":%q,"out_trade_no":%q,"status":%q,"success_time":"2026-10-01T10:00:00+08:00","amount":{"total":%d,"refund":%d}}`,
+				in.OutRefundNo, in.OutRefundNo, in.OutTradeNo, f.refundStatus, in.Amount.Total, in.Amount.Refund)
+		} else {
+			body = `{"code":"SYSTEM_ERROR","message":"系统超时"}`
+		}
+	case strings.HasPrefix(r.URL.Path, "/v3/pay/transactions/out-trade-no/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v3/pay/transactions/out-trade-no/")
+		if fen, ok := f.paid[id]; ok {
+			body = fmt.Sprintf(`{"out_trade_no":%q,"transaction_id":"42%s","trade_state":"SUCCESS","amount":{"total":%d}}`, id, id, fen)
+		} else {
+			body = fmt.Sprintf(`{"out_trade_no":%q,"trade_state":"NOTPAY","amount":{"total":100}}`, id)
+		}
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		status, body = http.StatusNotFound, `{"code":"NOT_FOUND"}`
+	}
+	h := http.Header{}
+	signResponse(f.t, h, f.platform, "PUB_KEY_ID_TEST", []byte(body))
+	return &http.Response{StatusCode: status, Header: h, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+}
+
+func (f *fakeWechatPay) checkMerchantSignature(r *http.Request, body []byte) {
+	authz := r.Header.Get("Authorization")
+	field := func(k string) string {
+		_, rest, ok := strings.Cut(authz, k+`="`)
+		if !ok {
+			return ""
+		}
+		v, _, _ := strings.Cut(rest, `"`)
+		return v
+	}
+	if field("mchid") != testMchID {
+		f.t.Errorf("%s %s: Authorization without our mchid: %q", r.Method, r.URL, authz)
+		return
+	}
+	msg := r.Method + "\n" + r.URL.RequestURI() + "\n" + field("timestamp") + "\n" + field("nonce_str") + "\n" + string(body) + "\n"
+	digest := sha256.Sum256([]byte(msg))
+	sig, _ := base64.StdEncoding.DecodeString(field("signature"))
+	if err := rsa.VerifyPKCS1v15(f.merchant, crypto.SHA256, digest[:], sig); err != nil {
+		f.t.Errorf("%s %s: merchant signature: %v", r.Method, r.URL, err)
+	}
+}
+
+// certificates answers /v3/certificates; serial_no is zero-padded like WeChat Pay sometimes does.
+func (f *fakeWechatPay) certificates(r *http.Request) *http.Response {
+	block, err := aes.NewCipher([]byte(f.apiV3Key))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var data []map[string]interface{}
+	for _, pc := range f.certs {
+		plain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pc.cert.Raw})
+		ct := gcm.Seal(nil, []byte("nonce1234567"), plain, []byte("certificate"))
+		data = append(data, map[string]interface{}{
+			"serial_no": "00" + pc.serial,
+			"encrypt_certificate": map[string]string{
+				"algorithm":       "AEAD_AES_256_GCM",
+				"nonce":           "nonce1234567",
+				"associated_data": "certificate",
+				"ciphertext":      base64.StdEncoding.EncodeToString(ct),
+			},
+		})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"data": data})
+	h := http.Header{}
+	signResponse(f.t, h, f.certs[0].key, f.certs[0].serial, body)
+	return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(bytes.NewReader(body)), Request: r}
+}
+
+func (f *fakeWechatPay) setPaid(outTradeNo string, fen int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paid[outTradeNo] = fen
+}